@@ -17,6 +17,7 @@ import "C"
 import (
 	"fmt"
 	"strconv"
+	"time"
 	"unsafe"
 
 	"github.com/youtube/vitess/go/hack"
@@ -147,10 +148,65 @@ const (
 	// It's hard-coded for now because it causes problems on import.
 	ErrServerLost = 2013
 
+	// ErrAuthPluginCannotLoad is C.CR_AUTH_PLUGIN_CANNOT_LOAD.
+	// It's hard-coded like ErrServerLost.
+	ErrAuthPluginCannotLoad = 2059
+
 	// RedactedPassword is the password value used in redacted configs
 	RedactedPassword = "****"
 )
 
+const (
+	// AuthNativePassword is the authentication plugin of MySQL 5.x.
+	AuthNativePassword = "mysql_native_password"
+	// AuthCachingSha2Password is the default authentication plugin of
+	// MySQL 8. Unless the server has the password cached, the first
+	// authentication must be done over SSL or a unix socket.
+	AuthCachingSha2Password = "caching_sha2_password"
+)
+
+// CheckAuthPlugin returns an error if plugin isn't a ConnParams.AuthPlugin
+// we support, or if the linked client library can't load it, e.g.
+// caching_sha2_password with a client library older than MySQL 8. The
+// latter error is a *sqldb.SQLError with the ErrAuthPluginCannotLoad
+// number of the client library. An empty plugin is valid.
+func CheckAuthPlugin(plugin string) error {
+	switch plugin {
+	case "":
+		return nil
+	case AuthNativePassword, AuthCachingSha2Password:
+	default:
+		return fmt.Errorf("unsupported authentication plugin %q, should be %v or %v", plugin, AuthNativePassword, AuthCachingSha2Password)
+	}
+
+	name := C.CString(plugin)
+	defer cfree(name)
+	var msg [512]C.char
+	if errnum := C.vt_check_auth_plugin(name, &msg[0], C.ulong(len(msg))); errnum != 0 {
+		return &sqldb.SQLError{
+			Num:     int(errnum),
+			Message: fmt.Sprintf("the MySQL client library %v can't use authentication plugin %q: %v", C.GoString(C.mysql_get_client_info()), plugin, C.GoString(&msg[0])),
+		}
+	}
+	return nil
+}
+
+// authPluginError makes the error returned when the server demands an
+// authentication plugin the client library can't load say what to do.
+// Other errors are returned unchanged.
+func authPluginError(params sqldb.ConnParams, err error) error {
+	sqlErr, ok := err.(*sqldb.SQLError)
+	if !ok || sqlErr.Num != ErrAuthPluginCannotLoad {
+		return err
+	}
+	return &sqldb.SQLError{
+		Num:     sqlErr.Num,
+		State:   sqlErr.State,
+		Message: fmt.Sprintf("%v (auth plugin = %q: the server requires an authentication plugin this client can't use; set the auth plugin to %v or %v, or change the user's plugin on the server)", sqlErr.Message, params.AuthPlugin, AuthNativePassword, AuthCachingSha2Password),
+		Query:   sqlErr.Query,
+	}
+}
+
 func handleError(err *error) {
 	if x := recover(); x != nil {
 		terr := x.(*sqldb.SQLError)
@@ -175,6 +231,10 @@ type Connection struct {
 
 // Connect uses the connection parameters to connect and returns the connection
 func Connect(params sqldb.ConnParams) (sqldb.Conn, error) {
+	if err := CheckAuthPlugin(params.AuthPlugin); err != nil {
+		return nil, err
+	}
+
 	var err error
 	defer handleError(&err)
 
@@ -192,11 +252,26 @@ func Connect(params sqldb.ConnParams) (sqldb.Conn, error) {
 	charset := C.CString(params.Charset)
 	defer cfree(charset)
 	flags := C.ulong(params.Flags)
+	var sslConnect C.int
+	if params.SslConnect {
+		sslConnect = 1
+	}
+	sslKey := C.CString(params.SslKey)
+	defer cfree(sslKey)
+	sslCert := C.CString(params.SslCert)
+	defer cfree(sslCert)
+	sslCa := C.CString(params.SslCa)
+	defer cfree(sslCa)
+	sslCaPath := C.CString(params.SslCaPath)
+	defer cfree(sslCaPath)
+	defaultAuth := C.CString(params.AuthPlugin)
+	defer cfree(defaultAuth)
+	keepAlive := C.uint((params.KeepAlive + time.Second - 1) / time.Second)
 
 	conn := &Connection{}
-	if C.vt_connect(&conn.c, host, uname, pass, dbname, port, unixSocket, charset, flags) != 0 {
+	if C.vt_connect(&conn.c, host, uname, pass, dbname, port, unixSocket, charset, flags, sslConnect, sslKey, sslCert, sslCa, sslCaPath, defaultAuth, keepAlive) != 0 {
 		defer conn.Close()
-		return nil, conn.lastError("")
+		return nil, authPluginError(params, conn.lastError(""))
 	}
 	return conn, nil
 }
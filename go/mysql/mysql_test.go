@@ -0,0 +1,150 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/sqldb"
+)
+
+func TestCheckAuthPlugin(t *testing.T) {
+	for _, plugin := range []string{"", AuthNativePassword} {
+		if err := CheckAuthPlugin(plugin); err != nil {
+			t.Errorf("CheckAuthPlugin(%q) = %v, want nil", plugin, err)
+		}
+	}
+	// Only the client libraries of MySQL 8 have caching_sha2_password.
+	if err := CheckAuthPlugin(AuthCachingSha2Password); err != nil {
+		sqlErr, ok := err.(*sqldb.SQLError)
+		if !ok || sqlErr.Num != ErrAuthPluginCannotLoad || !strings.Contains(sqlErr.Message, AuthCachingSha2Password) {
+			t.Errorf("CheckAuthPlugin(%v) = %#v, want nil or an error %v for it", AuthCachingSha2Password, err, ErrAuthPluginCannotLoad)
+		}
+	}
+	if err := CheckAuthPlugin("sha256_password"); err == nil {
+		t.Errorf("CheckAuthPlugin(sha256_password) = nil, want error")
+	}
+}
+
+func TestAuthPluginError(t *testing.T) {
+	params := sqldb.ConnParams{AuthPlugin: AuthNativePassword}
+
+	// This is what the client library returns when a MySQL 8 server asks
+	// for caching_sha2_password and the library can't load it.
+	err := authPluginError(params, &sqldb.SQLError{
+		Num:     ErrAuthPluginCannotLoad,
+		State:   "HY000",
+		Message: "Authentication plugin 'caching_sha2_password' cannot be loaded",
+	})
+	sqlErr, ok := err.(*sqldb.SQLError)
+	if !ok {
+		t.Fatalf("got %#v, want a *sqldb.SQLError", err)
+	}
+	if sqlErr.Num != ErrAuthPluginCannotLoad {
+		t.Errorf("got error number %v, want %v", sqlErr.Num, ErrAuthPluginCannotLoad)
+	}
+	for _, want := range []string{"caching_sha2_password' cannot be loaded", `auth plugin = "mysql_native_password"`} {
+		if !strings.Contains(sqlErr.Message, want) {
+			t.Errorf("got message %q, want it to contain %q", sqlErr.Message, want)
+		}
+	}
+
+	// Other errors are unchanged.
+	lost := &sqldb.SQLError{Num: ErrServerLost, Message: "Lost connection to MySQL server"}
+	if got := authPluginError(params, lost); got != lost {
+		t.Errorf("got %v, want %v", got, lost)
+	}
+	other := errors.New("other")
+	if got := authPluginError(params, other); got != other {
+		t.Errorf("got %v, want %v", got, other)
+	}
+}
+
+// handshakeV10 is the initial handshake packet of a server that offers
+// mysql_native_password, with the CLIENT_LONG_PASSWORD, CLIENT_PROTOCOL_41,
+// CLIENT_SECURE_CONNECTION and CLIENT_PLUGIN_AUTH capabilities.
+func handshakeV10() []byte {
+	payload := []byte{10}
+	payload = append(payload, "5.7.0-fake\x00"...)
+	payload = append(payload, 1, 0, 0, 0)
+	payload = append(payload, "abcdefgh"...)
+	payload = append(payload, 0)
+	payload = append(payload, 0x01, 0x82)
+	payload = append(payload, 33)
+	payload = append(payload, 0x02, 0)
+	payload = append(payload, 0x08, 0)
+	payload = append(payload, 21)
+	payload = append(payload, make([]byte, 10)...)
+	payload = append(payload, "ijklmnopqrst\x00"...)
+	payload = append(payload, AuthNativePassword+"\x00"...)
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint32(header, uint32(len(payload)))
+	header[3] = 0
+	return append(header, payload...)
+}
+
+func TestConnectAuthPlugin(t *testing.T) {
+	if err := CheckAuthPlugin(AuthCachingSha2Password); err != nil {
+		t.Skipf("the client library doesn't have %v: %v", AuthCachingSha2Password, err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+
+	// The fake server only reads the handshake response of the client,
+	// which names the authentication plugin it started with.
+	responses := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			responses <- nil
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		header := make([]byte, 4)
+		if _, err := conn.Write(handshakeV10()); err != nil {
+			responses <- nil
+			return
+		}
+		if _, err := io.ReadFull(conn, header); err != nil {
+			responses <- nil
+			return
+		}
+		payload := make([]byte, int(header[0])|int(header[1])<<8|int(header[2])<<16)
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			responses <- nil
+			return
+		}
+		responses <- payload
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	_, err = Connect(sqldb.ConnParams{Host: "127.0.0.1", Port: port, Uname: "vt_repl", AuthPlugin: AuthCachingSha2Password})
+	if err == nil {
+		t.Errorf("Connect() to the fake server succeeded")
+	}
+	// Connect() may have failed before it dialed the fake server.
+	listener.Close()
+	response := <-responses
+	if response == nil {
+		t.Fatalf("the fake server got no handshake response, Connect() error: %v", err)
+	}
+	// The server offered mysql_native_password, but the client starts
+	// with the one of AuthPlugin.
+	if !bytes.Contains(response, []byte(AuthCachingSha2Password+"\x00")) {
+		t.Errorf("handshake response %q doesn't name %v", response, AuthCachingSha2Password)
+	}
+}
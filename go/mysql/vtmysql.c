@@ -2,6 +2,13 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+#include <stdio.h>
+#include <sys/socket.h>
+#include <netinet/in.h>
+#include <netinet/tcp.h>
+
+#include <mysql/client_plugin.h>
+
 #include "vtmysql.h"
 #include "vtmysql_internals.h"
 
@@ -24,6 +31,28 @@ void vt_library_init(void) {
   mysql_library_init(0, 0, 0);
 }
 
+// null_if_empty turns empty strings from go into the NULL pointers
+// mysql_ssl_set expects for unset parameters.
+static const char *null_if_empty(const char *str) {
+  return (str && *str) ? str : NULL;
+}
+
+// set_keepalive enables TCP keepalive probes on fd, sent after the
+// connection has been idle for interval seconds, and then every interval
+// seconds. This is best effort: unix sockets don't support all of it.
+static void set_keepalive(int fd, unsigned int interval) {
+  int on = 1;
+  int secs = (int)interval;
+
+  setsockopt(fd, SOL_SOCKET, SO_KEEPALIVE, &on, sizeof(on));
+#ifdef TCP_KEEPIDLE
+  setsockopt(fd, IPPROTO_TCP, TCP_KEEPIDLE, &secs, sizeof(secs));
+#endif
+#ifdef TCP_KEEPINTVL
+  setsockopt(fd, IPPROTO_TCP, TCP_KEEPINTVL, &secs, sizeof(secs));
+#endif
+}
+
 int vt_connect(
     VT_CONN *conn,
     const char *host,
@@ -33,19 +62,57 @@ int vt_connect(
     unsigned int port,
     const char *unix_socket,
     const char *csname,
-    unsigned long client_flag)
+    unsigned long client_flag,
+    int ssl_connect,
+    const char *ssl_key,
+    const char *ssl_cert,
+    const char *ssl_ca,
+    const char *ssl_capath,
+    const char *default_auth,
+    unsigned int keepalive)
 {
   MYSQL *c;
 
   mysql_thread_init();
   conn->mysql = mysql_init(0);
+  if (ssl_connect) {
+    mysql_ssl_set(conn->mysql, null_if_empty(ssl_key), null_if_empty(ssl_cert),
+        null_if_empty(ssl_ca), null_if_empty(ssl_capath), NULL);
+  }
+  if (default_auth && *default_auth) {
+    mysql_options(conn->mysql, MYSQL_DEFAULT_AUTH, default_auth);
+  }
   c = mysql_real_connect(conn->mysql, host, user, passwd, db, port, unix_socket, client_flag);
   if(!c) {
     return 1;
   }
+  if (keepalive) {
+    set_keepalive(conn->mysql->net.fd, keepalive);
+  }
   return mysql_set_character_set(conn->mysql, csname);
 }
 
+unsigned int vt_check_auth_plugin(const char *name, char *err, unsigned long err_len) {
+  MYSQL *mysql;
+  unsigned int errnum = 0;
+
+  mysql_thread_init();
+  mysql = mysql_init(0);
+  if (!mysql) {
+    snprintf(err, err_len, "mysql_init failed");
+    return 1;
+  }
+  if (!mysql_client_find_plugin(mysql, name, MYSQL_CLIENT_AUTHENTICATION_PLUGIN)) {
+    errnum = mysql_errno(mysql);
+    snprintf(err, err_len, "%s", mysql_error(mysql));
+    if (!errnum) {
+      errnum = 1;
+    }
+  }
+  mysql_close(mysql);
+  return errnum;
+}
+
 void vt_close(VT_CONN *conn) {
   if (conn->mysql) {
     mysql_thread_init();
@@ -19,6 +19,9 @@ typedef struct vt_conn {
 } VT_CONN;
 
 // vt_connect: Create a connection. You must call vt_close even if vt_connect fails.
+// The ssl_* file names are only used if ssl_connect is set; empty ones are ignored.
+// default_auth is the authentication plugin to start with; empty uses the library default.
+// keepalive is the TCP keepalive interval in seconds; 0 leaves keepalive off.
 int vt_connect(
     VT_CONN *conn,
     const char *host,
@@ -28,9 +31,21 @@ int vt_connect(
     unsigned int port,
     const char *unix_socket,
     const char *csname,
-    unsigned long client_flag);
+    unsigned long client_flag,
+    int ssl_connect,
+    const char *ssl_key,
+    const char *ssl_cert,
+    const char *ssl_ca,
+    const char *ssl_capath,
+    const char *default_auth,
+    unsigned int keepalive);
 void vt_close(VT_CONN *conn);
 
+// vt_check_auth_plugin: Returns 0 if the client library can load the authentication plugin
+// name, like vt_connect does for default_auth. Otherwise, it returns the error number, and
+// copies the error message to err.
+unsigned int vt_check_auth_plugin(const char *name, char *err, unsigned long err_len);
+
 // vt_execute: stream!=0 uses streaming (use_result). Otherwise it prefetches (store_result).
 extern int vt_execute(VT_CONN *conn, const char *stmt_str, unsigned long length, int stream);
 
@@ -5,6 +5,8 @@
 // Package sqldb defines an interface for low level db connection
 package sqldb
 
+import "time"
+
 // ConnParams contains all the parameters to use to connect to mysql
 type ConnParams struct {
 	Engine     string `json:"engine"`
@@ -23,4 +25,20 @@ type ConnParams struct {
 	SslCaPath string `json:"ssl_ca_path"`
 	SslCert   string `json:"ssl_cert"`
 	SslKey    string `json:"ssl_key"`
+
+	// SslConnect, if set, makes our own connection use SSL with the
+	// Ssl* files above, e.g. for the SlaveConnections of
+	// mysqlctl.SlaveConnectionOptions.SSL. Without it, they are only
+	// used for 'Change Master'.
+	SslConnect bool `json:"ssl_connect"`
+
+	// AuthPlugin is the authentication plugin our own connections
+	// start with, e.g. caching_sha2_password for MySQL 8. If empty,
+	// the client library default is used.
+	AuthPlugin string `json:"auth_plugin"`
+
+	// KeepAlive, if non-zero, enables TCP keepalive probes on our own
+	// connections once they have been idle that long, so the kernel
+	// notices dead connections. It is rounded up to a whole second.
+	KeepAlive time.Duration `json:"keepalive"`
 }
@@ -0,0 +1,75 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"fmt"
+
+	"github.com/youtube/vitess/go/sqltypes"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// ApplyConn is the connection to the target mysqld of NewApplySender().
+// A sqldb.Conn implements it.
+type ApplyConn interface {
+	ExecuteFetch(query string, maxrows int, wantfields bool) (*sqltypes.Result, error)
+	SetCharset(cs *binlogdatapb.Charset) error
+}
+
+// NewApplySender returns a sendTransaction func for NewStreamer() that
+// applies each BinlogTransaction to a target mysqld through conn, like a
+// minimal BinlogPlayer. The statements of a transaction are run between a
+// BEGIN and a COMMIT of its own, so the BEGIN and COMMIT statements of
+// BeginCommit are skipped. The SET statements, e.g. SET TIMESTAMP, are run
+// like the others, in the session of conn.
+//
+// charset is the client charset of the Streamer, the one of the
+// statements without a Charset, and of conn when it's created. When a
+// statement has a different one, the charset of conn is changed with
+// SetCharset() before it's run. With a nil charset, the charset of conn is
+// only changed for the statements that have one.
+//
+// The transactions without statements, e.g. the filtered ones, are
+// skipped. If a statement fails, the transaction is rolled back, and the
+// stream ends with an error that has the statement.
+func NewApplySender(conn ApplyConn, charset *binlogdatapb.Charset) func(trans *binlogdatapb.BinlogTransaction) error {
+	current := charset
+	return func(trans *binlogdatapb.BinlogTransaction) error {
+		if len(trans.Statements) == 0 {
+			return nil
+		}
+		if _, err := conn.ExecuteFetch("BEGIN", 0, false); err != nil {
+			return fmt.Errorf("can't begin binlog transaction %v: %v", trans.TransactionId, err)
+		}
+		for _, st := range trans.Statements {
+			switch st.Category {
+			case binlogdatapb.BinlogTransaction_Statement_BL_BEGIN, binlogdatapb.BinlogTransaction_Statement_BL_COMMIT:
+				continue
+			}
+			want := st.Charset
+			if want == nil {
+				want = charset
+			}
+			if want != nil && (current == nil || *current != *want) {
+				if err := conn.SetCharset(want); err != nil {
+					// The charset of conn is unknown now.
+					current = nil
+					conn.ExecuteFetch("ROLLBACK", 0, false)
+					return fmt.Errorf("can't set charset %v for statement %q of binlog transaction %v: %v", want, st.Sql, trans.TransactionId, err)
+				}
+				current = want
+			}
+			if _, err := conn.ExecuteFetch(st.Sql, 0, false); err != nil {
+				conn.ExecuteFetch("ROLLBACK", 0, false)
+				return fmt.Errorf("can't apply statement %q of binlog transaction %v: %v", st.Sql, trans.TransactionId, err)
+			}
+		}
+		if _, err := conn.ExecuteFetch("COMMIT", 0, false); err != nil {
+			return fmt.Errorf("can't commit binlog transaction %v: %v", trans.TransactionId, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// appliedStatement is a statement committed on fakeApplyConn, with the
+// session state it ran in.
+type appliedStatement struct {
+	sql       string
+	timestamp string
+	charset   binlogdatapb.Charset
+}
+
+// fakeApplyConn implements ApplyConn. It keeps the statements of the
+// current transaction until it's committed or rolled back, and fails the
+// statements of fail.
+type fakeApplyConn struct {
+	fail      map[string]bool
+	charset   binlogdatapb.Charset
+	timestamp string
+	inTx      bool
+	pending   []appliedStatement
+	committed []appliedStatement
+	rollbacks int
+}
+
+func (conn *fakeApplyConn) ExecuteFetch(query string, maxrows int, wantfields bool) (*sqltypes.Result, error) {
+	if conn.fail[query] {
+		return nil, errors.New("Duplicate entry '1' for key 'PRIMARY' (errno 1062)")
+	}
+	switch {
+	case query == "BEGIN":
+		if conn.inTx {
+			return nil, fmt.Errorf("BEGIN in a transaction")
+		}
+		conn.inTx = true
+	case query == "COMMIT":
+		conn.committed = append(conn.committed, conn.pending...)
+		conn.pending, conn.inTx = nil, false
+	case query == "ROLLBACK":
+		conn.pending, conn.inTx = nil, false
+		conn.rollbacks++
+	case strings.HasPrefix(query, "SET TIMESTAMP="):
+		conn.timestamp = strings.TrimPrefix(query, "SET TIMESTAMP=")
+	default:
+		if !conn.inTx {
+			return nil, fmt.Errorf("%q outside of a transaction", query)
+		}
+		conn.pending = append(conn.pending, appliedStatement{sql: query, timestamp: conn.timestamp, charset: conn.charset})
+	}
+	return &sqltypes.Result{}, nil
+}
+
+func (conn *fakeApplyConn) SetCharset(cs *binlogdatapb.Charset) error {
+	conn.charset = *cs
+	return nil
+}
+
+func TestApplySender(t *testing.T) {
+	latin1 := &binlogdatapb.Charset{Client: 8, Conn: 8, Server: 33}
+	query := func(sql string, cs *binlogdatapb.Charset, timestamp uint32) replication.BinlogEvent {
+		return timestampEvent{queryEvent{query: replication.Query{Database: "vt_test_keyspace", Charset: cs, SQL: sql}}, timestamp}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		query("insert into vt_a(eid, id) values (1, 1)", charset, 1407805590),
+		query("BEGIN", charset, 1407805591),
+		query("insert into vt_a(eid, id) values (2, 1)", latin1, 1407805591),
+		query("update vt_a set id = 2 where eid = 1", charset, 1407805592),
+		xidEvent{},
+		// The filtered transactions apply nothing.
+		queryEvent{query: replication.Query{Database: "vt_other", Charset: charset, SQL: "insert into vt_b(id) values (1)"}},
+		query("BEGIN", charset, 1407805594),
+		query("ROLLBACK", charset, 1407805594),
+	}
+
+	conn := &fakeApplyConn{charset: *charset}
+	bls := NewStreamer("vt_test_keyspace", nil, charset, replication.Position{}, NewApplySender(conn, charset))
+	bls.BeginCommit = BeginCommitAll
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := []appliedStatement{
+		{"insert into vt_a(eid, id) values (1, 1)", "1407805590", *charset},
+		{"insert into vt_a(eid, id) values (2, 1)", "1407805591", *latin1},
+		{"update vt_a set id = 2 where eid = 1", "1407805592", *charset},
+	}
+	if !reflect.DeepEqual(conn.committed, want) {
+		t.Errorf("committed statements = %v, want %v", conn.committed, want)
+	}
+	if conn.inTx || conn.rollbacks != 0 {
+		t.Errorf("inTx = %v, rollbacks = %v, want no transaction and no rollback", conn.inTx, conn.rollbacks)
+	}
+}
+
+func TestApplySenderError(t *testing.T) {
+	conn := &fakeApplyConn{
+		charset: *charset,
+		fail:    map[string]bool{"insert into vt_a(eid, id) values (1, 2)": true},
+	}
+	send := NewApplySender(conn, charset)
+	err := send(&binlogdatapb.BinlogTransaction{
+		Statements: []*binlogdatapb.BinlogTransaction_Statement{
+			{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+			{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (2, 1)"},
+			{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 2)"},
+		},
+		TransactionId: "MariaDB/0-62344-1",
+	})
+	if err == nil || !strings.Contains(err.Error(), "insert into vt_a(eid, id) values (1, 2)") || !strings.Contains(err.Error(), "errno 1062") {
+		t.Errorf("wrong error, got %v, want the failed statement and its error", err)
+	}
+	if len(conn.committed) != 0 || conn.rollbacks != 1 || conn.inTx {
+		t.Errorf("committed = %v, rollbacks = %v, inTx = %v, want a rolled back transaction", conn.committed, conn.rollbacks, conn.inTx)
+	}
+}
@@ -5,21 +5,111 @@
 package binlog
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/sqltypes"
 	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/trace"
 	"github.com/youtube/vitess/go/vt/mysqlctl"
 	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+	"golang.org/x/net/context"
 
 	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
 )
 
 var (
 	binlogStreamerErrors = stats.NewCounters("BinlogStreamerErrors")
+	// binlogStreamerEvents counts the binlog events received from mysqld,
+	// keyed by event type.
+	binlogStreamerEvents = stats.NewCounters("BinlogStreamerEvents")
+	// binlogStreamerTransactions counts the transactions sent to consumers.
+	binlogStreamerTransactions = stats.NewInt("BinlogStreamerTransactions")
+	// binlogStreamerSecondsBehindMaster is the difference between the local
+	// clock and the timestamp of the last transaction sent to a consumer.
+	binlogStreamerSecondsBehindMaster = stats.NewInt("BinlogStreamerSecondsBehindMaster")
+	// binlogStreamerPreFormatEvents counts the events that were ignored
+	// because they came before the FORMAT_DESCRIPTION_EVENT, keyed by event
+	// type. See Streamer.TolerateBeforeFormat.
+	binlogStreamerPreFormatEvents = stats.NewCounters("BinlogStreamerPreFormatEvents")
+	// binlogStreamerFormatChanges counts the FORMAT_DESCRIPTION_EVENTs that
+	// changed the binlog format in the middle of a stream.
+	binlogStreamerFormatChanges = stats.NewInt("BinlogStreamerFormatChanges")
+	// binlogStreamerLogFormatSwitches counts the transactions whose changes
+	// were logged in another LogFormat than the ones of the previous
+	// transaction with changes. See Streamer.LogFormatChanged.
+	binlogStreamerLogFormatSwitches = stats.NewInt("BinlogStreamerLogFormatSwitches")
+	// binlogStreamerEmptyQueries counts the QUERY_EVENTs that were skipped
+	// because their SQL is empty or only whitespace.
+	binlogStreamerEmptyQueries = stats.NewInt("BinlogStreamerEmptyQueries")
+	// binlogStreamerBytesRead is the total length of the binlog events
+	// received from mysqld, including the ones that were filtered out.
+	binlogStreamerBytesRead = stats.NewInt("BinlogStreamerBytesRead")
+	// binlogStreamerDuplicateDDLs counts the DDL statements that were
+	// dropped as repeats of the previous one. See Streamer.DedupDDLWindow.
+	binlogStreamerDuplicateDDLs = stats.NewInt("BinlogStreamerDuplicateDDLs")
+	// binlogStreamerMissingDatabases counts the streams that were started
+	// for a database that doesn't exist on mysqld. See
+	// Streamer.RequireDatabase.
+	binlogStreamerMissingDatabases = stats.NewInt("BinlogStreamerMissingDatabases")
+	// binlogStreamerSetupRetries counts the setup steps of Stream() that
+	// were tried again after a transient failure, keyed by step. See
+	// Streamer.SetupRetries.
+	binlogStreamerSetupRetries = stats.NewCounters("BinlogStreamerSetupRetries")
+	// binlogStreamerSkippedGTIDs counts the transactions whose statements
+	// were dropped because of Streamer.SkipGTIDs.
+	binlogStreamerSkippedGTIDs = stats.NewInt("BinlogStreamerSkippedGTIDs")
+	// binlogStreamerHeartbeats counts the HEARTBEAT_LOG_EVENTs that were
+	// sent as empty transactions, and the ones that were coalesced with a
+	// later one. See Streamer.HeartbeatInterval.
+	binlogStreamerHeartbeats = stats.NewCounters("BinlogStreamerHeartbeats")
+	// binlogStreamerDuplicates counts the transactions that were dropped
+	// because a previous Streamer already sent them. See Streamer.Dedup.
+	binlogStreamerDuplicates = stats.NewInt("BinlogStreamerDuplicates")
+	// binlogStreamerCatchUpRate is the number of seconds of binlog the last
+	// stream went through per second, over catchUpRateWindow: the
+	// advancement of the timestamps of its transactions over the time it
+	// took to send them. Around 1 the stream keeps up with mysqld; above 1
+	// it's catching up, which tells a large but shrinking
+	// BinlogStreamerSecondsBehindMaster from a stuck one, where it's 0. It's
+	// only updated when a transaction is sent.
+	binlogStreamerCatchUpRate = stats.NewFloat("BinlogStreamerCatchUpRate")
+	// binlogStreamerSuppressedTransactions counts the empty transactions
+	// that weren't sent because of Streamer.SuppressEmptyTransactions.
+	binlogStreamerSuppressedTransactions = stats.NewInt("BinlogStreamerSuppressedTransactions")
+	// binlogStreamerSendRetries counts the transactions that were sent
+	// again after the consumer failed to take them. See
+	// Streamer.SendRetries.
+	binlogStreamerSendRetries = stats.NewInt("BinlogStreamerSendRetries")
+	// binlogStreamerSystemStatements counts the statements that were
+	// dropped because they touch a system schema. See
+	// Streamer.SkipSystemSchemas.
+	binlogStreamerSystemStatements = stats.NewInt("BinlogStreamerSystemStatements")
+	// binlogStreamerOpenTransactionSeconds is the time since a stream read
+	// the beginning of the transaction it's reading, updated with each of
+	// its events, or 0 outside of a transaction. See
+	// Streamer.MaxOpenTransactionDuration.
+	binlogStreamerOpenTransactionSeconds = stats.NewInt("BinlogStreamerOpenTransactionSeconds")
+	// binlogStreamerTransactionIntervals is the distribution of the time
+	// between the transactions sent by a stream, in microseconds. It
+	// shows the shape of the workload, e.g. bursts of transactions, which
+	// the lag doesn't.
+	binlogStreamerTransactionIntervals = stats.NewHistogram("BinlogStreamerTransactionIntervals", []int64{100, 1000, 10000, 100000, 1000000, 10000000})
+	// binlogStreamerDatabaseEvents, binlogStreamerDatabaseTransactions and
+	// binlogStreamerDatabaseStatements are like binlogStreamerEvents and
+	// binlogStreamerTransactions, keyed by the database of the stream. They
+	// are only updated by the Streamers that have DatabaseStats set.
+	binlogStreamerDatabaseEvents       = stats.NewMultiCounters("BinlogStreamerDatabaseEvents", []string{"Database", "Type"})
+	binlogStreamerDatabaseTransactions = stats.NewCounters("BinlogStreamerDatabaseTransactions")
+	binlogStreamerDatabaseStatements   = stats.NewCounters("BinlogStreamerDatabaseStatements")
 
 	// ErrClientEOF is returned by Streamer if the stream ended because the
 	// consumer of the stream indicated it doesn't want any more events.
@@ -28,6 +118,80 @@ var (
 	// connection to the mysqld server was lost, or the stream was terminated by
 	// mysqld.
 	ErrServerEOF = fmt.Errorf("binlog stream connection was closed by mysqld")
+	// ErrServerStopped is returned by Streamer if the stream ended because
+	// mysqld was shut down cleanly, i.e. the last event received before the
+	// connection was closed was a STOP_EVENT.
+	ErrServerStopped = fmt.Errorf("binlog stream ended because mysqld was shut down")
+
+	// maxEventLength is the largest event mysqld can send, as
+	// max_allowed_packet can't be set above 1GB. An event declaring a larger
+	// length means the stream is corrupted.
+	maxEventLength uint32 = 1 << 30
+
+	// incidentNames are the names of the INCIDENT_EVENT types.
+	incidentNames = map[uint16]string{
+		0: "NONE",
+		1: "LOST_EVENTS",
+	}
+
+	// ignoredEventTypes are the event types, other than the ones the
+	// Streamer handles, that don't carry changes, so it's safe to skip
+	// them even with StrictEvents.
+	ignoredEventTypes = map[byte]bool{
+		27:  true, // HEARTBEAT_LOG_EVENT
+		28:  true, // IGNORABLE_LOG_EVENT
+		29:  true, // ROWS_QUERY_LOG_EVENT
+		34:  true, // ANONYMOUS_GTID_LOG_EVENT
+		35:  true, // PREVIOUS_GTIDS_LOG_EVENT
+		36:  true, // TRANSACTION_CONTEXT_EVENT
+		37:  true, // VIEW_CHANGE_EVENT
+		160: true, // ANNOTATE_ROWS_EVENT (MariaDB)
+		161: true, // BINLOG_CHECKPOINT_EVENT (MariaDB)
+		163: true, // GTID_LIST_EVENT (MariaDB)
+		164: true, // START_ENCRYPTION_EVENT (MariaDB)
+	}
+
+	// rowsEventTypes are the types of the rows events, which have the
+	// changes of row-based replication.
+	rowsEventTypes = map[byte]bool{
+		20: true, 21: true, 22: true, // PRE_GA_*_ROWS_EVENT
+		23: true, 24: true, 25: true, // *_ROWS_EVENTv1
+		30: true, 31: true, 32: true, // *_ROWS_EVENT
+	}
+
+	// rdsManagementStatement matches the statements of Amazon RDS on its
+	// own tables in the mysql database, e.g. "INSERT INTO
+	// mysql.rds_heartbeat2(id, value) values (1,1409892744000) ON DUPLICATE
+	// KEY UPDATE value = 1409892744000". See ProviderRDS.
+	rdsManagementStatement = regexp.MustCompile("(?i)^\\s*(insert|replace|update|delete)\\b[^(]*?`?mysql`?\\s*\\.\\s*`?rds_")
+
+	// eventTypeNames are the names of the event types the Streamer can
+	// report in an UnsupportedEventError or a RowBasedEventError.
+	eventTypeNames = map[byte]string{
+		4:  "ROTATE_EVENT",
+		5:  "INTVAR_EVENT",
+		6:  "LOAD_EVENT",
+		7:  "SLAVE_EVENT",
+		8:  "CREATE_FILE_EVENT",
+		9:  "APPEND_BLOCK_EVENT",
+		10: "EXEC_LOAD_EVENT",
+		11: "DELETE_FILE_EVENT",
+		12: "NEW_LOAD_EVENT",
+		13: "RAND_EVENT",
+		14: "USER_VAR_EVENT",
+		17: "BEGIN_LOAD_QUERY_EVENT",
+		18: "EXECUTE_LOAD_QUERY_EVENT",
+		19: "TABLE_MAP_EVENT",
+		20: "PRE_GA_WRITE_ROWS_EVENT",
+		21: "PRE_GA_UPDATE_ROWS_EVENT",
+		22: "PRE_GA_DELETE_ROWS_EVENT",
+		23: "WRITE_ROWS_EVENTv1",
+		24: "UPDATE_ROWS_EVENTv1",
+		25: "DELETE_ROWS_EVENTv1",
+		30: "WRITE_ROWS_EVENT",
+		31: "UPDATE_ROWS_EVENT",
+		32: "DELETE_ROWS_EVENT",
+	}
 
 	// statementPrefixes are normal sql statement prefixes.
 	statementPrefixes = map[string]binlogdatapb.BinlogTransaction_Statement_Category{
@@ -58,9 +222,407 @@ func getStatementCategory(sql string) binlogdatapb.BinlogTransaction_Statement_C
 	return statementPrefixes[strings.ToLower(sql)]
 }
 
+// heartbeatLogEvent is the type of the HEARTBEAT_LOG_EVENTs mysqld sends
+// when it has no events to send. See Streamer.HeartbeatInterval.
+const heartbeatLogEvent = 27
+
+// catchUpRateWindow is the minimum time over which
+// binlogStreamerCatchUpRate is computed, so the bursts of transactions
+// don't make it swing.
+const catchUpRateWindow = 10 * time.Second
+
+// xaBranch is an XA transaction branch that was prepared, and is sent
+// once it's committed.
+type xaBranch struct {
+	statements     []*binlogdatapb.BinlogTransaction_Statement
+	statementsSize int
+	logPositions   []LogPosition
+	filtered       bool
+	beginTimestamp uint32
+	statementsLog  bool
+	rowsLog        bool
+	invoker        string
+}
+
+// parseXAStatement splits an XA statement into its verb, in upper case,
+// and the XID that follows it. ok is false if sql isn't an XA statement.
+func parseXAStatement(sql string) (verb, xid string, ok bool) {
+	fields := strings.SplitN(strings.TrimSpace(sql), " ", 3)
+	if len(fields) < 2 || !strings.EqualFold(fields[0], "XA") {
+		return "", "", false
+	}
+	if len(fields) == 3 {
+		xid = strings.TrimSpace(fields[2])
+	}
+	return strings.ToUpper(fields[1]), xid, true
+}
+
+// isTransactionBoundary returns true if ev, decoded as sev, starts or ends
+// a transaction in the binlog.
+func isTransactionBoundary(ev replication.BinlogEvent, sev *StreamEvent) bool {
+	switch {
+	case ev.IsGTID():
+		return sev.BeginGTID
+	case ev.IsXID(), ev.IsXAPrepare():
+		return true
+	case ev.IsQuery():
+		if _, _, ok := parseXAStatement(sev.Query.SQL); ok {
+			return true
+		}
+		switch getStatementCategory(sev.Query.SQL) {
+		case binlogdatapb.BinlogTransaction_Statement_BL_BEGIN, binlogdatapb.BinlogTransaction_Statement_BL_COMMIT, binlogdatapb.BinlogTransaction_Statement_BL_ROLLBACK:
+			return true
+		}
+	}
+	return false
+}
+
+// getEventType returns the name of the event type, as used in the
+// BinlogStreamerEvents stats.
+func getEventType(ev replication.BinlogEvent) string {
+	switch {
+	case ev.IsFormatDescription():
+		return "FormatDescription"
+	case ev.IsRotate():
+		return "Rotate"
+	case ev.IsGTID():
+		return "GTID"
+	case ev.IsXID():
+		return "XID"
+	case ev.IsIntVar():
+		return "IntVar"
+	case ev.IsRand():
+		return "Rand"
+	case ev.IsQuery():
+		return "Query"
+	case ev.IsStop():
+		return "Stop"
+	case ev.IsIncident():
+		return "Incident"
+	case ev.IsTableMap():
+		return "TableMap"
+	case ev.IsXAPrepare():
+		return "XAPrepare"
+	}
+	return "Other"
+}
+
+// ReplicationIncidentError is returned by Streamer when it receives an
+// INCIDENT_EVENT, which mysqld writes when it knows changes are missing from
+// the binlog. Continuing the stream past that point can't be trusted.
+type ReplicationIncidentError struct {
+	// Type is the incident type, e.g. 1 for LOST_EVENTS.
+	Type uint16
+	// Message is the message mysqld wrote along with the incident.
+	Message string
+}
+
+// Error is part of the error interface.
+func (e *ReplicationIncidentError) Error() string {
+	name, ok := incidentNames[e.Type]
+	if !ok {
+		name = fmt.Sprintf("type %v", e.Type)
+	}
+	return fmt.Sprintf("replication incident %v in the binlog: %v", name, e.Message)
+}
+
+// UnsupportedEventError is returned by a Streamer with StrictEvents when it
+// receives an event it can't turn into statements, such as a rows event
+// of row-based replication.
+type UnsupportedEventError struct {
+	// Type is the type code of the event.
+	Type byte
+}
+
+// Error is part of the error interface.
+func (e *UnsupportedEventError) Error() string {
+	name, ok := eventTypeNames[e.Type]
+	if !ok {
+		name = fmt.Sprintf("type %v", e.Type)
+	}
+	return fmt.Sprintf("unsupported binlog event %v", name)
+}
+
+// RowBasedEventError is returned by a Streamer with AssertStatementBased
+// when it receives an event of row-based replication.
+type RowBasedEventError struct {
+	// Type is the type code of the event.
+	Type byte
+	// Position is the position of the stream at the event.
+	Position replication.Position
+}
+
+// Error is part of the error interface.
+func (e *RowBasedEventError) Error() string {
+	name, ok := eventTypeNames[e.Type]
+	if !ok {
+		name = fmt.Sprintf("type %v", e.Type)
+	}
+	return fmt.Sprintf("row-based binlog event %v @ %v: the binlog_format of mysqld is ROW or MIXED, and the Streamer doesn't decode rows events", name, e.Position)
+}
+
+// OpenTransactionError is returned by a Streamer with
+// MaxOpenTransactionDuration when a transaction stays open for longer.
+type OpenTransactionError struct {
+	// Position is the position of the stream at the event that found it.
+	Position replication.Position
+	// Duration is the time since the Streamer read its beginning.
+	Duration time.Duration
+	// Statements is the number of statements it read so far.
+	Statements int
+}
+
+// Error is part of the error interface.
+func (e *OpenTransactionError) Error() string {
+	return fmt.Sprintf("binlog transaction @ %v has been open for %v with %v statements, longer than MaxOpenTransactionDuration", e.Position, e.Duration, e.Statements)
+}
+
+// Filter selects the statements a Streamer sends. See Streamer.SetFilter().
+type Filter struct {
+	// Database is the database to send the statements of. Statements
+	// of other databases are skipped.
+	Database string
+	// DropStatements has the same meaning as Streamer.DropStatements.
+	DropStatements []*regexp.Regexp
+}
+
+// TransactionMetadata describes a BinlogTransaction, so consumers can make
+// batching decisions without walking its statements. See
+// Streamer.SendMetadata.
+type TransactionMetadata struct {
+	// Statements is the number of statements in the transaction.
+	Statements int
+	// Size is the total length in bytes of the SQL of the statements.
+	Size int
+	// LogPositions is only set if Streamer.StatementLogPositions is true.
+	// It has the LogPosition of the event each statement comes from, in the
+	// same order as the statements.
+	LogPositions []LogPosition
+	// ChecksumAlgorithm is the checksum algorithm of the binlog the
+	// transaction comes from, as declared in the FORMAT_DESCRIPTION_EVENT.
+	// See Streamer.ChecksumAlgorithm().
+	ChecksumAlgorithm byte
+	// Filtered is true if the transaction had statements in the binlog,
+	// but they were all filtered out, by database or by DropStatements.
+	// Empty transactions that are sent for other reasons, e.g. for a
+	// ROLLBACK, aren't Filtered.
+	Filtered bool
+	// Checksums is only set if Streamer.StatementChecksums is true. It has
+	// the StatementChecksum() of each statement, in the same order as the
+	// statements.
+	Checksums []uint32
+	// LastCommitted and SequenceNumber are the logical clock of the
+	// transaction, from its GTID_EVENT, for parallel appliers: the
+	// transaction can be applied in parallel with the ones whose
+	// SequenceNumber is greater than its LastCommitted. They are only set
+	// by MySQL 5.7 and later, SequenceNumber starts at 1, so it's 0 when
+	// they aren't set. With binlog_transaction_dependency_tracking=WRITESET,
+	// LastCommitted can be far behind, see BinlogEvent.LogicalClock().
+	LastCommitted  int64
+	SequenceNumber int64
+	// BeginTimestamp and CommitTimestamp are the timestamps of the events
+	// that started and ended the transaction: its GTID_EVENT or BEGIN, and
+	// its XID_EVENT or COMMIT. CommitTimestamp is the Timestamp of the
+	// BinlogTransaction. For autocommit statements, they are the same.
+	BeginTimestamp  int64
+	CommitTimestamp int64
+	// OriginalCommitTimestamp and ImmediateCommitTimestamp are the times
+	// the transaction was committed on the master it comes from, and on
+	// the server the stream reads, in microseconds since the epoch, from
+	// its GTID_EVENT. They are only set by MySQL 8.0 and later, and not
+	// for a batch of BatchAutocommit. The lag then comes from
+	// OriginalCommitTimestamp, rather than from the timestamp of the
+	// events, which is only precise to the second. They are 0 otherwise.
+	OriginalCommitTimestamp  int64
+	ImmediateCommitTimestamp int64
+	// LogFormat tells whether the changes of the transaction were logged
+	// as statements, as rows events, or both, so consumers can check they
+	// get the binlog_format they expect. The rows events aren't decoded,
+	// so their changes aren't in the statements of the transaction.
+	LogFormat LogFormat
+	// Annotations has the Streamer.Annotations, and the context decoded
+	// from the events of the transaction, e.g. InvokerAnnotation. It's nil
+	// if there is neither.
+	Annotations map[string]string
+	// DDLAlgorithms is nil if none of the statements is a DDL with an
+	// ALGORITHM clause. Otherwise it has the DDLAlgorithm() of each
+	// statement, e.g. INSTANT for a DDL that only changes metadata, and ""
+	// for the others, in the same order as the statements.
+	DDLAlgorithms []string
+}
+
+// InvokerAnnotation is the key of the TransactionMetadata.Annotations that
+// has the account of the first statement of the transaction that was
+// logged with one, as user@host. mysqld only logs it for the statements
+// that depend on the current user, e.g. GRANT.
+const InvokerAnnotation = "invoker"
+
+// LogFormat is the binlog format of the events of a transaction, see
+// TransactionMetadata.LogFormat.
+type LogFormat int
+
+const (
+	// LogFormatNone is for transactions without changes, e.g. for a
+	// ROLLBACK.
+	LogFormatNone LogFormat = iota
+	// LogFormatStatement is for transactions whose changes are all
+	// QUERY_EVENTs, as with binlog_format=STATEMENT. DDLs are always
+	// logged as statements.
+	LogFormatStatement
+	// LogFormatRow is for transactions whose changes are all rows events,
+	// as with binlog_format=ROW.
+	LogFormatRow
+	// LogFormatMixed is for transactions with both, as with
+	// binlog_format=MIXED.
+	LogFormatMixed
+)
+
+// String returns the name of the format.
+func (f LogFormat) String() string {
+	switch f {
+	case LogFormatNone:
+		return "None"
+	case LogFormatStatement:
+		return "Statement"
+	case LogFormatRow:
+		return "Row"
+	case LogFormatMixed:
+		return "Mixed"
+	}
+	return "Unknown"
+}
+
+// newLogFormat returns the LogFormat of a transaction that had statements
+// and rows events, or not.
+func newLogFormat(statements, rows bool) LogFormat {
+	switch {
+	case statements && rows:
+		return LogFormatMixed
+	case rows:
+		return LogFormatRow
+	case statements:
+		return LogFormatStatement
+	}
+	return LogFormatNone
+}
+
+// hasDML returns true if one of statements is a DML.
+func hasDML(statements []*binlogdatapb.BinlogTransaction_Statement) bool {
+	for _, st := range statements {
+		if st.Category == binlogdatapb.BinlogTransaction_Statement_BL_DML {
+			return true
+		}
+	}
+	return false
+}
+
+// LogPosition is the location of an event in the binlog files of mysqld.
+type LogPosition struct {
+	// File is the name of the binlog file.
+	File string
+	// Offset is the offset of the start of the event in File.
+	Offset uint32
+}
+
+// BeginCommitMode controls whether a Streamer adds explicit BEGIN and COMMIT
+// statements to the transactions it sends.
+type BeginCommitMode int
+
+const (
+	// BeginCommitNone doesn't add BEGIN and COMMIT statements. Transaction
+	// boundaries are only conveyed by the BinlogTransaction itself.
+	BeginCommitNone BeginCommitMode = iota
+	// BeginCommitTransactions wraps the statements of transactions that were
+	// explicitly started in the binlog, with a BEGIN or a GTID_EVENT.
+	BeginCommitTransactions
+	// BeginCommitAll wraps the statements of all transactions, including
+	// autocommit statements.
+	BeginCommitAll
+)
+
+// SetTimestampMode controls how often a Streamer adds SET TIMESTAMP
+// statements to the transactions it sends.
+type SetTimestampMode int
+
+const (
+	// SetTimestampEveryStatement adds a SET TIMESTAMP statement before each
+	// statement, so replaying them reproduces the master's NOW() exactly.
+	SetTimestampEveryStatement SetTimestampMode = iota
+	// SetTimestampOncePerTransaction only adds a SET TIMESTAMP statement
+	// before the first statement of each transaction.
+	SetTimestampOncePerTransaction
+	// SetTimestampNever doesn't add any SET TIMESTAMP statement, for
+	// consumers that don't depend on the master's timestamps.
+	SetTimestampNever
+)
+
+// Provider identifies who runs the mysqld a Streamer reads from, so it can
+// handle the binlog quirks of managed MySQL services.
+type Provider int
+
+const (
+	// ProviderMySQL is a mysqld without known quirks.
+	ProviderMySQL Provider = iota
+	// ProviderRDS is Amazon RDS for MySQL, or Aurora MySQL. They write to
+	// their own management tables in the mysql database, e.g. every few
+	// minutes to mysql.rds_heartbeat2. Those writes are logged without a
+	// default database, so they aren't skipped as cross-db statements, and
+	// with binlog_format=MIXED or ROW they are logged as rows events, which
+	// StrictEvents rejects. With ProviderRDS, the Streamer drops the
+	// statements that write to a mysql.rds_* table, like DropStatements
+	// does, and it skips the rows events of the transactions that only
+	// write to mysql.rds_* tables, even with StrictEvents.
+	ProviderRDS
+)
+
+// BinlogConnection is the connection to mysqld used by a Streamer to
+// receive binlog events. It is implemented by *mysqlctl.SlaveConnection.
+type BinlogConnection interface {
+	// GetCharset returns the default charset of the connection.
+	GetCharset() (*binlogdatapb.Charset, error)
+	// StartBinlogDump requests a binlog dump starting at startPos and returns
+	// the channel on which the events will be sent.
+	StartBinlogDump(startPos replication.Position) (<-chan replication.BinlogEvent, error)
+	// Close closes the connection, which also closes the events channel.
+	Close()
+}
+
+// dumpErrorReporter is implemented by the BinlogConnections that can tell
+// why their events channel was closed, like *mysqlctl.SlaveConnection.
+type dumpErrorReporter interface {
+	// DumpError returns the error that closed the events channel, or nil
+	// if the server ended the stream cleanly.
+	DumpError() error
+}
+
+// ServerError is returned by Streamer when the events channel was closed
+// because of an error, e.g. an ERR packet mysqld sent in the middle of the
+// binlog dump, like ER_MASTER_FATAL_ERROR_READING_BINLOG (1236) when the
+// binlogs were purged. It's only returned for connections that report
+// those errors, like *mysqlctl.SlaveConnection. Others end the stream
+// with ErrServerEOF.
+type ServerError struct {
+	// Position is where the stream ended.
+	Position replication.Position
+	// Err is the error reported by the connection.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("stream error @ %v: binlog dump ended with an error: %v", e.Position, e.Err)
+}
+
+// Number returns the MySQL error code of Err, or 0 if it has none, e.g.
+// when the connection timed out.
+func (e *ServerError) Number() int {
+	num, _ := sqlErrorNumber(e.Err)
+	return num
+}
+
 // Streamer streams binlog events from MySQL by connecting as a slave.
-// A Streamer should only be used once. To start another stream, call
-// NewStreamer() again.
+// A Streamer runs one stream at a time. To start another stream, call
+// NewStreamer() again, or Reset() it once the previous stream has ended.
 type Streamer struct {
 	// dbname and mysqld are set at creation.
 	dbname          string
@@ -69,7 +631,429 @@ type Streamer struct {
 	startPos        replication.Position
 	sendTransaction sendTransactionFunc
 
-	conn *mysqlctl.SlaveConnection
+	conn BinlogConnection
+	// ownsConn is true if the Streamer created conn, and must close it.
+	ownsConn bool
+
+	// filter holds the *Filter set by SetFilter(), if any.
+	filter atomic.Value
+	// bytesRead is returned by BytesRead().
+	bytesRead sync2.AtomicInt64
+	// eventAt and heartbeatAt are the times in Unix nanoseconds the running
+	// stream last received an event other than a heartbeat, and a
+	// heartbeat or the start of the binlog dump. They are 0 when no stream
+	// is running. See Health().
+	eventAt     sync2.AtomicInt64
+	heartbeatAt sync2.AtomicInt64
+	// now is the clock of the stats, replaced in tests.
+	now func() time.Time
+
+	// mu protects the fields below, which let WaitForPosition() and
+	// ChecksumAlgorithm() follow the progress of a running stream.
+	mu sync.Mutex
+	// committedPos is the position of the last transaction sent.
+	committedPos replication.Position
+	// posChanged is closed and replaced every time committedPos changes,
+	// and when the stream ends.
+	posChanged chan struct{}
+	// ended is true once the stream has ended.
+	ended bool
+	// running is true while Stream() runs.
+	running bool
+	// format is the one of the last FORMAT_DESCRIPTION_EVENT received.
+	format replication.BinlogFormat
+	// history has the last transactions sent, if PositionHistorySize is set.
+	history *positionHistory
+	// resumed is non-nil while the stream is paused, and closed by Resume().
+	resumed chan struct{}
+
+	// The fields below are optional settings. They must be set before
+	// Stream() is called.
+
+	// BeginCommit controls whether BL_BEGIN and BL_COMMIT statements are
+	// added around the statements of each transaction. Empty transactions
+	// are never wrapped.
+	BeginCommit BeginCommitMode
+
+	// TolerateBeforeFormat is the set of event types, as named in the
+	// BinlogStreamerEvents stats (e.g. "Query", "Other"), that are ignored
+	// if they arrive before the FORMAT_DESCRIPTION_EVENT. By default, any
+	// event other than ROTATE_EVENT is an error at that point. This is meant
+	// to work around proxies or middleware that inject events of their own.
+	TolerateBeforeFormat map[string]bool
+
+	// SkipMasterErrors drops statements whose QUERY_EVENT carries a non-zero
+	// error code, i.e. statements that failed on the master. Replaying them
+	// on the consumer side is usually wrong. They're dropped like the ones
+	// that match DropStatements: outside of a transaction, an empty
+	// transaction is sent in their place, so the position still advances.
+	SkipMasterErrors bool
+
+	// MaxEventLength, if non-zero, is the largest event length accepted by
+	// the Streamer. It should match max_allowed_packet on mysqld. Longer
+	// events end the stream with an error, like events longer than 1GB
+	// always do.
+	MaxEventLength uint32
+
+	// PositionStore, if set, persists the position of the stream. If the
+	// start position is empty, Stream() resumes from the position it loads.
+	// The position of the last transaction sent is saved as the stream
+	// progresses, and once more when it ends.
+	PositionStore PositionStore
+	// PositionSaveInterval is the minimum time between two saves to
+	// PositionStore. Zero saves after every transaction.
+	PositionSaveInterval time.Duration
+
+	// DropStatements is a list of patterns for DML and DDL statements that
+	// must not be sent. A statement is dropped if its SQL matches any of
+	// them. Autocommit statements that are dropped are replaced by an empty
+	// transaction, so the position still advances. SetFilter() can replace
+	// them while the stream runs.
+	DropStatements []*regexp.Regexp
+
+	// FormatChanged, if set, is called when a FORMAT_DESCRIPTION_EVENT in the
+	// middle of the stream describes a different format than the previous
+	// one, e.g. after mysqld was upgraded or its checksum setting changed.
+	// Note binlog_format (statement or row based) isn't part of the format
+	// description, so switching it doesn't trigger FormatChanged, but
+	// LogFormatChanged.
+	FormatChanged func(old, new replication.BinlogFormat)
+
+	// LogFormatChanged, if set, is called when the changes of a transaction
+	// were logged in another LogFormat than the ones of the previous
+	// transaction with changes, e.g. when binlog_format switched from
+	// STATEMENT to ROW, or within the transaction, for LogFormatMixed. The
+	// DDLs are always logged as statements, so they don't count. Each switch
+	// is counted in BinlogStreamerLogFormatSwitches, and the first one of
+	// a stream is logged. Like PositionObserver, it runs in the parse loop
+	// and must not block.
+	LogFormatChanged func(old, new LogFormat)
+
+	// SendEvent, if set, is called with each event as it is decoded, before
+	// it is grouped into a transaction. FORMAT_DESCRIPTION_EVENTs and
+	// STOP_EVENTs are handled by the Streamer and not passed on. If the
+	// Streamer was created with a nil sendTransaction func, it only sends
+	// the individual events. Like sendTransaction, SendEvent can return
+	// io.EOF to end the stream.
+	SendEvent func(ev *StreamEvent) error
+
+	// SSL, if set, makes the connection to mysqld use SSL with these
+	// settings. It is ignored for Streamers created with
+	// NewStreamerWithConn().
+	SSL *mysqlctl.SSLParams
+
+	// IgnoreIncidents makes the Streamer log INCIDENT_EVENTs and go on,
+	// instead of ending the stream with a *ReplicationIncidentError.
+	IgnoreIncidents bool
+
+	// SendMetadata, if set, is called with the TransactionMetadata of each
+	// transaction, just before the transaction is sent. The size is kept up
+	// to date as statements are added, so this doesn't cost a pass over
+	// the statements.
+	SendMetadata func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata)
+
+	// SetTimestamp controls how often SET TIMESTAMP statements are added to
+	// the transactions. By default, each statement is preceded by one.
+	SetTimestamp SetTimestampMode
+
+	// PositionObserver, if set, is called each time the stream position
+	// advances, with the new position and the GTID and timestamp of the
+	// event that advanced it. It is called in binlog order, for every
+	// transaction, including the ones whose statements are all filtered
+	// out. It runs in the parse loop, so it must be fast and must not block.
+	PositionObserver func(pos replication.Position, gtid replication.GTID, timestamp uint32)
+
+	// TableMapObserver, if set, is called with the decoded TABLE_MAP_EVENTs
+	// for tables of the stream's database, which tell which tables the
+	// following rows events modify. The rows events themselves aren't
+	// decoded. TABLE_MAP_EVENTs are only decoded when this is set, or with
+	// ProviderRDS, and one that can't be decoded ends the stream with an
+	// error. Like
+	// PositionObserver, it runs in the parse loop and must not block.
+	TableMapObserver func(tm *replication.TableMap)
+
+	// SkipInvalidQueries makes the Streamer skip QUERY_EVENTs it can't
+	// decode, instead of ending the stream with an error. Each skipped event
+	// is logged with its data, and counted in BinlogStreamerErrors as
+	// "SkippedQuery". Outside of a transaction, the skipped event is replaced
+	// by an empty transaction. Inside of one, the transaction still ends
+	// at the next XID_EVENT or COMMIT. Skipped statements are lost, so this
+	// trades completeness for availability. As the skipped event may be a
+	// BEGIN, a COMMIT or a ROLLBACK, the stream still ends with an error
+	// when the next events don't show it wasn't: outside of a transaction,
+	// the next event must begin one, and inside of one, the transaction
+	// must end before another one begins.
+	SkipInvalidQueries bool
+
+	// StatementLogPositions makes the Streamer report the LogPosition of
+	// each statement in TransactionMetadata.LogPositions, so consumers can
+	// resume in the middle of a big transaction. It requires SendMetadata.
+	// The synthetic BEGIN and COMMIT statements get the position of the
+	// first statement and of the commit event.
+	StatementLogPositions bool
+
+	// StatementChecksums makes the Streamer report the StatementChecksum()
+	// of each statement in TransactionMetadata.Checksums, so consumers can
+	// check the statements they receive. It requires SendMetadata. It's
+	// unrelated to the checksums of the binlog events, and it costs a pass
+	// over the SQL of each statement.
+	StatementChecksums bool
+
+	// ReadTimeout and KeepAlive, if non-zero, are used for the connection
+	// to mysqld, so a dead connection ends the stream with an error instead
+	// of hanging. See mysqlctl.SlaveConnectionOptions. Like SSL, they are
+	// ignored for Streamers created with NewStreamerWithConn().
+	ReadTimeout time.Duration
+	KeepAlive   time.Duration
+
+	// CoalesceSets makes the Streamer combine the SET statements it adds
+	// before a query (SET TIMESTAMP, and the SETs of INTVAR_EVENTs and
+	// RAND_EVENTs) into a single one, to save round-trips to consumers that
+	// run one statement at a time. SET statements from the binlog itself
+	// are left as they are.
+	CoalesceSets bool
+
+	// DatabaseStats makes the Streamer also count its events, transactions
+	// and statements by database, to tell apart the Streamers of a process
+	// that stream different databases. It's off by default, since every
+	// database adds its own set of counters.
+	DatabaseStats bool
+
+	// PositionHistorySize, if non-zero, makes the Streamer remember when it
+	// sent each of the last PositionHistorySize transactions, with their
+	// binlog timestamp and position. See PositionHistory().
+	PositionHistorySize int
+
+	// MaxDuration, if non-zero, makes the stream end once it has run for
+	// that long. A transaction that is partially read by then is finished
+	// first, so the stream always ends at a transaction boundary. Stream()
+	// then returns nil, like when the service is stopped.
+	MaxDuration time.Duration
+
+	// StrictEvents makes the Streamer end the stream with an
+	// *UnsupportedEventError when it receives an event it doesn't handle,
+	// instead of skipping it. This way, a binlog_format=ROW binlog fails
+	// loudly rather than streaming transactions without their changes.
+	// Events that don't carry changes, like PREVIOUS_GTIDS_LOG_EVENT, are
+	// still skipped, and so are TABLE_MAP_EVENTs, since the rows events
+	// that follow them are reported instead.
+	StrictEvents bool
+
+	// AssertStatementBased makes the Streamer end the stream with a
+	// *RowBasedEventError as soon as it receives a TABLE_MAP_EVENT or a
+	// rows event, which are only logged with binlog_format ROW or MIXED.
+	// Unlike StrictEvents, it doesn't wait for the rows event after a
+	// TABLE_MAP_EVENT, nor reject the other unsupported events. This is a
+	// safety net for consumers of statement-based replication while the
+	// binlog_format of mysqld is being migrated. The events of RDS
+	// management tables with ProviderRDS, and of SkipGTIDs, are still
+	// skipped.
+	AssertStatementBased bool
+
+	// Provider enables the handling of the binlog quirks of a managed MySQL
+	// service. See ProviderRDS.
+	Provider Provider
+
+	// LagChanged, if set, is called with lagging = true when the lag of the
+	// stream goes above LagThreshold, and with lagging = false when it gets
+	// back below LagThreshold - LagHysteresis. The hysteresis keeps a lag
+	// that hovers around the threshold from calling it for every
+	// transaction. The lag is measured like BinlogStreamerSecondsBehindMaster,
+	// when each transaction is sent, to the second. Like PositionObserver,
+	// LagChanged runs in the parse loop, so it must be fast and must not
+	// block.
+	LagChanged    func(lagging bool, lag time.Duration)
+	LagThreshold  time.Duration
+	LagHysteresis time.Duration
+
+	// DDLOnly makes the Streamer only send DDL statements, e.g. to track
+	// schema changes. Other statements are dropped like the ones that match
+	// DropStatements, so transactions without DDLs are sent empty and the
+	// position still advances.
+	DDLOnly bool
+
+	// DedupDDLWindow, if non-zero, makes the Streamer drop a DDL statement
+	// whose SQL is identical to the one of the previous DDL it sent, if
+	// their binlog timestamps are at most DedupDDLWindow apart. This skips
+	// the DDLs of migrations that were retried, or that are idempotent and
+	// were run twice. A DDL that differs in any way, even only in
+	// whitespace, is sent. Dropped DDLs are counted in
+	// BinlogStreamerDuplicateDDLs.
+	DedupDDLWindow time.Duration
+
+	// RequireDatabase makes Stream() fail with a SetupError if the database
+	// to stream doesn't exist on mysqld, or if that can't be checked.
+	// Otherwise, Stream() only logs a warning and counts the stream in
+	// BinlogStreamerMissingDatabases, since all the statements of the
+	// stream would be filtered out. The database can't be checked for
+	// Streamers created with NewStreamerWithConn(), which have no mysqld.
+	RequireDatabase bool
+
+	// BatchAutocommit, if non-zero, makes the Streamer group up to that
+	// many consecutive autocommit statements into one transaction, to save
+	// consumers the overhead of one transaction per statement. A batch is
+	// also sent once BatchAutocommitWindow has passed since its first
+	// statement was read, if it's set, and always before a transaction
+	// that starts or ends in the binlog, so real transactions are never
+	// merged with anything. The batch is sent with the position, GTID and
+	// timestamp of its last statement, and each statement keeps its SET
+	// TIMESTAMP, even with SetTimestampOncePerTransaction. Its metadata has
+	// no logical clock, and the BeginTimestamp of its first statement.
+	// Without BatchAutocommitWindow, a batch that isn't full waits for the
+	// next statement or the end of the stream.
+	BatchAutocommit       int
+	BatchAutocommitWindow time.Duration
+	// BatchAutocommitSize, if non-zero, also sends a batch once the SQL of
+	// its statements has at least that many bytes.
+	// BatchAutocommitSameTimestamp makes a batch only group statements
+	// with the same timestamp, e.g. the ones of a bulk load: the batch is
+	// sent before a statement with another timestamp.
+	BatchAutocommitSize          int
+	BatchAutocommitSameTimestamp bool
+
+	// SetupRetries, if non-zero, makes Stream() try to connect to mysqld
+	// and to get its charset up to SetupRetries more times when they fail
+	// with a retryable error, see IsRetryable(). Permanent errors, like bad
+	// credentials, are returned right away. The first retry waits about
+	// SetupRetryDelay, 100ms by default, and the delay doubles after each
+	// attempt, with a random jitter so the Streamers of a flaky server
+	// don't all retry at once.
+	SetupRetries    int
+	SetupRetryDelay time.Duration
+
+	// SkipGTIDs is a set of GTIDs whose transactions must not be applied,
+	// e.g. a transaction that keeps crashing the consumer. All their
+	// statements are dropped, so they are sent empty and the position
+	// still advances past them. A GTID only matches if it's equal to one
+	// of the set, e.g. skipping MariaDB GTID 0-62344-2 doesn't skip
+	// 0-62344-20, nor the transactions before it. This loses changes, so
+	// each skipped transaction is logged as a warning, and counted in
+	// BinlogStreamerSkippedGTIDs. Rows events of skipped transactions
+	// aren't rejected by StrictEvents.
+	SkipGTIDs map[replication.GTID]bool
+
+	// ReadAhead, if non-zero, makes the Streamer read up to that many
+	// events from the connection ahead of the ones it's parsing, so a
+	// connection that delivers events in bursts, e.g. because of network
+	// latency, doesn't stall the parsing and sending of the transactions.
+	// The events are read ahead in a buffer, which stops the reads when
+	// it's full: a slow consumer still holds back the connection.
+	ReadAhead int
+
+	// RewriteStatement, if set, is called with each DDL, DML, SET and
+	// unrecognized statement of the binlog that is sent, and the default
+	// database of its QUERY_EVENT, which can be empty. The statement is
+	// sent with the SQL it returns, e.g. to tag it with a comment, or to
+	// rename a database for another environment. It's called after the
+	// statements are filtered, so it can't be used to drop them. The SET
+	// statements the Streamer adds before them, e.g. SET TIMESTAMP, are
+	// only rewritten if RewriteSets is set, and BEGIN and COMMIT never are.
+	// Like PositionObserver, it runs in the parse loop and must not block.
+	RewriteStatement func(category binlogdatapb.BinlogTransaction_Statement_Category, database, sql string) string
+	RewriteSets      bool
+
+	// SetCharset, if set, adds a SET statement of the client, connection
+	// and server charsets of a statement before it, like mysqlbinlog does,
+	// when they differ from the ones of the previous statement of the
+	// transaction, or it's the first one. This replays statements of
+	// sessions with different charsets faithfully on consumers that don't
+	// apply the Charset of each statement. The SET is the last one before
+	// the statement, after SET TIMESTAMP.
+	SetCharset bool
+
+	// IsolateDDL makes the Streamer send each DDL of a transaction as a
+	// transaction of its own, with the SET statements before it, e.g. for
+	// consumers that can't apply DDL along with DML. The statements before
+	// and after it are sent as separate transactions. Only the last part of
+	// a split transaction has its GTID as TransactionId, and advances the
+	// position: the others have an empty one. So if the stream restarts
+	// in the middle of the parts, the whole transaction is sent again,
+	// rather than losing the parts after the restart position.
+	IsolateDDL bool
+
+	// Annotations are added to the TransactionMetadata.Annotations of every
+	// transaction, e.g. to tell consumers which stream a transaction comes
+	// from. The context decoded from the events, like InvokerAnnotation,
+	// takes precedence over them. It must not be modified while streaming.
+	Annotations map[string]string
+
+	// HeartbeatInterval, if non-zero, makes the Streamer send an empty
+	// transaction with its current position for the HEARTBEAT_LOG_EVENTs
+	// of mysqld, which it sends when it has no events to send, see
+	// ReadTimeout. It keeps the position and the lag of idle consumers
+	// fresh, e.g. for the FilePosGTID of a master without GTIDs. It sends
+	// at most one every HeartbeatInterval: the heartbeats in between are
+	// coalesced into the next one, and the last one is sent when the
+	// stream ends. The heartbeats inside a transaction, or a batch of
+	// BatchAutocommit, are ignored.
+	HeartbeatInterval time.Duration
+
+	// Dedup, if set, makes the Streamer drop the transactions that are in
+	// its position, and add the ones it sends to it. Share one between the
+	// Streamers that successively stream to a consumer that doesn't save
+	// its position, so the transactions a Streamer sends again after a
+	// reconnect, e.g. from a position saved every PositionSaveInterval,
+	// aren't applied twice. The dropped transactions still advance the
+	// position of the stream.
+	Dedup *Deduplicator
+
+	// SuppressEmptyTransactions makes the Streamer drop the transactions
+	// without statements instead of sending them, e.g. the filtered ones,
+	// the ROLLBACKs and the heartbeats of HeartbeatInterval, for consumers
+	// that track their position with a PositionStore. They still advance
+	// the position of the stream, which is saved, observed and waited for
+	// as usual, but a consumer that takes its position from the
+	// TransactionIds it receives falls behind it, and resumes from there.
+	// See BinlogStreamerSuppressedTransactions.
+	SuppressEmptyTransactions bool
+
+	// SendRetries, if non-zero, makes the Streamer send a transaction up to
+	// SendRetries more times when the consumer fails to take it, e.g. for a
+	// transient error downstream, instead of ending the stream. The first
+	// retry waits about SendRetryDelay, 100ms by default, and the delay
+	// doubles after each attempt, like for SetupRetries. The same
+	// transaction is sent again, and the stream waits meanwhile, without
+	// reading more events. An io.EOF still ends the stream right away.
+	SendRetries    int
+	SendRetryDelay time.Duration
+
+	// HealthThreshold is the time after which a stream that received no
+	// event is HealthStalled, see Health(). It's 30s by default. mysqld
+	// only sends heartbeats to idle streams if ReadTimeout is set, so
+	// HealthThreshold should be longer than it: without heartbeats, an
+	// idle stream can't be told from a stalled one.
+	HealthThreshold time.Duration
+
+	// Tracer, if set, makes the Streamer create spans with it: a
+	// "Streamer.Setup" span for the setup of Stream(), with a child span
+	// for each of its steps, e.g. "Streamer.Connect", and, with
+	// TraceTransactions, a "Streamer.SendTransaction" span for each
+	// transaction it sends. A SpanFactory of the tracing plugin can be
+	// used, without registering it for the whole process. The spans are
+	// annotated with the database, the GTID, the number of statements and
+	// the size of the transactions, and the errors.
+	Tracer            trace.SpanFactory
+	TraceTransactions bool
+
+	// SkipSystemSchemas makes the Streamer drop the statements that touch
+	// the SystemSchemas, DefaultSystemSchemas if it's empty, even when
+	// they're run from the database of the stream, e.g. "INSERT INTO
+	// mysql.user ...", or GRANT and the other account management
+	// statements, which write to the mysql schema. They're dropped like the
+	// ones that match DropStatements. The statements are matched by the
+	// qualified names they contain, so a string literal like 'mysql.user'
+	// drops a statement as well. See BinlogStreamerSystemStatements.
+	SkipSystemSchemas bool
+	SystemSchemas     []string
+
+	// MaxOpenTransactionDuration, if non-zero, makes the stream end with an
+	// *OpenTransactionError when a transaction is still open that long
+	// after the Streamer read its beginning, e.g. a huge transaction that
+	// fills the memory of the Streamer, rather than buffering it until its
+	// end. It's checked with each event, including the heartbeats mysqld
+	// sends when ReadTimeout is set, so a stalled transaction is caught at
+	// most ReadTimeout/2 late. See BinlogStreamerOpenTransactionSeconds.
+	MaxOpenTransactionDuration time.Duration
 }
 
 // NewStreamer creates a binlog Streamer.
@@ -77,7 +1061,10 @@ type Streamer struct {
 // dbname specifes the database to stream events for.
 // mysqld is the local instance of mysqlctl.Mysqld.
 // charset is the default character set on the BinlogPlayer side.
-// startPos is the position to start streaming at.
+// startPos is the position to start streaming at. For a master without GTIDs,
+// it can be a replication.FilePosGTID: the positions of the stream and the
+// TransactionIds are then the binlog coordinates of the end of each
+// transaction.
 // sendTransaction is called each time a transaction is committed or rolled back.
 func NewStreamer(dbname string, mysqld mysqlctl.MysqlDaemon, clientCharset *binlogdatapb.Charset, startPos replication.Position, sendTransaction sendTransactionFunc) *Streamer {
 	return &Streamer{
@@ -86,23 +1073,115 @@ func NewStreamer(dbname string, mysqld mysqlctl.MysqlDaemon, clientCharset *binl
 		clientCharset:   clientCharset,
 		startPos:        startPos,
 		sendTransaction: sendTransaction,
+		committedPos:    startPos,
+		posChanged:      make(chan struct{}),
+		now:             time.Now,
+	}
+}
+
+// NewStreamerWithConn creates a binlog Streamer that uses an already
+// established connection, instead of creating one with
+// mysqld.NewSlaveConnection(). The connection is owned by the caller:
+// Stream() will not close it.
+//
+// The other parameters are the same as for NewStreamer().
+func NewStreamerWithConn(dbname string, conn BinlogConnection, clientCharset *binlogdatapb.Charset, startPos replication.Position, sendTransaction sendTransactionFunc) *Streamer {
+	return &Streamer{
+		dbname:          dbname,
+		clientCharset:   clientCharset,
+		startPos:        startPos,
+		sendTransaction: sendTransaction,
+		conn:            conn,
+		committedPos:    startPos,
+		posChanged:      make(chan struct{}),
+		now:             time.Now,
 	}
 }
 
 // Stream starts streaming binlog events using the settings from NewStreamer().
 func (bls *Streamer) Stream(ctx *sync2.ServiceContext) (err error) {
+	bls.mu.Lock()
+	if bls.running {
+		bls.mu.Unlock()
+		return fmt.Errorf("binlog stream is already running")
+	}
+	bls.running = true
+	bls.mu.Unlock()
+	defer func() {
+		bls.mu.Lock()
+		defer bls.mu.Unlock()
+		bls.running = false
+	}()
+
 	stopPos := bls.startPos
 	defer func() {
-		if err != nil {
+		if se, ok := err.(*SetupError); ok {
+			se.Position = stopPos
+		} else if _, ok := err.(*ServerError); ok {
+			// It already has the position.
+		} else if err != nil {
 			err = fmt.Errorf("stream error @ %v: %v", stopPos, err)
 		}
 		log.Infof("stream ended @ %v, err = %v", stopPos, err)
 	}()
 
-	if bls.conn, err = bls.mysqld.NewSlaveConnection(); err != nil {
-		return err
+	if bls.PositionStore != nil && bls.startPos.IsZero() {
+		pos, err := bls.PositionStore.Load()
+		if err != nil {
+			return fmt.Errorf("can't load start position: %v", err)
+		}
+		log.Infof("resuming binlog stream from saved position %v", pos)
+		bls.startPos = pos
+		stopPos = pos
+		bls.setCommittedPosition(pos)
+	}
+
+	setupSpan := bls.newSpan(nil)
+	setupSpan.StartLocal("Streamer.Setup")
+	setupSpan.Annotate("db", bls.dbname)
+	setupSpan.Annotate("start_position", bls.startPos.String())
+	defer func() {
+		// The span is finished before parseEvents() if the setup succeeds.
+		if setupSpan != nil {
+			if err != nil {
+				setupSpan.Annotate("error", err.Error())
+			}
+			setupSpan.Finish()
+		}
+	}()
+
+	if bls.mysqld != nil {
+		if err := bls.checkDatabase(); err != nil {
+			return err
+		}
+	}
+
+	if bls.conn == nil {
+		var conn *mysqlctl.SlaveConnection
+		if se := bls.traceRetrySetup(ctx, setupSpan, "Streamer.Connect", func() *SetupError {
+			var err error
+			if bls.SSL != nil || bls.ReadTimeout != 0 || bls.KeepAlive != 0 {
+				conn, err = bls.mysqld.NewSlaveConnectionWithOptions(mysqlctl.SlaveConnectionOptions{
+					SSL:         bls.SSL,
+					ReadTimeout: bls.ReadTimeout,
+					KeepAlive:   bls.KeepAlive,
+				})
+			} else {
+				conn, err = bls.mysqld.NewSlaveConnection()
+			}
+			if err != nil {
+				return newSetupError(SetupStepConnect, err, false)
+			}
+			return nil
+		}); se != nil {
+			return se
+		}
+		bls.conn = conn
+		bls.ownsConn = true
+	}
+	if bls.ownsConn {
+		defer bls.conn.Close()
 	}
-	defer bls.conn.Close()
 
 	// Check that the default charsets match, if the client specified one.
 	// Note that Streamer uses the settings for the 'dba' user, while
@@ -112,20 +1191,38 @@ func (bls *Streamer) Stream(ctx *sync2.ServiceContext) (err error) {
 	// general doesn't support servers with different default charsets, so we
 	// treat it as a configuration error.
 	if bls.clientCharset != nil {
-		cs, err := bls.conn.GetCharset()
-		if err != nil {
-			return fmt.Errorf("can't get charset to check binlog stream: %v", err)
+		var cs *binlogdatapb.Charset
+		if se := bls.traceRetrySetup(ctx, setupSpan, "Streamer.CheckCharset", func() *SetupError {
+			var err error
+			if cs, err = bls.conn.GetCharset(); err != nil {
+				return newSetupError(SetupStepCheckCharset, fmt.Errorf("can't get charset to check binlog stream: %v", err), true)
+			}
+			return nil
+		}); se != nil {
+			return se
 		}
 		log.Infof("binlog stream client charset = %v, server charset = %v", *bls.clientCharset, cs)
 		if *cs != *bls.clientCharset {
-			return fmt.Errorf("binlog stream client charset (%v) doesn't match server (%v)", bls.clientCharset, cs)
+			return newSetupError(SetupStepCheckCharset, fmt.Errorf("binlog stream client charset (%v) doesn't match server (%v)", bls.clientCharset, cs), false)
 		}
 	}
 
 	var events <-chan replication.BinlogEvent
+	dumpSpan := bls.newSpan(setupSpan)
+	dumpSpan.StartClient("Streamer.StartBinlogDump")
 	events, err = bls.conn.StartBinlogDump(bls.startPos)
 	if err != nil {
-		return err
+		dumpSpan.Annotate("error", err.Error())
+		dumpSpan.Finish()
+		return newSetupError(SetupStepStartBinlogDump, err, true)
+	}
+	dumpSpan.Finish()
+	setupSpan.Finish()
+	setupSpan = nil
+	if bls.ReadAhead != 0 {
+		done := make(chan struct{})
+		defer close(done)
+		events = readAhead(events, bls.ReadAhead, done)
 	}
 	// parseEvents will loop until the events channel is closed, the
 	// service enters the SHUTTING_DOWN state, or an error occurs.
@@ -133,51 +1230,608 @@ func (bls *Streamer) Stream(ctx *sync2.ServiceContext) (err error) {
 	return err
 }
 
+// checkDatabase looks up the database of the stream in mysqld. It only
+// returns an error with RequireDatabase.
+func (bls *Streamer) checkDatabase() error {
+	dbname := bls.currentFilter().Database
+	buf := &bytes.Buffer{}
+	buf.WriteString("SELECT 1 FROM information_schema.schemata WHERE schema_name = ")
+	sqltypes.MakeString([]byte(dbname)).EncodeSQL(buf)
+	qr, err := bls.mysqld.FetchSuperQuery(buf.String())
+	if err != nil {
+		if bls.RequireDatabase {
+			return newSetupError(SetupStepCheckDatabase, fmt.Errorf("can't check that database %v exists: %v", dbname, err), true)
+		}
+		log.Warningf("can't check that database %v exists: %v", dbname, err)
+		return nil
+	}
+	if len(qr.Rows) != 0 {
+		return nil
+	}
+	binlogStreamerMissingDatabases.Add(1)
+	if bls.RequireDatabase {
+		return newSetupError(SetupStepCheckDatabase, fmt.Errorf("database %v doesn't exist", dbname), false)
+	}
+	log.Warningf("database %v doesn't exist, all the statements of the binlog stream will be filtered out", dbname)
+	return nil
+}
+
+// Reset prepares the Streamer for another Stream() starting at startPos,
+// with the same settings. The connection the Streamer created is dropped,
+// so Stream() creates a new one. A connection given to
+// NewStreamerWithConn() is kept, and must be able to start another binlog
+// dump. Reset returns an error if Stream() is running.
+func (bls *Streamer) Reset(startPos replication.Position) error {
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+	if bls.running {
+		return fmt.Errorf("can't reset binlog stream while it is running")
+	}
+	if bls.ownsConn {
+		bls.conn = nil
+		bls.ownsConn = false
+	}
+	bls.startPos = startPos
+	bls.committedPos = startPos
+	bls.posChanged = make(chan struct{})
+	bls.ended = false
+	bls.format = replication.BinlogFormat{}
+	bls.history = nil
+	return nil
+}
+
 // parseEvents processes the raw binlog dump stream from the server, one event
 // at a time, and groups them into transactions. It is called from within the
 // service function launched by Stream().
 //
 // If the sendTransaction func returns io.EOF, parseEvents returns ErrClientEOF.
-// If the events channel is closed, parseEvents returns ErrServerEOF.
+// If the events channel is closed, parseEvents returns ErrServerEOF, or
+// ErrServerStopped if the last event received was a STOP_EVENT, or a
+// *ServerError if the connection reports the error that closed it. An
+// INCIDENT_EVENT makes it return a *ReplicationIncidentError, unless
+// IgnoreIncidents is set.
 func (bls *Streamer) parseEvents(ctx *sync2.ServiceContext, events <-chan replication.BinlogEvent) (replication.Position, error) {
+	bls.heartbeatAt.Set(bls.now().UnixNano())
+	defer func() {
+		bls.eventAt.Set(0)
+		bls.heartbeatAt.Set(0)
+		binlogStreamerOpenTransactionSeconds.Set(0)
+	}()
+
 	var statements []*binlogdatapb.BinlogTransaction_Statement
+	// statementsSize is the total length of the SQL of statements.
+	var statementsSize int
+	// timestampSet is true if statements has a SET TIMESTAMP statement.
+	var timestampSet bool
+	// filtered is true if statements of the current transaction were
+	// filtered out.
+	var filtered bool
+	// logPositions is only kept if StatementLogPositions is true. It has
+	// the LogPosition of each of statements, and logPos is the one of the
+	// current event. logFile comes from the last ROTATE_EVENT, and
+	// pendingRotate is a ROTATE_EVENT we can't parse until we get the
+	// FORMAT_DESCRIPTION_EVENT. They are also kept for filePos.
+	var logPositions []LogPosition
+	var logPos LogPosition
+	var logFile string
+	var pendingRotate replication.BinlogEvent
+	// querySets has the SET statements of the INTVAR_EVENTs and RAND_EVENTs
+	// since the last QUERY_EVENT, and querySetPositions their LogPositions.
+	// Like in mysqld, they only apply to the next query, so they are dropped
+	// if that query is skipped.
+	var querySets []*binlogdatapb.BinlogTransaction_Statement
+	var querySetPositions []LogPosition
+	// rdsTables and otherTables tell whether the current transaction had
+	// TABLE_MAP_EVENTs for RDS management tables, and for other tables.
+	// They are only kept with ProviderRDS.
+	var rdsTables, otherTables bool
+	// lastLogFormat is the LogFormat of the changes of the last transaction
+	// that had some, and logFormatSwitched is true once it switched. See
+	// LogFormatChanged.
+	var lastLogFormat LogFormat
+	var logFormatSwitched bool
+	// statementsLog and rowsLog tell whether the current transaction had
+	// changes logged as QUERY_EVENTs, and as rows events.
+	var statementsLog, rowsLog bool
+	// prepared has the XA transaction branches that were prepared but not
+	// committed or rolled back yet, keyed by XID.
+	prepared := make(map[string]xaBranch)
 	var format replication.BinlogFormat
 	var gtid replication.GTID
 	var pos = bls.startPos
+	// filePos is true if the stream started at binlog coordinates instead
+	// of a GTID. The position is then the end of the last event read, see
+	// replication.FilePosGTID.
+	_, filePos := bls.startPos.GTIDSet.(replication.FilePosGTID)
 	var autocommit = true
+	var stopped bool
 	var err error
 
+	// sentPos is the position of the last transaction sent. It is saved to
+	// the PositionStore at most once per PositionSaveInterval, and savePending
+	// is true while the latest one hasn't been saved yet.
+	var sentPos = bls.startPos
+	var savedAt time.Time
+	var savePending bool
+	// lagging is true if LagChanged was last called with lagging = true.
+	var lagging bool
+	// lastSentAt is the time the last transaction was sent, or zero.
+	var lastSentAt time.Time
+	// lastCommitted and sequenceNumber are the logical clock of the
+	// current transaction, from its GTID_EVENT.
+	var lastCommitted, sequenceNumber int64
+	// originalCommit and immediateCommit are the commit timestamps of the
+	// current transaction in microseconds, from its GTID_EVENT.
+	var originalCommit, immediateCommit int64
+	// beginTimestamp is the timestamp of the event that started the
+	// current transaction, or 0.
+	var beginTimestamp uint32
+	// readAt is when the current event was read, and openedAt when the
+	// event that began the current transaction was.
+	var readAt, openedAt time.Time
+	// skippedQuery is the position of the QUERY_EVENT SkipInvalidQueries
+	// skipped last, until the next events show it wasn't a transaction
+	// boundary, and nil otherwise. skippedInTransaction is true if it was
+	// skipped inside of a transaction.
+	var skippedQuery *replication.Position
+	var skippedInTransaction bool
+	// lastDDL and lastDDLTimestamp are the SQL and the timestamp of the
+	// last DDL sent. They are only kept if DedupDDLWindow is set.
+	var lastDDL string
+	var lastDDLTimestamp uint32
+	// skippedGTID is the GTID of the last transaction skipped because of
+	// SkipGTIDs, so it's only logged and counted once.
+	var skippedGTID replication.GTID
+	// lastCharset is the charset of the last statement added to the current
+	// transaction that had one. It's only kept if SetCharset is set.
+	var lastCharset *binlogdatapb.Charset
+	// invoker is the InvokerAnnotation of the current transaction, or "".
+	var invoker string
+	// lastHeartbeatAt is the time the last heartbeat was sent, or zero.
+	// heartbeatPending is true if a heartbeat was coalesced since then,
+	// with heartbeatTimestamp as its timestamp. It's only kept until the
+	// next event, since the others move the position.
+	var lastHeartbeatAt time.Time
+	var heartbeatPending bool
+	var heartbeatTimestamp uint32
+	// catchUpAt and catchUpTimestamp are the time and the timestamp of
+	// the transaction that started the current window of
+	// binlogStreamerCatchUpRate.
+	var catchUpAt time.Time
+	var catchUpTimestamp uint32
+	// batched is the number of autocommit statements in the current
+	// transaction, which is a batch if BatchAutocommit is set. batchPos,
+	// batchGTID and batchTimestamp are the ones of its last statement, and
+	// batchBegin the timestamp of its first one. batchExpired fires once
+	// BatchAutocommitWindow has passed since the batch started.
+	var batched int
+	var batchPos replication.Position
+	var batchGTID replication.GTID
+	var batchTimestamp, batchBegin uint32
+	var batchTimer *time.Timer
+	var batchExpired <-chan time.Time
+	defer func() {
+		if batchTimer != nil {
+			batchTimer.Stop()
+		}
+	}()
+	savePosition := func(force bool) {
+		if !force && bls.now().Sub(savedAt) < bls.PositionSaveInterval {
+			savePending = true
+			return
+		}
+		if err := bls.PositionStore.Save(sentPos); err != nil {
+			log.Errorf("can't save binlog stream position %v: %v", sentPos, err)
+			binlogStreamerErrors.Add("PositionStore", 1)
+			savePending = true
+			return
+		}
+		savedAt = bls.now()
+		savePending = false
+	}
+	defer func() {
+		if savePending {
+			savePosition(true)
+		}
+		bls.endPositionWaits()
+	}()
+
+	// seq is the sequence number of the next transaction. commit() gives
+	// each transaction it sends its number, in binlog order, and sender
+	// checks they are sent in that order. The sends all happen in this
+	// loop for now, so it guards the ones that will move out of it.
+	var seq int64
+	nextSeq := func() int64 {
+		seq++
+		return seq - 1
+	}
+	send := bls.sendTransaction
+	if bls.SendRetries != 0 {
+		send = bls.retrySend(ctx, send)
+	}
+	sender := newOrderedSender(send)
+
+	// capacity is the number of statements begin() allocates room for.
+	var capacity statementsCapacity
+
 	// A begin can be triggered either by a BEGIN query, or by a GTID_EVENT.
-	begin := func() {
+	// timestamp is the one of that event, unless a GTID_EVENT came first.
+	begin := func(timestamp uint32) {
 		if statements != nil {
 			// If this happened, it would be a legitimate error.
 			log.Errorf("BEGIN in binlog stream while still in another transaction; dropping %d statements: %v", len(statements), statements)
 			binlogStreamerErrors.Add("ParseEvents", 1)
 		}
-		statements = make([]*binlogdatapb.BinlogTransaction_Statement, 0, 10)
+		statements = make([]*binlogdatapb.BinlogTransaction_Statement, 0, capacity.get())
+		statementsSize = 0
+		timestampSet = false
+		filtered = false
+		logPositions = nil
+		if autocommit {
+			openedAt = readAt
+		}
 		autocommit = false
+		if beginTimestamp == 0 {
+			beginTimestamp = timestamp
+		}
 	}
-	// A commit can be triggered either by a COMMIT query, or by an XID_EVENT.
-	// Statements that aren't wrapped in BEGIN/COMMIT are committed immediately.
-	commit := func(timestamp uint32) error {
+	// addStatement adds st to the current transaction. at is the
+	// LogPosition of the event it comes from.
+	addStatement := func(st *binlogdatapb.BinlogTransaction_Statement, at LogPosition) {
+		statementsSize += len(st.Sql)
+		if bls.StatementLogPositions {
+			logPositions = append(logPositions, at)
+		}
+		statements = append(statements, st)
+	}
+	// sendStatements sends statements, whose SQL has size bytes, as the
+	// transaction number seq with the GTID id, which is nil for the parts
+	// of a split transaction but the last one. single is true if it's an
+	// autocommit statement or a DDL, which BeginCommitTransactions doesn't
+	// wrap.
+	sendStatements := func(seq int64, statements []*binlogdatapb.BinlogTransaction_Statement, size int, logPositions []LogPosition, single bool, id replication.GTID, timestamp uint32) error {
+		// The COMMIT of a part is at its last statement.
+		commitPos := logPos
+		if id == nil && len(logPositions) != 0 {
+			commitPos = logPositions[len(logPositions)-1]
+		}
+		if len(statements) > 0 && (bls.BeginCommit == BeginCommitAll || (bls.BeginCommit == BeginCommitTransactions && !single)) {
+			wrapped := make([]*binlogdatapb.BinlogTransaction_Statement, 0, len(statements)+2)
+			wrapped = append(wrapped, &binlogdatapb.BinlogTransaction_Statement{
+				Category: binlogdatapb.BinlogTransaction_Statement_BL_BEGIN,
+				Sql:      "BEGIN",
+			})
+			wrapped = append(wrapped, statements...)
+			statements = append(wrapped, &binlogdatapb.BinlogTransaction_Statement{
+				Category: binlogdatapb.BinlogTransaction_Statement_BL_COMMIT,
+				Sql:      "COMMIT",
+			})
+			size += len("BEGIN") + len("COMMIT")
+			if bls.StatementLogPositions {
+				wrappedPositions := make([]LogPosition, 0, len(logPositions)+2)
+				wrappedPositions = append(wrappedPositions, logPositions[0])
+				wrappedPositions = append(wrappedPositions, logPositions...)
+				logPositions = append(wrappedPositions, commitPos)
+			}
+		}
 		trans := &binlogdatapb.BinlogTransaction{
 			Statements:    statements,
 			Timestamp:     int64(timestamp),
-			TransactionId: replication.EncodeGTID(gtid),
+			TransactionId: replication.EncodeGTID(id),
+		}
+		if bls.SendMetadata != nil {
+			started := beginTimestamp
+			if (autocommit && batched == 0) || started == 0 {
+				started = timestamp
+			}
+			md := TransactionMetadata{
+				Statements:               len(statements),
+				Size:                     size,
+				LogPositions:             logPositions,
+				ChecksumAlgorithm:        format.ChecksumAlgorithm,
+				Filtered:                 filtered && len(statements) == 0,
+				LastCommitted:            lastCommitted,
+				SequenceNumber:           sequenceNumber,
+				BeginTimestamp:           int64(started),
+				CommitTimestamp:          int64(timestamp),
+				OriginalCommitTimestamp:  originalCommit,
+				ImmediateCommitTimestamp: immediateCommit,
+				LogFormat:                newLogFormat(statementsLog, rowsLog),
+				DDLAlgorithms:            ddlAlgorithms(statements),
+			}
+			if len(bls.Annotations) != 0 || invoker != "" {
+				md.Annotations = make(map[string]string, len(bls.Annotations)+1)
+				for k, v := range bls.Annotations {
+					md.Annotations[k] = v
+				}
+				if invoker != "" {
+					md.Annotations[InvokerAnnotation] = invoker
+				}
+			}
+			if bls.StatementChecksums {
+				md.Checksums = make([]uint32, len(statements))
+				for i, st := range statements {
+					md.Checksums[i] = StatementChecksum(st)
+				}
+			}
+			bls.SendMetadata(trans, md)
+		}
+		span := trace.Span(noSpan{})
+		if bls.TraceTransactions {
+			span = bls.newSpan(nil)
+			span.StartLocal("Streamer.SendTransaction")
+			span.Annotate("db", bls.dbname)
+			span.Annotate("gtid", trans.TransactionId)
+			span.Annotate("statements", len(statements))
+			span.Annotate("size", size)
 		}
-		if err = bls.sendTransaction(trans); err != nil {
+		err := sender.sendInOrder(seq, trans)
+		if err != nil {
+			span.Annotate("error", err.Error())
+		}
+		span.Finish()
+		if err != nil {
 			if err == io.EOF {
 				return ErrClientEOF
 			}
 			return fmt.Errorf("send reply error: %v", err)
 		}
+		binlogStreamerTransactions.Add(1)
+		return nil
+	}
+	// resetTransaction drops the statements of the current transaction, and
+	// the state they set. With end, it also ends the transaction, so the
+	// state of its BEGIN and its GTID_EVENT doesn't carry over to the next
+	// one. A ROLLBACK doesn't end it, since it still needs that state to
+	// commit() an empty transaction.
+	resetTransaction := func(end bool) {
 		statements = nil
+		statementsSize = 0
+		timestampSet = false
+		filtered = false
+		logPositions = nil
+		statementsLog, rowsLog = false, false
+		if !end {
+			return
+		}
 		autocommit = true
+		binlogStreamerOpenTransactionSeconds.Set(0)
+		if skippedInTransaction {
+			skippedQuery = nil
+		}
+		// SETs that weren't followed by their query don't carry over to the
+		// next transaction.
+		querySets, querySetPositions = nil, nil
+		rdsTables, otherTables = false, false
+		lastCommitted, sequenceNumber = 0, 0
+		originalCommit, immediateCommit = 0, 0
+		beginTimestamp = 0
+		lastCharset = nil
+		invoker = ""
+	}
+	// A commit can be triggered either by a COMMIT query, or by an XID_EVENT.
+	// Statements that aren't wrapped in BEGIN/COMMIT are committed immediately.
+	commit := func(timestamp uint32) error {
+		if !autocommit {
+			capacity.record(len(statements))
+		}
+		if changes := newLogFormat(statementsLog && hasDML(statements), rowsLog); changes != LogFormatNone {
+			if lastLogFormat != LogFormatNone && changes != lastLogFormat {
+				binlogStreamerLogFormatSwitches.Add(1)
+				if !logFormatSwitched {
+					log.Warningf("binlog stream changes switched from log format %v to %v @ %v, the next switches are only counted in BinlogStreamerLogFormatSwitches", lastLogFormat, changes, pos)
+					logFormatSwitched = true
+				}
+				if bls.LogFormatChanged != nil {
+					bls.LogFormatChanged(lastLogFormat, changes)
+				}
+			}
+			lastLogFormat = changes
+		}
+		single := autocommit
+		// A duplicate was sent by a previous Streamer of the consumer.
+		duplicate := bls.Dedup != nil && bls.Dedup.sent(gtid)
+		if duplicate {
+			binlogStreamerDuplicates.Add(1)
+		}
+		if bls.IsolateDDL && !duplicate {
+			// The parts before the last one don't advance the position,
+			// so the transaction is sent again if the stream restarts
+			// before its end.
+			parts := splitDDL(statements, logPositions)
+			for _, part := range parts[:len(parts)-1] {
+				size := 0
+				for _, st := range part.statements {
+					size += len(st.Sql)
+				}
+				if err := sendStatements(nextSeq(), part.statements, size, part.logPositions, part.ddl, nil, timestamp); err != nil {
+					return err
+				}
+				statementsSize -= size
+			}
+			last := parts[len(parts)-1]
+			statements, logPositions = last.statements, last.logPositions
+			single = single || last.ddl
+		}
+		// A suppressed transaction only advances the position.
+		suppressed := !duplicate && bls.SuppressEmptyTransactions && len(statements) == 0
+		if suppressed {
+			binlogStreamerSuppressedTransactions.Add(1)
+		}
+		if !duplicate && !suppressed {
+			if err := sendStatements(nextSeq(), statements, statementsSize, logPositions, single, gtid, timestamp); err != nil {
+				return err
+			}
+			sentAt := bls.now()
+			if !lastSentAt.IsZero() {
+				binlogStreamerTransactionIntervals.Add(int64(sentAt.Sub(lastSentAt) / time.Microsecond))
+			}
+			lastSentAt = sentAt
+			if timestamp != 0 {
+				switch elapsed := sentAt.Sub(catchUpAt); {
+				case catchUpAt.IsZero():
+					catchUpAt, catchUpTimestamp = sentAt, timestamp
+				case elapsed >= catchUpRateWindow:
+					binlogStreamerCatchUpRate.Set(float64(int64(timestamp)-int64(catchUpTimestamp)) / elapsed.Seconds())
+					catchUpAt, catchUpTimestamp = sentAt, timestamp
+				}
+			}
+			if bls.DatabaseStats {
+				binlogStreamerDatabaseTransactions.Add(bls.dbname, 1)
+				binlogStreamerDatabaseStatements.Add(bls.dbname, int64(len(statements)))
+			}
+		}
+		if bls.Dedup != nil && !duplicate {
+			bls.Dedup.add(gtid)
+		}
+		if bls.PositionHistorySize != 0 {
+			bls.addPositionSample(PositionSample{
+				Time:      bls.now(),
+				Timestamp: timestamp,
+				Position:  pos,
+			})
+		}
+		bls.setCommittedPosition(pos)
+		sentPos = pos
+		if bls.PositionStore != nil {
+			savePosition(false)
+		}
+		if timestamp != 0 || originalCommit != 0 {
+			now := bls.now()
+			lagDuration := time.Duration(now.Unix()-int64(timestamp)) * time.Second
+			if originalCommit != 0 {
+				lagDuration = now.Sub(time.Unix(0, originalCommit*int64(time.Microsecond)))
+			}
+			binlogStreamerSecondsBehindMaster.Set(int64(lagDuration / time.Second))
+			if bls.LagChanged != nil {
+				switch {
+				case !lagging && lagDuration > bls.LagThreshold:
+					lagging = true
+					bls.LagChanged(true, lagDuration)
+				case lagging && lagDuration < bls.LagThreshold-bls.LagHysteresis:
+					lagging = false
+					bls.LagChanged(false, lagDuration)
+				}
+			}
+		}
+		resetTransaction(true)
+		heartbeatPending = false
 		return nil
 	}
+	// flushBatch sends the batch of autocommit statements, if any. It can
+	// be called in the middle of the events of the next transaction or
+	// statement, so it keeps their position and pending state.
+	flushBatch := func() error {
+		if batched == 0 {
+			return nil
+		}
+		if batchTimer != nil {
+			batchTimer.Stop()
+			batchTimer, batchExpired = nil, nil
+		}
+		curPos, curGTID := pos, gtid
+		curSets, curSetPositions := querySets, querySetPositions
+		curRDSTables, curOtherTables := rdsTables, otherTables
+		curLastCommitted, curSequenceNumber, curBeginTimestamp := lastCommitted, sequenceNumber, beginTimestamp
+		curOriginalCommit, curImmediateCommit := originalCommit, immediateCommit
+		pos, gtid = batchPos, batchGTID
+		lastCommitted, sequenceNumber, beginTimestamp = 0, 0, batchBegin
+		originalCommit, immediateCommit = 0, 0
+		err := commit(batchTimestamp)
+		batched = 0
+		pos, gtid = curPos, curGTID
+		querySets, querySetPositions = curSets, curSetPositions
+		rdsTables, otherTables = curRDSTables, curOtherTables
+		lastCommitted, sequenceNumber, beginTimestamp = curLastCommitted, curSequenceNumber, curBeginTimestamp
+		originalCommit, immediateCommit = curOriginalCommit, curImmediateCommit
+		return err
+	}
+	// commitAutocommit ends an autocommit statement, whose event has the
+	// given timestamp. It's committed on its own, unless BatchAutocommit
+	// is set and the batch isn't full yet.
+	commitAutocommit := func(timestamp uint32) error {
+		if bls.BatchAutocommit == 0 {
+			return commit(timestamp)
+		}
+		if batched == 0 {
+			batchBegin = timestamp
+			if bls.BatchAutocommitWindow != 0 {
+				batchTimer = time.NewTimer(bls.BatchAutocommitWindow)
+				batchExpired = batchTimer.C
+			}
+		}
+		batched++
+		batchPos, batchGTID, batchTimestamp = pos, gtid, timestamp
+		// The state of the statement doesn't carry over to the next one,
+		// like after a commit.
+		timestampSet = false
+		querySets, querySetPositions = nil, nil
+		rdsTables, otherTables = false, false
+		lastCommitted, sequenceNumber = 0, 0
+		originalCommit, immediateCommit = 0, 0
+		beginTimestamp = 0
+		if batched >= bls.BatchAutocommit || (bls.BatchAutocommitSize != 0 && statementsSize >= bls.BatchAutocommitSize) {
+			return flushBatch()
+		}
+		return nil
+	}
+	// sendHeartbeat sends the empty transaction of a heartbeat.
+	sendHeartbeat := func(timestamp uint32) error {
+		lastHeartbeatAt = bls.now()
+		binlogStreamerHeartbeats.Add("Sent", 1)
+		return commit(timestamp)
+	}
+	// flushPending sends what's pending when the stream ends: the batch of
+	// autocommit statements, and the last coalesced heartbeat.
+	flushPending := func() error {
+		if err := flushBatch(); err != nil {
+			return err
+		}
+		if heartbeatPending {
+			return sendHeartbeat(heartbeatTimestamp)
+		}
+		return nil
+	}
+
+	// expired fires once the stream has run for MaxDuration. It's then set
+	// to nil, and the stream ends at the next transaction boundary.
+	var expired <-chan time.Time
+	var maxDurationReached bool
+	if bls.MaxDuration != 0 {
+		timer := time.NewTimer(bls.MaxDuration)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	// filter is only replaced outside of a transaction, so SetFilter()
+	// doesn't split a transaction between two filters.
+	filter := bls.currentFilter()
+	var systemSchemas *systemSchemaFilter
+	if bls.SkipSystemSchemas {
+		systemSchemas = newSystemSchemaFilter(bls.SystemSchemas)
+	}
 
 	// Parse events.
 	for ctx.IsRunning() {
+		// Outside of a transaction, pos is sentPos unless a GTID_EVENT came
+		// before a BEGIN.
+		if maxDurationReached && batched != 0 {
+			if err = flushBatch(); err != nil {
+				return pos, err
+			}
+		}
+		if maxDurationReached && autocommit && pos.Equal(sentPos) {
+			log.Infof("stopping binlog stream after MaxDuration (%v)", bls.MaxDuration)
+			return pos, nil
+		}
+		if !bls.waitWhilePaused(ctx) {
+			log.Infof("stopping early due to binlog Streamer service shutdown while paused")
+			return pos, flushPending()
+		}
+		if autocommit {
+			filter = bls.currentFilter()
+		}
+
 		var ev replication.BinlogEvent
 		var ok bool
 
@@ -185,27 +1839,121 @@ func (bls *Streamer) parseEvents(ctx *sync2.ServiceContext, events <-chan replic
 		case ev, ok = <-events:
 			if !ok {
 				// events channel has been closed, which means the connection died.
+				// The statements of the batch are complete, so they are sent.
+				if err = flushPending(); err != nil {
+					return pos, err
+				}
+				if stopped {
+					log.Infof("reached end of binlog event stream after mysqld was shut down")
+					return pos, ErrServerStopped
+				}
+				if r, ok := bls.conn.(dumpErrorReporter); ok {
+					if dumpErr := r.DumpError(); dumpErr != nil {
+						log.Infof("binlog event stream ended with an error: %v", dumpErr)
+						binlogStreamerErrors.Add("Server", 1)
+						return pos, &ServerError{Position: pos, Err: dumpErr}
+					}
+				}
 				log.Infof("reached end of binlog event stream")
 				return pos, ErrServerEOF
 			}
 		case <-ctx.ShuttingDown:
 			log.Infof("stopping early due to binlog Streamer service shutdown")
-			return pos, nil
+			return pos, flushPending()
+		case <-expired:
+			maxDurationReached = true
+			expired = nil
+			continue
+		case <-batchExpired:
+			if err = flushBatch(); err != nil {
+				return pos, err
+			}
+			continue
 		}
 
+		// Reject events that are too large before anything tries to read
+		// them, so a max_allowed_packet mismatch isn't reported as garbage.
+		if err := bls.checkEventLength(ev); err != nil {
+			binlogStreamerErrors.Add("EventLength", 1)
+			return pos, err
+		}
+		length := int64(ev.Length())
+		bls.bytesRead.Add(length)
+		binlogStreamerBytesRead.Add(length)
 		// Validate the buffer before reading fields from it.
 		if !ev.IsValid() {
 			return pos, fmt.Errorf("can't parse binlog event, invalid data: %#v", ev)
 		}
+		eventType := getEventType(ev)
+		binlogStreamerEvents.Add(eventType, 1)
+		if bls.DatabaseStats {
+			binlogStreamerDatabaseEvents.Add([]string{bls.dbname, eventType}, 1)
+		}
+		if bls.StatementLogPositions {
+			logPos = LogPosition{}
+			if next := ev.NextPosition(); next != 0 {
+				logPos = LogPosition{File: logFile, Offset: next - ev.Length()}
+			}
+		}
+
+		readAt = bls.now()
+		if ev.Type() != heartbeatLogEvent {
+			bls.eventAt.Set(readAt.UnixNano())
+			heartbeatPending = false
+		} else {
+			bls.heartbeatAt.Set(readAt.UnixNano())
+		}
+		if !autocommit {
+			age := readAt.Sub(openedAt)
+			binlogStreamerOpenTransactionSeconds.Set(int64(age / time.Second))
+			if bls.MaxOpenTransactionDuration != 0 && age > bls.MaxOpenTransactionDuration {
+				binlogStreamerErrors.Add("OpenTransaction", 1)
+				return pos, &OpenTransactionError{Position: pos, Duration: age, Statements: len(statements)}
+			}
+		}
+
+		// A STOP_EVENT is written when mysqld shuts down cleanly. When we're
+		// reading older binlogs, it will be followed by the events of the next
+		// file. Otherwise, the connection is about to be closed.
+		stopped = ev.IsStop()
+		if stopped {
+			log.Infof("received STOP_EVENT, mysqld was shut down cleanly")
+			continue
+		}
 
 		// We need to keep checking for FORMAT_DESCRIPTION_EVENT even after we've
 		// seen one, because another one might come along (e.g. on log rotate due to
 		// binlog settings change) that changes the format.
 		if ev.IsFormatDescription() {
-			format, err = ev.Format()
+			newFormat, err := ev.Format()
 			if err != nil {
 				return pos, fmt.Errorf("can't parse FORMAT_DESCRIPTION_EVENT: %v, event data: %#v", err, ev)
 			}
+			if !format.IsZero() && newFormat != format {
+				log.Warningf("binlog format changed from %+v to %+v", format, newFormat)
+				binlogStreamerFormatChanges.Add(1)
+				if bls.FormatChanged != nil {
+					bls.FormatChanged(format, newFormat)
+				}
+			}
+			if format.IsZero() && pendingRotate == nil && (bls.StatementLogPositions || filePos) {
+				// A stream, or a file, can also start with the
+				// FORMAT_DESCRIPTION_EVENT. The file is then unknown
+				// until the next ROTATE_EVENT.
+				log.Warningf("binlog stream starts with a FORMAT_DESCRIPTION_EVENT without a ROTATE_EVENT, the binlog file is unknown until the next one")
+			}
+			format = newFormat
+			bls.setFormat(format)
+			if pendingRotate != nil {
+				// The artificial ROTATE_EVENT mysqld sends first always
+				// has a v4 header.
+				rotateFormat := format
+				rotateFormat.HeaderLength = 19
+				if logFile, err = rotateFile(pendingRotate, rotateFormat); err != nil {
+					return pos, err
+				}
+				pendingRotate = nil
+			}
 			continue
 		}
 
@@ -214,11 +1962,28 @@ func (bls *Streamer) parseEvents(ctx *sync2.ServiceContext, events <-chan replic
 		if format.IsZero() {
 			// The only thing that should come before the FORMAT_DESCRIPTION_EVENT
 			// is a fake ROTATE_EVENT, which the master sends to tell us the name
-			// of the current log file.
+			// of the current log file. There may be none, e.g. in a file that
+			// starts with the FORMAT_DESCRIPTION_EVENT: logFile is then empty.
 			if ev.IsRotate() {
+				if bls.StatementLogPositions || filePos {
+					pendingRotate = ev
+				}
 				continue
 			}
-			return pos, fmt.Errorf("got a real event before FORMAT_DESCRIPTION_EVENT: %#v", ev)
+			evType := getEventType(ev)
+			if bls.TolerateBeforeFormat[evType] {
+				log.Warningf("ignoring %v event before FORMAT_DESCRIPTION_EVENT: %#v", evType, ev)
+				binlogStreamerPreFormatEvents.Add(evType, 1)
+				continue
+			}
+			return pos, fmt.Errorf("got a real event before FORMAT_DESCRIPTION_EVENT: %#v (type %v)", ev, evType)
+		}
+
+		// A ROTATE_EVENT tells us the name of the next binlog file.
+		if (bls.StatementLogPositions || filePos) && ev.IsRotate() {
+			if logFile, err = rotateFile(ev, format); err != nil {
+				return pos, err
+			}
 		}
 
 		// Strip the checksum, if any. We don't actually verify the checksum, so discard it.
@@ -227,6 +1992,16 @@ func (bls *Streamer) parseEvents(ctx *sync2.ServiceContext, events <-chan replic
 			return pos, fmt.Errorf("can't strip checksum from binlog event: %v, event data: %#v", err, ev)
 		}
 
+		// A GTID_EVENT starts a new transaction. If the current one began,
+		// but got neither statements nor a COMMIT, like an empty transaction
+		// that only has a GTID, it's committed first with its own GTID, so
+		// the position advances past it.
+		if ev.IsGTID() && !autocommit && len(statements) == 0 {
+			if err = commit(beginTimestamp); err != nil {
+				return pos, err
+			}
+		}
+
 		// Update the GTID if the event has one. The actual event type could be
 		// something special like GTID_EVENT (MariaDB, MySQL 5.6), or it could be
 		// an arbitrary event with a GTID in the header (Google MySQL).
@@ -235,59 +2010,303 @@ func (bls *Streamer) parseEvents(ctx *sync2.ServiceContext, events <-chan replic
 			if err != nil {
 				return pos, fmt.Errorf("can't get GTID from binlog event: %v, event data: %#v", err, ev)
 			}
-			pos = replication.AppendGTID(pos, gtid)
+			if bls.SkipGTIDs[gtid] && gtid != skippedGTID {
+				log.Warningf("SKIPPING transaction %v of the binlog stream: all its statements are dropped, because its GTID is in SkipGTIDs", gtid)
+				binlogStreamerSkippedGTIDs.Add(1)
+				skippedGTID = gtid
+			}
+			newPos := replication.AppendGTID(pos, gtid)
+			if bls.PositionObserver != nil && !newPos.Equal(pos) {
+				bls.PositionObserver(newPos, gtid, ev.Timestamp())
+			}
+			pos = newPos
+		}
+		// The ROTATE_EVENT at the end of a binlog file is in that file, but
+		// logFile is already the next one, so it doesn't move the position.
+		// Neither does a HEARTBEAT_LOG_EVENT, which isn't in the binlog.
+		if filePos && !ev.IsRotate() && ev.Type() != heartbeatLogEvent && logFile != "" {
+			if next := ev.NextPosition(); next != 0 {
+				filePosGTID := replication.FilePosGTID{File: logFile, Pos: next}
+				newPos := replication.Position{GTIDSet: filePosGTID}
+				if bls.PositionObserver != nil && !newPos.Equal(pos) {
+					bls.PositionObserver(newPos, filePosGTID, ev.Timestamp())
+				}
+				gtid, pos = filePosGTID, newPos
+			}
+		}
+
+		sev, err := decodeEvent(ev, format)
+		if err != nil {
+			if !bls.SkipInvalidQueries || !ev.IsQuery() {
+				return pos, err
+			}
+			if skippedQuery != nil && !skippedInTransaction {
+				return pos, fmt.Errorf("can't skip QUERY_EVENT @ %v, the one skipped @ %v may have been a transaction boundary: %v", pos, *skippedQuery, err)
+			}
+			log.Errorf("skipping QUERY_EVENT that can't be decoded @ %v: %v", pos, err)
+			binlogStreamerErrors.Add("SkippedQuery", 1)
+			if skippedQuery == nil {
+				skippedPos := pos
+				skippedQuery, skippedInTransaction = &skippedPos, !autocommit
+			}
+			querySets, querySetPositions = nil, nil
+			if bls.sendTransaction != nil && autocommit {
+				// Commit an empty transaction, so the position still advances.
+				if err = commitAutocommit(ev.Timestamp()); err != nil {
+					return pos, err
+				}
+			}
+			continue
+		}
+		// A skipped QUERY_EVENT may have been the BEGIN, the COMMIT or the
+		// ROLLBACK of a transaction. Outside of a transaction, only the
+		// beginning of the next one shows it wasn't a BEGIN. Inside of one,
+		// its end shows it wasn't its COMMIT or ROLLBACK, but the beginning
+		// of another one shows it was.
+		if skippedQuery != nil && ev.Type() != heartbeatLogEvent && !ev.IsRotate() {
+			begins := ev.IsGTID() || (ev.IsQuery() && getStatementCategory(sev.Query.SQL) == binlogdatapb.BinlogTransaction_Statement_BL_BEGIN)
+			switch {
+			case !skippedInTransaction && !begins:
+				return pos, fmt.Errorf("the QUERY_EVENT skipped @ %v may have been a BEGIN, since %v event @ %v doesn't begin a transaction", *skippedQuery, getEventType(ev), pos)
+			case !skippedInTransaction:
+				skippedQuery = nil
+			case begins && !autocommit:
+				return pos, fmt.Errorf("the QUERY_EVENT skipped @ %v may have been a COMMIT or a ROLLBACK, since %v event @ %v begins a transaction before the end of the current one", *skippedQuery, getEventType(ev), pos)
+			}
+		}
+		sev.Position = pos
+		if ev.HasGTID(format) {
+			sev.GTID = gtid
+		}
+		if bls.SendEvent != nil {
+			if err = bls.SendEvent(sev); err != nil {
+				if err == io.EOF {
+					return pos, ErrClientEOF
+				}
+				return pos, fmt.Errorf("send event error: %v", err)
+			}
+		}
+		if ev.IsIncident() {
+			incident := &ReplicationIncidentError{Type: sev.IncidentType, Message: sev.IncidentMessage}
+			binlogStreamerErrors.Add("Incident", 1)
+			if !bls.IgnoreIncidents {
+				return pos, incident
+			}
+			log.Warningf("ignoring %v", incident)
+		}
+		// rdsTableMap is true if ev is the TABLE_MAP_EVENT of an RDS
+		// management table.
+		var rdsTableMap bool
+		if (bls.TableMapObserver != nil || bls.Provider == ProviderRDS) && ev.IsTableMap() {
+			tm, err := ev.TableMap(format)
+			if err != nil {
+				return pos, fmt.Errorf("can't parse TABLE_MAP_EVENT: %v, event data: %#v", err, ev)
+			}
+			if bls.Provider == ProviderRDS {
+				if tm.Database == "mysql" && strings.HasPrefix(tm.Name, "rds_") {
+					rdsTables = true
+					rdsTableMap = true
+				} else {
+					otherTables = true
+				}
+			}
+			if bls.TableMapObserver != nil && tm.Database == filter.Database {
+				bls.TableMapObserver(&tm)
+			}
+		}
+		// The rows events of RDS management tables are skipped, see
+		// ProviderRDS.
+		rdsRows := rdsTables && !otherTables && rowsEventTypes[ev.Type()]
+		if rowsEventTypes[ev.Type()] && !rdsRows {
+			rowsLog = true
+		}
+		if bls.AssertStatementBased && ((ev.IsTableMap() && !rdsTableMap) || (rowsEventTypes[ev.Type()] && !rdsRows)) && !bls.SkipGTIDs[gtid] {
+			binlogStreamerErrors.Add("RowBasedEvent", 1)
+			return pos, &RowBasedEventError{Type: ev.Type(), Position: pos}
+		}
+		if bls.StrictEvents && sev.Type == "Other" && !ignoredEventTypes[ev.Type()] && !rdsRows && !bls.SkipGTIDs[gtid] {
+			binlogStreamerErrors.Add("UnsupportedEvent", 1)
+			return pos, &UnsupportedEventError{Type: ev.Type()}
+		}
+		if bls.sendTransaction == nil {
+			// Only the ungrouped events are wanted.
+			continue
+		}
+		if batched != 0 && (isTransactionBoundary(ev, sev) || (bls.BatchAutocommitSameTimestamp && ev.IsQuery() && ev.Timestamp() != batchTimestamp)) {
+			if err = flushBatch(); err != nil {
+				return pos, err
+			}
 		}
 
 		switch {
+		case ev.Type() == heartbeatLogEvent: // HEARTBEAT_LOG_EVENT
+			if bls.HeartbeatInterval == 0 || !autocommit || batched != 0 {
+				continue
+			}
+			if !lastHeartbeatAt.IsZero() && bls.now().Sub(lastHeartbeatAt) < bls.HeartbeatInterval {
+				heartbeatPending, heartbeatTimestamp = true, ev.Timestamp()
+				binlogStreamerHeartbeats.Add("Coalesced", 1)
+				continue
+			}
+			if err = sendHeartbeat(ev.Timestamp()); err != nil {
+				return pos, err
+			}
 		case ev.IsGTID(): // GTID_EVENT
-			if ev.IsBeginGTID(format) {
-				begin()
+			lastCommitted, sequenceNumber = sev.LastCommitted, sev.SequenceNumber
+			originalCommit, immediateCommit = sev.OriginalCommitTimestamp, sev.ImmediateCommitTimestamp
+			beginTimestamp = ev.Timestamp()
+			if sev.BeginGTID {
+				begin(ev.Timestamp())
 			}
 		case ev.IsXID(): // XID_EVENT (equivalent to COMMIT)
 			if err = commit(ev.Timestamp()); err != nil {
 				return pos, err
 			}
-		case ev.IsIntVar(): // INTVAR_EVENT
-			name, value, err := ev.IntVar(format)
-			if err != nil {
-				return pos, fmt.Errorf("can't parse INTVAR_EVENT: %v, event data: %#v", err, ev)
+		case ev.IsXAPrepare(): // XA_PREPARE_LOG_EVENT
+			if sev.XAPrepare.OnePhase {
+				if err = commit(ev.Timestamp()); err != nil {
+					return pos, err
+				}
+				continue
 			}
-			statements = append(statements, &binlogdatapb.BinlogTransaction_Statement{
+			// The branch is sent when an XA COMMIT in a later transaction
+			// commits it.
+			prepared[sev.XAPrepare.XID()] = xaBranch{
+				statements:     statements,
+				statementsSize: statementsSize,
+				logPositions:   logPositions,
+				filtered:       filtered,
+				beginTimestamp: beginTimestamp,
+				statementsLog:  statementsLog,
+				rowsLog:        rowsLog,
+				invoker:        invoker,
+			}
+			resetTransaction(true)
+		case ev.IsIntVar(): // INTVAR_EVENT
+			// This is the same statement mysqlbinlog prints: INSERT_ID is the
+			// next auto-increment value, LAST_INSERT_ID the value returned
+			// by LAST_INSERT_ID() in the query.
+			querySets = append(querySets, &binlogdatapb.BinlogTransaction_Statement{
 				Category: binlogdatapb.BinlogTransaction_Statement_BL_SET,
-				Sql:      fmt.Sprintf("SET %s=%d", name, value),
+				Sql:      fmt.Sprintf("SET %s=%d", sev.IntVarName, sev.IntVarValue),
 			})
+			querySetPositions = append(querySetPositions, logPos)
 		case ev.IsRand(): // RAND_EVENT
-			seed1, seed2, err := ev.Rand(format)
-			if err != nil {
-				return pos, fmt.Errorf("can't parse RAND_EVENT: %v, event data: %#v", err, ev)
-			}
-			statements = append(statements, &binlogdatapb.BinlogTransaction_Statement{
+			querySets = append(querySets, &binlogdatapb.BinlogTransaction_Statement{
 				Category: binlogdatapb.BinlogTransaction_Statement_BL_SET,
-				Sql:      fmt.Sprintf("SET @@RAND_SEED1=%d, @@RAND_SEED2=%d", seed1, seed2),
+				Sql:      fmt.Sprintf("SET @@RAND_SEED1=%d, @@RAND_SEED2=%d", sev.RandSeed1, sev.RandSeed2),
 			})
+			querySetPositions = append(querySetPositions, logPos)
 		case ev.IsQuery(): // QUERY_EVENT
-			// Extract the query string and group into transactions.
-			q, err := ev.Query(format)
-			if err != nil {
-				return pos, fmt.Errorf("can't get query from binlog event: %v, event data: %#v", err, ev)
+			// The pending SETs are only added if this query is.
+			sets, setPositions := querySets, querySetPositions
+			querySets, querySetPositions = nil, nil
+
+			// Group the query strings into transactions.
+			q := sev.Query
+			if verb, xid, ok := parseXAStatement(q.SQL); ok {
+				switch verb {
+				case "START", "BEGIN":
+					begin(ev.Timestamp())
+				case "COMMIT":
+					branch, ok := prepared[xid]
+					if !ok {
+						// It was prepared before the start of the stream.
+						log.Warningf("XA COMMIT of a branch that wasn't prepared in this stream, sending an empty transaction instead: %v", q.SQL)
+						binlogStreamerErrors.Add("XACommit", 1)
+					}
+					delete(prepared, xid)
+					statements = branch.statements
+					statementsSize = branch.statementsSize
+					logPositions = branch.logPositions
+					filtered = branch.filtered
+					beginTimestamp = branch.beginTimestamp
+					statementsLog, rowsLog = branch.statementsLog, branch.rowsLog
+					invoker = branch.invoker
+					autocommit = branch.statements == nil
+					if err = commit(ev.Timestamp()); err != nil {
+						return pos, err
+					}
+				case "ROLLBACK":
+					// Like for a ROLLBACK, an empty transaction is sent so the
+					// position advances.
+					delete(prepared, xid)
+					if err = commit(ev.Timestamp()); err != nil {
+						return pos, err
+					}
+				}
+				// XA END is followed by the XA_PREPARE_LOG_EVENT, which
+				// ends the branch.
+				continue
 			}
 			switch cat := getStatementCategory(q.SQL); cat {
 			case binlogdatapb.BinlogTransaction_Statement_BL_BEGIN:
-				begin()
+				begin(ev.Timestamp())
 			case binlogdatapb.BinlogTransaction_Statement_BL_ROLLBACK:
 				// Rollbacks are possible under some circumstances. Since the stream
 				// client keeps track of its replication position by updating the set
 				// of GTIDs it's seen, we must commit an empty transaction so the client
 				// can update its position.
-				statements = nil
+				resetTransaction(false)
 				fallthrough
 			case binlogdatapb.BinlogTransaction_Statement_BL_COMMIT:
 				if err = commit(ev.Timestamp()); err != nil {
 					return pos, err
 				}
 			default: // BL_DDL, BL_DML, BL_SET, BL_UNRECOGNIZED
-				if q.Database != "" && q.Database != bls.dbname {
-					// Skip cross-db statements.
+				if q.Database != "" && q.Database != filter.Database {
+					// Skip cross-db statements. Outside of a transaction,
+					// nothing is sent for them.
+					if !autocommit {
+						filtered = true
+					}
+					continue
+				}
+				if bls.SkipMasterErrors && q.ErrorCode != 0 {
+					log.Warningf("skipping statement that failed on the master with error code %v: %v", q.ErrorCode, q.SQL)
+					// It's dropped like the statements of DropStatements.
+					filtered = true
+					if autocommit {
+						if err = commitAutocommit(ev.Timestamp()); err != nil {
+							return pos, err
+						}
+					}
+					continue
+				}
+				if strings.TrimSpace(q.SQL) == "" {
+					// Some internal server operations log QUERY_EVENTs without
+					// a statement, which consumers can't execute.
+					binlogStreamerEmptyQueries.Add(1)
+					if autocommit {
+						if err = commitAutocommit(ev.Timestamp()); err != nil {
+							return pos, err
+						}
+					}
+					continue
+				}
+				statementsLog = true
+				drop := filter.isDropped(cat, q.SQL) ||
+					(bls.Provider == ProviderRDS && rdsManagementStatement.MatchString(q.SQL)) ||
+					(bls.DDLOnly && cat != binlogdatapb.BinlogTransaction_Statement_BL_DDL) ||
+					bls.SkipGTIDs[gtid]
+				if !drop && systemSchemas != nil && systemSchemas.matches(q.SQL) {
+					binlogStreamerSystemStatements.Add(1)
+					drop = true
+				}
+				if !drop && bls.DedupDDLWindow != 0 && cat == binlogdatapb.BinlogTransaction_Statement_BL_DDL && q.SQL == lastDDL {
+					if elapsed := int64(ev.Timestamp()) - int64(lastDDLTimestamp); elapsed >= 0 && time.Duration(elapsed)*time.Second <= bls.DedupDDLWindow {
+						log.Infof("dropping DDL identical to the previous one, %v seconds after it: %v", elapsed, q.SQL)
+						binlogStreamerDuplicateDDLs.Add(1)
+						drop = true
+					}
+				}
+				if drop {
+					filtered = true
+					if autocommit {
+						if err = commitAutocommit(ev.Timestamp()); err != nil {
+							return pos, err
+						}
+					}
 					continue
 				}
 				setTimestamp := &binlogdatapb.BinlogTransaction_Statement{
@@ -305,9 +2324,44 @@ func (bls *Streamer) parseEvents(ctx *sync2.ServiceContext, events <-chan replic
 					setTimestamp.Charset = q.Charset
 					statement.Charset = q.Charset
 				}
-				statements = append(statements, setTimestamp, statement)
+				if bls.SetTimestamp == SetTimestampEveryStatement || (bls.SetTimestamp == SetTimestampOncePerTransaction && !timestampSet) {
+					sets = append(sets, setTimestamp)
+					setPositions = append(setPositions, logPos)
+					timestampSet = true
+				}
+				if bls.SetCharset && q.Charset != nil && (lastCharset == nil || *q.Charset != *lastCharset) {
+					sets = append(sets, &binlogdatapb.BinlogTransaction_Statement{
+						Category: binlogdatapb.BinlogTransaction_Statement_BL_SET,
+						Charset:  statement.Charset,
+						Sql:      fmt.Sprintf("SET @@session.character_set_client=%d, @@session.collation_connection=%d, @@session.collation_server=%d", q.Charset.Client, q.Charset.Conn, q.Charset.Server),
+					})
+					setPositions = append(setPositions, logPos)
+					lastCharset = q.Charset
+				}
+				if bls.CoalesceSets && len(sets) > 1 {
+					sets = []*binlogdatapb.BinlogTransaction_Statement{coalesceSets(sets, statement.Charset)}
+					setPositions = setPositions[:1]
+				}
+				if bls.RewriteStatement != nil {
+					statement.Sql = bls.RewriteStatement(cat, q.Database, statement.Sql)
+					if bls.RewriteSets {
+						for _, st := range sets {
+							st.Sql = bls.RewriteStatement(st.Category, q.Database, st.Sql)
+						}
+					}
+				}
+				for i, st := range sets {
+					addStatement(st, setPositions[i])
+				}
+				addStatement(statement, logPos)
+				if invoker == "" && q.InvokerUser != "" {
+					invoker = q.InvokerUser + "@" + q.InvokerHost
+				}
+				if bls.DedupDDLWindow != 0 && cat == binlogdatapb.BinlogTransaction_Statement_BL_DDL {
+					lastDDL, lastDDLTimestamp = q.SQL, ev.Timestamp()
+				}
 				if autocommit {
-					if err = commit(ev.Timestamp()); err != nil {
+					if err = commitAutocommit(ev.Timestamp()); err != nil {
 						return pos, err
 					}
 				}
@@ -317,3 +2371,279 @@ func (bls *Streamer) parseEvents(ctx *sync2.ServiceContext, events <-chan replic
 
 	return pos, nil
 }
+
+// Pause makes the stream stop reading events, and so stop sending
+// transactions, until Resume() is called. The connection to mysqld stays
+// open. A transaction that is partially read is sent after Resume().
+//
+// While paused, mysqld can't send the events to the Streamer, and its
+// dump thread gives up once it has waited longer than net_write_timeout on
+// the master. The stream then ends with an error when it's resumed, so
+// pauses should be kept shorter than that. Pausing a paused stream does
+// nothing. It is safe to call while Stream() is running.
+func (bls *Streamer) Pause() {
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+	if bls.resumed == nil {
+		bls.resumed = make(chan struct{})
+	}
+}
+
+// Resume continues a stream paused by Pause(). Resuming a stream that
+// isn't paused does nothing.
+func (bls *Streamer) Resume() {
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+	if bls.resumed != nil {
+		close(bls.resumed)
+		bls.resumed = nil
+	}
+}
+
+// waitWhilePaused blocks until the stream is resumed, if it's paused. It
+// returns false if the service is shutting down first.
+func (bls *Streamer) waitWhilePaused(ctx *sync2.ServiceContext) bool {
+	bls.mu.Lock()
+	resumed := bls.resumed
+	bls.mu.Unlock()
+	if resumed == nil {
+		return true
+	}
+
+	log.Infof("binlog stream paused")
+	select {
+	case <-resumed:
+		log.Infof("binlog stream resumed")
+		return true
+	case <-ctx.ShuttingDown:
+		return false
+	}
+}
+
+// WaitForPosition blocks until the stream has sent a transaction at or past
+// target, without stopping the stream. It returns an error if the stream
+// ends before reaching target, or if ctx is done first. It is safe to call
+// from multiple goroutines while Stream() is running.
+func (bls *Streamer) WaitForPosition(ctx context.Context, target replication.Position) error {
+	for {
+		bls.mu.Lock()
+		pos := bls.committedPos
+		ended := bls.ended
+		changed := bls.posChanged
+		bls.mu.Unlock()
+
+		if pos.AtLeast(target) {
+			return nil
+		}
+		if ended {
+			return fmt.Errorf("binlog stream ended @ %v before reaching %v", pos, target)
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// transactionPart is a part of a transaction split by splitDDL().
+type transactionPart struct {
+	statements   []*binlogdatapb.BinlogTransaction_Statement
+	logPositions []LogPosition
+	// ddl is true if the part is a DDL, with the SET statements before it.
+	ddl bool
+}
+
+// splitDDL splits the statements of a transaction so each DDL is in a part
+// of its own, with the SET statements before it, between the parts of the
+// other statements before and after it. logPositions are the LogPositions
+// of the statements, if they are kept. There is always at least one part,
+// which is empty if statements is.
+func splitDDL(statements []*binlogdatapb.BinlogTransaction_Statement, logPositions []LogPosition) []transactionPart {
+	var parts []transactionPart
+	add := func(start, end int, ddl bool) {
+		part := transactionPart{statements: statements[start:end], ddl: ddl}
+		if len(logPositions) != 0 {
+			part.logPositions = logPositions[start:end]
+		}
+		parts = append(parts, part)
+	}
+	start := 0
+	for i, st := range statements {
+		if st.Category != binlogdatapb.BinlogTransaction_Statement_BL_DDL {
+			continue
+		}
+		setsStart := i
+		for setsStart > start && statements[setsStart-1].Category == binlogdatapb.BinlogTransaction_Statement_BL_SET {
+			setsStart--
+		}
+		if setsStart > start {
+			add(start, setsStart, false)
+		}
+		add(setsStart, i+1, true)
+		start = i + 1
+	}
+	if start < len(statements) || len(parts) == 0 {
+		add(start, len(statements), false)
+	}
+	return parts
+}
+
+// coalesceSets combines the SET statements the Streamer adds before a query
+// into one, keeping their order. They only set integer values, so the
+// combined statement can use the charset of the query.
+func coalesceSets(sets []*binlogdatapb.BinlogTransaction_Statement, charset *binlogdatapb.Charset) *binlogdatapb.BinlogTransaction_Statement {
+	assignments := make([]string, len(sets))
+	for i, st := range sets {
+		assignments[i] = strings.TrimPrefix(st.Sql, "SET ")
+	}
+	return &binlogdatapb.BinlogTransaction_Statement{
+		Category: binlogdatapb.BinlogTransaction_Statement_BL_SET,
+		Sql:      "SET " + strings.Join(assignments, ", "),
+		Charset:  charset,
+	}
+}
+
+// BytesRead returns the total length of the binlog events the Streamer
+// received from mysqld, as declared in their headers, since it was created.
+// Events are counted before their checksum is stripped, and whether or not
+// they are sent, so comparing it with the size of the transactions that
+// were sent tells how much is filtered out. It is safe to call while
+// Stream() is running.
+func (bls *Streamer) BytesRead() int64 {
+	return bls.bytesRead.Get()
+}
+
+// ChecksumAlgorithm returns the checksum algorithm mysqld uses for the
+// binlog events, as declared in the last FORMAT_DESCRIPTION_EVENT: one of
+// mysqlctl.BinlogChecksumAlgOff, BinlogChecksumAlgCRC32 or
+// BinlogChecksumAlgUndef. ok is false if no FORMAT_DESCRIPTION_EVENT was
+// received yet. It is safe to call while Stream() is running.
+func (bls *Streamer) ChecksumAlgorithm() (alg byte, ok bool) {
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+	if bls.format.IsZero() {
+		return 0, false
+	}
+	return bls.format.ChecksumAlgorithm, true
+}
+
+// Format returns the binlog format declared in the last
+// FORMAT_DESCRIPTION_EVENT, e.g. to re-serialize the events sent by
+// SendEvent. It changes when a FORMAT_DESCRIPTION_EVENT declares a new
+// one, see FormatChanged. It's the zero BinlogFormat if no
+// FORMAT_DESCRIPTION_EVENT was received yet. It is safe to call while
+// Stream() is running.
+func (bls *Streamer) Format() replication.BinlogFormat {
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+	return bls.format
+}
+
+// PositionHistory returns the samples of the last transactions sent,
+// oldest first. It returns nil if PositionHistorySize isn't set, or if no
+// transaction was sent yet. It is safe to call while Stream() is running.
+func (bls *Streamer) PositionHistory() []PositionSample {
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+	if bls.history == nil {
+		return nil
+	}
+	return bls.history.values()
+}
+
+// addPositionSample records s in the position history.
+func (bls *Streamer) addPositionSample(s PositionSample) {
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+	if bls.history == nil {
+		bls.history = newPositionHistory(bls.PositionHistorySize)
+	}
+	bls.history.add(s)
+}
+
+// setFormat records the format of the last FORMAT_DESCRIPTION_EVENT.
+func (bls *Streamer) setFormat(format replication.BinlogFormat) {
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+	bls.format = format
+}
+
+// rotateFile returns the name of the binlog file a ROTATE_EVENT points to.
+// ev must be valid, with its checksum, if any, still there.
+func rotateFile(ev replication.BinlogEvent, format replication.BinlogFormat) (string, error) {
+	ev, _, err := ev.StripChecksum(format)
+	if err != nil {
+		return "", fmt.Errorf("can't strip checksum from binlog event: %v, event data: %#v", err, ev)
+	}
+	_, fileName, err := ev.Rotate(format)
+	if err != nil {
+		return "", fmt.Errorf("can't parse ROTATE_EVENT: %v, event data: %#v", err, ev)
+	}
+	return fileName, nil
+}
+
+// setCommittedPosition records the position of the last transaction sent,
+// and wakes up the WaitForPosition() callers.
+func (bls *Streamer) setCommittedPosition(pos replication.Position) {
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+	bls.committedPos = pos
+	close(bls.posChanged)
+	bls.posChanged = make(chan struct{})
+}
+
+// endPositionWaits releases the WaitForPosition() callers that are still
+// waiting when the stream ends.
+func (bls *Streamer) endPositionWaits() {
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+	bls.ended = true
+	close(bls.posChanged)
+	bls.posChanged = make(chan struct{})
+}
+
+// SetFilter replaces the database and the DropStatements patterns the
+// Streamer filters statements with. It can be called while the stream is
+// running: the new filter applies from the next transaction on, so all the
+// statements of a transaction are filtered the same way. f.DropStatements
+// must not be modified afterwards.
+func (bls *Streamer) SetFilter(f Filter) {
+	bls.filter.Store(&f)
+}
+
+// currentFilter returns the filter set by SetFilter(), or else the one the
+// Streamer was created with.
+func (bls *Streamer) currentFilter() *Filter {
+	if f, ok := bls.filter.Load().(*Filter); ok {
+		return f
+	}
+	return &Filter{Database: bls.dbname, DropStatements: bls.DropStatements}
+}
+
+// isDropped returns true if sql is a DML or DDL statement that matches one
+// of the DropStatements patterns.
+func (f *Filter) isDropped(cat binlogdatapb.BinlogTransaction_Statement_Category, sql string) bool {
+	if cat != binlogdatapb.BinlogTransaction_Statement_BL_DML && cat != binlogdatapb.BinlogTransaction_Statement_BL_DDL {
+		return false
+	}
+	for _, re := range f.DropStatements {
+		if re.MatchString(sql) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEventLength returns an error if the length declared in the header of
+// ev is larger than the Streamer accepts.
+func (bls *Streamer) checkEventLength(ev replication.BinlogEvent) error {
+	length := ev.Length()
+	if length > maxEventLength {
+		return fmt.Errorf("binlog event declares an implausible length of %v bytes (more than %v), the stream is probably corrupted", length, maxEventLength)
+	}
+	if bls.MaxEventLength != 0 && length > bls.MaxEventLength {
+		return fmt.Errorf("binlog event length of %v bytes exceeds the maximum of %v bytes, check that max_allowed_packet on mysqld matches the streamer", length, bls.MaxEventLength)
+	}
+	return nil
+}
@@ -9,13 +9,18 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/youtube/vitess/go/mysql"
+	"github.com/youtube/vitess/go/sqldb"
 	"github.com/youtube/vitess/go/sync2"
 	"github.com/youtube/vitess/go/vt/mysqlctl"
 	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+	"golang.org/x/net/context"
 
 	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
 )
@@ -24,6 +29,7 @@ import (
 type fakeEvent struct{}
 
 func (fakeEvent) IsValid() bool                         { return true }
+func (fakeEvent) Length() uint32                        { return 19 }
 func (fakeEvent) IsFormatDescription() bool             { return false }
 func (fakeEvent) IsQuery() bool                         { return false }
 func (fakeEvent) IsXID() bool                           { return false }
@@ -31,8 +37,14 @@ func (fakeEvent) IsGTID() bool                          { return false }
 func (fakeEvent) IsRotate() bool                        { return false }
 func (fakeEvent) IsIntVar() bool                        { return false }
 func (fakeEvent) IsRand() bool                          { return false }
+func (fakeEvent) IsStop() bool                          { return false }
+func (fakeEvent) IsIncident() bool                      { return false }
+func (fakeEvent) IsTableMap() bool                      { return false }
+func (fakeEvent) IsXAPrepare() bool                     { return false }
 func (fakeEvent) HasGTID(replication.BinlogFormat) bool { return true }
+func (fakeEvent) Type() byte                            { return 0 }
 func (fakeEvent) Timestamp() uint32                     { return 1407805592 }
+func (fakeEvent) NextPosition() uint32                  { return 0 }
 func (fakeEvent) Format() (replication.BinlogFormat, error) {
 	return replication.BinlogFormat{}, errors.New("not a format")
 }
@@ -40,6 +52,12 @@ func (fakeEvent) GTID(replication.BinlogFormat) (replication.GTID, error) {
 	return replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 0xd}, nil
 }
 func (fakeEvent) IsBeginGTID(replication.BinlogFormat) bool { return false }
+func (fakeEvent) LogicalClock(replication.BinlogFormat) (int64, int64, bool) {
+	return 0, 0, false
+}
+func (fakeEvent) CommitTimestamps(replication.BinlogFormat) (int64, int64, bool) {
+	return 0, 0, false
+}
 func (fakeEvent) Query(replication.BinlogFormat) (replication.Query, error) {
 	return replication.Query{}, errors.New("not a query")
 }
@@ -49,6 +67,18 @@ func (fakeEvent) IntVar(replication.BinlogFormat) (string, uint64, error) {
 func (fakeEvent) Rand(replication.BinlogFormat) (uint64, uint64, error) {
 	return 0, 0, errors.New("not a rand")
 }
+func (fakeEvent) Incident(replication.BinlogFormat) (uint16, string, error) {
+	return 0, "", errors.New("not an incident")
+}
+func (fakeEvent) Rotate(replication.BinlogFormat) (uint64, string, error) {
+	return 0, "", errors.New("not a rotate")
+}
+func (fakeEvent) TableMap(replication.BinlogFormat) (replication.TableMap, error) {
+	return replication.TableMap{}, errors.New("not a table map")
+}
+func (fakeEvent) XAPrepare(replication.BinlogFormat) (replication.XAPrepare, error) {
+	return replication.XAPrepare{}, errors.New("not an XA prepare")
+}
 func (ev fakeEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
 	return ev, nil, nil
 }
@@ -60,13 +90,39 @@ func (ev invalidEvent) StripChecksum(replication.BinlogFormat) (replication.Binl
 	return ev, nil, nil
 }
 
-type rotateEvent struct{ fakeEvent }
+// oversizedEvent declares a length that doesn't match its buffer, as when a
+// large event was truncated.
+type oversizedEvent struct {
+	fakeEvent
+	length uint32
+}
+
+func (oversizedEvent) IsValid() bool     { return false }
+func (ev oversizedEvent) Length() uint32 { return ev.length }
+func (ev oversizedEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
+type rotateEvent struct {
+	fakeEvent
+	fileName string
+}
 
 func (rotateEvent) IsRotate() bool { return true }
+func (ev rotateEvent) Rotate(replication.BinlogFormat) (uint64, string, error) {
+	return 4, ev.fileName, nil
+}
 func (ev rotateEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
 	return ev, nil, nil
 }
 
+type stopEvent struct{ fakeEvent }
+
+func (stopEvent) IsStop() bool { return true }
+func (ev stopEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
 type formatEvent struct{ fakeEvent }
 
 func (formatEvent) IsFormatDescription() bool { return true }
@@ -77,6 +133,19 @@ func (ev formatEvent) StripChecksum(replication.BinlogFormat) (replication.Binlo
 	return ev, nil, nil
 }
 
+// versionFormatEvent is a formatEvent for the given server version.
+type versionFormatEvent struct {
+	formatEvent
+	serverVersion string
+}
+
+func (ev versionFormatEvent) Format() (replication.BinlogFormat, error) {
+	return replication.BinlogFormat{FormatVersion: 1, ServerVersion: ev.serverVersion}, nil
+}
+func (ev versionFormatEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
 type invalidFormatEvent struct{ formatEvent }
 
 func (invalidFormatEvent) Format() (replication.BinlogFormat, error) {
@@ -101,6 +170,55 @@ func (ev queryEvent) StripChecksum(replication.BinlogFormat) (replication.Binlog
 
 type invalidQueryEvent struct{ queryEvent }
 
+// logPosEvent is an event read from a binlog file, with next as the
+// next_position header field.
+type logPosEvent struct {
+	replication.BinlogEvent
+	next uint32
+}
+
+func (ev logPosEvent) NextPosition() uint32 { return ev.next }
+
+// timestampEvent is an event with its own timestamp.
+type timestampEvent struct {
+	replication.BinlogEvent
+	timestamp uint32
+}
+
+func (ev timestampEvent) Timestamp() uint32 { return ev.timestamp }
+func (ev timestampEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
+// sequenceQueryEvent is a queryEvent with its own GTID sequence number.
+type sequenceQueryEvent struct {
+	queryEvent
+	sequence uint64
+}
+
+func (ev sequenceQueryEvent) GTID(replication.BinlogFormat) (replication.GTID, error) {
+	return replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: ev.sequence}, nil
+}
+func (ev sequenceQueryEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
+// sequenceEvent returns an autocommit insert with the given GTID sequence
+// number.
+func sequenceEvent(sequence uint64) replication.BinlogEvent {
+	return sequenceQueryEvent{
+		queryEvent: queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      fmt.Sprintf("insert into vt_a(eid, id) values (%d, 1)", sequence)}},
+		sequence: sequence,
+	}
+}
+
+// sequencePosition returns the position of sequenceEvent(sequence).
+func sequencePosition(sequence uint64) replication.Position {
+	return replication.AppendGTID(replication.Position{}, replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: sequence})
+}
+
 func (invalidQueryEvent) Query(replication.BinlogFormat) (replication.Query, error) {
 	return replication.Query{}, errors.New("invalid query event")
 }
@@ -108,6 +226,41 @@ func (ev invalidQueryEvent) StripChecksum(replication.BinlogFormat) (replication
 	return ev, nil, nil
 }
 
+// gtidEvent is a MySQL 5.7 GTID_EVENT, with a logical clock, or a MySQL
+// 8.0 one if it has commit timestamps.
+type gtidEvent struct {
+	fakeEvent
+	lastCommitted, sequenceNumber   int64
+	originalCommit, immediateCommit int64
+}
+
+func (gtidEvent) IsGTID() bool { return true }
+func (ev gtidEvent) LogicalClock(replication.BinlogFormat) (int64, int64, bool) {
+	return ev.lastCommitted, ev.sequenceNumber, true
+}
+func (ev gtidEvent) CommitTimestamps(replication.BinlogFormat) (int64, int64, bool) {
+	return ev.originalCommit, ev.immediateCommit, ev.originalCommit != 0
+}
+func (ev gtidEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
+// beginGTIDEvent is a MariaDB GTID_EVENT that starts a transaction, with
+// its own GTID sequence number.
+type beginGTIDEvent struct {
+	fakeEvent
+	sequence uint64
+}
+
+func (beginGTIDEvent) IsGTID() bool                              { return true }
+func (beginGTIDEvent) IsBeginGTID(replication.BinlogFormat) bool { return true }
+func (ev beginGTIDEvent) GTID(replication.BinlogFormat) (replication.GTID, error) {
+	return replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: ev.sequence}, nil
+}
+func (ev beginGTIDEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
 type xidEvent struct{ fakeEvent }
 
 func (xidEvent) IsXID() bool { return true }
@@ -129,6 +282,66 @@ func (ev intVarEvent) StripChecksum(replication.BinlogFormat) (replication.Binlo
 	return ev, nil, nil
 }
 
+// otherEvent is an event of a type the Streamer doesn't handle.
+type otherEvent struct {
+	fakeEvent
+	typ byte
+}
+
+func (ev otherEvent) Type() byte { return ev.typ }
+func (ev otherEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
+type randEvent struct {
+	fakeEvent
+	seed1, seed2 uint64
+}
+
+func (randEvent) IsRand() bool { return true }
+func (ev randEvent) Rand(replication.BinlogFormat) (uint64, uint64, error) {
+	return ev.seed1, ev.seed2, nil
+}
+func (ev randEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
+type tableMapEvent struct {
+	fakeEvent
+	tm replication.TableMap
+}
+
+func (tableMapEvent) IsTableMap() bool { return true }
+func (tableMapEvent) Type() byte       { return 19 }
+func (ev tableMapEvent) TableMap(replication.BinlogFormat) (replication.TableMap, error) {
+	return ev.tm, nil
+}
+func (ev tableMapEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
+type invalidTableMapEvent struct{ tableMapEvent }
+
+func (invalidTableMapEvent) TableMap(replication.BinlogFormat) (replication.TableMap, error) {
+	return replication.TableMap{}, errors.New("invalid table map")
+}
+func (ev invalidTableMapEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
+type xaPrepareEvent struct {
+	fakeEvent
+	xa replication.XAPrepare
+}
+
+func (xaPrepareEvent) IsXAPrepare() bool { return true }
+func (ev xaPrepareEvent) XAPrepare(replication.BinlogFormat) (replication.XAPrepare, error) {
+	return ev.xa, nil
+}
+func (ev xaPrepareEvent) StripChecksum(replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
 type invalidIntVarEvent struct{ intVarEvent }
 
 func (invalidIntVarEvent) IntVar(replication.BinlogFormat) (string, uint64, error) {
@@ -142,15 +355,51 @@ func (ev invalidIntVarEvent) StripChecksum(replication.BinlogFormat) (replicatio
 var (
 	mariadbRotateEvent         = mysqlctl.NewMariadbBinlogEvent([]byte{0x0, 0x0, 0x0, 0x0, 0x4, 0x88, 0xf3, 0x0, 0x0, 0x33, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x20, 0x0, 0x4, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x76, 0x74, 0x2d, 0x30, 0x30, 0x30, 0x30, 0x30, 0x36, 0x32, 0x33, 0x34, 0x34, 0x2d, 0x62, 0x69, 0x6e, 0x2e, 0x30, 0x30, 0x30, 0x30, 0x30, 0x31})
 	mariadbFormatEvent         = mysqlctl.NewMariadbBinlogEvent([]byte{0x87, 0x41, 0x9, 0x54, 0xf, 0x88, 0xf3, 0x0, 0x0, 0xf4, 0x0, 0x0, 0x0, 0xf8, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x0, 0x31, 0x30, 0x2e, 0x30, 0x2e, 0x31, 0x33, 0x2d, 0x4d, 0x61, 0x72, 0x69, 0x61, 0x44, 0x42, 0x2d, 0x31, 0x7e, 0x70, 0x72, 0x65, 0x63, 0x69, 0x73, 0x65, 0x2d, 0x6c, 0x6f, 0x67, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x87, 0x41, 0x9, 0x54, 0x13, 0x38, 0xd, 0x0, 0x8, 0x0, 0x12, 0x0, 0x4, 0x4, 0x4, 0x4, 0x12, 0x0, 0x0, 0xdc, 0x0, 0x4, 0x1a, 0x8, 0x0, 0x0, 0x0, 0x8, 0x8, 0x8, 0x2, 0x0, 0x0, 0x0, 0xa, 0xa, 0xa, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x13, 0x4, 0x0, 0x6e, 0xe0, 0xfd, 0x41})
+	mariadbChecksumFormatEvent = mysqlctl.NewMariadbBinlogEvent([]byte{0x22, 0xe5, 0x3e, 0x54, 0xf, 0x8b, 0xf3, 0x0, 0x0, 0xf4, 0x0, 0x0, 0x0, 0xf8, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x0, 0x31, 0x30, 0x2e, 0x30, 0x2e, 0x31, 0x33, 0x2d, 0x4d, 0x61, 0x72, 0x69, 0x61, 0x44, 0x42, 0x2d, 0x31, 0x7e, 0x70, 0x72, 0x65, 0x63, 0x69, 0x73, 0x65, 0x2d, 0x6c, 0x6f, 0x67, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x13, 0x38, 0xd, 0x0, 0x8, 0x0, 0x12, 0x0, 0x4, 0x4, 0x4, 0x4, 0x12, 0x0, 0x0, 0xdc, 0x0, 0x4, 0x1a, 0x8, 0x0, 0x0, 0x0, 0x8, 0x8, 0x8, 0x2, 0x0, 0x0, 0x0, 0xa, 0xa, 0xa, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x13, 0x4, 0x1, 0x14, 0x13, 0x32, 0xdc})
 	mariadbStandaloneGTIDEvent = mysqlctl.NewMariadbBinlogEvent([]byte{0x88, 0x41, 0x9, 0x54, 0xa2, 0x88, 0xf3, 0x0, 0x0, 0x26, 0x0, 0x0, 0x0, 0xcf, 0x8, 0x0, 0x0, 0x8, 0x0, 0x9, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0})
 	mariadbBeginGTIDEvent      = mysqlctl.NewMariadbBinlogEvent([]byte{0x88, 0x41, 0x9, 0x54, 0xa2, 0x88, 0xf3, 0x0, 0x0, 0x26, 0x0, 0x0, 0x0, 0xb5, 0x9, 0x0, 0x0, 0x8, 0x0, 0xa, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0})
 	mariadbCreateEvent         = mysqlctl.NewMariadbBinlogEvent([]byte{0x88, 0x41, 0x9, 0x54, 0x2, 0x88, 0xf3, 0x0, 0x0, 0xc2, 0x0, 0x0, 0x0, 0xf2, 0x6, 0x0, 0x0, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x8, 0x0, 0x8, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x20, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x20, 0x69, 0x66, 0x20, 0x6e, 0x6f, 0x74, 0x20, 0x65, 0x78, 0x69, 0x73, 0x74, 0x73, 0x20, 0x76, 0x74, 0x5f, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x20, 0x28, 0xa, 0x69, 0x64, 0x20, 0x62, 0x69, 0x67, 0x69, 0x6e, 0x74, 0x20, 0x61, 0x75, 0x74, 0x6f, 0x5f, 0x69, 0x6e, 0x63, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x2c, 0xa, 0x6d, 0x73, 0x67, 0x20, 0x76, 0x61, 0x72, 0x63, 0x68, 0x61, 0x72, 0x28, 0x36, 0x34, 0x29, 0x2c, 0xa, 0x70, 0x72, 0x69, 0x6d, 0x61, 0x72, 0x79, 0x20, 0x6b, 0x65, 0x79, 0x20, 0x28, 0x69, 0x64, 0x29, 0xa, 0x29, 0x20, 0x45, 0x6e, 0x67, 0x69, 0x6e, 0x65, 0x3d, 0x49, 0x6e, 0x6e, 0x6f, 0x44, 0x42})
 	mariadbInsertEvent         = mysqlctl.NewMariadbBinlogEvent([]byte{0x88, 0x41, 0x9, 0x54, 0x2, 0x88, 0xf3, 0x0, 0x0, 0xa8, 0x0, 0x0, 0x0, 0x79, 0xa, 0x0, 0x0, 0x0, 0x0, 0x27, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x21, 0x0, 0x21, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x20, 0x69, 0x6e, 0x74, 0x6f, 0x20, 0x76, 0x74, 0x5f, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x28, 0x6d, 0x73, 0x67, 0x29, 0x20, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x20, 0x28, 0x27, 0x74, 0x65, 0x73, 0x74, 0x20, 0x30, 0x27, 0x29, 0x20, 0x2f, 0x2a, 0x20, 0x5f, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x20, 0x76, 0x74, 0x5f, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x20, 0x28, 0x69, 0x64, 0x20, 0x29, 0x20, 0x28, 0x6e, 0x75, 0x6c, 0x6c, 0x20, 0x29, 0x3b, 0x20, 0x2a, 0x2f})
 	mariadbXidEvent            = mysqlctl.NewMariadbBinlogEvent([]byte{0x88, 0x41, 0x9, 0x54, 0x10, 0x88, 0xf3, 0x0, 0x0, 0x1b, 0x0, 0x0, 0x0, 0xe0, 0xc, 0x0, 0x0, 0x0, 0x0, 0x85, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0})
+	mariadbIncidentEvent       = mysqlctl.NewMariadbBinlogEvent([]byte{0x88, 0x41, 0x9, 0x54, 0x1a, 0x88, 0xf3, 0x0, 0x0, 0x35, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x1f, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x20, 0x77, 0x72, 0x69, 0x74, 0x69, 0x6e, 0x67, 0x20, 0x74, 0x6f, 0x20, 0x74, 0x68, 0x65, 0x20, 0x62, 0x69, 0x6e, 0x61, 0x72, 0x79, 0x20, 0x6c, 0x6f, 0x67})
+
+	// The HEARTBEAT_LOG_EVENT mysqld sends when it has nothing else to send
+	// in a binlog dump. It isn't in the binlog.
+	mariadbHeartbeatEvent = mysqlctl.NewMariadbBinlogEvent([]byte{0x0, 0x0, 0x0, 0x0, 0x1b, 0x88, 0xf3, 0x0, 0x0, 0x2b, 0x0, 0x0, 0x0, 0xe0, 0xc, 0x0, 0x0, 0x0, 0x0, 0x76, 0x74, 0x2d, 0x30, 0x30, 0x30, 0x30, 0x30, 0x36, 0x32, 0x33, 0x34, 0x34, 0x2d, 0x62, 0x69, 0x6e, 0x2e, 0x30, 0x30, 0x30, 0x30, 0x30, 0x31})
+
+	// The heartbeat of Amazon RDS, as a statement without a default
+	// database, and as rows events.
+	rdsHeartbeatEvent         = mysqlctl.NewMariadbBinlogEvent([]byte{0x88, 0x41, 0x9, 0x54, 0x2, 0x88, 0xf3, 0x0, 0x0, 0xad, 0x0, 0x0, 0x0, 0x0, 0xb, 0x0, 0x0, 0x0, 0x0, 0x27, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x21, 0x0, 0x21, 0x0, 0x21, 0x0, 0x0, 0x49, 0x4e, 0x53, 0x45, 0x52, 0x54, 0x20, 0x49, 0x4e, 0x54, 0x4f, 0x20, 0x6d, 0x79, 0x73, 0x71, 0x6c, 0x2e, 0x72, 0x64, 0x73, 0x5f, 0x68, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x32, 0x28, 0x69, 0x64, 0x2c, 0x20, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x29, 0x20, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x20, 0x28, 0x31, 0x2c, 0x31, 0x34, 0x30, 0x39, 0x38, 0x39, 0x32, 0x37, 0x34, 0x34, 0x30, 0x30, 0x30, 0x29, 0x20, 0x4f, 0x4e, 0x20, 0x44, 0x55, 0x50, 0x4c, 0x49, 0x43, 0x41, 0x54, 0x45, 0x20, 0x4b, 0x45, 0x59, 0x20, 0x55, 0x50, 0x44, 0x41, 0x54, 0x45, 0x20, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x20, 0x3d, 0x20, 0x31, 0x34, 0x30, 0x39, 0x38, 0x39, 0x32, 0x37, 0x34, 0x34, 0x30, 0x30, 0x30})
+	rdsHeartbeatTableMapEvent = mysqlctl.NewMariadbBinlogEvent([]byte{0x88, 0x41, 0x9, 0x54, 0x13, 0x88, 0xf3, 0x0, 0x0, 0x37, 0x0, 0x0, 0x0, 0x0, 0xc, 0x0, 0x0, 0x0, 0x0, 0x4e, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x5, 0x6d, 0x79, 0x73, 0x71, 0x6c, 0x0, 0xe, 0x72, 0x64, 0x73, 0x5f, 0x68, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x32, 0x0, 0x2, 0x3, 0x8, 0x0, 0x2})
+	rdsHeartbeatRowsEvent     = mysqlctl.NewMariadbBinlogEvent([]byte{0x88, 0x41, 0x9, 0x54, 0x17, 0x88, 0xf3, 0x0, 0x0, 0x2a, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x4e, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x2, 0x3, 0x0, 0x1, 0x0, 0x0, 0x0, 0x40, 0xfb, 0x27, 0x44, 0x48, 0x1, 0x0, 0x0})
 
 	charset = &binlogdatapb.Charset{Client: 33, Conn: 33, Server: 33}
 )
 
+// fakeBinlogConnection implements BinlogConnection. StartBinlogDump sends
+// the given events, then closes the channel.
+type fakeBinlogConnection struct {
+	charset  *binlogdatapb.Charset
+	events   []replication.BinlogEvent
+	closed   bool
+	startPos replication.Position
+}
+
+func (conn *fakeBinlogConnection) GetCharset() (*binlogdatapb.Charset, error) {
+	return conn.charset, nil
+}
+
+func (conn *fakeBinlogConnection) StartBinlogDump(startPos replication.Position) (<-chan replication.BinlogEvent, error) {
+	conn.startPos = startPos
+	events := make(chan replication.BinlogEvent)
+	go sendTestEvents(events, conn.events)
+	return events, nil
+}
+
+func (conn *fakeBinlogConnection) Close() {
+	conn.closed = true
+}
+
 func sendTestEvents(channel chan<- replication.BinlogEvent, events []replication.BinlogEvent) {
 	for _, ev := range events {
 		channel <- ev
@@ -158,6 +407,32 @@ func sendTestEvents(channel chan<- replication.BinlogEvent, events []replication
 	close(channel)
 }
 
+// eventBytes returns the data of an event created by mysqlctl.
+func eventBytes(ev replication.BinlogEvent) []byte {
+	return ev.(interface {
+		Bytes() []byte
+	}).Bytes()
+}
+
+// dumpPacket returns the binlog dump packet of an event created by
+// mysqlctl, for mysqlctl.FakeMysqlDaemon.BinlogDump.
+func dumpPacket(ev replication.BinlogEvent) []byte {
+	return append([]byte{0}, eventBytes(ev)...)
+}
+
+// parseTestEvents runs bls.parseEvents() on the given events, and returns
+// its error once the events have all been processed.
+func parseTestEvents(bls *Streamer, input []replication.BinlogEvent) error {
+	events := make(chan replication.BinlogEvent)
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	return svm.Join()
+}
+
 func TestStreamerParseEventsXID(t *testing.T) {
 	input := []replication.BinlogEvent{
 		rotateEvent{},
@@ -262,137 +537,129 @@ func TestStreamerParseEventsCommit(t *testing.T) {
 	}
 }
 
-func TestStreamerStop(t *testing.T) {
-	events := make(chan replication.BinlogEvent)
+func TestStreamerWithConn(t *testing.T) {
+	conn := &fakeBinlogConnection{
+		charset: charset,
+		events: []replication.BinlogEvent{
+			rotateEvent{},
+			formatEvent{},
+			queryEvent{query: replication.Query{
+				Database: "vt_test_keyspace",
+				SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+		},
+	}
 
+	var got []binlogdatapb.BinlogTransaction
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
 		return nil
 	}
-	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls := NewStreamerWithConn("vt_test_keyspace", conn, charset, replication.Position{}, sendTransaction)
 
-	// Start parseEvents(), but don't send it anything, so it just waits.
 	svm := &sync2.ServiceManager{}
-	svm.Go(func(ctx *sync2.ServiceContext) error {
-		_, err := bls.parseEvents(ctx, events)
-		if err != nil {
-			t.Errorf("unexpected error: %v", err)
-		}
-		return nil
-	})
-
-	done := make(chan struct{})
-	go func() {
-		svm.Stop()
-		close(done)
-	}()
-
-	select {
-	case <-done:
-	case <-time.After(1 * time.Second):
-		t.Errorf("timed out waiting for binlogConnStreamer.Stop()")
+	svm.Go(bls.Stream)
+	if err := svm.Join(); err == nil || !strings.Contains(err.Error(), ErrServerEOF.Error()) {
+		t.Errorf("wrong error, got %v, want %v", err, ErrServerEOF)
+	}
+	if len(got) != 1 {
+		t.Errorf("got %v transactions, want 1", len(got))
+	}
+	if conn.closed {
+		t.Errorf("Stream() closed a connection it doesn't own")
 	}
 }
 
-func TestStreamerParseEventsClientEOF(t *testing.T) {
+func TestStreamerPauseResume(t *testing.T) {
 	input := []replication.BinlogEvent{
 		rotateEvent{},
 		formatEvent{},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "BEGIN"}},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
-		xidEvent{},
 	}
-	want := ErrClientEOF
-
-	events := make(chan replication.BinlogEvent)
+	for i := uint64(1); i <= 5; i++ {
+		input = append(input, sequenceEvent(i))
+	}
 
+	var got []string
+	sent := make(chan struct{}, 10)
+	var bls *Streamer
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
-		return io.EOF
+		got = append(got, trans.TransactionId)
+		if len(got) == 2 {
+			// Pause in the middle of the stream.
+			bls.Pause()
+		}
+		sent <- struct{}{}
+		return nil
 	}
-	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
 
+	events := make(chan replication.BinlogEvent)
 	go sendTestEvents(events, input)
 	svm := &sync2.ServiceManager{}
 	svm.Go(func(ctx *sync2.ServiceContext) error {
 		_, err := bls.parseEvents(ctx, events)
 		return err
 	})
-	err := svm.Join()
-	if err == nil {
-		t.Errorf("expected error, got none")
+
+	<-sent
+	<-sent
+	select {
+	case <-sent:
+		t.Fatalf("transaction sent while the stream is paused")
+	case <-time.After(50 * time.Millisecond):
 	}
-	if err != want {
-		t.Errorf("wrong error, got %#v, want %#v", err, want)
+	bls.Resume()
+	if err := svm.Join(); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
 	}
-}
-
-func TestStreamerParseEventsServerEOF(t *testing.T) {
-	want := ErrServerEOF
 
-	events := make(chan replication.BinlogEvent)
-	close(events)
+	var want []string
+	for i := uint64(1); i <= 5; i++ {
+		want = append(want, replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: i}))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+}
 
+func TestStreamerPauseShutdown(t *testing.T) {
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
 		return nil
 	}
 	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.Pause()
 
+	// The events channel is never closed, so only the shutdown can end the
+	// paused stream.
+	events := make(chan replication.BinlogEvent)
 	svm := &sync2.ServiceManager{}
 	svm.Go(func(ctx *sync2.ServiceContext) error {
 		_, err := bls.parseEvents(ctx, events)
 		return err
 	})
-	err := svm.Join()
-	if err == nil {
-		t.Errorf("expected error, got none")
-	}
-	if err != want {
-		t.Errorf("wrong error, got %#v, want %#v", err, want)
+	svm.Stop()
+	if err := svm.Join(); err != nil {
+		t.Errorf("unexpected error: %v", err)
 	}
 }
 
-func TestStreamerParseEventsSendErrorXID(t *testing.T) {
-	input := []replication.BinlogEvent{
-		rotateEvent{},
-		formatEvent{},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "BEGIN"}},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
-		xidEvent{},
-	}
-	want := "send reply error: foobar"
-
-	events := make(chan replication.BinlogEvent)
-
+func TestStreamerMaxDuration(t *testing.T) {
+	var got []binlogdatapb.BinlogTransaction
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
-		return fmt.Errorf("foobar")
+		got = append(got, *trans)
+		return nil
 	}
 	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.MaxDuration = 10 * time.Millisecond
 
-	go sendTestEvents(events, input)
+	events := make(chan replication.BinlogEvent)
 	svm := &sync2.ServiceManager{}
+	var endPos replication.Position
 	svm.Go(func(ctx *sync2.ServiceContext) error {
-		_, err := bls.parseEvents(ctx, events)
+		var err error
+		endPos, err = bls.parseEvents(ctx, events)
 		return err
 	})
-	err := svm.Join()
-	if err == nil {
-		t.Errorf("expected error, got none")
-		return
-	}
-	if got := err.Error(); got != want {
-		t.Errorf("wrong error, got %#v, want %#v", got, want)
-	}
-}
-
-func TestStreamerParseEventsSendErrorCommit(t *testing.T) {
-	input := []replication.BinlogEvent{
+	for _, ev := range []replication.BinlogEvent{
 		rotateEvent{},
 		formatEvent{},
 		queryEvent{query: replication.Query{
@@ -400,52 +667,372 @@ func TestStreamerParseEventsSendErrorCommit(t *testing.T) {
 			SQL:      "BEGIN"}},
 		queryEvent{query: replication.Query{
 			Database: "vt_test_keyspace",
-			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "COMMIT"}},
+			SQL:      "insert into vt_a(eid, id) values (1, 1)"}},
+	} {
+		events <- ev
 	}
-	want := "send reply error: foobar"
 
-	events := make(chan replication.BinlogEvent)
+	// MaxDuration is reached in the middle of the transaction, which is
+	// still finished.
+	time.Sleep(50 * time.Millisecond)
+	events <- queryEvent{query: replication.Query{
+		Database: "vt_test_keyspace",
+		SQL:      "insert into vt_a(eid, id) values (2, 2)"}}
+	events <- xidEvent{}
+
+	// No other event is read.
+	if err := svm.Join(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	select {
+	case events <- sequenceEvent(14):
+		t.Errorf("event read after the end of the stream")
+	default:
+	}
+
+	if len(got) != 1 || len(got[0].Statements) != 4 {
+		t.Errorf("got %v, want 1 transaction with 4 statements", got)
+	}
+	wantPos := replication.AppendGTID(replication.Position{}, replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 0x0d})
+	if !endPos.Equal(wantPos) {
+		t.Errorf("got end position %v, want %v", endPos, wantPos)
+	}
+}
 
+func TestStreamerMaxDurationIdle(t *testing.T) {
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
-		return fmt.Errorf("foobar")
+		return nil
 	}
 	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.MaxDuration = 10 * time.Millisecond
 
-	go sendTestEvents(events, input)
-	svm := &sync2.ServiceManager{}
-	svm.Go(func(ctx *sync2.ServiceContext) error {
-		_, err := bls.parseEvents(ctx, events)
-		return err
-	})
-	err := svm.Join()
-	if err == nil {
-		t.Errorf("expected error, got none")
-		return
-	}
-	if got := err.Error(); got != want {
-		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	// The stream ends even if no event comes.
+	events := make(chan replication.BinlogEvent)
+	if _, err := bls.parseEvents(&sync2.ServiceContext{}, events); err != nil {
+		t.Errorf("unexpected error: %v", err)
 	}
 }
 
-func TestStreamerParseEventsInvalid(t *testing.T) {
+func TestStreamerReset(t *testing.T) {
+	conn := &fakeBinlogConnection{
+		events: []replication.BinlogEvent{
+			rotateEvent{},
+			formatEvent{},
+			sequenceEvent(2),
+			sequenceEvent(3),
+			// This transaction is never committed, so it must not be
+			// part of the next stream.
+			queryEvent{query: replication.Query{
+				Database: "vt_test_keyspace",
+				SQL:      "BEGIN"}},
+			sequenceEvent(4),
+		},
+	}
+
+	var bls *Streamer
+	var got []binlogdatapb.BinlogTransaction
+	var resetErr error
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
+		resetErr = bls.Reset(replication.Position{})
+		return nil
+	}
+	bls = NewStreamerWithConn("vt_test_keyspace", conn, nil, sequencePosition(1), sendTransaction)
+
+	for _, startPos := range []replication.Position{sequencePosition(1), sequencePosition(2), sequencePosition(1)} {
+		if err := bls.Reset(startPos); err != nil {
+			t.Fatalf("Reset(%v) failed: %v", startPos, err)
+		}
+		if !bls.committedPos.Equal(startPos) || bls.ended {
+			t.Errorf("Reset(%v) left committed position %v, ended = %v", startPos, bls.committedPos, bls.ended)
+		}
+		got = nil
+		resetErr = nil
+
+		svm := &sync2.ServiceManager{}
+		svm.Go(bls.Stream)
+		if err := svm.Join(); err == nil || !strings.Contains(err.Error(), ErrServerEOF.Error()) {
+			t.Errorf("wrong error, got %v, want %v", err, ErrServerEOF)
+		}
+		if resetErr == nil {
+			t.Errorf("Reset() during Stream() should have failed")
+		}
+		if !conn.startPos.Equal(startPos) {
+			t.Errorf("got binlog dump start position %v, want %v", conn.startPos, startPos)
+		}
+
+		// Each stream starts with no statements left over.
+		want := []binlogdatapb.BinlogTransaction{
+			{
+				Statements: []*binlogdatapb.BinlogTransaction_Statement{
+					{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+					{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (2, 1)"},
+				},
+			},
+			{
+				Statements: []*binlogdatapb.BinlogTransaction_Statement{
+					{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+					{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (3, 1)"},
+				},
+			},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got %v transactions, want %v: %v", len(got), len(want), got)
+		}
+		for i := range want {
+			if !reflect.DeepEqual(got[i].Statements, want[i].Statements) {
+				t.Errorf("transaction %v: got statements %v, want %v", i, got[i].Statements, want[i].Statements)
+			}
+		}
+		if want := sequencePosition(3); !bls.committedPos.Equal(want) {
+			t.Errorf("got committed position %v, want %v", bls.committedPos, want)
+		}
+	}
+}
+
+func TestStreamerWaitForPosition(t *testing.T) {
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, sequencePosition(1), sendTransaction)
+
+	// The start position is reached right away.
+	if err := bls.WaitForPosition(context.Background(), sequencePosition(1)); err != nil {
+		t.Errorf("WaitForPosition(start position) failed: %v", err)
+	}
+
+	wait := func(sequence uint64) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- bls.WaitForPosition(context.Background(), sequencePosition(sequence))
+		}()
+		return done
+	}
+	wait2 := wait(2)
+	wait4a := wait(4)
+	wait4b := wait(4)
+	wait9 := wait(9)
+
+	events := make(chan replication.BinlogEvent)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	events <- rotateEvent{}
+	events <- formatEvent{}
+	events <- sequenceEvent(2)
+	// Once the next event is received, the previous one has been sent.
+	events <- sequenceEvent(3)
+	if err := <-wait2; err != nil {
+		t.Errorf("WaitForPosition(2) failed: %v", err)
+	}
+	select {
+	case err := <-wait4a:
+		t.Errorf("WaitForPosition(4) returned early: %v", err)
+	default:
+	}
+
+	events <- sequenceEvent(4)
+	events <- sequenceEvent(5)
+	for _, done := range []<-chan error{wait4a, wait4b} {
+		if err := <-done; err != nil {
+			t.Errorf("WaitForPosition(4) failed: %v", err)
+		}
+	}
+
+	// Waiters for positions that are never reached are released when the
+	// stream ends.
+	close(events)
+	if err := svm.Join(); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := "binlog stream ended @ "
+	if err := <-wait9; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("wrong error from WaitForPosition(9), got %v, want %v", err, want)
+	}
+
+	// Context expiration also ends the wait.
+	bls = NewStreamer("vt_test_keyspace", nil, nil, sequencePosition(1), sendTransaction)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := bls.WaitForPosition(ctx, sequencePosition(2)); err != context.Canceled {
+		t.Errorf("wrong error from WaitForPosition with canceled context, got %v, want %v", err, context.Canceled)
+	}
+}
+
+// countingPositionStore is a MemoryPositionStore that counts saves.
+type countingPositionStore struct {
+	MemoryPositionStore
+	saves int
+}
+
+func (s *countingPositionStore) Save(pos replication.Position) error {
+	s.saves++
+	return s.MemoryPositionStore.Save(pos)
+}
+
+func TestStreamerPositionStore(t *testing.T) {
+	conn := &fakeBinlogConnection{
+		charset: charset,
+		events: []replication.BinlogEvent{
+			rotateEvent{},
+			formatEvent{},
+			sequenceEvent(3),
+			sequenceEvent(4),
+			sequenceEvent(5),
+			sequenceEvent(6),
+		},
+	}
+	// The consumer fails on the last transaction, so it must not be saved.
+	// Each transaction takes a second.
+	clock := time.Unix(1407805592, 0)
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		clock = clock.Add(time.Second)
+		if len(trans.Statements) == 2 && trans.Statements[1].Sql == "insert into vt_a(eid, id) values (6, 1)" {
+			return errors.New("consumer failure")
+		}
+		return nil
+	}
+
+	testcases := []struct {
+		interval  time.Duration
+		wantSaves int
+	}{
+		// Saves after each transaction.
+		{0, 3},
+		// Saves after the first transaction, and when the stream ends.
+		{time.Hour, 2},
+		// Saves after the first and the third transactions.
+		{2 * time.Second, 2},
+		// Saves after each transaction, a second apart.
+		{time.Second, 3},
+	}
+	for _, tcase := range testcases {
+		store := &countingPositionStore{}
+		store.Save(sequencePosition(2))
+		store.saves = 0
+
+		bls := NewStreamerWithConn("vt_test_keyspace", conn, charset, replication.Position{}, sendTransaction)
+		bls.PositionStore = store
+		bls.PositionSaveInterval = tcase.interval
+		bls.now = func() time.Time { return clock }
+
+		svm := &sync2.ServiceManager{}
+		svm.Go(bls.Stream)
+		if err := svm.Join(); err == nil || !strings.Contains(err.Error(), "consumer failure") {
+			t.Errorf("interval %v: wrong error, got %v, want consumer failure", tcase.interval, err)
+		}
+		if !conn.startPos.Equal(sequencePosition(2)) {
+			t.Errorf("interval %v: stream started at %v, want %v", tcase.interval, conn.startPos, sequencePosition(2))
+		}
+		got, _ := store.Load()
+		if !got.Equal(sequencePosition(5)) {
+			t.Errorf("interval %v: saved position %v, want %v", tcase.interval, got, sequencePosition(5))
+		}
+		if store.saves != tcase.wantSaves {
+			t.Errorf("interval %v: got %v saves, want %v", tcase.interval, store.saves, tcase.wantSaves)
+		}
+	}
+}
+
+func TestStreamerSSL(t *testing.T) {
+	mysqld := mysqlctl.NewFakeMysqlDaemon(nil)
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", mysqld, nil, replication.Position{}, sendTransaction)
+	bls.SSL = &mysqlctl.SSLParams{
+		Ca:   "ca.pem",
+		Cert: "client-cert.pem",
+		Key:  "client-key.pem",
+	}
+
+	// FakeMysqlDaemon can't create slave connections, but it records the
+	// SSL settings it was asked to use.
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	if err := svm.Join(); err == nil {
+		t.Errorf("expected error from FakeMysqlDaemon, got none")
+	}
+	if mysqld.SlaveConnectionOptions.SSL != bls.SSL {
+		t.Errorf("SlaveConnection SSL settings = %v, want %v", mysqld.SlaveConnectionOptions.SSL, bls.SSL)
+	}
+}
+
+func TestStreamerConnectionTimeouts(t *testing.T) {
+	mysqld := mysqlctl.NewFakeMysqlDaemon(nil)
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", mysqld, nil, replication.Position{}, sendTransaction)
+	bls.ReadTimeout = 30 * time.Second
+	bls.KeepAlive = 10 * time.Second
+
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	if err := svm.Join(); err == nil {
+		t.Errorf("expected error from FakeMysqlDaemon, got none")
+	}
+	want := mysqlctl.SlaveConnectionOptions{
+		ReadTimeout: 30 * time.Second,
+		KeepAlive:   10 * time.Second,
+	}
+	if got := mysqld.SlaveConnectionOptions; got != want {
+		t.Errorf("SlaveConnection options = %+v, want %+v", got, want)
+	}
+}
+
+func TestStreamerStop(t *testing.T) {
+	events := make(chan replication.BinlogEvent)
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	// Start parseEvents(), but don't send it anything, so it just waits.
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		svm.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Errorf("timed out waiting for binlogConnStreamer.Stop()")
+	}
+}
+
+func TestStreamerParseEventsClientEOF(t *testing.T) {
 	input := []replication.BinlogEvent{
 		rotateEvent{},
 		formatEvent{},
 		queryEvent{query: replication.Query{
 			Database: "vt_test_keyspace",
 			SQL:      "BEGIN"}},
-		invalidEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
 		xidEvent{},
 	}
-	want := "can't parse binlog event, invalid data:"
+	want := ErrClientEOF
 
 	events := make(chan replication.BinlogEvent)
 
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
-		return nil
+		return io.EOF
 	}
 	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
 
@@ -458,17 +1045,41 @@ func TestStreamerParseEventsInvalid(t *testing.T) {
 	err := svm.Join()
 	if err == nil {
 		t.Errorf("expected error, got none")
-		return
 	}
-	if got := err.Error(); !strings.HasPrefix(got, want) {
-		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	if err != want {
+		t.Errorf("wrong error, got %#v, want %#v", err, want)
 	}
 }
 
-func TestStreamerParseEventsInvalidFormat(t *testing.T) {
+func TestStreamerParseEventsServerEOF(t *testing.T) {
+	want := ErrServerEOF
+
+	events := make(chan replication.BinlogEvent)
+	close(events)
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	err := svm.Join()
+	if err == nil {
+		t.Errorf("expected error, got none")
+	}
+	if err != want {
+		t.Errorf("wrong error, got %#v, want %#v", err, want)
+	}
+}
+
+func TestStreamerParseEventsServerStopped(t *testing.T) {
 	input := []replication.BinlogEvent{
 		rotateEvent{},
-		invalidFormatEvent{},
+		formatEvent{},
 		queryEvent{query: replication.Query{
 			Database: "vt_test_keyspace",
 			SQL:      "BEGIN"}},
@@ -476,80 +1087,3170 @@ func TestStreamerParseEventsInvalidFormat(t *testing.T) {
 			Database: "vt_test_keyspace",
 			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
 		xidEvent{},
+		stopEvent{},
 	}
-	want := "can't parse FORMAT_DESCRIPTION_EVENT:"
+	want := ErrServerStopped
+
+	events := make(chan replication.BinlogEvent)
+
+	var got []binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	if err := svm.Join(); err != want {
+		t.Errorf("wrong error, got %#v, want %#v", err, want)
+	}
+	if len(got) != 1 {
+		t.Errorf("got %v transactions, want 1", len(got))
+	}
+}
+
+// dumpErrorConnection is a fakeBinlogConnection that reports err as the
+// reason its events channel was closed, like mysqlctl.SlaveConnection.
+type dumpErrorConnection struct {
+	fakeBinlogConnection
+	err error
+}
+
+func (conn *dumpErrorConnection) DumpError() error {
+	return conn.err
+}
+
+func TestStreamerServerError(t *testing.T) {
+	purged := sqldb.NewSQLError(1236, "HY000", "Could not find first log file name in binary log index file")
+	testcases := []struct {
+		desc    string
+		err     error
+		stopped bool
+		want    error
+	}{
+		{desc: "server error", err: purged, want: &ServerError{Position: sequencePosition(1), Err: purged}},
+		{desc: "clean end", want: ErrServerEOF},
+		{desc: "error after shutdown", err: purged, stopped: true, want: ErrServerStopped},
+	}
+
+	for _, tc := range testcases {
+		conn := &dumpErrorConnection{
+			fakeBinlogConnection: fakeBinlogConnection{
+				events: []replication.BinlogEvent{rotateEvent{}, formatEvent{}, sequenceEvent(1)},
+			},
+			err: tc.err,
+		}
+		if tc.stopped {
+			conn.events = append(conn.events, stopEvent{})
+		}
+		var got int
+		sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+			got++
+			return nil
+		}
+		bls := NewStreamerWithConn("vt_test_keyspace", conn, nil, replication.Position{}, sendTransaction)
+
+		svm := &sync2.ServiceManager{}
+		svm.Go(bls.Stream)
+		err := svm.Join()
+		if se, ok := tc.want.(*ServerError); ok {
+			// Stream() returns it as it is, so its code can be checked.
+			if !reflect.DeepEqual(err, se) {
+				t.Errorf("%v: Stream() = %#v, want %#v", tc.desc, err, se)
+			}
+			if got, ok := err.(*ServerError); !ok || got.Number() != 1236 {
+				t.Errorf("%v: Stream() = %v, want a ServerError with code 1236", tc.desc, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.want.Error()) {
+			t.Errorf("%v: Stream() = %v, want %v", tc.desc, err, tc.want)
+		}
+		if got != 1 {
+			t.Errorf("%v: got %v transactions, want 1", tc.desc, got)
+		}
+	}
+}
+
+func TestStreamerServerErrorSlaveConnection(t *testing.T) {
+	// A lost connection ends the dump of a SlaveConnection like an EOF
+	// packet, the other errors are ServerErrors.
+	purged := sqldb.NewSQLError(1236, "HY000", "Could not find first log file name in binary log index file")
+	testcases := []struct {
+		desc      string
+		err       error
+		want      error
+		wantCount int64
+	}{
+		{desc: "connection lost", err: sqldb.NewSQLError(mysql.ErrServerLost, "HY000", "Lost connection to MySQL server during query"), want: ErrServerEOF},
+		{desc: "server error", err: purged, want: &ServerError{Err: purged}, wantCount: 1},
+	}
+
+	for _, tc := range testcases {
+		mysqld := mysqlctl.NewFakeMysqlDaemon(nil)
+		mysqld.BinlogDump = make(chan []byte, 2)
+		mysqld.BinlogDump <- dumpPacket(mariadbRotateEvent)
+		mysqld.BinlogDump <- dumpPacket(mariadbFormatEvent)
+		close(mysqld.BinlogDump)
+		mysqld.BinlogDumpError = tc.err
+
+		bls := NewStreamer("vt_test_keyspace", mysqld, nil, replication.Position{}, func(*binlogdatapb.BinlogTransaction) error { return nil })
+		before := binlogStreamerErrors.Counts()["Server"]
+		svm := &sync2.ServiceManager{}
+		svm.Go(bls.Stream)
+		err := svm.Join()
+		if se, ok := tc.want.(*ServerError); ok {
+			if got, ok := err.(*ServerError); !ok || got.Err != se.Err {
+				t.Errorf("%v: Stream() = %#v, want a ServerError of %v", tc.desc, err, se.Err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.want.Error()) {
+			t.Errorf("%v: Stream() = %v, want %v", tc.desc, err, tc.want)
+		}
+		if got := binlogStreamerErrors.Counts()["Server"] - before; got != tc.wantCount {
+			t.Errorf("%v: got %v Server errors, want %v", tc.desc, got, tc.wantCount)
+		}
+	}
+}
+
+func TestStreamerParseEventsStopThenMoreEvents(t *testing.T) {
+	// When reading older binlogs, a STOP_EVENT is followed by the events of
+	// the next file. The stream should continue and end with ErrServerEOF.
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		stopEvent{},
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+	}
+	want := ErrServerEOF
 
 	events := make(chan replication.BinlogEvent)
 
+	var got []binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	if err := svm.Join(); err != want {
+		t.Errorf("wrong error, got %#v, want %#v", err, want)
+	}
+	if len(got) != 1 {
+		t.Errorf("got %v transactions, want 1", len(got))
+	}
+}
+
+func TestStreamerParseEventsSendErrorXID(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+		xidEvent{},
+	}
+	want := "send reply error: foobar"
+
+	events := make(chan replication.BinlogEvent)
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return fmt.Errorf("foobar")
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	err := svm.Join()
+	if err == nil {
+		t.Errorf("expected error, got none")
+		return
+	}
+	if got := err.Error(); got != want {
+		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	}
+}
+
+func TestStreamerParseEventsSendErrorCommit(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "COMMIT"}},
+	}
+	want := "send reply error: foobar"
+
+	events := make(chan replication.BinlogEvent)
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return fmt.Errorf("foobar")
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	err := svm.Join()
+	if err == nil {
+		t.Errorf("expected error, got none")
+		return
+	}
+	if got := err.Error(); got != want {
+		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	}
+}
+
+func TestStreamerParseEventsInvalid(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		invalidEvent{},
+		xidEvent{},
+	}
+	want := "can't parse binlog event, invalid data:"
+
+	events := make(chan replication.BinlogEvent)
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	err := svm.Join()
+	if err == nil {
+		t.Errorf("expected error, got none")
+		return
+	}
+	if got := err.Error(); !strings.HasPrefix(got, want) {
+		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	}
+}
+
+func TestStreamerParseEventsInvalidFormat(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		invalidFormatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+		xidEvent{},
+	}
+	want := "can't parse FORMAT_DESCRIPTION_EVENT:"
+
+	events := make(chan replication.BinlogEvent)
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	err := svm.Join()
+	if err == nil {
+		t.Errorf("expected error, got none")
+		return
+	}
+	if got := err.Error(); !strings.HasPrefix(got, want) {
+		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	}
+}
+
+func TestStreamerParseEventsNoFormat(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		//formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+		xidEvent{},
+	}
+	want := "got a real event before FORMAT_DESCRIPTION_EVENT:"
+
+	events := make(chan replication.BinlogEvent)
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	err := svm.Join()
+	if err == nil {
+		t.Errorf("expected error, got none")
+		return
+	}
+	if got := err.Error(); !strings.HasPrefix(got, want) {
+		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	}
+}
+
+func TestStreamerParseEventsTolerateBeforeFormat(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		xidEvent{},
+		fakeEvent{},
+		xidEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1)"}},
+	}
+
+	var got []binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+
+	// Only tolerating some of the injected events is still an error, which
+	// names the offending event type.
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.TolerateBeforeFormat = map[string]bool{"XID": true}
+	want := "(type Other)"
+	if err := parseTestEvents(bls, input); err == nil || !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("wrong error, got %v, want %v", err, want)
+	}
+
+	before := binlogStreamerPreFormatEvents.Counts()
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.TolerateBeforeFormat = map[string]bool{"XID": true, "Other": true}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(got) != 1 {
+		t.Errorf("got %v transactions, want 1", len(got))
+	}
+	after := binlogStreamerPreFormatEvents.Counts()
+	if got, want := after["XID"]-before["XID"], int64(2); got != want {
+		t.Errorf("BinlogStreamerPreFormatEvents[XID] increased by %v, want %v", got, want)
+	}
+	if got, want := after["Other"]-before["Other"], int64(1); got != want {
+		t.Errorf("BinlogStreamerPreFormatEvents[Other] increased by %v, want %v", got, want)
+	}
+}
+
+func TestStreamerParseEventsSkipMasterErrors(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database:  "vt_test_keyspace",
+			SQL:       "insert into vt_a(eid, id) values (1, 1)",
+			ErrorCode: 1062}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (2, 2)"}},
+		xidEvent{},
+		// An autocommit statement that failed on the master.
+		queryEvent{query: replication.Query{
+			Database:  "vt_test_keyspace",
+			SQL:       "insert into vt_a(eid, id) values (3, 3)",
+			ErrorCode: 1062}},
+	}
+
+	var got []binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+
+	// By default, statements that failed on the master are sent anyway.
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || len(got[0].Statements) != 4 || len(got[1].Statements) != 2 {
+		t.Fatalf("without SkipMasterErrors: got %v, want 2 transactions with 4 and 2 statements", got)
+	}
+
+	got = nil
+	var filtered []bool
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SkipMasterErrors = true
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		filtered = append(filtered, md.Filtered)
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := []*binlogdatapb.BinlogTransaction_Statement{
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (2, 2)"},
+	}
+	// The autocommit statement is replaced by an empty, filtered
+	// transaction.
+	if len(got) != 2 || !reflect.DeepEqual(got[0].Statements, want) || len(got[1].Statements) != 0 {
+		t.Errorf("with SkipMasterErrors: got %v, want transactions with statements %v and none", got, want)
+	}
+	if want := []bool{false, true}; !reflect.DeepEqual(filtered, want) {
+		t.Errorf("with SkipMasterErrors: got Filtered %v, want %v", filtered, want)
+	}
+}
+
+func TestStreamerParseEventsEmptyQuery(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      ""}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      " \n\t"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1)"}},
+		xidEvent{},
+	}
+
+	var got []binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	before := binlogStreamerEmptyQueries.Get()
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got, want := binlogStreamerEmptyQueries.Get()-before, int64(2); got != want {
+		t.Errorf("BinlogStreamerEmptyQueries = %v, want %v", got, want)
+	}
+
+	// The empty query outside of a transaction still advances the
+	// position with an empty transaction.
+	want := []binlogdatapb.BinlogTransaction{
+		{
+			Timestamp:     1407805592,
+			TransactionId: replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 0x0d}),
+		},
+		{
+			Statements: []*binlogdatapb.BinlogTransaction_Statement{
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1)"},
+			},
+			Timestamp:     1407805592,
+			TransactionId: replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 0x0d}),
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("binlogConnStreamer.parseEvents(): got %v, want %v", got, want)
+	}
+}
+
+func TestStreamerParseEventsOversized(t *testing.T) {
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+
+	testcases := []struct {
+		maxEventLength uint32
+		length         uint32
+		want           string
+	}{
+		{
+			length: 1<<32 - 1,
+			want:   "binlog event declares an implausible length of 4294967295 bytes (more than 1073741824), the stream is probably corrupted",
+		},
+		{
+			maxEventLength: 4 << 20,
+			length:         16 << 20,
+			want:           "binlog event length of 16777216 bytes exceeds the maximum of 4194304 bytes, check that max_allowed_packet on mysqld matches the streamer",
+		},
+		{
+			// Without a maximum, a truncated event is just invalid.
+			length: 16 << 20,
+			want:   "can't parse binlog event, invalid data:",
+		},
+	}
+	for _, tcase := range testcases {
+		input := []replication.BinlogEvent{
+			rotateEvent{},
+			formatEvent{},
+			oversizedEvent{length: tcase.length},
+		}
+		bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+		bls.MaxEventLength = tcase.maxEventLength
+
+		before := binlogStreamerErrors.Counts()["EventLength"]
+		err := parseTestEvents(bls, input)
+		if err == nil || !strings.HasPrefix(err.Error(), tcase.want) {
+			t.Errorf("MaxEventLength %v, length %v: wrong error, got %v, want %v", tcase.maxEventLength, tcase.length, err, tcase.want)
+		}
+		wantCount := int64(1)
+		if tcase.maxEventLength == 0 && tcase.length < 1<<30 {
+			wantCount = 0
+		}
+		if got := binlogStreamerErrors.Counts()["EventLength"] - before; got != wantCount {
+			t.Errorf("MaxEventLength %v, length %v: BinlogStreamerErrors[EventLength] increased by %v, want %v", tcase.maxEventLength, tcase.length, got, wantCount)
+		}
+	}
+}
+
+func TestStreamerParseEventsDropStatements(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1)"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_secret(eid, ssn) values (1, '123-45-6789')"}},
+		xidEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "update vt_secret set ssn = '987-65-4321' where eid = 1"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "SET INSERT_ID=1 /* vt_secret */"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_secret(eid, ssn) values (2, '555-55-5555')"}},
+		xidEvent{},
+	}
+
+	var got []binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.DropStatements = []*regexp.Regexp{
+		regexp.MustCompile(`^insert into vt_other`),
+		regexp.MustCompile(`\bvt_secret\b`),
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// The autocommit update was replaced by an empty transaction, and only
+	// DML and DDL statements are dropped.
+	want := []binlogdatapb.BinlogTransaction{
+		{
+			Statements: []*binlogdatapb.BinlogTransaction_Statement{
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1)"},
+			},
+		},
+		{},
+		{
+			Statements: []*binlogdatapb.BinlogTransaction_Statement{
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET INSERT_ID=1 /* vt_secret */"},
+			},
+		},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v transactions, want %v: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i].Statements, want[i].Statements) {
+			t.Errorf("transaction %v: got statements %v, want %v", i, got[i].Statements, want[i].Statements)
+		}
+	}
+}
+
+func TestStreamerSetFilter(t *testing.T) {
+	var got [][]string
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		var dml []string
+		for _, stmt := range trans.Statements {
+			if stmt.Category == binlogdatapb.BinlogTransaction_Statement_BL_DML {
+				dml = append(dml, stmt.Sql)
+			}
+		}
+		got = append(got, dml)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	events := make(chan replication.BinlogEvent)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	send := func(input ...replication.BinlogEvent) {
+		for _, ev := range input {
+			events <- ev
+		}
+	}
+	send(
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1)"}},
+	)
+
+	// The BEGIN has been processed, so the new filter only applies after
+	// this transaction. It's set over and over while the stream runs.
+	filter := Filter{
+		Database:       "other",
+		DropStatements: []*regexp.Regexp{regexp.MustCompile(`\bvt_b\b`)},
+	}
+	bls.SetFilter(filter)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				bls.SetFilter(filter)
+			}
+		}
+	}()
+
+	send(
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_b(eid, id) values (1, 1)"}},
+		xidEvent{},
+		queryEvent{query: replication.Query{
+			Database: "other",
+			SQL:      "insert into vt_b(eid, id) values (2, 2)"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (3, 3)"}},
+		queryEvent{query: replication.Query{
+			Database: "other",
+			SQL:      "insert into vt_a(eid, id) values (4, 4)"}},
+	)
+	close(events)
+	if err := svm.Join(); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	close(done)
+	wg.Wait()
+
+	want := [][]string{
+		{"insert into vt_a(eid, id) values (1, 1)", "insert into vt_b(eid, id) values (1, 1)"},
+		// The dropped autocommit insert.
+		nil,
+		{"insert into vt_a(eid, id) values (4, 4)"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %v, want %v", got, want)
+	}
+}
+
+func TestStreamerParseEventsSetTimestamp(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1)"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (2, 2)"}},
+		xidEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "update vt_a set id = 3 where eid = 1"}},
+	}
+
+	setTimestamp := &binlogdatapb.BinlogTransaction_Statement{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"}
+	insert1 := &binlogdatapb.BinlogTransaction_Statement{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1)"}
+	insert2 := &binlogdatapb.BinlogTransaction_Statement{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (2, 2)"}
+	update := &binlogdatapb.BinlogTransaction_Statement{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "update vt_a set id = 3 where eid = 1"}
+
+	testcases := []struct {
+		mode SetTimestampMode
+		want [][]*binlogdatapb.BinlogTransaction_Statement
+	}{
+		{
+			mode: SetTimestampEveryStatement,
+			want: [][]*binlogdatapb.BinlogTransaction_Statement{
+				{setTimestamp, insert1, setTimestamp, insert2},
+				{setTimestamp, update},
+			},
+		},
+		{
+			mode: SetTimestampOncePerTransaction,
+			want: [][]*binlogdatapb.BinlogTransaction_Statement{
+				{setTimestamp, insert1, insert2},
+				{setTimestamp, update},
+			},
+		},
+		{
+			mode: SetTimestampNever,
+			want: [][]*binlogdatapb.BinlogTransaction_Statement{
+				{insert1, insert2},
+				{update},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		var got [][]*binlogdatapb.BinlogTransaction_Statement
+		sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+			got = append(got, trans.Statements)
+			return nil
+		}
+		bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+		bls.SetTimestamp = tc.mode
+		if err := parseTestEvents(bls, input); err != ErrServerEOF {
+			t.Errorf("mode %v: unexpected error: %v", tc.mode, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("mode %v: got %v, want %v", tc.mode, got, tc.want)
+		}
+	}
+}
+
+func TestStreamerParseEventsFormatChanged(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		versionFormatEvent{serverVersion: "5.6.24-log"},
+		xidEvent{},
+		rotateEvent{},
+		versionFormatEvent{serverVersion: "5.6.24-log"},
+		xidEvent{},
+		rotateEvent{},
+		versionFormatEvent{serverVersion: "5.7.12-log"},
+		xidEvent{},
+	}
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	type change struct {
+		old, new replication.BinlogFormat
+	}
+	var got []change
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.FormatChanged = func(old, new replication.BinlogFormat) {
+		got = append(got, change{old, new})
+	}
+
+	before := binlogStreamerFormatChanges.Get()
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// The initial format and the identical one aren't changes.
+	want := []change{{
+		old: replication.BinlogFormat{FormatVersion: 1, ServerVersion: "5.6.24-log"},
+		new: replication.BinlogFormat{FormatVersion: 1, ServerVersion: "5.7.12-log"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FormatChanged calls: got %v, want %v", got, want)
+	}
+	if got := binlogStreamerFormatChanges.Get() - before; got != 1 {
+		t.Errorf("BinlogStreamerFormatChanges increased by %v, want 1", got)
+	}
+}
+
+func TestStreamerSendEvent(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		sequenceQueryEvent{
+			queryEvent: queryEvent{query: replication.Query{
+				Database: "vt_test_keyspace",
+				SQL:      "BEGIN"}},
+			sequence: 2,
+		},
+		intVarEvent{name: "INSERT_ID", value: 101},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (null, 1)"}},
+		xidEvent{},
+		rotateEvent{},
+	}
+
+	var gotEvents []*StreamEvent
+	sendEvent := func(ev *StreamEvent) error {
+		gotEvents = append(gotEvents, ev)
+		return nil
+	}
+	var gotTransactions []binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		gotTransactions = append(gotTransactions, *trans)
+		return nil
+	}
+
+	wantEvents := []*StreamEvent{
+		{
+			Type:      "Query",
+			Position:  sequencePosition(2),
+			Timestamp: 1407805592,
+			GTID:      replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 2},
+			Query:     &replication.Query{Database: "vt_test_keyspace", SQL: "BEGIN"},
+		},
+		{
+			Type:        "IntVar",
+			Position:    sequencePosition(13),
+			Timestamp:   1407805592,
+			GTID:        replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 13},
+			IntVarName:  "INSERT_ID",
+			IntVarValue: 101,
+		},
+		{
+			Type:      "Query",
+			Position:  sequencePosition(13),
+			Timestamp: 1407805592,
+			GTID:      replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 13},
+			Query:     &replication.Query{Database: "vt_test_keyspace", SQL: "insert into vt_a(eid, id) values (null, 1)"},
+		},
+		{
+			Type:      "XID",
+			Position:  sequencePosition(13),
+			Timestamp: 1407805592,
+			GTID:      replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 13},
+		},
+		{
+			Type:      "Rotate",
+			Position:  sequencePosition(13),
+			Timestamp: 1407805592,
+			GTID:      replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 13},
+		},
+	}
+
+	// Events only.
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, nil)
+	bls.SendEvent = sendEvent
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(gotEvents, wantEvents) {
+		t.Errorf("events only: got events %v, want %v", gotEvents, wantEvents)
+	}
+
+	// Events and transactions.
+	gotEvents = nil
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SendEvent = sendEvent
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(gotEvents, wantEvents) {
+		t.Errorf("events and transactions: got events %v, want %v", gotEvents, wantEvents)
+	}
+	if len(gotTransactions) != 1 || len(gotTransactions[0].Statements) != 3 {
+		t.Errorf("events and transactions: got transactions %v, want 1 with 3 statements", gotTransactions)
+	}
+
+	// The event consumer can end the stream.
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, nil)
+	bls.SendEvent = func(ev *StreamEvent) error {
+		return io.EOF
+	}
+	if err := parseTestEvents(bls, input); err != ErrClientEOF {
+		t.Errorf("wrong error, got %v, want %v", err, ErrClientEOF)
+	}
+}
+
+func TestStreamerSendMetadata(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		intVarEvent{name: "INSERT_ID", value: 101},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (null, 1)"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (null, 2)"}},
+		xidEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (3, 3)"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "ROLLBACK"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (4, 4)"}},
+	}
+
+	for _, mode := range []BeginCommitMode{BeginCommitNone, BeginCommitAll} {
+		var got []TransactionMetadata
+		var want []TransactionMetadata
+		sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+			md := TransactionMetadata{
+				Statements:      len(trans.Statements),
+				BeginTimestamp:  trans.Timestamp,
+				CommitTimestamp: trans.Timestamp,
+			}
+			for _, st := range trans.Statements {
+				md.Size += len(st.Sql)
+			}
+			if len(trans.Statements) != 0 {
+				md.LogFormat = LogFormatStatement
+			}
+			want = append(want, md)
+			return nil
+		}
+		bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+		bls.BeginCommit = mode
+		bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+			got = append(got, md)
+		}
+		if err := parseTestEvents(bls, input); err != ErrServerEOF {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if len(want) != 3 {
+			t.Errorf("BeginCommit %v: got %v transactions, want 3", mode, len(want))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("BeginCommit %v: got metadata %v, want %v", mode, got, want)
+		}
+	}
+}
+
+func TestStreamerLogFormat(t *testing.T) {
+	query := func(sql string) replication.BinlogEvent {
+		return queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: sql}}
+	}
+	writeRows := otherEvent{typ: 30}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		// Statement based.
+		query("BEGIN"),
+		query("insert into vt_a(eid, id) values (1, 1)"),
+		xidEvent{},
+		// Row based.
+		query("BEGIN"),
+		tableMapEvent{tm: replication.TableMap{Database: "vt_test_keyspace", Name: "vt_a"}},
+		writeRows,
+		xidEvent{},
+		// Mixed.
+		query("BEGIN"),
+		query("insert into vt_a(eid, id) values (2, 2)"),
+		tableMapEvent{tm: replication.TableMap{Database: "vt_test_keyspace", Name: "vt_a"}},
+		writeRows,
+		xidEvent{},
+		// A DDL is a statement.
+		query("alter table vt_a add column msg varchar(64)"),
+		// A rolled back transaction has no changes.
+		query("BEGIN"),
+		writeRows,
+		query("ROLLBACK"),
+	}
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	var got []LogFormat
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		got = append(got, md.LogFormat)
+	}
+	var switches [][2]LogFormat
+	bls.LogFormatChanged = func(old, new LogFormat) {
+		switches = append(switches, [2]LogFormat{old, new})
+	}
+	before := binlogStreamerLogFormatSwitches.Get()
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := []LogFormat{LogFormatStatement, LogFormatRow, LogFormatMixed, LogFormatStatement, LogFormatNone}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got log formats %v, want %v", got, want)
+	}
+	// The DDL and the ROLLBACK don't switch the format.
+	wantSwitches := [][2]LogFormat{{LogFormatStatement, LogFormatRow}, {LogFormatRow, LogFormatMixed}}
+	if !reflect.DeepEqual(switches, wantSwitches) {
+		t.Errorf("got log format switches %v, want %v", switches, wantSwitches)
+	}
+	if got := binlogStreamerLogFormatSwitches.Get() - before; got != 2 {
+		t.Errorf("BinlogStreamerLogFormatSwitches increased by %v, want 2", got)
+	}
+}
+
+func TestStreamerPositionObserver(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		sequenceEvent(1),
+		sequenceQueryEvent{
+			queryEvent: queryEvent{query: replication.Query{
+				Database: "vt_test_keyspace",
+				SQL:      "insert into vt_secret(eid, ssn) values (1, '123-45-6789')"}},
+			sequence: 2,
+		},
+		sequenceQueryEvent{
+			queryEvent: queryEvent{query: replication.Query{
+				Database: "other",
+				SQL:      "insert into vt_b(eid, id) values (1, 1)"}},
+			sequence: 3,
+		},
+		sequenceEvent(4),
+	}
+
+	var sent int
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		sent++
+		return nil
+	}
+	var got []replication.Position
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.DropStatements = []*regexp.Regexp{regexp.MustCompile(`\bvt_secret\b`)}
+	bls.PositionObserver = func(pos replication.Position, gtid replication.GTID, timestamp uint32) {
+		if !pos.Equal(replication.AppendGTID(replication.Position{}, gtid)) {
+			t.Errorf("position %v doesn't match GTID %v", pos, gtid)
+		}
+		if timestamp != 1407805592 {
+			t.Errorf("got timestamp %v, want 1407805592", timestamp)
+		}
+		got = append(got, pos)
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// The dropped statement was sent as an empty transaction, and the
+	// cross-db one wasn't sent at all, but both advanced the position.
+	want := []replication.Position{
+		sequencePosition(1),
+		sequencePosition(2),
+		sequencePosition(3),
+		sequencePosition(4),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got positions %v, want %v", got, want)
+	}
+	if sent != 3 {
+		t.Errorf("got %v transactions, want 3", sent)
+	}
+}
+
+func TestStreamerTableMapObserver(t *testing.T) {
+	vtA := replication.TableMap{
+		TableID:   0x4d,
+		Database:  "vt_test_keyspace",
+		Name:      "vt_a",
+		Types:     []byte{8, 15},
+		Metadata:  []uint16{0, 384},
+		CanBeNull: []bool{false, true},
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		tableMapEvent{tm: vtA},
+		tableMapEvent{tm: replication.TableMap{TableID: 0x4e, Database: "other", Name: "vt_b"}},
+		// A rows event, which isn't decoded.
+		fakeEvent{},
+		xidEvent{},
+	}
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+
+	var got []*replication.TableMap
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.TableMapObserver = func(tm *replication.TableMap) {
+		got = append(got, tm)
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// The table of the other database isn't reported.
+	if want := []*replication.TableMap{&vtA}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got table maps %v, want %v", got, want)
+	}
+
+	// TABLE_MAP_EVENTs are only decoded for a TableMapObserver.
+	input[3] = invalidTableMapEvent{}
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("without TableMapObserver: unexpected error: %v", err)
+	}
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.TableMapObserver = func(tm *replication.TableMap) {}
+	want := "can't parse TABLE_MAP_EVENT: invalid table map"
+	if err := parseTestEvents(bls, input); err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("with TableMapObserver: got error %v, want %v", err, want)
+	}
+}
+
+func TestStreamerParseEventsXA(t *testing.T) {
+	xa := replication.XAPrepare{FormatID: 1, GTRID: []byte("trx1"), BQual: []byte("b1")}
+	xaQuery := func(sql string) replication.BinlogEvent {
+		return queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      sql}}
+	}
+	branch := []replication.BinlogEvent{
+		xaQuery("XA START X'74727831',X'6231',1"),
+		xaQuery("insert into vt_a(eid, id) values (1, 1)"),
+		xaQuery("XA END X'74727831',X'6231',1"),
+	}
+	onePhase := xa
+	onePhase.OnePhase = true
+	insert := []*binlogdatapb.BinlogTransaction_Statement{
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1)"},
+	}
+	autocommit := []*binlogdatapb.BinlogTransaction_Statement{
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (2, 1)"},
+	}
+
+	testcases := []struct {
+		desc       string
+		input      []replication.BinlogEvent
+		want       [][]*binlogdatapb.BinlogTransaction_Statement
+		wantErrors int64
+	}{
+		{
+			// Other transactions can be sent between the prepare and the
+			// commit of the branch.
+			desc: "prepare then commit",
+			input: append(append([]replication.BinlogEvent{}, branch...),
+				xaPrepareEvent{xa: xa},
+				sequenceEvent(2),
+				xaQuery("XA COMMIT X'74727831',X'6231',1")),
+			want: [][]*binlogdatapb.BinlogTransaction_Statement{autocommit, insert},
+		},
+		{
+			desc: "prepare then rollback",
+			input: append(append([]replication.BinlogEvent{}, branch...),
+				xaPrepareEvent{xa: xa},
+				xaQuery("XA ROLLBACK X'74727831',X'6231',1")),
+			want: [][]*binlogdatapb.BinlogTransaction_Statement{nil},
+		},
+		{
+			desc: "one phase commit",
+			input: append(append([]replication.BinlogEvent{}, branch...),
+				xaPrepareEvent{xa: onePhase}),
+			want: [][]*binlogdatapb.BinlogTransaction_Statement{insert},
+		},
+		{
+			desc:       "commit of a branch prepared before the stream",
+			input:      []replication.BinlogEvent{xaQuery("XA COMMIT X'74727832',X'',1")},
+			want:       [][]*binlogdatapb.BinlogTransaction_Statement{nil},
+			wantErrors: 1,
+		},
+	}
+	for _, tc := range testcases {
+		var got [][]*binlogdatapb.BinlogTransaction_Statement
+		sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+			got = append(got, trans.Statements)
+			return nil
+		}
+		bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+		before := binlogStreamerErrors.Counts()["XACommit"]
+		input := append([]replication.BinlogEvent{rotateEvent{}, formatEvent{}}, tc.input...)
+		if err := parseTestEvents(bls, input); err != ErrServerEOF {
+			t.Errorf("%v: unexpected error: %v", tc.desc, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got transactions %v, want %v", tc.desc, got, tc.want)
+		}
+		if got := binlogStreamerErrors.Counts()["XACommit"] - before; got != tc.wantErrors {
+			t.Errorf("%v: got %v XACommit errors, want %v", tc.desc, got, tc.wantErrors)
+		}
+	}
+}
+
+func TestStreamerXAPrepareEndsTransaction(t *testing.T) {
+	xaQuery := func(sql string) replication.BinlogEvent {
+		return queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      sql}}
+	}
+	// The commit timestamps of the GTID_EVENT of the prepared branch don't
+	// belong to the transaction after it.
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		gtidEvent{lastCommitted: 1, sequenceNumber: 2, originalCommit: 1407805592000000, immediateCommit: 1407805592000000},
+		xaQuery("XA START X'74727831',X'6231',1"),
+		xaQuery("insert into vt_a(eid, id) values (1, 1)"),
+		xaQuery("XA END X'74727831',X'6231',1"),
+		xaPrepareEvent{xa: replication.XAPrepare{FormatID: 1, GTRID: []byte("trx1"), BQual: []byte("b1")}},
+		xaQuery("BEGIN"),
+		xaQuery("insert into vt_a(eid, id) values (2, 1)"),
+		xidEvent{},
+	}
+
+	var got [][2]int64
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, func(*binlogdatapb.BinlogTransaction) error { return nil })
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		got = append(got, [2]int64{md.OriginalCommitTimestamp, md.ImmediateCommitTimestamp})
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if want := [][2]int64{{0, 0}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got commit timestamps %v, want %v", got, want)
+	}
+}
+
+func TestParseXAStatement(t *testing.T) {
+	testcases := []struct {
+		sql      string
+		wantVerb string
+		wantXID  string
+		wantOK   bool
+	}{
+		{"XA START X'74727831',X'6231',1", "START", "X'74727831',X'6231',1", true},
+		{"xa commit X'74727831',X'6231',1 ", "COMMIT", "X'74727831',X'6231',1", true},
+		{"XA RECOVER", "RECOVER", "", true},
+		{"XA", "", "", false},
+		{"insert into xa values (1)", "", "", false},
+	}
+	for _, tc := range testcases {
+		verb, xid, ok := parseXAStatement(tc.sql)
+		if verb != tc.wantVerb || xid != tc.wantXID || ok != tc.wantOK {
+			t.Errorf("parseXAStatement(%q) = (%q, %q, %v), want (%q, %q, %v)", tc.sql, verb, xid, ok, tc.wantVerb, tc.wantXID, tc.wantOK)
+		}
+	}
+}
+
+func TestStreamerFilteredTransactions(t *testing.T) {
+	query := func(db, sql string) replication.BinlogEvent {
+		return queryEvent{query: replication.Query{Database: db, SQL: sql}}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		// All the statements are dropped.
+		query("vt_test_keyspace", "BEGIN"),
+		query("vt_test_keyspace", "insert into vt_secret(eid, ssn) values (1, '123-45-6789')"),
+		xidEvent{},
+		// A rollback.
+		query("vt_test_keyspace", "BEGIN"),
+		query("vt_test_keyspace", "ROLLBACK"),
+		// A dropped autocommit statement.
+		query("vt_test_keyspace", "update vt_secret set ssn = '987-65-4321' where eid = 1"),
+		// All the statements are for another database.
+		query("vt_test_keyspace", "BEGIN"),
+		query("other", "insert into vt_a(eid, id) values (1, 1)"),
+		xidEvent{},
+		// Only some statements are dropped.
+		query("vt_test_keyspace", "BEGIN"),
+		query("vt_test_keyspace", "insert into vt_a(eid, id) values (2, 2)"),
+		query("vt_test_keyspace", "insert into vt_secret(eid, ssn) values (2, '555-55-5555')"),
+		xidEvent{},
+		// Nothing is sent for the autocommit statement of another database,
+		// and the next transaction is really empty.
+		query("other", "insert into vt_a(eid, id) values (3, 3)"),
+		query("vt_test_keyspace", "BEGIN"),
+		xidEvent{},
+	}
+
+	var got []bool
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.DropStatements = []*regexp.Regexp{regexp.MustCompile(`\bvt_secret\b`)}
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		got = append(got, md.Filtered)
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := []bool{true, false, true, true, false, false}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got Filtered %v, want %v", got, want)
+	}
+}
+
+func TestStreamerDDLOnly(t *testing.T) {
+	query := func(sql string) replication.BinlogEvent {
+		return queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: sql}}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		query("BEGIN"),
+		query("insert into vt_a(eid, id) values (1, 1)"),
+		xidEvent{},
+		query("create table vt_b(eid int)"),
+		query("SET @@session.sql_mode = ''"),
+		query("alter table vt_b add column id int"),
+	}
+
+	var got [][]string
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		var sqls []string
+		for _, st := range trans.Statements {
+			sqls = append(sqls, st.Sql)
+		}
+		got = append(got, sqls)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SetTimestamp = SetTimestampNever
+	bls.DDLOnly = true
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// The transactions without DDLs are sent empty.
+	want := [][]string{
+		nil,
+		{"create table vt_b(eid int)"},
+		nil,
+		{"alter table vt_b add column id int"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %q, want %q", got, want)
+	}
+}
+
+func TestStreamerDedupDDLWindow(t *testing.T) {
+	ddl := func(sql string, timestamp uint32) replication.BinlogEvent {
+		return timestampEvent{queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: sql}}, timestamp}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		ddl("alter table vt_a add column id int", 1407805590),
+		// A retry of the same DDL is dropped.
+		ddl("alter table vt_a add column id int", 1407805595),
+		// Distinct DDLs are all sent, even if they repeat an earlier one.
+		ddl("alter table vt_b add column id int", 1407805596),
+		ddl("alter table vt_a add column id int", 1407805597),
+		ddl("alter table vt_a add column  id int", 1407805598),
+		// The same DDL again, but after the window.
+		ddl("alter table vt_a add column  id int", 1407805700),
+	}
+
+	var got []string
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		for _, st := range trans.Statements {
+			got = append(got, st.Sql)
+		}
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SetTimestamp = SetTimestampNever
+	bls.DedupDDLWindow = time.Minute
+	before := binlogStreamerDuplicateDDLs.Get()
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := []string{
+		"alter table vt_a add column id int",
+		"alter table vt_b add column id int",
+		"alter table vt_a add column id int",
+		"alter table vt_a add column  id int",
+		"alter table vt_a add column  id int",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %q, want %q", got, want)
+	}
+	if got, want := binlogStreamerDuplicateDDLs.Get()-before, int64(1); got != want {
+		t.Errorf("BinlogStreamerDuplicateDDLs went up by %v, want %v", got, want)
+	}
+}
+
+func TestStreamerSkipGTIDs(t *testing.T) {
+	query := func(seq uint64, sql string) replication.BinlogEvent {
+		return sequenceQueryEvent{
+			queryEvent: queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: sql}},
+			sequence:   seq,
+		}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		sequenceEvent(1),
+		sequenceEvent(2),
+		query(3, "BEGIN"),
+		query(3, "insert into vt_a(eid, id) values (3, 1)"),
+		query(3, "update vt_a set id = 2 where eid = 3"),
+		query(3, "COMMIT"),
+		// 20 isn't skipped, even though "0-62344-2" is a prefix of it.
+		sequenceEvent(20),
+	}
+
+	type transaction struct {
+		id         string
+		statements []string
+	}
+	var got []transaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		var sqls []string
+		for _, st := range trans.Statements {
+			sqls = append(sqls, st.Sql)
+		}
+		got = append(got, transaction{trans.TransactionId, sqls})
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SetTimestamp = SetTimestampNever
+	bls.SkipGTIDs = map[replication.GTID]bool{
+		replication.MustParseGTID(FlavorMariaDB, "0-62344-2"): true,
+		replication.MustParseGTID(FlavorMariaDB, "0-62344-3"): true,
+	}
+	store := NewMemoryPositionStore()
+	bls.PositionStore = store
+	before := binlogStreamerSkippedGTIDs.Get()
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	id := func(seq uint64) string {
+		return replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: seq})
+	}
+	// The skipped transactions are sent empty.
+	want := []transaction{
+		{id(1), []string{"insert into vt_a(eid, id) values (1, 1)"}},
+		{id(2), nil},
+		{id(3), nil},
+		{id(20), []string{"insert into vt_a(eid, id) values (20, 1)"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+	if got, want := binlogStreamerSkippedGTIDs.Get()-before, int64(2); got != want {
+		t.Errorf("BinlogStreamerSkippedGTIDs went up by %v, want %v", got, want)
+	}
+	if pos, _ := store.Load(); !pos.Equal(sequencePosition(20)) {
+		t.Errorf("saved position %v, want %v", pos, sequencePosition(20))
+	}
+}
+
+func TestStreamerSuppressEmptyTransactions(t *testing.T) {
+	query := func(seq uint64, sql string) replication.BinlogEvent {
+		return sequenceQueryEvent{
+			queryEvent: queryEvent{query: replication.Query{Database: "other", SQL: sql}},
+			sequence:   seq,
+		}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		sequenceEvent(1),
+		// Filtered out.
+		query(2, "BEGIN"),
+		query(2, "insert into vt_b(eid, id) values (2, 1)"),
+		query(2, "COMMIT"),
+		sequenceEvent(3),
+		// Rolled back.
+		query(4, "BEGIN"),
+		query(4, "ROLLBACK"),
+		// Filtered out, at the end of the stream.
+		query(5, "BEGIN"),
+		query(5, "insert into vt_b(eid, id) values (5, 1)"),
+		query(5, "COMMIT"),
+	}
+
+	var got []string
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, trans.TransactionId)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SuppressEmptyTransactions = true
+	store := NewMemoryPositionStore()
+	bls.PositionStore = store
+	before := binlogStreamerSuppressedTransactions.Get()
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	id := func(seq uint64) string {
+		return replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: seq})
+	}
+	if want := []string{id(1), id(3)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+	if got, want := binlogStreamerSuppressedTransactions.Get()-before, int64(3); got != want {
+		t.Errorf("BinlogStreamerSuppressedTransactions went up by %v, want %v", got, want)
+	}
+	// The suppressed transactions still advance the position.
+	if pos, _ := store.Load(); !pos.Equal(sequencePosition(5)) {
+		t.Errorf("saved position %v, want %v", pos, sequencePosition(5))
+	}
+	if err := bls.WaitForPosition(context.Background(), sequencePosition(5)); err != nil {
+		t.Errorf("WaitForPosition(%v) failed: %v", sequencePosition(5), err)
+	}
+
+	// Without SuppressEmptyTransactions, they are sent.
+	got = nil
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if want := []string{id(1), id(2), id(3), id(4), id(5)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+}
+
+func TestStreamerSendRetries(t *testing.T) {
+	input := []replication.BinlogEvent{rotateEvent{}, formatEvent{}, sequenceEvent(1), sequenceEvent(2)}
+	id := func(seq uint64) string {
+		return replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: seq})
+	}
+	// stream returns the transactions sent with a consumer that fails
+	// failures times in a row to take the first one, with failure.
+	stream := func(failures int, failure error) ([]string, error) {
+		var got []string
+		sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+			got = append(got, trans.TransactionId)
+			if failures > 0 {
+				failures--
+				return failure
+			}
+			return nil
+		}
+		bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+		bls.SendRetries = 2
+		bls.SendRetryDelay = time.Millisecond
+		return got, parseTestEvents(bls, input)
+	}
+
+	// A transient failure is retried with the same transaction.
+	before := binlogStreamerSendRetries.Get()
+	got, err := stream(2, errors.New("transient"))
+	if err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if want := []string{id(1), id(1), id(1), id(2)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+	if got, want := binlogStreamerSendRetries.Get()-before, int64(2); got != want {
+		t.Errorf("BinlogStreamerSendRetries went up by %v, want %v", got, want)
+	}
+
+	// The stream ends once the retries are exhausted.
+	got, err = stream(3, errors.New("permanent"))
+	if err == nil || !strings.Contains(err.Error(), "permanent") {
+		t.Errorf("got error %v, want the send error", err)
+	}
+	if want := []string{id(1), id(1), id(1)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+
+	// io.EOF isn't retried.
+	got, err = stream(1, io.EOF)
+	if err != ErrClientEOF {
+		t.Errorf("got error %v, want %v", err, ErrClientEOF)
+	}
+	if want := []string{id(1)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+}
+
+func TestStreamerRewriteStatement(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: "BEGIN"}},
+		intVarEvent{name: "INSERT_ID", value: 101},
+		queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: "insert into vt_test_keyspace.vt_a(eid, id) values (null, 1)"}},
+		xidEvent{},
+		queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: "alter table vt_test_keyspace.vt_a add column msg varchar(64)"}},
+	}
+	// renameSchema points the statements to another database, and tags
+	// them with the one they come from.
+	renameSchema := func(category binlogdatapb.BinlogTransaction_Statement_Category, database, sql string) string {
+		return fmt.Sprintf("/* from %v */ %v", database, strings.Replace(sql, "vt_test_keyspace.", "vt_staging.", -1))
+	}
+
+	testcases := []struct {
+		desc        string
+		rewriteSets bool
+		want        []string
+	}{{
+		desc: "statements only",
+		want: []string{
+			"BEGIN",
+			"SET INSERT_ID=101",
+			"SET TIMESTAMP=1407805592",
+			"/* from vt_test_keyspace */ insert into vt_staging.vt_a(eid, id) values (null, 1)",
+			"COMMIT",
+			"BEGIN",
+			"SET TIMESTAMP=1407805592",
+			"/* from vt_test_keyspace */ alter table vt_staging.vt_a add column msg varchar(64)",
+			"COMMIT",
+		},
+	}, {
+		desc:        "with sets",
+		rewriteSets: true,
+		want: []string{
+			"BEGIN",
+			"/* from vt_test_keyspace */ SET INSERT_ID=101",
+			"/* from vt_test_keyspace */ SET TIMESTAMP=1407805592",
+			"/* from vt_test_keyspace */ insert into vt_staging.vt_a(eid, id) values (null, 1)",
+			"COMMIT",
+			"BEGIN",
+			"/* from vt_test_keyspace */ SET TIMESTAMP=1407805592",
+			"/* from vt_test_keyspace */ alter table vt_staging.vt_a add column msg varchar(64)",
+			"COMMIT",
+		},
+	}}
+
+	for _, tc := range testcases {
+		var got []string
+		sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+			for _, st := range trans.Statements {
+				got = append(got, st.Sql)
+			}
+			return nil
+		}
+		bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+		bls.BeginCommit = BeginCommitAll
+		bls.RewriteStatement = renameSchema
+		bls.RewriteSets = tc.rewriteSets
+		if err := parseTestEvents(bls, input); err != ErrServerEOF {
+			t.Errorf("%v: unexpected error: %v", tc.desc, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got statements %q, want %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestStreamerSetCharset(t *testing.T) {
+	latin1 := &binlogdatapb.Charset{Client: 8, Conn: 8, Server: 33}
+	utf8mb4 := &binlogdatapb.Charset{Client: 45, Conn: 45, Server: 33}
+	query := func(sql string, cs *binlogdatapb.Charset) replication.BinlogEvent {
+		return queryEvent{query: replication.Query{Database: "vt_test_keyspace", Charset: cs, SQL: sql}}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		query("BEGIN", latin1),
+		query("insert into vt_a(eid, id) values (1, 1)", latin1),
+		query("insert into vt_a(eid, id) values (2, 1)", latin1),
+		query("insert into vt_a(eid, id) values (3, 1)", utf8mb4),
+		query("insert into vt_a(eid, id) values (4, 1)", nil),
+		xidEvent{},
+		// A new transaction sets its charset again.
+		query("insert into vt_a(eid, id) values (5, 1)", utf8mb4),
+	}
+	want := []string{
+		"SET @@session.character_set_client=8, @@session.collation_connection=8, @@session.collation_server=33",
+		"insert into vt_a(eid, id) values (1, 1)",
+		"insert into vt_a(eid, id) values (2, 1)",
+		"SET @@session.character_set_client=45, @@session.collation_connection=45, @@session.collation_server=33",
+		"insert into vt_a(eid, id) values (3, 1)",
+		"insert into vt_a(eid, id) values (4, 1)",
+		"SET @@session.character_set_client=45, @@session.collation_connection=45, @@session.collation_server=33",
+		"insert into vt_a(eid, id) values (5, 1)",
+	}
+
+	var got []string
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		for _, st := range trans.Statements {
+			got = append(got, st.Sql)
+		}
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, latin1, replication.Position{}, sendTransaction)
+	bls.SetTimestamp = SetTimestampNever
+	bls.SetCharset = true
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %q, want %q", got, want)
+	}
+}
+
+func TestStreamerTransactionIntervals(t *testing.T) {
+	insert := queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: "insert into vt_a(eid, id) values (1, 1)"}}
+	input := []replication.BinlogEvent{rotateEvent{}, formatEvent{}, insert, insert, insert, insert, insert}
+	// The transactions are sent 50us, 5ms, 2s and 2s apart.
+	start := time.Unix(1407805592, 0)
+	times := []time.Time{
+		start,
+		start.Add(50 * time.Microsecond),
+		start.Add(5050 * time.Microsecond),
+		start.Add(2005050 * time.Microsecond),
+		start.Add(4005050 * time.Microsecond),
+	}
+
+	before := binlogStreamerTransactionIntervals.Buckets()
+	var sent int
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, func(*binlogdatapb.BinlogTransaction) error {
+		sent++
+		return nil
+	})
+	bls.now = func() time.Time {
+		if sent == 0 {
+			return start
+		}
+		return times[sent-1]
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	after := binlogStreamerTransactionIntervals.Buckets()
+	got := make(map[string]int64)
+	for i, label := range binlogStreamerTransactionIntervals.Labels() {
+		if n := after[i] - before[i]; n != 0 {
+			got[label] = n
+		}
+	}
+	want := map[string]int64{"100": 1, "10000": 1, "10000000": 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got intervals %v, want %v", got, want)
+	}
+}
+
+func TestStreamerIsolateDDL(t *testing.T) {
+	query := func(sql string) replication.BinlogEvent {
+		return queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: sql}}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		query("BEGIN"),
+		query("insert into vt_a(eid, id) values (1, 1)"),
+		query("alter table vt_a add column msg varchar(64)"),
+		query("insert into vt_a(eid, id, msg) values (2, 1, 'a')"),
+		query("insert into vt_a(eid, id, msg) values (3, 1, 'b')"),
+		xidEvent{},
+		// An autocommit DDL is sent as it is.
+		query("create table vt_b(id bigint)"),
+	}
+	type transaction struct {
+		id         string
+		statements []string
+	}
+	gtid := replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 13})
+	want := []transaction{
+		{"", []string{"BEGIN", "SET TIMESTAMP=1407805592", "insert into vt_a(eid, id) values (1, 1)", "COMMIT"}},
+		{"", []string{"SET TIMESTAMP=1407805592", "alter table vt_a add column msg varchar(64)"}},
+		{gtid, []string{"BEGIN", "SET TIMESTAMP=1407805592", "insert into vt_a(eid, id, msg) values (2, 1, 'a')", "SET TIMESTAMP=1407805592", "insert into vt_a(eid, id, msg) values (3, 1, 'b')", "COMMIT"}},
+		{gtid, []string{"SET TIMESTAMP=1407805592", "create table vt_b(id bigint)"}},
+	}
+
+	var got []transaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		var statements []string
+		for _, st := range trans.Statements {
+			statements = append(statements, st.Sql)
+		}
+		got = append(got, transaction{trans.TransactionId, statements})
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.BeginCommit = BeginCommitTransactions
+	bls.SetTimestamp = SetTimestampEveryStatement
+	bls.IsolateDDL = true
+	store := NewMemoryPositionStore()
+	bls.PositionStore = store
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %q, want %q", got, want)
+	}
+	if pos, _ := store.Load(); !pos.Equal(replication.Position{GTIDSet: replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 13}}) {
+		t.Errorf("saved position %v, want 0-62344-13", pos)
+	}
+}
+
+func TestSplitDDL(t *testing.T) {
+	st := func(category binlogdatapb.BinlogTransaction_Statement_Category) *binlogdatapb.BinlogTransaction_Statement {
+		return &binlogdatapb.BinlogTransaction_Statement{Category: category}
+	}
+	set := st(binlogdatapb.BinlogTransaction_Statement_BL_SET)
+	dml := st(binlogdatapb.BinlogTransaction_Statement_BL_DML)
+	ddl := st(binlogdatapb.BinlogTransaction_Statement_BL_DDL)
+	testcases := []struct {
+		statements []*binlogdatapb.BinlogTransaction_Statement
+		want       []int
+	}{
+		{nil, []int{0}},
+		{[]*binlogdatapb.BinlogTransaction_Statement{set, dml, set, dml}, []int{4}},
+		{[]*binlogdatapb.BinlogTransaction_Statement{set, ddl}, []int{2}},
+		{[]*binlogdatapb.BinlogTransaction_Statement{dml, set, ddl, dml}, []int{1, 2, 1}},
+		{[]*binlogdatapb.BinlogTransaction_Statement{ddl, ddl, set, dml}, []int{1, 1, 2}},
+		{[]*binlogdatapb.BinlogTransaction_Statement{set, dml, ddl}, []int{2, 1}},
+	}
+	for i, tc := range testcases {
+		positions := make([]LogPosition, len(tc.statements))
+		for j := range positions {
+			positions[j] = LogPosition{File: "vt-bin.000001", Offset: uint32(j)}
+		}
+		var got []int
+		offset := 0
+		for _, part := range splitDDL(tc.statements, positions) {
+			got = append(got, len(part.statements))
+			if len(part.logPositions) != len(part.statements) || (len(part.logPositions) != 0 && part.logPositions[0].Offset != uint32(offset)) {
+				t.Errorf("%v: wrong log positions %v for the part at %v", i, part.logPositions, offset)
+			}
+			offset += len(part.statements)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: got parts of %v statements, want %v", i, got, tc.want)
+		}
+	}
+}
+
+func TestStreamerBatchAutocommit(t *testing.T) {
+	query := func(seq uint64, sql string) replication.BinlogEvent {
+		return sequenceQueryEvent{
+			queryEvent: queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: sql}},
+			sequence:   seq,
+		}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		timestampEvent{sequenceEvent(1), 1407805590},
+		timestampEvent{sequenceEvent(2), 1407805591},
+		sequenceEvent(3),
+		sequenceEvent(4),
+		sequenceEvent(5),
+		// The real transaction ends the batch of 5, which is sent with
+		// the GTID of 5 even though the BEGIN has the next one.
+		query(6, "BEGIN"),
+		query(6, "insert into vt_a(eid, id) values (6, 1)"),
+		query(6, "COMMIT"),
+		// The last batch is sent at the end of the stream.
+		sequenceEvent(7),
+	}
+
+	type transaction struct {
+		id             string
+		statements     []string
+		beginTimestamp int64
+	}
+	var got []transaction
+	var md TransactionMetadata
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		var sqls []string
+		for _, st := range trans.Statements {
+			sqls = append(sqls, st.Sql)
+		}
+		got = append(got, transaction{trans.TransactionId, sqls, md.BeginTimestamp})
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SetTimestamp = SetTimestampNever
+	bls.BatchAutocommit = 2
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, m TransactionMetadata) {
+		md = m
+	}
+	store := NewMemoryPositionStore()
+	bls.PositionStore = store
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	id := func(seq uint64) string {
+		return replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: seq})
+	}
+	insert := func(seq int) string {
+		return fmt.Sprintf("insert into vt_a(eid, id) values (%d, 1)", seq)
+	}
+	want := []transaction{
+		{id(2), []string{insert(1), insert(2)}, 1407805590},
+		{id(4), []string{insert(3), insert(4)}, 1407805592},
+		{id(5), []string{insert(5)}, 1407805592},
+		{id(6), []string{insert(6)}, 1407805592},
+		{id(7), []string{insert(7)}, 1407805592},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+	if pos, _ := store.Load(); !pos.Equal(sequencePosition(7)) {
+		t.Errorf("saved position %v, want %v", pos, sequencePosition(7))
+	}
+}
+
+func TestStreamerBatchAutocommitSameTimestamp(t *testing.T) {
+	insert := func(seq uint64, timestamp uint32) replication.BinlogEvent {
+		return timestampEvent{sequenceEvent(seq), timestamp}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		// The size cap sends the first two.
+		insert(1, 1407805590),
+		insert(2, 1407805590),
+		insert(3, 1407805590),
+		insert(4, 1407805591),
+		insert(5, 1407805591),
+		insert(6, 1407805592),
+	}
+
+	type transaction struct {
+		id         string
+		statements []string
+	}
+	var got []transaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		var sqls []string
+		for _, st := range trans.Statements {
+			sqls = append(sqls, st.Sql)
+		}
+		got = append(got, transaction{trans.TransactionId, sqls})
+		return nil
+	}
+	sql := func(seq int) string {
+		return fmt.Sprintf("insert into vt_a(eid, id) values (%d, 1)", seq)
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SetTimestamp = SetTimestampNever
+	bls.BatchAutocommit = 100
+	bls.BatchAutocommitSize = 2 * len(sql(1))
+	bls.BatchAutocommitSameTimestamp = true
+	store := NewMemoryPositionStore()
+	bls.PositionStore = store
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	id := func(seq uint64) string {
+		return replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: seq})
+	}
+	want := []transaction{
+		{id(2), []string{sql(1), sql(2)}},
+		{id(3), []string{sql(3)}},
+		{id(5), []string{sql(4), sql(5)}},
+		{id(6), []string{sql(6)}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+	if pos, _ := store.Load(); !pos.Equal(sequencePosition(6)) {
+		t.Errorf("saved position %v, want %v", pos, sequencePosition(6))
+	}
+}
+
+func TestStreamerBatchAutocommitWindow(t *testing.T) {
+	sent := make(chan []string, 10)
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		var sqls []string
+		for _, st := range trans.Statements {
+			sqls = append(sqls, st.Sql)
+		}
+		sent <- sqls
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SetTimestamp = SetTimestampNever
+	bls.BatchAutocommit = 100
+	bls.BatchAutocommitWindow = 10 * time.Millisecond
+
+	events := make(chan replication.BinlogEvent)
+	done := make(chan error)
+	go func() {
+		_, err := bls.parseEvents(&sync2.ServiceContext{}, events)
+		done <- err
+	}()
+	for _, ev := range []replication.BinlogEvent{rotateEvent{}, formatEvent{}, sequenceEvent(1), sequenceEvent(2)} {
+		events <- ev
+	}
+
+	// The batch is sent once the window has passed, without waiting for
+	// more statements.
+	want := []string{
+		"insert into vt_a(eid, id) values (1, 1)",
+		"insert into vt_a(eid, id) values (2, 1)",
+	}
+	select {
+	case got := <-sent:
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got statements %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("batch not sent after its window")
+	}
+
+	events <- sequenceEvent(3)
+	close(events)
+	if err := <-done; err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got, want := <-sent, []string{"insert into vt_a(eid, id) values (3, 1)"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %q, want %q", got, want)
+	}
+}
+
+func TestStreamerStatementChecksums(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			Charset:  &binlogdatapb.Charset{Client: 8, Conn: 8, Server: 8},
+			SQL:      "insert into vt_a(eid, id) values (1, 1)"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (2, 2)"}},
+		xidEvent{},
+	}
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.BeginCommit = BeginCommitAll
+	bls.StatementChecksums = true
+	var transactions int
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		transactions++
+		if len(md.Checksums) != len(trans.Statements) {
+			t.Fatalf("got %v checksums for %v statements", len(md.Checksums), len(trans.Statements))
+		}
+		for i, st := range trans.Statements {
+			if got, want := md.Checksums[i], StatementChecksum(st); got != want {
+				t.Errorf("statement %v (%v): checksum %#x, want %#x", i, st.Sql, got, want)
+			}
+		}
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if transactions != 1 {
+		t.Errorf("got %v transactions, want 1", transactions)
+	}
+}
+
+func TestStreamerLogicalClock(t *testing.T) {
+	transaction := func(id int) []replication.BinlogEvent {
+		return []replication.BinlogEvent{
+			queryEvent{query: replication.Query{
+				Database: "vt_test_keyspace",
+				SQL:      "BEGIN"}},
+			queryEvent{query: replication.Query{
+				Database: "vt_test_keyspace",
+				SQL:      fmt.Sprintf("insert into vt_a(eid, id) values (%d, 1)", id)}},
+			xidEvent{},
+		}
+	}
+	input := []replication.BinlogEvent{rotateEvent{}, formatEvent{}}
+	input = append(input, gtidEvent{lastCommitted: 1, sequenceNumber: 2})
+	input = append(input, transaction(1)...)
+	input = append(input, gtidEvent{lastCommitted: 1, sequenceNumber: 3})
+	input = append(input, transaction(2)...)
+	// A transaction without a GTID_EVENT has no logical clock.
+	input = append(input, transaction(3)...)
+
+	var got [][2]int64
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		got = append(got, [2]int64{md.LastCommitted, md.SequenceNumber})
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := [][2]int64{{1, 2}, {1, 3}, {0, 0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got logical clocks %v, want %v", got, want)
+	}
+}
+
+func TestStreamerCommitTimestamps(t *testing.T) {
+	transaction := []replication.BinlogEvent{
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1)"}},
+		xidEvent{},
+	}
+	// The transaction was committed 90.5s ago on the master, and 1s ago on
+	// the server the stream reads. The timestamp of the events is an hour
+	// old, so the lag is only 90.5s if it comes from the GTID_EVENT.
+	clock := time.Unix(1407805592+3600, 0)
+	now := clock.UnixNano() / int64(time.Microsecond)
+	original, immediate := now-90500000, now-1000000
+	input := []replication.BinlogEvent{rotateEvent{}, formatEvent{}}
+	input = append(input, gtidEvent{lastCommitted: 1, sequenceNumber: 2, originalCommit: original, immediateCommit: immediate})
+	input = append(input, transaction...)
+
+	var gotTimestamps [2]int64
+	var gotLag time.Duration
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, func(*binlogdatapb.BinlogTransaction) error { return nil })
+	bls.now = func() time.Time { return clock }
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		gotTimestamps = [2]int64{md.OriginalCommitTimestamp, md.ImmediateCommitTimestamp}
+	}
+	bls.LagThreshold = time.Minute
+	bls.LagChanged = func(lagging bool, lag time.Duration) {
+		gotLag = lag
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if want := [2]int64{original, immediate}; gotTimestamps != want {
+		t.Errorf("got commit timestamps %v, want %v", gotTimestamps, want)
+	}
+	if gotLag != 90500*time.Millisecond {
+		t.Errorf("got lag %v, want 90.5s", gotLag)
+	}
+	if got := binlogStreamerSecondsBehindMaster.Get(); got != 90 {
+		t.Errorf("BinlogStreamerSecondsBehindMaster = %v, want 90", got)
+	}
+
+	// Without commit timestamps, the lag comes from the events.
+	gotTimestamps, gotLag = [2]int64{-1, -1}, 0
+	input = append([]replication.BinlogEvent{rotateEvent{}, formatEvent{}, gtidEvent{lastCommitted: 1, sequenceNumber: 2}}, transaction...)
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if want := [2]int64{0, 0}; gotTimestamps != want {
+		t.Errorf("got commit timestamps %v, want %v", gotTimestamps, want)
+	}
+	if want := time.Duration(clock.Unix()-int64(fakeEvent{}.Timestamp())) * time.Second; gotLag != want {
+		t.Errorf("got lag %v, want %v", gotLag, want)
+	}
+}
+
+func TestStreamerBeginCommitTimestamps(t *testing.T) {
+	insert := func(id int, timestamp uint32) replication.BinlogEvent {
+		return timestampEvent{queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      fmt.Sprintf("insert into vt_a(eid, id) values (%d, 1)", id)}}, timestamp}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		// A transaction started by a BEGIN.
+		timestampEvent{queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}}, 1407805590},
+		insert(1, 1407805591),
+		insert(2, 1407805592),
+		timestampEvent{xidEvent{}, 1407805593},
+		// A transaction started by a GTID_EVENT, before its BEGIN.
+		timestampEvent{gtidEvent{}, 1407805594},
+		timestampEvent{queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}}, 1407805595},
+		insert(3, 1407805596),
+		timestampEvent{queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "COMMIT"}}, 1407805597},
+		// An autocommit statement.
+		insert(4, 1407805598),
+	}
+
+	var got [][2]int64
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		if md.CommitTimestamp != trans.Timestamp {
+			t.Errorf("CommitTimestamp = %v, want the transaction timestamp %v", md.CommitTimestamp, trans.Timestamp)
+		}
+		got = append(got, [2]int64{md.BeginTimestamp, md.CommitTimestamp})
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := [][2]int64{
+		{1407805590, 1407805593},
+		{1407805594, 1407805597},
+		{1407805598, 1407805598},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got begin and commit timestamps %v, want %v", got, want)
+	}
+}
+
+func TestStreamerStatementLogPositions(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{fileName: "vt-0000062344-bin.000001"},
+		formatEvent{},
+		logPosEvent{queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}}, 119},
+		logPosEvent{queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1)"}}, 219},
+		logPosEvent{queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (2, 2)"}}, 319},
+		logPosEvent{xidEvent{}, 419},
+		logPosEvent{rotateEvent{fileName: "vt-0000062344-bin.000002"}, 519},
+		formatEvent{},
+		logPosEvent{queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (3, 3)"}}, 219},
+	}
+
+	var got []TransactionMetadata
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.BeginCommit = BeginCommitAll
+	bls.StatementLogPositions = true
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		if len(md.LogPositions) != len(trans.Statements) {
+			t.Errorf("got %v log positions for %v statements", len(md.LogPositions), len(trans.Statements))
+		}
+		for i := 1; i < len(md.LogPositions); i++ {
+			if prev, cur := md.LogPositions[i-1], md.LogPositions[i]; cur.File != prev.File || cur.Offset < prev.Offset {
+				t.Errorf("log position %v of statement %v comes before %v", cur, i, prev)
+			}
+		}
+		got = append(got, md)
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// The events have a fake length of 19 bytes, so each one starts 19
+	// bytes before the next one.
+	file1 := "vt-0000062344-bin.000001"
+	file2 := "vt-0000062344-bin.000002"
+	want := [][]LogPosition{
+		{
+			{file1, 200}, // BEGIN
+			{file1, 200}, {file1, 200},
+			{file1, 300}, {file1, 300},
+			{file1, 400}, // COMMIT
+		},
+		{
+			{file2, 200}, // BEGIN
+			{file2, 200}, {file2, 200},
+			{file2, 200}, // COMMIT
+		},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v transactions, want %v: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i].LogPositions, want[i]) {
+			t.Errorf("transaction %v: got log positions %v, want %v", i, got[i].LogPositions, want[i])
+		}
+	}
+}
+
+func TestStreamerFormatBeforeRotate(t *testing.T) {
+	file1 := "vt-0000062344-bin.000001"
+	file2 := "vt-0000062344-bin.000002"
+	insert := func(id, next uint32) replication.BinlogEvent {
+		return logPosEvent{queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      fmt.Sprintf("insert into vt_a(eid, id) values (%d, 1)", id)}}, next}
+	}
+	rest := []replication.BinlogEvent{
+		insert(1, 219),
+		logPosEvent{rotateEvent{fileName: file2}, 319},
+		formatEvent{},
+		insert(2, 219),
+	}
+	testcases := []struct {
+		desc  string
+		input []replication.BinlogEvent
+		// wantFile is the file of the first transaction.
+		wantFile string
+	}{{
+		desc:     "ROTATE then FORMAT_DESCRIPTION",
+		input:    append([]replication.BinlogEvent{rotateEvent{fileName: file1}, formatEvent{}}, rest...),
+		wantFile: file1,
+	}, {
+		desc:     "FORMAT_DESCRIPTION first",
+		input:    append([]replication.BinlogEvent{formatEvent{}}, rest...),
+		wantFile: "",
+	}}
+	for _, tcase := range testcases {
+		var got []LogPosition
+		bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, func(*binlogdatapb.BinlogTransaction) error { return nil })
+		bls.SetTimestamp = SetTimestampNever
+		bls.StatementLogPositions = true
+		bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+			got = append(got, md.LogPositions...)
+		}
+		if err := parseTestEvents(bls, tcase.input); err != ErrServerEOF {
+			t.Errorf("%v: unexpected error: %v", tcase.desc, err)
+		}
+		want := []LogPosition{{tcase.wantFile, 200}, {file2, 200}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got log positions %v, want %v", tcase.desc, got, want)
+		}
+	}
+}
+
+func TestStreamerParseEventsInvalidQuery(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		invalidQueryEvent{},
+		xidEvent{},
+	}
+	want := "can't get query from binlog event:"
+
+	events := make(chan replication.BinlogEvent)
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	err := svm.Join()
+	if err == nil {
+		t.Errorf("expected error, got none")
+		return
+	}
+	if got := err.Error(); !strings.HasPrefix(got, want) {
+		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	}
+}
+
+func TestStreamerParseEventsSkipInvalidQueries(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1)"}},
+		invalidQueryEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (2, 2)"}},
+		xidEvent{},
+		// It's followed by a BEGIN, so it wasn't one.
+		invalidQueryEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (3, 3)"}},
+		xidEvent{},
+	}
+
+	var got []binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SkipInvalidQueries = true
+	skipped := binlogStreamerErrors.Counts()["SkippedQuery"]
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// The invalid query in the transaction is left out, and the one outside
+	// of a transaction is replaced by an empty transaction.
+	want := []binlogdatapb.BinlogTransaction{
+		{
+			Statements: []*binlogdatapb.BinlogTransaction_Statement{
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1)"},
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (2, 2)"},
+			},
+		},
+		{},
+		{
+			Statements: []*binlogdatapb.BinlogTransaction_Statement{
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (3, 3)"},
+			},
+		},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v transactions, want %v: %v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i].Statements, want[i].Statements) {
+			t.Errorf("transaction %v: got statements %v, want %v", i, got[i].Statements, want[i].Statements)
+		}
+	}
+	if got, want := binlogStreamerErrors.Counts()["SkippedQuery"]-skipped, int64(2); got != want {
+		t.Errorf("got %v skipped queries, want %v", got, want)
+	}
+}
+
+func TestStreamerParseEventsSkipInvalidBoundaries(t *testing.T) {
+	query := func(sql string) replication.BinlogEvent {
+		return queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: sql}}
+	}
+	testcases := []struct {
+		desc  string
+		input []replication.BinlogEvent
+		want  string
+		// wantSent is the number of transactions sent before the error.
+		// The insert after the skipped event is never sent.
+		wantSent int
+	}{{
+		desc: "BEGIN",
+		input: []replication.BinlogEvent{
+			query("BEGIN"),
+			query("insert into vt_a(eid, id) values (1, 1)"),
+			xidEvent{},
+			invalidQueryEvent{},
+			query("insert into vt_a(eid, id) values (2, 2)"),
+			xidEvent{},
+		},
+		want: "may have been a BEGIN",
+		// The first transaction, and the empty one of the skipped event.
+		wantSent: 2,
+	}, {
+		desc: "COMMIT",
+		input: []replication.BinlogEvent{
+			query("BEGIN"),
+			query("insert into vt_a(eid, id) values (1, 1)"),
+			invalidQueryEvent{},
+			query("BEGIN"),
+			query("insert into vt_a(eid, id) values (2, 2)"),
+			xidEvent{},
+		},
+		want:     "may have been a COMMIT or a ROLLBACK",
+		wantSent: 0,
+	}}
+	for _, tcase := range testcases {
+		var got int
+		sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+			got++
+			return nil
+		}
+		bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+		bls.SkipInvalidQueries = true
+		input := append([]replication.BinlogEvent{rotateEvent{}, formatEvent{}}, tcase.input...)
+		if err := parseTestEvents(bls, input); err == nil || !strings.Contains(err.Error(), tcase.want) {
+			t.Errorf("%v: wrong error, got %v, want %q", tcase.desc, err, tcase.want)
+		}
+		if got != tcase.wantSent {
+			t.Errorf("%v: got %v transactions, want %v", tcase.desc, got, tcase.wantSent)
+		}
+	}
+}
+
+func TestStreamerParseEventsRollback(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "ROLLBACK"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+		xidEvent{},
+	}
+
+	events := make(chan replication.BinlogEvent)
+
+	want := []binlogdatapb.BinlogTransaction{
+		{
+			Statements: nil,
+			Timestamp:  1407805592,
+			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
+				Domain:   0,
+				Server:   62344,
+				Sequence: 0x0d,
+			}),
+		},
+		{
+			Statements: []*binlogdatapb.BinlogTransaction_Statement{
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"},
+			},
+			Timestamp: 1407805592,
+			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
+				Domain:   0,
+				Server:   62344,
+				Sequence: 0x0d,
+			}),
+		},
+	}
+	var got []binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	if err := svm.Join(); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("binlogConnStreamer.parseEvents(): got %v, want %v", got, want)
+	}
+}
+
+func TestStreamerParseEventsDMLWithoutBegin(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+		xidEvent{},
+	}
+
+	events := make(chan replication.BinlogEvent)
+
+	want := []binlogdatapb.BinlogTransaction{
+		{
+			Statements: []*binlogdatapb.BinlogTransaction_Statement{
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"},
+			},
+			Timestamp: 1407805592,
+			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
+				Domain:   0,
+				Server:   62344,
+				Sequence: 0x0d,
+			}),
+		},
+		{
+			Statements: nil,
+			Timestamp:  1407805592,
+			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
+				Domain:   0,
+				Server:   62344,
+				Sequence: 0x0d,
+			}),
+		},
+	}
+	var got []binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	if err := svm.Join(); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("binlogConnStreamer.parseEvents(): got %v, want %v", got, want)
+	}
+}
+
+func TestStreamerParseEventsBeginCommit(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1)"}},
+		xidEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (2, 2)"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		xidEvent{},
+	}
+
+	begin := &binlogdatapb.BinlogTransaction_Statement{Category: binlogdatapb.BinlogTransaction_Statement_BL_BEGIN, Sql: "BEGIN"}
+	commit := &binlogdatapb.BinlogTransaction_Statement{Category: binlogdatapb.BinlogTransaction_Statement_BL_COMMIT, Sql: "COMMIT"}
+	setTimestamp := &binlogdatapb.BinlogTransaction_Statement{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"}
+	insert1 := &binlogdatapb.BinlogTransaction_Statement{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1)"}
+	insert2 := &binlogdatapb.BinlogTransaction_Statement{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (2, 2)"}
+
+	testcases := []struct {
+		mode BeginCommitMode
+		want [][]*binlogdatapb.BinlogTransaction_Statement
+	}{
+		{
+			mode: BeginCommitNone,
+			want: [][]*binlogdatapb.BinlogTransaction_Statement{
+				{setTimestamp, insert1},
+				{setTimestamp, insert2},
+				{},
+			},
+		},
+		{
+			mode: BeginCommitTransactions,
+			want: [][]*binlogdatapb.BinlogTransaction_Statement{
+				{begin, setTimestamp, insert1, commit},
+				{setTimestamp, insert2},
+				{},
+			},
+		},
+		{
+			mode: BeginCommitAll,
+			want: [][]*binlogdatapb.BinlogTransaction_Statement{
+				{begin, setTimestamp, insert1, commit},
+				{begin, setTimestamp, insert2, commit},
+				{},
+			},
+		},
+	}
+	for _, tcase := range testcases {
+		var got [][]*binlogdatapb.BinlogTransaction_Statement
+		sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+			got = append(got, trans.Statements)
+			return nil
+		}
+		bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+		bls.BeginCommit = tcase.mode
+
+		if err := parseTestEvents(bls, input); err != ErrServerEOF {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, tcase.want) {
+			t.Errorf("mode %v: got %v, want %v", tcase.mode, got, tcase.want)
+		}
+	}
+}
+
+func TestStreamerParseEventsBeginWithoutCommit(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		xidEvent{},
+	}
+
+	events := make(chan replication.BinlogEvent)
+
+	want := []binlogdatapb.BinlogTransaction{
+		{
+			Statements: []*binlogdatapb.BinlogTransaction_Statement{
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"},
+			},
+			Timestamp: 1407805592,
+			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
+				Domain:   0,
+				Server:   62344,
+				Sequence: 0x0d,
+			}),
+		},
+		{
+			Statements: []*binlogdatapb.BinlogTransaction_Statement{},
+			Timestamp:  1407805592,
+			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
+				Domain:   0,
+				Server:   62344,
+				Sequence: 0x0d,
+			}),
+		},
+	}
+	var got []binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	if err := svm.Join(); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("binlogConnStreamer.parseEvents(): got %v, want %v", got, want)
+	}
+}
+
+func TestStreamerParseEventsSetInsertID(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		intVarEvent{name: "INSERT_ID", value: 101},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+		xidEvent{},
+	}
+
+	events := make(chan replication.BinlogEvent)
+
+	want := []binlogdatapb.BinlogTransaction{
+		{
+			Statements: []*binlogdatapb.BinlogTransaction_Statement{
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET INSERT_ID=101"},
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"},
+			},
+			Timestamp: 1407805592,
+			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
+				Domain:   0,
+				Server:   62344,
+				Sequence: 0x0d,
+			}),
+		},
+	}
+	var got []binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	if err := svm.Join(); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("binlogConnStreamer.parseEvents(): got %v, want %v", got, want)
+	}
+}
+
+func TestStreamerParseEventsIntVarScope(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
+		// These only apply to the cross-db insert, which is skipped.
+		intVarEvent{name: "INSERT_ID", value: 7},
+		queryEvent{query: replication.Query{
+			Database: "other",
+			SQL:      "insert into vt_b(id) values (null)"}},
+		intVarEvent{name: "LAST_INSERT_ID", value: 7},
+		intVarEvent{name: "INSERT_ID", value: 101},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (null, last_insert_id())"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (null, 2)"}},
+		xidEvent{},
+	}
+
+	var got []binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Replaying this gives eid 101 and id 7 to the first insert, and the
+	// next auto-increment value (102) to the second one, as on the master.
+	want := []*binlogdatapb.BinlogTransaction_Statement{
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET LAST_INSERT_ID=7"},
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET INSERT_ID=101"},
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (null, last_insert_id())"},
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (null, 2)"},
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %v transactions, want 1: %v", len(got), got)
+	}
+	if !reflect.DeepEqual(got[0].Statements, want) {
+		t.Errorf("got statements %v, want %v", got[0].Statements, want)
+	}
+}
+
+func TestStreamerParseEventsIntVarRandAutocommit(t *testing.T) {
+	autocommitQuery := func(sql string) replication.BinlogEvent {
+		return queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      sql}}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		intVarEvent{name: "INSERT_ID", value: 1},
+		autocommitQuery("insert into vt_a(eid, id) values (null, 1)"),
+		autocommitQuery("insert into vt_a(eid, id) values (2, 2)"),
+		randEvent{seed1: 3, seed2: 4},
+		intVarEvent{name: "INSERT_ID", value: 3},
+		autocommitQuery("insert into vt_a(eid, id) values (null, rand())"),
+		// An INTVAR_EVENT without its query doesn't apply to the next
+		// transaction.
+		intVarEvent{name: "INSERT_ID", value: 9},
+		xidEvent{},
+		autocommitQuery("insert into vt_a(eid, id) values (4, 4)"),
+	}
+
+	var got [][]*binlogdatapb.BinlogTransaction_Statement
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, trans.Statements)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	setTimestamp := &binlogdatapb.BinlogTransaction_Statement{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"}
+	want := [][]*binlogdatapb.BinlogTransaction_Statement{
+		{
+			{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET INSERT_ID=1"},
+			setTimestamp,
+			{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (null, 1)"},
+		},
+		{
+			setTimestamp,
+			{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (2, 2)"},
+		},
+		{
+			{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET @@RAND_SEED1=3, @@RAND_SEED2=4"},
+			{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET INSERT_ID=3"},
+			setTimestamp,
+			{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (null, rand())"},
+		},
+		nil,
+		{
+			setTimestamp,
+			{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (4, 4)"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+}
+
+func TestStreamerDatabaseStats(t *testing.T) {
+	// databaseEvents returns the events of n transactions on db.
+	databaseEvents := func(db string, n int) []replication.BinlogEvent {
+		input := []replication.BinlogEvent{rotateEvent{}, formatEvent{}}
+		for i := 0; i < n; i++ {
+			input = append(input,
+				queryEvent{query: replication.Query{
+					Database: db,
+					SQL:      "BEGIN"}},
+				queryEvent{query: replication.Query{
+					Database: db,
+					SQL:      fmt.Sprintf("insert into vt_a(eid, id) values (%d, 1)", i)}},
+				xidEvent{})
+		}
+		return input
+	}
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+
+	for _, tc := range []struct {
+		db string
+		n  int
+	}{
+		{"vt_stats_keyspace1", 1},
+		{"vt_stats_keyspace2", 3},
+	} {
+		bls := NewStreamer(tc.db, nil, nil, replication.Position{}, sendTransaction)
+		bls.DatabaseStats = true
+		if err := parseTestEvents(bls, databaseEvents(tc.db, tc.n)); err != ErrServerEOF {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	wantEvents := map[string]int64{
+		"vt_stats_keyspace1.Rotate":            1,
+		"vt_stats_keyspace1.FormatDescription": 1,
+		"vt_stats_keyspace1.Query":             2,
+		"vt_stats_keyspace1.XID":               1,
+		"vt_stats_keyspace2.Rotate":            1,
+		"vt_stats_keyspace2.FormatDescription": 1,
+		"vt_stats_keyspace2.Query":             6,
+		"vt_stats_keyspace2.XID":               3,
+	}
+	events := binlogStreamerDatabaseEvents.Counts()
+	for name, want := range wantEvents {
+		if got := events[name]; got != want {
+			t.Errorf("BinlogStreamerDatabaseEvents[%v] = %v, want %v", name, got, want)
+		}
+	}
+	// Each transaction has a SET TIMESTAMP and an insert.
+	for db, want := range map[string]int64{"vt_stats_keyspace1": 1, "vt_stats_keyspace2": 3} {
+		if got := binlogStreamerDatabaseTransactions.Counts()[db]; got != want {
+			t.Errorf("BinlogStreamerDatabaseTransactions[%v] = %v, want %v", db, got, want)
+		}
+		if got := binlogStreamerDatabaseStatements.Counts()[db]; got != 2*want {
+			t.Errorf("BinlogStreamerDatabaseStatements[%v] = %v, want %v", db, got, 2*want)
+		}
+	}
+
+	// Streamers without DatabaseStats don't add counters.
+	bls := NewStreamer("vt_stats_keyspace3", nil, nil, replication.Position{}, sendTransaction)
+	if err := parseTestEvents(bls, databaseEvents("vt_stats_keyspace3", 1)); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, ok := binlogStreamerDatabaseTransactions.Counts()["vt_stats_keyspace3"]; ok {
+		t.Errorf("BinlogStreamerDatabaseTransactions has a counter for a Streamer without DatabaseStats")
+	}
+}
+
+func TestStreamerBytesRead(t *testing.T) {
+	input := []replication.BinlogEvent{
+		mariadbRotateEvent,
+		mariadbFormatEvent,
+		mariadbBeginGTIDEvent,
+		mariadbInsertEvent,
+		mariadbXidEvent,
+	}
+	var want int64
+	for _, ev := range input {
+		want += int64(len(eventBytes(ev)))
+	}
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	// The insert is dropped, but it was still read.
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.DropStatements = []*regexp.Regexp{regexp.MustCompile(`\bvt_insert_test\b`)}
+	before := binlogStreamerBytesRead.Get()
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got := bls.BytesRead(); got != want {
+		t.Errorf("BytesRead() = %v, want %v", got, want)
+	}
+	if got := binlogStreamerBytesRead.Get() - before; got != want {
+		t.Errorf("BinlogStreamerBytesRead grew by %v, want %v", got, want)
+	}
+}
+
+func TestStreamerLagChanged(t *testing.T) {
+	// Each XID_EVENT ends a transaction that is lag seconds behind.
+	clock := time.Unix(1407805592, 0)
+	now := clock.Unix()
+	input := []replication.BinlogEvent{rotateEvent{}, formatEvent{}}
+	for _, lag := range []int64{10, 100, 55, 30, 30, 120, 120} {
+		input = append(input, timestampEvent{xidEvent{}, uint32(now - lag)})
+	}
+
+	type lagChange struct {
+		lagging bool
+		lag     time.Duration
+	}
+	var got []lagChange
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
 		return nil
 	}
 	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.now = func() time.Time { return clock }
+	bls.LagThreshold = time.Minute
+	bls.LagHysteresis = 10 * time.Second
+	bls.LagChanged = func(lagging bool, lag time.Duration) {
+		got = append(got, lagChange{lagging, lag})
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
 
-	go sendTestEvents(events, input)
-	svm := &sync2.ServiceManager{}
-	svm.Go(func(ctx *sync2.ServiceContext) error {
-		_, err := bls.parseEvents(ctx, events)
-		return err
-	})
-	err := svm.Join()
-	if err == nil {
-		t.Errorf("expected error, got none")
-		return
+	// 55s is still lagging, because of the hysteresis.
+	want := []lagChange{
+		{true, 100 * time.Second},
+		{false, 30 * time.Second},
+		{true, 120 * time.Second},
 	}
-	if got := err.Error(); !strings.HasPrefix(got, want) {
-		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got lag changes %v, want %v", got, want)
+	}
+	if got := binlogStreamerSecondsBehindMaster.Get(); got != 120 {
+		t.Errorf("BinlogStreamerSecondsBehindMaster = %v, want 120", got)
 	}
 }
 
-func TestStreamerParseEventsNoFormat(t *testing.T) {
+func TestStreamerParseEventsCoalesceSets(t *testing.T) {
+	charset := &binlogdatapb.Charset{Client: 33, Conn: 33, Server: 33}
 	input := []replication.BinlogEvent{
 		rotateEvent{},
-		//formatEvent{},
+		formatEvent{},
 		queryEvent{query: replication.Query{
 			Database: "vt_test_keyspace",
 			SQL:      "BEGIN"}},
+		intVarEvent{name: "LAST_INSERT_ID", value: 7},
+		intVarEvent{name: "INSERT_ID", value: 101},
+		randEvent{seed1: 1, seed2: 2},
 		queryEvent{query: replication.Query{
 			Database: "vt_test_keyspace",
-			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+			Charset:  charset,
+			SQL:      "insert into vt_a(eid, id) values (null, rand())"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "SET @a = 1"}},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, @a)"}},
 		xidEvent{},
 	}
-	want := "got a real event before FORMAT_DESCRIPTION_EVENT:"
-
-	events := make(chan replication.BinlogEvent)
 
+	var got []binlogdatapb.BinlogTransaction
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, *trans)
 		return nil
 	}
 	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.CoalesceSets = true
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
 
-	go sendTestEvents(events, input)
-	svm := &sync2.ServiceManager{}
-	svm.Go(func(ctx *sync2.ServiceContext) error {
-		_, err := bls.parseEvents(ctx, events)
-		return err
-	})
-	err := svm.Join()
-	if err == nil {
-		t.Errorf("expected error, got none")
-		return
+	// Only the SETs added by the Streamer are combined, and never with
+	// the query that follows them.
+	want := []*binlogdatapb.BinlogTransaction_Statement{
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET LAST_INSERT_ID=7, INSERT_ID=101, @@RAND_SEED1=1, @@RAND_SEED2=2, TIMESTAMP=1407805592", Charset: charset},
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (null, rand())", Charset: charset},
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET @a = 1"},
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+		{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, @a)"},
 	}
-	if got := err.Error(); !strings.HasPrefix(got, want) {
-		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	if len(got) != 1 {
+		t.Fatalf("got %v transactions, want 1: %v", len(got), got)
+	}
+	if !reflect.DeepEqual(got[0].Statements, want) {
+		t.Errorf("got statements %v, want %v", got[0].Statements, want)
 	}
 }
 
-func TestStreamerParseEventsInvalidQuery(t *testing.T) {
+func TestStreamerParseEventsInvalidIntVar(t *testing.T) {
 	input := []replication.BinlogEvent{
 		rotateEvent{},
 		formatEvent{},
 		queryEvent{query: replication.Query{
 			Database: "vt_test_keyspace",
 			SQL:      "BEGIN"}},
-		invalidQueryEvent{},
+		invalidIntVarEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
 		xidEvent{},
 	}
-	want := "can't get query from binlog event:"
+	want := "can't parse INTVAR_EVENT:"
 
 	events := make(chan replication.BinlogEvent)
 
@@ -574,7 +4275,7 @@ func TestStreamerParseEventsInvalidQuery(t *testing.T) {
 	}
 }
 
-func TestStreamerParseEventsRollback(t *testing.T) {
+func TestStreamerParseEventsOtherDB(t *testing.T) {
 	input := []replication.BinlogEvent{
 		rotateEvent{},
 		formatEvent{},
@@ -582,17 +4283,8 @@ func TestStreamerParseEventsRollback(t *testing.T) {
 			Database: "vt_test_keyspace",
 			SQL:      "BEGIN"}},
 		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "ROLLBACK"}},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "BEGIN"}},
+			Database: "other",
+			SQL:      "INSERT INTO test values (3, 4)"}},
 		queryEvent{query: replication.Query{
 			Database: "vt_test_keyspace",
 			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
@@ -602,15 +4294,6 @@ func TestStreamerParseEventsRollback(t *testing.T) {
 	events := make(chan replication.BinlogEvent)
 
 	want := []binlogdatapb.BinlogTransaction{
-		{
-			Statements: nil,
-			Timestamp:  1407805592,
-			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
-				Domain:   0,
-				Server:   62344,
-				Sequence: 0x0d,
-			}),
-		},
 		{
 			Statements: []*binlogdatapb.BinlogTransaction_Statement{
 				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
@@ -646,10 +4329,16 @@ func TestStreamerParseEventsRollback(t *testing.T) {
 	}
 }
 
-func TestStreamerParseEventsDMLWithoutBegin(t *testing.T) {
+func TestStreamerParseEventsOtherDBBegin(t *testing.T) {
 	input := []replication.BinlogEvent{
 		rotateEvent{},
 		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "other",
+			SQL:      "BEGIN"}}, // Check that this doesn't get filtered out.
+		queryEvent{query: replication.Query{
+			Database: "other",
+			SQL:      "INSERT INTO test values (3, 4)"}},
 		queryEvent{query: replication.Query{
 			Database: "vt_test_keyspace",
 			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
@@ -671,15 +4360,6 @@ func TestStreamerParseEventsDMLWithoutBegin(t *testing.T) {
 				Sequence: 0x0d,
 			}),
 		},
-		{
-			Statements: nil,
-			Timestamp:  1407805592,
-			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
-				Domain:   0,
-				Server:   62344,
-				Sequence: 0x0d,
-			}),
-		},
 	}
 	var got []binlogdatapb.BinlogTransaction
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
@@ -703,50 +4383,28 @@ func TestStreamerParseEventsDMLWithoutBegin(t *testing.T) {
 	}
 }
 
-func TestStreamerParseEventsBeginWithoutCommit(t *testing.T) {
+func TestStreamerParseEventsBeginAgain(t *testing.T) {
 	input := []replication.BinlogEvent{
 		rotateEvent{},
 		formatEvent{},
+		queryEvent{query: replication.Query{
+			Database: "vt_test_keyspace",
+			SQL:      "BEGIN"}},
 		queryEvent{query: replication.Query{
 			Database: "vt_test_keyspace",
 			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
 		queryEvent{query: replication.Query{
 			Database: "vt_test_keyspace",
 			SQL:      "BEGIN"}},
-		xidEvent{},
 	}
 
 	events := make(chan replication.BinlogEvent)
 
-	want := []binlogdatapb.BinlogTransaction{
-		{
-			Statements: []*binlogdatapb.BinlogTransaction_Statement{
-				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
-				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"},
-			},
-			Timestamp: 1407805592,
-			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
-				Domain:   0,
-				Server:   62344,
-				Sequence: 0x0d,
-			}),
-		},
-		{
-			Statements: []*binlogdatapb.BinlogTransaction_Statement{},
-			Timestamp:  1407805592,
-			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
-				Domain:   0,
-				Server:   62344,
-				Sequence: 0x0d,
-			}),
-		},
-	}
-	var got []binlogdatapb.BinlogTransaction
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
-		got = append(got, *trans)
 		return nil
 	}
 	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	before := binlogStreamerErrors.Counts()["ParseEvents"]
 
 	go sendTestEvents(events, input)
 	svm := &sync2.ServiceManager{}
@@ -757,246 +4415,656 @@ func TestStreamerParseEventsBeginWithoutCommit(t *testing.T) {
 	if err := svm.Join(); err != ErrServerEOF {
 		t.Errorf("unexpected error: %v", err)
 	}
+	after := binlogStreamerErrors.Counts()["ParseEvents"]
+	if got := after - before; got != 1 {
+		t.Errorf("error count change = %v, want 1", got)
+	}
+}
 
-	if !reflect.DeepEqual(got, want) {
-		t.Errorf("binlogConnStreamer.parseEvents(): got %v, want %v", got, want)
+func TestStreamerFormat(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		versionFormatEvent{serverVersion: "5.6.24-log"},
+		xidEvent{},
+		xidEvent{},
+		rotateEvent{},
+		versionFormatEvent{serverVersion: "5.7.12-log"},
+		xidEvent{},
+	}
+
+	var bls *Streamer
+	var got []replication.BinlogFormat
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, bls.Format())
+		return nil
+	}
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	if format := bls.Format(); !format.IsZero() {
+		t.Errorf("Format() = %v before the stream started, want the zero BinlogFormat", format)
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	old := replication.BinlogFormat{FormatVersion: 1, ServerVersion: "5.6.24-log"}
+	new := replication.BinlogFormat{FormatVersion: 1, ServerVersion: "5.7.12-log"}
+	if want := []replication.BinlogFormat{old, old, new}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Format() of the transactions: got %v, want %v", got, want)
+	}
+	if format := bls.Format(); format != new {
+		t.Errorf("Format() = %v after the stream ended, want %v", format, new)
 	}
 }
 
-func TestStreamerParseEventsSetInsertID(t *testing.T) {
+func TestStreamerEmptyGTIDTransactions(t *testing.T) {
+	commit := func(sequence uint64) replication.BinlogEvent {
+		return sequenceQueryEvent{
+			queryEvent: queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: "COMMIT"}},
+			sequence:   sequence,
+		}
+	}
+	insert := sequenceEvent(3)
 	input := []replication.BinlogEvent{
 		rotateEvent{},
 		formatEvent{},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "BEGIN"}},
-		intVarEvent{name: "INSERT_ID", value: 101},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
-		xidEvent{},
+		// Two transactions that only have a GTID.
+		beginGTIDEvent{sequence: 1},
+		beginGTIDEvent{sequence: 2},
+		beginGTIDEvent{sequence: 3},
+		insert,
+		commit(3),
+		beginGTIDEvent{sequence: 4},
+		beginGTIDEvent{sequence: 5},
+		commit(5),
 	}
 
-	events := make(chan replication.BinlogEvent)
+	var got []*binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, trans)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	parseErrors := binlogStreamerErrors.Counts()["ParseEvents"]
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
 
-	want := []binlogdatapb.BinlogTransaction{
+	transactionID := func(sequence uint64) string {
+		return replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: sequence})
+	}
+	timestamp := int64(fakeEvent{}.Timestamp())
+	want := []*binlogdatapb.BinlogTransaction{
+		{Statements: []*binlogdatapb.BinlogTransaction_Statement{}, Timestamp: timestamp, TransactionId: transactionID(1)},
+		{Statements: []*binlogdatapb.BinlogTransaction_Statement{}, Timestamp: timestamp, TransactionId: transactionID(2)},
 		{
 			Statements: []*binlogdatapb.BinlogTransaction_Statement{
-				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET INSERT_ID=101"},
 				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
-				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"},
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (3, 1)"},
 			},
-			Timestamp: 1407805592,
-			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
-				Domain:   0,
-				Server:   62344,
-				Sequence: 0x0d,
-			}),
+			Timestamp:     timestamp,
+			TransactionId: transactionID(3),
 		},
+		{Statements: []*binlogdatapb.BinlogTransaction_Statement{}, Timestamp: timestamp, TransactionId: transactionID(4)},
+		{Statements: []*binlogdatapb.BinlogTransaction_Statement{}, Timestamp: timestamp, TransactionId: transactionID(5)},
 	}
-	var got []binlogdatapb.BinlogTransaction
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("binlogConnStreamer.parseEvents(): got:\n%v\nwant:\n%v", got, want)
+	}
+	if !bls.committedPos.Equal(sequencePosition(5)) {
+		t.Errorf("committed position = %v, want %v", bls.committedPos, sequencePosition(5))
+	}
+	if n := binlogStreamerErrors.Counts()["ParseEvents"]; n != parseErrors {
+		t.Errorf("ParseEvents errors = %v, want %v", n, parseErrors)
+	}
+}
+
+func TestStreamerHeartbeatInterval(t *testing.T) {
+	query := func(sql string) replication.BinlogEvent {
+		return queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: sql}}
+	}
+	heartbeat := otherEvent{typ: heartbeatLogEvent}
+	// After the FORMAT_DESCRIPTION_EVENT, the events come a second apart.
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		heartbeat, // 1s: sent
+		heartbeat, // 2s: coalesced
+		heartbeat, // 3s: coalesced
+		heartbeat, // 4s: sent
+		heartbeat, // 5s: coalesced, and dropped by the transaction
+		query("BEGIN"),
+		heartbeat, // 7s: ignored inside the transaction
+		query("insert into vt_a(eid, id) values (1, 1)"),
+		xidEvent{},
+		heartbeat, // 10s: sent
+		heartbeat, // 11s: coalesced
+		heartbeat, // 12s: coalesced, and sent at the end of the stream
+	}
+
+	var got []int
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
-		got = append(got, *trans)
+		got = append(got, len(trans.Statements))
 		return nil
 	}
 	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.HeartbeatInterval = 3 * time.Second
+	clock := time.Unix(1407805592, 0)
+	bls.now = func() time.Time { return clock }
+	bls.SendEvent = func(*StreamEvent) error {
+		clock = clock.Add(time.Second)
+		return nil
+	}
+	before := binlogStreamerHeartbeats.Counts()
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
 
-	go sendTestEvents(events, input)
+	if want := []int{0, 0, 2, 0, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions of %v statements, want %v", got, want)
+	}
+	after := binlogStreamerHeartbeats.Counts()
+	if sent, coalesced := after["Sent"]-before["Sent"], after["Coalesced"]-before["Coalesced"]; sent != 4 || coalesced != 5 {
+		t.Errorf("got %v heartbeats sent and %v coalesced, want 4 and 5", sent, coalesced)
+	}
+
+	// Without HeartbeatInterval, the heartbeats are ignored.
+	got = nil
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if want := []int{2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions of %v statements, want %v", got, want)
+	}
+}
+
+func TestStreamerHeartbeatIntervalSlaveConnection(t *testing.T) {
+	// The heartbeats come from a SlaveConnection, like in production.
+	mysqld := mysqlctl.NewFakeMysqlDaemon(nil)
+	mysqld.BinlogDump = make(chan []byte, 6)
+	for _, ev := range []replication.BinlogEvent{mariadbRotateEvent, mariadbFormatEvent, mariadbHeartbeatEvent, mariadbHeartbeatEvent, mariadbHeartbeatEvent, mariadbHeartbeatEvent} {
+		mysqld.BinlogDump <- dumpPacket(ev)
+	}
+	close(mysqld.BinlogDump)
+
+	var got []*binlogdatapb.BinlogTransaction
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, trans)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", mysqld, nil, replication.Position{}, sendTransaction)
+	bls.HeartbeatInterval = 3 * time.Second
+	clock := time.Unix(1407805592, 0)
+	bls.now = func() time.Time { return clock }
+	bls.SendEvent = func(*StreamEvent) error {
+		clock = clock.Add(time.Second)
+		return nil
+	}
+	before := binlogStreamerHeartbeats.Counts()
 	svm := &sync2.ServiceManager{}
-	svm.Go(func(ctx *sync2.ServiceContext) error {
-		_, err := bls.parseEvents(ctx, events)
-		return err
-	})
-	if err := svm.Join(); err != ErrServerEOF {
+	svm.Go(bls.Stream)
+	if err := svm.Join(); err == nil || !strings.Contains(err.Error(), ErrServerEOF.Error()) {
+		t.Errorf("wrong error, got %v, want %v", err, ErrServerEOF)
+	}
+
+	// At 1s sent, at 2s and 3s coalesced, at 4s sent.
+	if len(got) != 2 {
+		t.Fatalf("got %v transactions, want 2 heartbeats", len(got))
+	}
+	for _, trans := range got {
+		if len(trans.Statements) != 0 {
+			t.Errorf("got heartbeat %v, want no statements", trans)
+		}
+	}
+	after := binlogStreamerHeartbeats.Counts()
+	if sent, coalesced := after["Sent"]-before["Sent"], after["Coalesced"]-before["Coalesced"]; sent != 2 || coalesced != 2 {
+		t.Errorf("got %v heartbeats sent and %v coalesced, want 2 and 2", sent, coalesced)
+	}
+}
+
+func TestStreamerDedup(t *testing.T) {
+	var got []string
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, trans.TransactionId)
+		return nil
+	}
+	transactionID := func(sequence uint64) string {
+		return replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: sequence})
+	}
+
+	// The consumer has the transactions up to 3.
+	dedup := NewDeduplicator(sequencePosition(3))
+	bls := NewStreamer("vt_test_keyspace", nil, nil, sequencePosition(3), sendTransaction)
+	bls.Dedup = dedup
+	if err := parseTestEvents(bls, []replication.BinlogEvent{rotateEvent{}, formatEvent{}, sequenceEvent(4), sequenceEvent(5)}); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if want := []string{transactionID(4), transactionID(5)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+
+	// After a reconnect from an older position, only the transactions
+	// after 5 are sent again, including the ones before 4, which this
+	// Deduplicator didn't send.
+	got = nil
+	duplicates := binlogStreamerDuplicates.Get()
+	bls = NewStreamer("vt_test_keyspace", nil, nil, sequencePosition(1), sendTransaction)
+	bls.Dedup = dedup
+	if err := parseTestEvents(bls, []replication.BinlogEvent{rotateEvent{}, formatEvent{}, sequenceEvent(2), sequenceEvent(3), sequenceEvent(4), sequenceEvent(5), sequenceEvent(6)}); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if want := []string{transactionID(6)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+	if n := binlogStreamerDuplicates.Get() - duplicates; n != 4 {
+		t.Errorf("got %v duplicates, want 4", n)
+	}
+	// The dropped transactions still advance the position.
+	if !bls.committedPos.Equal(sequencePosition(6)) {
+		t.Errorf("committed position = %v, want %v", bls.committedPos, sequencePosition(6))
+	}
+	if pos := dedup.Position(); !pos.Equal(sequencePosition(6)) {
+		t.Errorf("Deduplicator.Position() = %v, want %v", pos, sequencePosition(6))
+	}
+}
+
+func TestStreamerDDLAlgorithms(t *testing.T) {
+	query := func(sql string) replication.BinlogEvent {
+		return queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: sql}}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		query("alter table vt_a add column c int, algorithm=instant"),
+		query("alter table vt_a add index (c), algorithm=inplace"),
+		query("alter table vt_a modify c bigint, algorithm=copy"),
+		query("alter table vt_a drop column c"),
+	}
+
+	var got [][]string
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		got = append(got, md.DDLAlgorithms)
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
 		t.Errorf("unexpected error: %v", err)
 	}
-
+	// The DDL comes after its SET TIMESTAMP.
+	want := [][]string{
+		{"", "INSTANT"},
+		{"", "INPLACE"},
+		{"", "COPY"},
+		nil,
+	}
 	if !reflect.DeepEqual(got, want) {
-		t.Errorf("binlogConnStreamer.parseEvents(): got %v, want %v", got, want)
+		t.Errorf("got DDLAlgorithms %q, want %q", got, want)
 	}
 }
 
-func TestStreamerParseEventsInvalidIntVar(t *testing.T) {
+func TestStreamerCatchUpRate(t *testing.T) {
+	// stream sends count transactions, whose timestamps advance by step
+	// seconds, and returns the rate once they are sent a second apart.
+	stream := func(count int, step uint32) float64 {
+		input := []replication.BinlogEvent{rotateEvent{}, formatEvent{}}
+		for i := 0; i < count; i++ {
+			input = append(input, timestampEvent{sequenceEvent(uint64(i + 1)), 1407805592 + uint32(i)*step})
+		}
+		clock := time.Unix(1407805592, 0)
+		sendTransaction := func(*binlogdatapb.BinlogTransaction) error {
+			clock = clock.Add(time.Second)
+			return nil
+		}
+		bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+		bls.now = func() time.Time { return clock }
+		binlogStreamerCatchUpRate.Set(-1)
+		if err := parseTestEvents(bls, input); err != ErrServerEOF {
+			t.Errorf("unexpected error: %v", err)
+		}
+		return binlogStreamerCatchUpRate.Get()
+	}
+
+	testcases := []struct {
+		desc  string
+		count int
+		step  uint32
+		want  float64
+	}{
+		// A backfill goes through a minute of binlog per second.
+		{"catching up", 12, 60, 60},
+		{"keeping up", 12, 1, 1},
+		{"stuck", 12, 0, 0},
+		// The rate isn't updated before the end of the first window.
+		{"short", 10, 60, -1},
+	}
+	for _, tc := range testcases {
+		if got := stream(tc.count, tc.step); got != tc.want {
+			t.Errorf("%v: got rate %v, want %v", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestStreamerAnnotations(t *testing.T) {
 	input := []replication.BinlogEvent{
 		rotateEvent{},
 		formatEvent{},
+		queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: "BEGIN"}},
+		queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: "insert into vt_a(eid, id) values (1, 1)"}},
+		xidEvent{},
 		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "BEGIN"}},
-		invalidIntVarEvent{},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+			Database:    "vt_test_keyspace",
+			SQL:         "create view vt_v as select eid from vt_a",
+			InvokerUser: "vt_app",
+			InvokerHost: "localhost",
+		}},
+		queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: "BEGIN"}},
 		xidEvent{},
 	}
-	want := "can't parse INTVAR_EVENT:"
-
-	events := make(chan replication.BinlogEvent)
 
+	var got []map[string]string
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
 		return nil
 	}
 	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.Annotations = map[string]string{"stream": "vt_test_keyspace/0", InvokerAnnotation: "static"}
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		got = append(got, md.Annotations)
+		// Consumers get their own copy.
+		md.Annotations["stream"] = "modified"
+	}
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := []map[string]string{
+		{"stream": "modified", InvokerAnnotation: "static"},
+		{"stream": "modified", InvokerAnnotation: "vt_app@localhost"},
+		// The invoker of a transaction doesn't carry over to the next one.
+		{"stream": "modified", InvokerAnnotation: "static"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got Annotations %v, want %v", got, want)
+	}
+	if bls.Annotations["stream"] != "vt_test_keyspace/0" {
+		t.Errorf("Annotations = %v, want them unmodified", bls.Annotations)
+	}
 
-	go sendTestEvents(events, input)
-	svm := &sync2.ServiceManager{}
-	svm.Go(func(ctx *sync2.ServiceContext) error {
-		_, err := bls.parseEvents(ctx, events)
-		return err
-	})
-	err := svm.Join()
-	if err == nil {
-		t.Errorf("expected error, got none")
-		return
+	// Without Annotations, only the transactions with context have some.
+	got = nil
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		got = append(got, md.Annotations)
 	}
-	if got := err.Error(); !strings.HasPrefix(got, want) {
-		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want = []map[string]string{nil, {InvokerAnnotation: "vt_app@localhost"}, nil}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got Annotations %v, want %v", got, want)
 	}
 }
 
-func TestStreamerParseEventsOtherDB(t *testing.T) {
-	input := []replication.BinlogEvent{
-		rotateEvent{},
-		formatEvent{},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "BEGIN"}},
-		queryEvent{query: replication.Query{
-			Database: "other",
-			SQL:      "INSERT INTO test values (3, 4)"}},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
-		xidEvent{},
+func TestStreamerChecksumAlgorithm(t *testing.T) {
+	testcases := []struct {
+		format replication.BinlogEvent
+		want   byte
+	}{
+		{mariadbFormatEvent, mysqlctl.BinlogChecksumAlgOff},
+		{mariadbChecksumFormatEvent, mysqlctl.BinlogChecksumAlgCRC32},
 	}
+	for _, tcase := range testcases {
+		var got []TransactionMetadata
+		sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+			return nil
+		}
+		bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+		bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+			got = append(got, md)
+		}
+		if _, ok := bls.ChecksumAlgorithm(); ok {
+			t.Errorf("ChecksumAlgorithm() is known before the stream started")
+		}
+		input := []replication.BinlogEvent{mariadbRotateEvent, tcase.format}
+		if tcase.want == mysqlctl.BinlogChecksumAlgOff {
+			input = append(input, mariadbBeginGTIDEvent, mariadbInsertEvent, mariadbXidEvent)
+		}
+		if err := parseTestEvents(bls, input); err != ErrServerEOF {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if alg, ok := bls.ChecksumAlgorithm(); !ok || alg != tcase.want {
+			t.Errorf("ChecksumAlgorithm() = (%v, %v), want (%v, true)", alg, ok, tcase.want)
+		}
+		for _, md := range got {
+			if md.ChecksumAlgorithm != tcase.want {
+				t.Errorf("got TransactionMetadata.ChecksumAlgorithm %v, want %v", md.ChecksumAlgorithm, tcase.want)
+			}
+		}
+	}
+}
 
-	events := make(chan replication.BinlogEvent)
-
-	want := []binlogdatapb.BinlogTransaction{
-		{
-			Statements: []*binlogdatapb.BinlogTransaction_Statement{
-				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
-				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"},
-			},
-			Timestamp: 1407805592,
-			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
-				Domain:   0,
-				Server:   62344,
-				Sequence: 0x0d,
-			}),
-		},
+func TestStreamerParseEventsMariadbIncident(t *testing.T) {
+	input := []replication.BinlogEvent{
+		mariadbRotateEvent,
+		mariadbFormatEvent,
+		mariadbBeginGTIDEvent,
+		mariadbInsertEvent,
+		mariadbIncidentEvent,
+		mariadbXidEvent,
 	}
+
 	var got []binlogdatapb.BinlogTransaction
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
 		got = append(got, *trans)
 		return nil
 	}
+
+	// By default, the incident ends the stream.
 	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	err := parseTestEvents(bls, input)
+	want := &ReplicationIncidentError{Type: 1, Message: "error writing to the binary log"}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("wrong error, got %#v, want %#v", err, want)
+	}
+	if got, want := err.Error(), "replication incident LOST_EVENTS in the binlog: error writing to the binary log"; got != want {
+		t.Errorf("wrong error message, got %#v, want %#v", got, want)
+	}
+	if len(got) != 0 {
+		t.Errorf("transactions were sent past the incident: %v", got)
+	}
 
-	go sendTestEvents(events, input)
-	svm := &sync2.ServiceManager{}
-	svm.Go(func(ctx *sync2.ServiceContext) error {
-		_, err := bls.parseEvents(ctx, events)
-		return err
-	})
-	if err := svm.Join(); err != ErrServerEOF {
+	// It can be ignored.
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.IgnoreIncidents = true
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
 		t.Errorf("unexpected error: %v", err)
 	}
-
-	if !reflect.DeepEqual(got, want) {
-		t.Errorf("binlogConnStreamer.parseEvents(): got %v, want %v", got, want)
+	if len(got) != 1 {
+		t.Errorf("got %v transactions, want 1", len(got))
 	}
 }
 
-func TestStreamerParseEventsOtherDBBegin(t *testing.T) {
+func TestStreamerParseEventsStrictEvents(t *testing.T) {
 	input := []replication.BinlogEvent{
 		rotateEvent{},
 		formatEvent{},
-		queryEvent{query: replication.Query{
-			Database: "other",
-			SQL:      "BEGIN"}}, // Check that this doesn't get filtered out.
-		queryEvent{query: replication.Query{
-			Database: "other",
-			SQL:      "INSERT INTO test values (3, 4)"}},
+		// PREVIOUS_GTIDS_LOG_EVENT doesn't carry changes.
+		otherEvent{typ: 35},
 		queryEvent{query: replication.Query{
 			Database: "vt_test_keyspace",
-			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
+			SQL:      "BEGIN"}},
+		// WRITE_ROWS_EVENT
+		otherEvent{typ: 30},
 		xidEvent{},
 	}
 
-	events := make(chan replication.BinlogEvent)
-
-	want := []binlogdatapb.BinlogTransaction{
-		{
-			Statements: []*binlogdatapb.BinlogTransaction_Statement{
-				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
-				{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Sql: "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"},
-			},
-			Timestamp: 1407805592,
-			TransactionId: replication.EncodeGTID(replication.MariadbGTID{
-				Domain:   0,
-				Server:   62344,
-				Sequence: 0x0d,
-			}),
-		},
-	}
 	var got []binlogdatapb.BinlogTransaction
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
 		got = append(got, *trans)
 		return nil
 	}
-	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
 
-	go sendTestEvents(events, input)
-	svm := &sync2.ServiceManager{}
-	svm.Go(func(ctx *sync2.ServiceContext) error {
-		_, err := bls.parseEvents(ctx, events)
-		return err
-	})
-	if err := svm.Join(); err != ErrServerEOF {
+	// By default, the rows event is skipped.
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
 		t.Errorf("unexpected error: %v", err)
 	}
+	if len(got) != 1 {
+		t.Errorf("got %v transactions, want 1", len(got))
+	}
 
-	if !reflect.DeepEqual(got, want) {
-		t.Errorf("binlogConnStreamer.parseEvents(): got %v, want %v", got, want)
+	got = nil
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.StrictEvents = true
+	err := parseTestEvents(bls, input)
+	uerr, ok := err.(*UnsupportedEventError)
+	if !ok || uerr.Type != 30 {
+		t.Fatalf("wrong error, got %#v, want an *UnsupportedEventError for type 30", err)
+	}
+	if got, want := err.Error(), "unsupported binlog event WRITE_ROWS_EVENT"; got != want {
+		t.Errorf("wrong error message, got %#v, want %#v", got, want)
+	}
+	if len(got) != 0 {
+		t.Errorf("transactions were sent past the unsupported event: %v", got)
 	}
 }
 
-func TestStreamerParseEventsBeginAgain(t *testing.T) {
+func TestStreamerParseEventsProviderRDS(t *testing.T) {
 	input := []replication.BinlogEvent{
-		rotateEvent{},
-		formatEvent{},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "BEGIN"}},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "insert into vt_a(eid, id) values (1, 1) /* _stream vt_a (eid id ) (1 1 ); */"}},
-		queryEvent{query: replication.Query{
-			Database: "vt_test_keyspace",
-			SQL:      "BEGIN"}},
+		mariadbRotateEvent,
+		mariadbFormatEvent,
+		mariadbBeginGTIDEvent,
+		rdsHeartbeatEvent,
+		mariadbXidEvent,
+		mariadbBeginGTIDEvent,
+		rdsHeartbeatTableMapEvent,
+		rdsHeartbeatRowsEvent,
+		mariadbXidEvent,
+		mariadbBeginGTIDEvent,
+		mariadbInsertEvent,
+		mariadbXidEvent,
 	}
 
-	events := make(chan replication.BinlogEvent)
-
+	var got [][]string
 	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		var sql []string
+		for _, stmt := range trans.Statements {
+			if stmt.Category != binlogdatapb.BinlogTransaction_Statement_BL_SET {
+				sql = append(sql, stmt.Sql)
+			}
+		}
+		got = append(got, sql)
 		return nil
 	}
+	insert := "insert into vt_insert_test(msg) values ('test 0') /* _stream vt_insert_test (id ) (null ); */"
+
+	// By default, the statement of the heartbeat is sent, and its rows
+	// events are rejected.
 	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
-	before := binlogStreamerErrors.Counts()["ParseEvents"]
+	bls.StrictEvents = true
+	err := parseTestEvents(bls, input)
+	if uerr, ok := err.(*UnsupportedEventError); !ok || uerr.Type != 23 {
+		t.Errorf("wrong error, got %#v, want an *UnsupportedEventError for type 23", err)
+	}
+	want := [][]string{
+		{"INSERT INTO mysql.rds_heartbeat2(id, value) values (1,1409892744000) ON DUPLICATE KEY UPDATE value = 1409892744000"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %v, want %v", got, want)
+	}
 
-	go sendTestEvents(events, input)
-	svm := &sync2.ServiceManager{}
-	svm.Go(func(ctx *sync2.ServiceContext) error {
-		_, err := bls.parseEvents(ctx, events)
-		return err
-	})
-	if err := svm.Join(); err != ErrServerEOF {
+	// Both are skipped with ProviderRDS.
+	got = nil
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.StrictEvents = true
+	bls.Provider = ProviderRDS
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
 		t.Errorf("unexpected error: %v", err)
 	}
-	after := binlogStreamerErrors.Counts()["ParseEvents"]
-	if got := after - before; got != 1 {
-		t.Errorf("error count change = %v, want 1", got)
+	want = [][]string{nil, nil, {insert}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %v, want %v", got, want)
+	}
+}
+
+func TestStreamerAssertStatementBased(t *testing.T) {
+	begin := queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: "BEGIN"}}
+	insert := queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: "insert into vt_a(eid, id) values (1, 1)"}}
+	testcases := []struct {
+		desc  string
+		input []replication.BinlogEvent
+		want  byte
+	}{{
+		desc:  "table map",
+		input: []replication.BinlogEvent{rotateEvent{}, formatEvent{}, begin, insert, xidEvent{}, begin, tableMapEvent{tm: replication.TableMap{Database: "vt_test_keyspace", Name: "vt_a"}}, otherEvent{typ: 30}, xidEvent{}},
+		want:  19,
+	}, {
+		desc:  "rows event",
+		input: []replication.BinlogEvent{rotateEvent{}, formatEvent{}, begin, insert, xidEvent{}, begin, otherEvent{typ: 23}, xidEvent{}},
+		want:  23,
+	}}
+
+	for _, tc := range testcases {
+		var got int
+		sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+			got++
+			return nil
+		}
+		bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+		bls.AssertStatementBased = true
+		err := parseTestEvents(bls, tc.input)
+		rerr, ok := err.(*RowBasedEventError)
+		if !ok || rerr.Type != tc.want {
+			t.Errorf("%v: wrong error, got %#v, want a *RowBasedEventError for type %v", tc.desc, err, tc.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), "binlog_format of mysqld is ROW") {
+			t.Errorf("%v: error %q doesn't tell the binlog_format", tc.desc, err)
+		}
+		// The transaction of the statement is sent, not the one of the
+		// rows event.
+		if got != 1 {
+			t.Errorf("%v: got %v transactions, want 1", tc.desc, got)
+		}
+	}
+
+	// The rows events of RDS management tables are skipped with
+	// ProviderRDS.
+	input := []replication.BinlogEvent{
+		mariadbRotateEvent,
+		mariadbFormatEvent,
+		mariadbBeginGTIDEvent,
+		rdsHeartbeatTableMapEvent,
+		rdsHeartbeatRowsEvent,
+		mariadbXidEvent,
+		mariadbBeginGTIDEvent,
+		mariadbInsertEvent,
+		mariadbXidEvent,
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, func(*binlogdatapb.BinlogTransaction) error { return nil })
+	bls.AssertStatementBased = true
+	bls.Provider = ProviderRDS
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRDSManagementStatement(t *testing.T) {
+	testcases := []struct {
+		sql  string
+		want bool
+	}{
+		{"INSERT INTO mysql.rds_heartbeat2(id, value) values (1,1409892744000) ON DUPLICATE KEY UPDATE value = 1409892744000", true},
+		{"update `mysql`.`rds_configuration` set value = 24 where name = 'binlog retention hours'", true},
+		{"DELETE FROM mysql.rds_history WHERE action_timestamp < now()", true},
+		{"insert into vt_a(eid, msg) values (1, 'mysql.rds_heartbeat2')", false},
+		{"insert into mysql.user(host, user) values ('%', 'rds_admin')", false},
+		{"select * from mysql.rds_heartbeat2", false},
+	}
+	for _, tcase := range testcases {
+		if got := rdsManagementStatement.MatchString(tcase.sql); got != tcase.want {
+			t.Errorf("rdsManagementStatement.MatchString(%q) = %v, want %v", tcase.sql, got, tcase.want)
+		}
 	}
 }
 
@@ -1120,3 +5188,124 @@ func TestGetStatementCategory(t *testing.T) {
 		}
 	}
 }
+
+// dropStatementsPatterns returns n patterns in the style of a data-masking
+// configuration, none of which match the benchmark statement.
+func dropStatementsPatterns(n int) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, n)
+	for i := range patterns {
+		patterns[i] = regexp.MustCompile(fmt.Sprintf(`\bvt_masked_%d\b`, i))
+	}
+	return patterns
+}
+
+func benchmarkStreamerIsDropped(b *testing.B, patterns []*regexp.Regexp) {
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, nil)
+	bls.DropStatements = patterns
+	sql := "insert into vt_a(eid, id, name) values (1, 1, 'some name') /* _stream vt_a (eid id ) (1 1 ); */"
+	filter := bls.currentFilter()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if filter.isDropped(binlogdatapb.BinlogTransaction_Statement_BL_DML, sql) {
+			b.Fatalf("statement was dropped")
+		}
+	}
+}
+
+func BenchmarkStreamerIsDroppedNoPatterns(b *testing.B) {
+	benchmarkStreamerIsDropped(b, nil)
+}
+
+func BenchmarkStreamerIsDropped50Patterns(b *testing.B) {
+	benchmarkStreamerIsDropped(b, dropStatementsPatterns(50))
+}
+
+func TestStreamerMaxOpenTransactionDuration(t *testing.T) {
+	query := func(sql string) replication.BinlogEvent {
+		return queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: sql}}
+	}
+	// After the FORMAT_DESCRIPTION_EVENT, the events come 10 seconds apart.
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		query("BEGIN"),
+		query("insert into vt_a(eid, id) values (1, 1)"),
+		xidEvent{}, // open for 20s
+		query("BEGIN"),
+		query("insert into vt_a(eid, id) values (2, 1)"),
+		query("insert into vt_a(eid, id) values (3, 1)"),
+		query("insert into vt_a(eid, id) values (4, 1)"), // open for 30s
+		xidEvent{},
+	}
+
+	var got []int
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, len(trans.Statements))
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SetTimestamp = SetTimestampNever
+	bls.MaxOpenTransactionDuration = 25 * time.Second
+	clock := time.Unix(1407805592, 0)
+	bls.now = func() time.Time { return clock }
+	var ages []int64
+	bls.SendEvent = func(*StreamEvent) error {
+		ages = append(ages, binlogStreamerOpenTransactionSeconds.Get())
+		clock = clock.Add(10 * time.Second)
+		return nil
+	}
+	err := parseTestEvents(bls, input)
+	ote, ok := err.(*OpenTransactionError)
+	if !ok {
+		t.Fatalf("got error %v, want an *OpenTransactionError", err)
+	}
+	if ote.Duration != 30*time.Second || ote.Statements != 2 {
+		t.Errorf("got %v statements open for %v, want 2 for 30s", ote.Statements, ote.Duration)
+	}
+	// Only the first transaction is sent.
+	if want := []int{1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions of %v statements, want %v", got, want)
+	}
+	if want := []int64{0, 10, 20, 0, 10, 20}; !reflect.DeepEqual(ages, want) {
+		t.Errorf("got open transaction ages %v, want %v", ages, want)
+	}
+	if age := binlogStreamerOpenTransactionSeconds.Get(); age != 0 {
+		t.Errorf("BinlogStreamerOpenTransactionSeconds = %v after the stream, want 0", age)
+	}
+
+	// Without MaxOpenTransactionDuration, both are sent.
+	got = nil
+	bls = NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SetTimestamp = SetTimestampNever
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if want := []int{1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions of %v statements, want %v", got, want)
+	}
+}
+
+func TestStreamerMaxOpenTransactionDurationHeartbeats(t *testing.T) {
+	// A transaction stalls in the middle, so only the heartbeats of the
+	// SlaveConnection come, 10 seconds apart.
+	mysqld := mysqlctl.NewFakeMysqlDaemon(nil)
+	mysqld.BinlogDump = make(chan []byte, 6)
+	for _, ev := range []replication.BinlogEvent{mariadbRotateEvent, mariadbFormatEvent, mariadbBeginGTIDEvent, mariadbInsertEvent, mariadbHeartbeatEvent, mariadbHeartbeatEvent} {
+		mysqld.BinlogDump <- dumpPacket(ev)
+	}
+	close(mysqld.BinlogDump)
+
+	bls := NewStreamer("vt_test_keyspace", mysqld, nil, replication.Position{}, func(*binlogdatapb.BinlogTransaction) error { return nil })
+	bls.MaxOpenTransactionDuration = 25 * time.Second
+	clock := time.Unix(1407805592, 0)
+	bls.now = func() time.Time { return clock }
+	bls.SendEvent = func(*StreamEvent) error {
+		clock = clock.Add(10 * time.Second)
+		return nil
+	}
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	if err := svm.Join(); err == nil || !strings.Contains(err.Error(), "has been open for 30s") {
+		t.Errorf("wrong error, got %v, want a transaction open for 30s", err)
+	}
+}
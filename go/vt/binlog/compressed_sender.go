@@ -0,0 +1,109 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/golang/protobuf/proto"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// Compression is an algorithm NewCompressedSender() can compress the
+// transactions with.
+type Compression byte
+
+const (
+	// CompressionNone doesn't compress the transactions, e.g. for
+	// consumers on the same host.
+	CompressionNone Compression = iota
+	// CompressionGzip compresses the transactions with gzip. The level is
+	// one of the compress/gzip levels, from gzip.BestSpeed to
+	// gzip.BestCompression, or gzip.DefaultCompression.
+	CompressionGzip
+)
+
+// NewCompressedSender returns a sendTransaction func for NewStreamer() that
+// marshals each BinlogTransaction to its proto encoding, compresses it
+// with c at the given level, and passes the result to send. The first byte
+// of the result is c, so DecompressTransaction() doesn't need to be told
+// how it was compressed. This is meant for consumers that are far away,
+// since it saves bandwidth at the cost of some CPU on both ends.
+//
+// It returns an error if c or level isn't supported.
+func NewCompressedSender(c Compression, level int, send func(data []byte) error) (func(trans *binlogdatapb.BinlogTransaction) error, error) {
+	var zw *gzip.Writer
+	switch c {
+	case CompressionNone:
+	case CompressionGzip:
+		var err error
+		if zw, err = gzip.NewWriterLevel(ioutil.Discard, level); err != nil {
+			return nil, fmt.Errorf("invalid gzip compression level %v: %v", level, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown compression %v", c)
+	}
+
+	return func(trans *binlogdatapb.BinlogTransaction) error {
+		data, err := proto.Marshal(trans)
+		if err != nil {
+			return fmt.Errorf("can't marshal binlog transaction: %v", err)
+		}
+		if zw == nil {
+			return send(append([]byte{byte(c)}, data...))
+		}
+		buf := bytes.NewBuffer(make([]byte, 0, len(data)/2+1))
+		buf.WriteByte(byte(c))
+		zw.Reset(buf)
+		if _, err := zw.Write(data); err != nil {
+			return fmt.Errorf("can't compress binlog transaction: %v", err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("can't compress binlog transaction: %v", err)
+		}
+		return send(buf.Bytes())
+	}, nil
+}
+
+// DecompressTransaction decodes a BinlogTransaction sent by a
+// NewCompressedSender() func.
+func DecompressTransaction(data []byte) (*binlogdatapb.BinlogTransaction, error) {
+	payload, err := decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	trans := &binlogdatapb.BinlogTransaction{}
+	if err := proto.Unmarshal(payload, trans); err != nil {
+		return nil, fmt.Errorf("can't unmarshal binlog transaction: %v", err)
+	}
+	return trans, nil
+}
+
+// decompress returns the proto encoding of the transaction in data.
+func decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("can't decompress binlog transaction: no data")
+	}
+	payload := data[1:]
+	switch c := Compression(data[0]); c {
+	case CompressionNone:
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("can't decompress binlog transaction: %v", err)
+		}
+		if payload, err = ioutil.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("can't decompress binlog transaction: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("can't decompress binlog transaction: unknown compression %v", c)
+	}
+	return payload, nil
+}
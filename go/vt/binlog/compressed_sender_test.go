@@ -0,0 +1,156 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// largeTransaction returns a transaction of n inserts, as sent for a bulk
+// load.
+func largeTransaction(n int) *binlogdatapb.BinlogTransaction {
+	trans := &binlogdatapb.BinlogTransaction{
+		Timestamp:     1407805592,
+		TransactionId: "MariaDB/0-62344-13",
+	}
+	for i := 0; i < n; i++ {
+		trans.Statements = append(trans.Statements,
+			&binlogdatapb.BinlogTransaction_Statement{
+				Category: binlogdatapb.BinlogTransaction_Statement_BL_SET,
+				Sql:      "SET TIMESTAMP=1407805592",
+			},
+			&binlogdatapb.BinlogTransaction_Statement{
+				Category: binlogdatapb.BinlogTransaction_Statement_BL_DML,
+				Charset:  &binlogdatapb.Charset{Client: 33, Conn: 33, Server: 33},
+				Sql:      fmt.Sprintf("insert into vt_a(eid, id, msg) values (%d, %d, 'message number %d') /* _stream vt_a (eid id ) (%d %d ); */", i, i*7, i, i, i*7),
+			})
+	}
+	return trans
+}
+
+// protoSize returns the length of the proto encoding of trans.
+func protoSize(trans *binlogdatapb.BinlogTransaction) int {
+	data, _ := proto.Marshal(trans)
+	return len(data)
+}
+
+func TestCompressedSender(t *testing.T) {
+	testcases := []struct {
+		compression Compression
+		level       int
+	}{
+		{CompressionNone, 0},
+		{CompressionGzip, gzip.BestSpeed},
+		{CompressionGzip, gzip.DefaultCompression},
+		{CompressionGzip, gzip.BestCompression},
+	}
+	input := []*binlogdatapb.BinlogTransaction{
+		largeTransaction(100),
+		// An empty transaction.
+		{Timestamp: 1407805593, TransactionId: "MariaDB/0-62344-14"},
+	}
+
+	for _, tc := range testcases {
+		var sent [][]byte
+		send, err := NewCompressedSender(tc.compression, tc.level, func(data []byte) error {
+			sent = append(sent, data)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("NewCompressedSender(%v, %v) failed: %v", tc.compression, tc.level, err)
+		}
+		for _, trans := range input {
+			if err := send(trans); err != nil {
+				t.Fatalf("send() failed: %v", err)
+			}
+		}
+		for i, data := range sent {
+			if _, err := DecompressTransaction(data); err != nil {
+				t.Errorf("compression %v level %v: DecompressTransaction(%v) failed: %v", tc.compression, tc.level, i, err)
+				continue
+			}
+			got, err := decompress(data)
+			if err != nil {
+				t.Errorf("compression %v level %v: decompress(%v) failed: %v", tc.compression, tc.level, i, err)
+				continue
+			}
+			if want, _ := proto.Marshal(input[i]); !bytes.Equal(got, want) {
+				t.Errorf("compression %v level %v: transaction %v decompresses to %q, want %q", tc.compression, tc.level, i, got, want)
+			}
+		}
+		if tc.compression == CompressionGzip {
+			if size := protoSize(input[0]); len(sent[0]) >= size/2 {
+				t.Errorf("level %v: compressed %v bytes to %v, want less than half", tc.level, size, len(sent[0]))
+			}
+		}
+	}
+}
+
+func TestCompressedSenderErrors(t *testing.T) {
+	send := func([]byte) error { return nil }
+	if _, err := NewCompressedSender(Compression(7), 0, send); err == nil || err.Error() != "unknown compression 7" {
+		t.Errorf("got error %v, want unknown compression", err)
+	}
+	if _, err := NewCompressedSender(CompressionGzip, 42, send); err == nil || !strings.HasPrefix(err.Error(), "invalid gzip compression level 42") {
+		t.Errorf("got error %v, want invalid gzip compression level", err)
+	}
+
+	for _, tc := range []struct {
+		data []byte
+		want string
+	}{
+		{nil, "can't decompress binlog transaction: no data"},
+		{[]byte{7, 1, 2}, "can't decompress binlog transaction: unknown compression 7"},
+		{[]byte{byte(CompressionGzip), 1, 2}, "can't decompress binlog transaction: "},
+	} {
+		if _, err := DecompressTransaction(tc.data); err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("DecompressTransaction(%v) = %v, want error starting with %q", tc.data, err, tc.want)
+		}
+	}
+}
+
+// benchmarkCompressedSender reports the time it takes to send a large
+// transaction, and the ratio of the sent bytes to its proto encoding.
+func benchmarkCompressedSender(b *testing.B, c Compression, level int) {
+	trans := largeTransaction(1000)
+	size := protoSize(trans)
+	var sentBytes int
+	send, err := NewCompressedSender(c, level, func(data []byte) error {
+		sentBytes = len(data)
+		return nil
+	})
+	if err != nil {
+		b.Fatalf("NewCompressedSender() failed: %v", err)
+	}
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := send(trans); err != nil {
+			b.Fatalf("send() failed: %v", err)
+		}
+	}
+	b.StopTimer()
+	b.Logf("sent %v bytes for a transaction of %v bytes (%.1f%%)", sentBytes, size, 100*float64(sentBytes)/float64(size))
+}
+
+func BenchmarkCompressedSenderNone(b *testing.B) {
+	benchmarkCompressedSender(b, CompressionNone, 0)
+}
+
+func BenchmarkCompressedSenderGzipBestSpeed(b *testing.B) {
+	benchmarkCompressedSender(b, CompressionGzip, gzip.BestSpeed)
+}
+
+func BenchmarkCompressedSenderGzipDefault(b *testing.B) {
+	benchmarkCompressedSender(b, CompressionGzip, gzip.DefaultCompression)
+}
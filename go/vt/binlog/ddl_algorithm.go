@@ -0,0 +1,79 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"regexp"
+	"strings"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// ddlAlgorithmClause matches the ALGORITHM clause of an ALTER TABLE, a
+// CREATE INDEX or a DROP INDEX. The ALGORITHM of a CREATE VIEW has other
+// values, so it doesn't match.
+var ddlAlgorithmClause = regexp.MustCompile(`(?i)\balgorithm\s*=?\s*(default|instant|inplace|nocopy|copy)\b`)
+
+// DDLAlgorithm returns the algorithm a DDL asks for in its ALGORITHM
+// clause, in upper case, or "" if it doesn't have one. It's INSTANT or
+// INPLACE for the DDLs that mysqld can run without copying the table,
+// e.g. ALGORITHM=INSTANT only changes the metadata of the table with
+// MySQL 8.0, COPY for a rebuild of the table, NOCOPY with MariaDB, or
+// DEFAULT. Without the clause, mysqld picks the algorithm, which isn't in
+// the binlog. The quoted strings and identifiers of the DDL are ignored.
+// See TransactionMetadata.DDLAlgorithms.
+func DDLAlgorithm(sql string) string {
+	m := ddlAlgorithmClause.FindStringSubmatch(unquoted(sql))
+	if m == nil {
+		return ""
+	}
+	return strings.ToUpper(m[1])
+}
+
+// unquoted returns sql with its strings and quoted identifiers replaced by
+// spaces.
+func unquoted(sql string) string {
+	if strings.IndexAny(sql, "'\"`") < 0 {
+		return sql
+	}
+	buf := []byte(sql)
+	var quote byte
+	for i := 0; i < len(buf); i++ {
+		c := buf[i]
+		switch {
+		case quote == 0:
+			if c == '\'' || c == '"' || c == '`' {
+				quote = c
+				buf[i] = ' '
+			}
+			continue
+		case c == '\\' && quote != '`' && i+1 < len(buf):
+			buf[i] = ' '
+			i++
+		case c == quote:
+			quote = 0
+		}
+		buf[i] = ' '
+	}
+	return string(buf)
+}
+
+// ddlAlgorithms returns the DDLAlgorithm() of each statement, or nil if
+// none of them has one.
+func ddlAlgorithms(statements []*binlogdatapb.BinlogTransaction_Statement) []string {
+	var algorithms []string
+	for i, st := range statements {
+		if st.Category != binlogdatapb.BinlogTransaction_Statement_BL_DDL {
+			continue
+		}
+		if algorithm := DDLAlgorithm(st.Sql); algorithm != "" {
+			if algorithms == nil {
+				algorithms = make([]string, len(statements))
+			}
+			algorithms[i] = algorithm
+		}
+	}
+	return algorithms
+}
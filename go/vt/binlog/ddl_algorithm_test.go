@@ -0,0 +1,56 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"reflect"
+	"testing"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+func TestDDLAlgorithm(t *testing.T) {
+	testcases := []struct {
+		sql, want string
+	}{
+		{"alter table vt_a add column c int, algorithm=instant", "INSTANT"},
+		{"ALTER TABLE vt_a ADD INDEX (c), ALGORITHM=INPLACE, LOCK=NONE", "INPLACE"},
+		{"alter table vt_a modify c bigint, ALGORITHM = COPY", "COPY"},
+		{"alter table vt_a engine=InnoDB algorithm copy", "COPY"},
+		{"alter table vt_a add column d int, algorithm=NOCOPY", "NOCOPY"},
+		{"alter table vt_a drop column c, algorithm=default", "DEFAULT"},
+		{"create index c on vt_a (c) algorithm=inplace", "INPLACE"},
+		{"drop index c on vt_a algorithm=instant", "INSTANT"},
+		{"alter table vt_a add column c int", ""},
+		// The algorithm of a view is something else.
+		{"create algorithm=merge view vt_v as select * from vt_a", ""},
+		// Nor is a string or a quoted identifier.
+		{"alter table vt_a add column c int comment 'algorithm=copy'", ""},
+		{"alter table vt_a add column c int comment 'it\\'s algorithm=copy'", ""},
+		{"alter table vt_a add column `algorithm=copy` int, algorithm=instant", "INSTANT"},
+		{"alter table vt_a add column algorithm int", ""},
+	}
+	for _, tc := range testcases {
+		if got := DDLAlgorithm(tc.sql); got != tc.want {
+			t.Errorf("DDLAlgorithm(%q) = %q, want %q", tc.sql, got, tc.want)
+		}
+	}
+}
+
+func TestDDLAlgorithms(t *testing.T) {
+	ddl := func(sql string) *binlogdatapb.BinlogTransaction_Statement {
+		return &binlogdatapb.BinlogTransaction_Statement{Category: binlogdatapb.BinlogTransaction_Statement_BL_DDL, Sql: sql}
+	}
+	setTimestamp := &binlogdatapb.BinlogTransaction_Statement{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"}
+
+	statements := []*binlogdatapb.BinlogTransaction_Statement{setTimestamp, ddl("alter table vt_a add column c int, algorithm=instant")}
+	if got, want := ddlAlgorithms(statements), []string{"", "INSTANT"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ddlAlgorithms() = %q, want %q", got, want)
+	}
+	statements = []*binlogdatapb.BinlogTransaction_Statement{setTimestamp, ddl("alter table vt_a add column c int")}
+	if got := ddlAlgorithms(statements); got != nil {
+		t.Errorf("ddlAlgorithms() = %q, want nil", got)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"sync"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+)
+
+// Deduplicator remembers the transactions sent to a consumer by its
+// successive Streamers, so the ones a Streamer sends again after a
+// reconnect are dropped. See Streamer.Dedup. It's a best effort for the
+// consumers that can't tell the Streamer what they applied: the
+// transactions that were sent but not applied are lost, e.g. if the
+// consumer crashed.
+type Deduplicator struct {
+	mu  sync.Mutex
+	pos replication.Position
+}
+
+// NewDeduplicator returns a Deduplicator for a consumer that has the
+// transactions of pos, e.g. the start position of its first Streamer.
+func NewDeduplicator(pos replication.Position) *Deduplicator {
+	return &Deduplicator{pos: pos}
+}
+
+// Position returns the position of the transactions the consumer has: the
+// one the Deduplicator was created with, and the ones sent since then.
+func (d *Deduplicator) Position() replication.Position {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pos
+}
+
+// sent returns true if the transaction gtid was already sent to the
+// consumer. It compares GTID sets, e.g. with MariaDB, a transaction is
+// covered by any later one of its domain. A nil gtid is never sent.
+func (d *Deduplicator) sent(gtid replication.GTID) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return gtid != nil && d.pos.GTIDSet != nil && d.pos.GTIDSet.ContainsGTID(gtid)
+}
+
+// add records that the transaction gtid was sent to the consumer.
+func (d *Deduplicator) add(gtid replication.GTID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pos = replication.AppendGTID(d.pos, gtid)
+}
@@ -0,0 +1,52 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+)
+
+func TestDeduplicatorMysql56(t *testing.T) {
+	sid, err := replication.ParseSID("00010203-0405-0607-0809-0a0b0c0d0e0f")
+	if err != nil {
+		t.Fatalf("ParseSID() failed: %v", err)
+	}
+	other, err := replication.ParseSID("00010203-0405-0607-0809-0a0b0c0d0eff")
+	if err != nil {
+		t.Fatalf("ParseSID() failed: %v", err)
+	}
+	d := NewDeduplicator(replication.MustParsePosition(FlavorMySQL56, "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5:8"))
+
+	testcases := []struct {
+		gtid replication.GTID
+		want bool
+	}{
+		{nil, false},
+		{replication.Mysql56GTID{Server: sid, Sequence: 3}, true},
+		{replication.Mysql56GTID{Server: sid, Sequence: 8}, true},
+		// The gap in the set wasn't sent.
+		{replication.Mysql56GTID{Server: sid, Sequence: 6}, false},
+		{replication.Mysql56GTID{Server: sid, Sequence: 9}, false},
+		{replication.Mysql56GTID{Server: other, Sequence: 1}, false},
+	}
+	for _, tc := range testcases {
+		if got := d.sent(tc.gtid); got != tc.want {
+			t.Errorf("sent(%v) = %v, want %v", tc.gtid, got, tc.want)
+		}
+	}
+
+	d.add(replication.Mysql56GTID{Server: sid, Sequence: 6})
+	want := replication.MustParsePosition(FlavorMySQL56, "00010203-0405-0607-0809-0a0b0c0d0e0f:1-6:8")
+	if got := d.Position(); !got.Equal(want) {
+		t.Errorf("Position() = %v, want %v", got, want)
+	}
+
+	// Nothing was sent to a consumer that starts from scratch.
+	if NewDeduplicator(replication.Position{}).sent(replication.Mysql56GTID{Server: sid, Sequence: 1}) {
+		t.Errorf("sent() = true for an empty Deduplicator")
+	}
+}
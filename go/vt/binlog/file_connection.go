@@ -0,0 +1,293 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// binlogFileMagic is the header of every binlog file.
+var binlogFileMagic = []byte{0xfe, 'b', 'i', 'n'}
+
+// FileConnection is a BinlogConnection that reads the events from binlog
+// files on disk instead of from mysqld, e.g. to replay the binlogs of a
+// backup. Use it with NewStreamerWithConn().
+//
+// It starts with one file, and follows the ROTATE_EVENT at the end of each
+// file to the next one in the same directory, so the Streamer sees a
+// continuous stream. The events channel is closed when a file ends without
+// a ROTATE_EVENT, or when the file it names doesn't exist, which makes the
+// stream end with ErrServerEOF. A file that can't be read also ends the
+// stream, after logging the error. Like the other connections passed to
+// NewStreamerWithConn(), it must be closed by the caller.
+//
+// The files that MySQL encrypts with binlog_encryption=ON are decrypted
+// with EncryptionKey. MariaDB encrypts the events after a
+// START_ENCRYPTION_EVENT instead, which isn't supported: such a file ends
+// the stream. The connections to mysqld don't need the keys, since it
+// decrypts the events before sending them.
+type FileConnection struct {
+	// EncryptionKey, if set, returns the key of the keyring of mysqld
+	// named keyID, e.g. MySQLReplicationKey_<server_uuid>_1, which
+	// encrypts the password of the encrypted binlog files. It's needed to
+	// read them.
+	EncryptionKey func(keyID string) ([]byte, error)
+
+	dir       string
+	firstFile string
+	newEvent  func(buf []byte) replication.BinlogEvent
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewFileConnection returns a FileConnection that starts at the binlog
+// file named firstFile in dir. newEvent creates the events of the flavor
+// of mysqld that wrote the files, e.g. mysqlctl.NewMariadbBinlogEvent.
+func NewFileConnection(dir, firstFile string, newEvent func(buf []byte) replication.BinlogEvent) *FileConnection {
+	return &FileConnection{
+		dir:       dir,
+		firstFile: firstFile,
+		newEvent:  newEvent,
+		closed:    make(chan struct{}),
+	}
+}
+
+// GetCharset is part of the BinlogConnection interface. Binlog files don't
+// have a connection charset, so it always fails: Streamers that read from
+// a FileConnection must be created with a nil clientCharset.
+func (fc *FileConnection) GetCharset() (*binlogdatapb.Charset, error) {
+	return nil, fmt.Errorf("binlog files in %v have no connection charset", fc.dir)
+}
+
+// StartBinlogDump is part of the BinlogConnection interface. Binlog files
+// can't be searched for a GTID, so the events start at the beginning of the
+// first file, and startPos is ignored, unless it's a
+// replication.FilePosGTID. The events then start at its offset in its file,
+// after the FORMAT_DESCRIPTION_EVENT of the file, like mysqld sends them.
+func (fc *FileConnection) StartBinlogDump(startPos replication.Position) (<-chan replication.BinlogEvent, error) {
+	name := fc.firstFile
+	var start uint32
+	if filePos, ok := startPos.GTIDSet.(replication.FilePosGTID); ok {
+		name, start = filePos.File, filePos.Pos
+	}
+	f, err := fc.openBinlogFile(name)
+	if err != nil {
+		return nil, err
+	}
+	eventChan := make(chan replication.BinlogEvent)
+	fc.wg.Add(1)
+	go func() {
+		defer fc.wg.Done()
+		defer close(eventChan)
+		for {
+			next, err := fc.readFile(name, f, start, eventChan)
+			f.Close()
+			if err != nil {
+				log.Errorf("can't read binlog file %v: %v", path.Join(fc.dir, name), err)
+				return
+			}
+			select {
+			case <-fc.closed:
+				return
+			default:
+			}
+			if next == "" {
+				log.Infof("reached the end of binlog file %v, which doesn't rotate to another file", path.Join(fc.dir, name))
+				return
+			}
+			name, start = next, 0
+			if f, err = fc.openBinlogFile(name); err != nil {
+				if os.IsNotExist(err) {
+					log.Infof("reached the end of the binlog files, %v doesn't exist", name)
+				} else {
+					log.Errorf("can't open binlog file: %v", err)
+				}
+				return
+			}
+		}
+	}()
+	return eventChan, nil
+}
+
+// Close is part of the BinlogConnection interface. It also waits for the
+// events channel to be closed.
+func (fc *FileConnection) Close() {
+	fc.closeOnce.Do(func() {
+		close(fc.closed)
+	})
+	fc.wg.Wait()
+}
+
+// readFile sends the events of f, the binlog file name, on eventChan, until
+// the end of f or until the connection is closed. Like mysqld, it starts
+// with an artificial ROTATE_EVENT to name, so the Streamer knows which file
+// the events come from. The events before the offset start are skipped,
+// except for the FORMAT_DESCRIPTION_EVENT. It returns the name of the file
+// the last ROTATE_EVENT of f points to, if any.
+func (fc *FileConnection) readFile(name string, f io.Reader, start uint32, eventChan chan<- replication.BinlogEvent) (next string, err error) {
+	var format replication.BinlogFormat
+	offset := uint32(len(binlogFileMagic))
+	for {
+		header := make([]byte, 19)
+		if _, err := io.ReadFull(f, header); err != nil {
+			if err == io.EOF {
+				return next, nil
+			}
+			return "", fmt.Errorf("can't read event header: %v", err)
+		}
+		length := binary.LittleEndian.Uint32(header[9 : 9+4])
+		if length < 19 || length > maxEventLength {
+			return "", fmt.Errorf("event declares an invalid length of %v bytes", length)
+		}
+		buf := make([]byte, length)
+		copy(buf, header)
+		if _, err := io.ReadFull(f, buf[19:]); err != nil {
+			return "", fmt.Errorf("can't read event of %v bytes: %v", length, err)
+		}
+		skip := offset < start
+		if skip && offset+length > start {
+			return "", fmt.Errorf("start position %v is in the middle of the event at %v", start, offset)
+		}
+		offset += length
+
+		// The Streamer reports the events that aren't valid.
+		ev := fc.newEvent(buf)
+		if skip && !(ev.IsValid() && ev.IsFormatDescription()) {
+			continue
+		}
+		if ev.IsValid() {
+			switch {
+			case ev.Type() == startEncryptionEvent:
+				return "", fmt.Errorf("the events after the START_ENCRYPTION_EVENT at %v are encrypted by MariaDB, which isn't supported", offset-length)
+			case ev.IsFormatDescription():
+				first := format.IsZero()
+				if format, err = ev.Format(); err != nil {
+					return "", fmt.Errorf("can't parse FORMAT_DESCRIPTION_EVENT: %v", err)
+				}
+				if first && !fc.send(eventChan, fc.newEvent(artificialRotateEvent(name, format.ChecksumAlgorithm))) {
+					return "", nil
+				}
+			case ev.IsRotate() && !format.IsZero():
+				if next, err = rotateFile(ev, format); err != nil {
+					return "", err
+				}
+			}
+		}
+
+		if !fc.send(eventChan, ev) {
+			return "", nil
+		}
+	}
+}
+
+// send sends ev on eventChan. It returns false if the connection was closed
+// instead.
+func (fc *FileConnection) send(eventChan chan<- replication.BinlogEvent, ev replication.BinlogEvent) bool {
+	select {
+	case eventChan <- ev:
+		return true
+	case <-fc.closed:
+		return false
+	}
+}
+
+// artificialRotateEvent returns the ROTATE_EVENT mysqld sends at the start
+// of a binlog dump, to tell the slave which file it reads. Unlike the one
+// at the end of a binlog file, it has the LOG_EVENT_ARTIFICIAL_F flag and
+// no log_pos.
+func artificialRotateEvent(fileName string, checksumAlgorithm byte) []byte {
+	length := 19 + 8 + len(fileName)
+	if checksumAlgorithm == mysqlctl.BinlogChecksumAlgCRC32 {
+		length += 4
+	}
+	buf := make([]byte, length)
+	buf[4] = 4 // ROTATE_EVENT
+	binary.LittleEndian.PutUint32(buf[9:9+4], uint32(length))
+	binary.LittleEndian.PutUint16(buf[17:17+2], 0x20) // LOG_EVENT_ARTIFICIAL_F
+	binary.LittleEndian.PutUint64(buf[19:19+8], 4)
+	copy(buf[19+8:], fileName)
+	if checksumAlgorithm == mysqlctl.BinlogChecksumAlgCRC32 {
+		binary.LittleEndian.PutUint32(buf[length-4:], crc32.ChecksumIEEE(buf[:length-4]))
+	}
+	return buf
+}
+
+// binlogFile is a binlog file opened by openBinlogFile. Reader has its
+// content, decrypted if it's encrypted.
+type binlogFile struct {
+	io.Reader
+	io.Closer
+}
+
+// openBinlogFile opens the binlog file name of the directory, and reads its
+// header. If the file is encrypted, the content is decrypted with the key
+// returned by EncryptionKey.
+func (fc *FileConnection) openBinlogFile(name string) (*binlogFile, error) {
+	name = path.Join(fc.dir, name)
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	file := &binlogFile{Reader: f, Closer: f}
+	magic := make([]byte, len(binlogFileMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%v is not a binlog file", name)
+	}
+	if bytes.Equal(magic, encryptedBinlogFileMagic) {
+		if file.Reader, err = fc.decryptBinlogFile(f, magic); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("can't decrypt binlog file %v: %v", name, err)
+		}
+		// A wrong key decrypts garbage, so it's found by the magic.
+		if _, err := io.ReadFull(file, magic); err != nil || !bytes.Equal(magic, binlogFileMagic) {
+			f.Close()
+			return nil, fmt.Errorf("can't decrypt binlog file %v: wrong key", name)
+		}
+		return file, nil
+	}
+	if !bytes.Equal(magic, binlogFileMagic) {
+		f.Close()
+		return nil, fmt.Errorf("%v is not a binlog file", name)
+	}
+	return file, nil
+}
+
+// decryptBinlogFile reads the rest of the encryption header of f, which
+// starts with magic, and returns a reader of its decrypted content.
+func (fc *FileConnection) decryptBinlogFile(f io.Reader, magic []byte) (io.Reader, error) {
+	buf := make([]byte, encryptionHeaderSize)
+	copy(buf, magic)
+	if _, err := io.ReadFull(f, buf[len(magic):]); err != nil {
+		return nil, fmt.Errorf("can't read encryption header: %v", err)
+	}
+	header, err := parseEncryptionHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+	if fc.EncryptionKey == nil {
+		return nil, fmt.Errorf("it's encrypted with key %v, but there is no EncryptionKey", header.keyID)
+	}
+	key, err := fc.EncryptionKey(header.keyID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get key %v: %v", header.keyID, err)
+	}
+	return header.decrypt(f, key)
+}
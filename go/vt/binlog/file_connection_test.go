@@ -0,0 +1,446 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// rotateEventTo returns a ROTATE_EVENT to fileName, as mysqld writes at
+// the end of a binlog file.
+func rotateEventTo(fileName string) []byte {
+	buf := make([]byte, 19+8+len(fileName))
+	copy(buf, []byte{0x88, 0x41, 0x9, 0x54, 0x4, 0x88, 0xf3, 0x0, 0x0})
+	binary.LittleEndian.PutUint32(buf[9:], uint32(len(buf)))
+	binary.LittleEndian.PutUint32(buf[13:], 0xe00)
+	binary.LittleEndian.PutUint64(buf[19:], 4)
+	copy(buf[19+8:], fileName)
+	return buf
+}
+
+// writeBinlogFile writes a binlog file with the given events in dir.
+func writeBinlogFile(t *testing.T, dir, name string, events ...[]byte) {
+	data := append([]byte(nil), binlogFileMagic...)
+	for _, ev := range events {
+		data = append(data, ev...)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, name), data, 0644); err != nil {
+		t.Fatalf("can't write binlog file: %v", err)
+	}
+}
+
+// writeEncryptedBinlogFile writes a binlog file with the given events in
+// dir, encrypted with the keyring key named keyID.
+func writeEncryptedBinlogFile(t *testing.T, dir, name, keyID string, key []byte, events ...[]byte) {
+	data := append([]byte(nil), binlogFileMagic...)
+	for _, ev := range events {
+		data = append(data, ev...)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, name), encryptBinlogFile(data, keyID, key), 0644); err != nil {
+		t.Fatalf("can't write binlog file: %v", err)
+	}
+}
+
+func TestFileConnectionRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_connection_test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	transaction := [][]byte{
+		eventBytes(mariadbBeginGTIDEvent),
+		eventBytes(mariadbInsertEvent),
+		eventBytes(mariadbXidEvent),
+	}
+	writeBinlogFile(t, dir, "vt-bin.000001", append(append([][]byte{eventBytes(mariadbFormatEvent)}, transaction...), rotateEventTo("vt-bin.000002"))...)
+	// The last file rotates to one that doesn't exist yet.
+	writeBinlogFile(t, dir, "vt-bin.000002", append(append([][]byte{eventBytes(mariadbFormatEvent)}, transaction...), rotateEventTo("vt-bin.000003"))...)
+
+	var got []string
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	conn := NewFileConnection(dir, "vt-bin.000001", mysqlctl.NewMariadbBinlogEvent)
+	defer conn.Close()
+	bls := NewStreamerWithConn("vt_test_keyspace", conn, nil, replication.Position{}, sendTransaction)
+	bls.StatementLogPositions = true
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		got = append(got, md.LogPositions[len(md.LogPositions)-1].File)
+	}
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	if err := svm.Join(); err == nil || !strings.Contains(err.Error(), ErrServerEOF.Error()) {
+		t.Errorf("wrong error, got %v, want %v", err, ErrServerEOF)
+	}
+
+	// The position in the binlog files follows the rotation.
+	want := []string{"vt-bin.000001", "vt-bin.000002"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions in files %v, want %v", got, want)
+	}
+}
+
+// atOffsets returns copies of events whose next_position header field is
+// the end of the event, as if they were the events of a binlog file in
+// that order. ends has the end of each event.
+func atOffsets(events ...[]byte) (withPos [][]byte, ends []uint32) {
+	offset := uint32(len(binlogFileMagic))
+	for _, ev := range events {
+		ev = append([]byte(nil), ev...)
+		offset += uint32(len(ev))
+		binary.LittleEndian.PutUint32(ev[13:], offset)
+		withPos = append(withPos, ev)
+		ends = append(ends, offset)
+	}
+	return withPos, ends
+}
+
+func TestFileConnectionFilePos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_connection_test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// A master without GTIDs only logs the statements.
+	file1, ends1 := atOffsets(eventBytes(mariadbFormatEvent), eventBytes(mariadbCreateEvent), eventBytes(mariadbInsertEvent), eventBytes(mariadbInsertEvent), rotateEventTo("vt-bin.000002"))
+	file2, ends2 := atOffsets(eventBytes(mariadbFormatEvent), eventBytes(mariadbInsertEvent))
+	writeBinlogFile(t, dir, "vt-bin.000001", file1...)
+	writeBinlogFile(t, dir, "vt-bin.000002", file2...)
+
+	// The stream starts after the CREATE TABLE.
+	startPos := replication.Position{GTIDSet: replication.FilePosGTID{File: "vt-bin.000001", Pos: ends1[1]}}
+	var got []string
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, trans.TransactionId)
+		return nil
+	}
+	conn := NewFileConnection(dir, "vt-bin.000001", mysqlctl.NewMariadbBinlogEvent)
+	defer conn.Close()
+	bls := NewStreamerWithConn("vt_test_keyspace", conn, nil, startPos, sendTransaction)
+	var observed []string
+	bls.PositionObserver = func(pos replication.Position, gtid replication.GTID, timestamp uint32) {
+		observed = append(observed, replication.EncodeGTID(gtid))
+	}
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	if err := svm.Join(); err == nil || !strings.Contains(err.Error(), ErrServerEOF.Error()) {
+		t.Errorf("wrong error, got %v, want %v", err, ErrServerEOF)
+	}
+
+	// Each insert is a transaction, identified by the coordinates of its
+	// end, which is the position to restart at.
+	want := []string{
+		replication.EncodeGTID(replication.FilePosGTID{File: "vt-bin.000001", Pos: ends1[2]}),
+		replication.EncodeGTID(replication.FilePosGTID{File: "vt-bin.000001", Pos: ends1[3]}),
+		replication.EncodeGTID(replication.FilePosGTID{File: "vt-bin.000002", Pos: ends2[1]}),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %q, want %q", got, want)
+	}
+	// The observer sees the same positions as the transactions.
+	if !reflect.DeepEqual(observed, want) {
+		t.Errorf("PositionObserver got %q, want %q", observed, want)
+	}
+	wantPos := replication.Position{GTIDSet: replication.FilePosGTID{File: "vt-bin.000002", Pos: ends2[1]}}
+	if pos := bls.committedPos; !pos.Equal(wantPos) {
+		t.Errorf("committed position %v, want %v", pos, wantPos)
+	}
+
+	// A position in the middle of an event ends the events after the
+	// artificial ROTATE_EVENT and the FORMAT_DESCRIPTION_EVENT.
+	conn = NewFileConnection(dir, "vt-bin.000001", mysqlctl.NewMariadbBinlogEvent)
+	defer conn.Close()
+	events, err := conn.StartBinlogDump(replication.Position{GTIDSet: replication.FilePosGTID{File: "vt-bin.000001", Pos: ends1[1] + 1}})
+	if err != nil {
+		t.Fatalf("StartBinlogDump() failed: %v", err)
+	}
+	var count int
+	for range events {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("got %v events, want 2", count)
+	}
+}
+
+func TestFileConnectionFileStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_connection_test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file1, _ := atOffsets(eventBytes(mariadbFormatEvent), eventBytes(mariadbInsertEvent), rotateEventTo("vt-bin.000002"))
+	file2, ends2 := atOffsets(eventBytes(mariadbFormatEvent), eventBytes(mariadbInsertEvent), eventBytes(mariadbInsertEvent))
+	writeBinlogFile(t, dir, "vt-bin.000001", file1...)
+	writeBinlogFile(t, dir, "vt-bin.000002", file2...)
+
+	var got []string
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, trans.TransactionId)
+		return nil
+	}
+	conn := NewFileConnection(dir, "vt-bin.000001", mysqlctl.NewMariadbBinlogEvent)
+	defer conn.Close()
+	bls := NewStreamerWithConn("vt_test_keyspace", conn, nil, replication.BinlogFileStart("vt-bin.000002"), sendTransaction)
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	if err := svm.Join(); err == nil || !strings.Contains(err.Error(), ErrServerEOF.Error()) {
+		t.Errorf("wrong error, got %v, want %v", err, ErrServerEOF)
+	}
+
+	// The insert of the first file is skipped.
+	want := []string{
+		replication.EncodeGTID(replication.FilePosGTID{File: "vt-bin.000002", Pos: ends2[1]}),
+		replication.EncodeGTID(replication.FilePosGTID{File: "vt-bin.000002", Pos: ends2[2]}),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %q, want %q", got, want)
+	}
+}
+
+func TestFileConnectionEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_connection_test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	keys := map[string][]byte{
+		"MySQLReplicationKey_vt_1": testEncryptionKey(1),
+		"MySQLReplicationKey_vt_2": testEncryptionKey(2),
+	}
+	file1, ends1 := atOffsets(eventBytes(mariadbFormatEvent), eventBytes(mariadbBeginGTIDEvent), eventBytes(mariadbInsertEvent), eventBytes(mariadbXidEvent), rotateEventTo("vt-bin.000002"))
+	file2, ends2 := atOffsets(eventBytes(mariadbFormatEvent), eventBytes(mariadbBeginGTIDEvent), eventBytes(mariadbInsertEvent), eventBytes(mariadbXidEvent))
+	// Each file has its own key, after a rotation of the master key.
+	writeEncryptedBinlogFile(t, dir, "vt-bin.000001", "MySQLReplicationKey_vt_1", keys["MySQLReplicationKey_vt_1"], file1...)
+	writeEncryptedBinlogFile(t, dir, "vt-bin.000002", "MySQLReplicationKey_vt_2", keys["MySQLReplicationKey_vt_2"], file2...)
+
+	var got []LogPosition
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	conn := NewFileConnection(dir, "vt-bin.000001", mysqlctl.NewMariadbBinlogEvent)
+	conn.EncryptionKey = func(keyID string) ([]byte, error) {
+		key, ok := keys[keyID]
+		if !ok {
+			return nil, fmt.Errorf("no key %v", keyID)
+		}
+		return key, nil
+	}
+	defer conn.Close()
+	bls := NewStreamerWithConn("vt_test_keyspace", conn, nil, replication.Position{}, sendTransaction)
+	bls.StatementLogPositions = true
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		got = append(got, md.LogPositions[len(md.LogPositions)-1])
+	}
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	if err := svm.Join(); err == nil || !strings.Contains(err.Error(), ErrServerEOF.Error()) {
+		t.Errorf("wrong error, got %v, want %v", err, ErrServerEOF)
+	}
+
+	// The positions are offsets in the decrypted files, like mysqld
+	// reports them.
+	want := []LogPosition{
+		{File: "vt-bin.000001", Offset: ends1[1]},
+		{File: "vt-bin.000002", Offset: ends2[1]},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions at %v, want %v", got, want)
+	}
+
+	// The files can't be read without the right key.
+	wrongKey := func(keyID string) ([]byte, error) {
+		return testEncryptionKey(3), nil
+	}
+	for _, tc := range []struct {
+		key  func(keyID string) ([]byte, error)
+		want string
+	}{
+		{nil, "it's encrypted with key MySQLReplicationKey_vt_1, but there is no EncryptionKey"},
+		{wrongKey, "wrong key"},
+	} {
+		conn := NewFileConnection(dir, "vt-bin.000001", mysqlctl.NewMariadbBinlogEvent)
+		conn.EncryptionKey = tc.key
+		if _, err := conn.StartBinlogDump(replication.Position{}); err == nil || !strings.HasSuffix(err.Error(), tc.want) {
+			t.Errorf("StartBinlogDump() = %v, want error ending with %q", err, tc.want)
+		}
+		conn.Close()
+	}
+}
+
+func TestFileConnectionMariadbEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_connection_test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	startEncryption := make([]byte, 19+1+4+12)
+	copy(startEncryption, []byte{0x88, 0x41, 0x9, 0x54, startEncryptionEvent, 0x88, 0xf3, 0x0, 0x0})
+	binary.LittleEndian.PutUint32(startEncryption[9:], uint32(len(startEncryption)))
+	startEncryption[19] = 1 // the encryption scheme
+	writeBinlogFile(t, dir, "vt-bin.000001", eventBytes(mariadbFormatEvent), startEncryption, eventBytes(mariadbBeginGTIDEvent))
+
+	// The events end after the artificial ROTATE_EVENT and the
+	// FORMAT_DESCRIPTION_EVENT.
+	conn := NewFileConnection(dir, "vt-bin.000001", mysqlctl.NewMariadbBinlogEvent)
+	defer conn.Close()
+	events, err := conn.StartBinlogDump(replication.Position{})
+	if err != nil {
+		t.Fatalf("StartBinlogDump() failed: %v", err)
+	}
+	var count int
+	for range events {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("got %v events, want 2", count)
+	}
+}
+
+func TestFileConnectionErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_connection_test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, "not-a-binlog"), []byte("some text"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	insert := eventBytes(mariadbInsertEvent)
+	writeBinlogFile(t, dir, "truncated", eventBytes(mariadbFormatEvent), insert[:len(insert)-1])
+
+	for _, name := range []string{"missing", "not-a-binlog"} {
+		conn := NewFileConnection(dir, name, mysqlctl.NewMariadbBinlogEvent)
+		if _, err := conn.StartBinlogDump(replication.Position{}); err == nil {
+			t.Errorf("StartBinlogDump() on %v didn't fail", name)
+		}
+		conn.Close()
+	}
+
+	// A truncated file ends the events after the last full one, which
+	// comes after the artificial ROTATE_EVENT.
+	conn := NewFileConnection(dir, "truncated", mysqlctl.NewMariadbBinlogEvent)
+	events, err := conn.StartBinlogDump(replication.Position{})
+	if err != nil {
+		t.Fatalf("StartBinlogDump() failed: %v", err)
+	}
+	var got int
+	for range events {
+		got++
+	}
+	if got != 2 {
+		t.Errorf("got %v events, want 2", got)
+	}
+	conn.Close()
+}
+
+func TestFileConnectionClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file_connection_test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeBinlogFile(t, dir, "vt-bin.000001", eventBytes(mariadbFormatEvent), eventBytes(mariadbInsertEvent))
+
+	conn := NewFileConnection(dir, "vt-bin.000001", mysqlctl.NewMariadbBinlogEvent)
+	events, err := conn.StartBinlogDump(replication.Position{})
+	if err != nil {
+		t.Fatalf("StartBinlogDump() failed: %v", err)
+	}
+	<-events
+
+	// Close doesn't wait for the other events to be received.
+	conn.Close()
+	if _, ok := <-events; ok {
+		t.Errorf("events channel is still open after Close()")
+	}
+}
+
+func TestArtificialRotateEvent(t *testing.T) {
+	for _, formatEvent := range []replication.BinlogEvent{mariadbFormatEvent, mariadbChecksumFormatEvent} {
+		format, err := formatEvent.Format()
+		if err != nil {
+			t.Fatalf("Format() failed: %v", err)
+		}
+		ev := mysqlctl.NewMariadbBinlogEvent(artificialRotateEvent("vt-bin.000007", format.ChecksumAlgorithm))
+		if !ev.IsValid() || !ev.IsRotate() || ev.NextPosition() != 0 {
+			t.Errorf("checksum algorithm %v: %#v is not an artificial ROTATE_EVENT", format.ChecksumAlgorithm, ev)
+			continue
+		}
+		if got, err := rotateFile(ev, format); err != nil || got != "vt-bin.000007" {
+			t.Errorf("checksum algorithm %v: rotateFile() = (%v, %v), want vt-bin.000007", format.ChecksumAlgorithm, got, err)
+		}
+	}
+}
+
+// TestFileConnectionTestdata streams testdata/vt-bin.000001, a binlog file
+// of MySQL 5.6.24 with CRC32 checksums. Its FORMAT_DESCRIPTION_EVENT,
+// GTID_EVENT and insert are the events mysqld wrote, which are also in
+// mysqlctl/mysql_flavor_mysql56_test.go, moved to consecutive positions.
+// The PREVIOUS_GTIDS_EVENT, BEGIN, XID_EVENT and ROTATE_EVENT around them
+// are written like mysqld writes them.
+func TestFileConnectionTestdata(t *testing.T) {
+	testFileConnectionTestdata(t, NewFileConnection("testdata", "vt-bin.000001", mysqlctl.NewMysql56BinlogEvent))
+}
+
+// testFileConnectionTestdata checks that conn streams the events of
+// testdata/vt-bin.000001.
+func testFileConnectionTestdata(t *testing.T, conn *FileConnection) {
+	var got []*binlogdatapb.BinlogTransaction
+	var gotPositions []LogPosition
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, trans)
+		return nil
+	}
+	defer conn.Close()
+	bls := NewStreamerWithConn("test", conn, nil, replication.Position{}, sendTransaction)
+	bls.StatementLogPositions = true
+	bls.SendMetadata = func(trans *binlogdatapb.BinlogTransaction, md TransactionMetadata) {
+		if md.ChecksumAlgorithm != mysqlctl.BinlogChecksumAlgCRC32 {
+			t.Errorf("got checksum algorithm %v, want CRC32", md.ChecksumAlgorithm)
+		}
+		gotPositions = append(gotPositions, md.LogPositions...)
+	}
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	// The file rotates to vt-bin.000002, which doesn't exist.
+	if err := svm.Join(); err == nil || !strings.Contains(err.Error(), ErrServerEOF.Error()) {
+		t.Errorf("wrong error, got %v, want %v", err, ErrServerEOF)
+	}
+
+	charset := &binlogdatapb.Charset{Client: 8, Conn: 8, Server: 33}
+	want := []*binlogdatapb.BinlogTransaction{{
+		Statements: []*binlogdatapb.BinlogTransaction_Statement{
+			{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Charset: charset, Sql: "SET TIMESTAMP=1430867711"},
+			{Category: binlogdatapb.BinlogTransaction_Statement_BL_DML, Charset: charset, Sql: "insert into test_table (msg) values ('hello')"},
+		},
+		Timestamp: 1430867711,
+		TransactionId: replication.EncodeGTID(replication.Mysql56GTID{
+			Server:   replication.SID{0x43, 0x91, 0x92, 0xbd, 0xf3, 0x7c, 0x11, 0xe4, 0xbb, 0xeb, 0x2, 0x42, 0xac, 0x11, 0x3, 0x5a},
+			Sequence: 4,
+		}),
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+	// Both statements come from the insert, after the BEGIN at 239.
+	wantPositions := []LogPosition{{File: "vt-bin.000001", Offset: 311}, {File: "vt-bin.000001", Offset: 311}}
+	if !reflect.DeepEqual(gotPositions, wantPositions) {
+		t.Errorf("got statements at %v, want %v", gotPositions, wantPositions)
+	}
+}
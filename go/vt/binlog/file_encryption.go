@@ -0,0 +1,127 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"fmt"
+	"io"
+)
+
+// encryptedBinlogFileMagic is the header of the binlog files that mysqld
+// encrypts, with binlog_encryption=ON in MySQL 8.0.14 and later. It's
+// followed by the rest of the encryption header, and then by the encrypted
+// content of the file, which starts with binlogFileMagic.
+var encryptedBinlogFileMagic = []byte{0xfd, 'b', 'i', 'n'}
+
+const (
+	// encryptionHeaderSize is the size of the encryption header, magic
+	// included. The positions of the events don't count it: they are
+	// offsets in the decrypted content.
+	encryptionHeaderSize = 512
+	// encryptionHeaderVersion is the only version of the header.
+	encryptionHeaderVersion = 1
+
+	// The types of the fields of the header.
+	encryptionKeyIDField    = 1
+	encryptionPasswordField = 2
+	encryptionIVField       = 3
+
+	// encryptionPasswordSize is the size of the file password, which is
+	// encrypted with the key named in the header.
+	encryptionPasswordSize = 32
+
+	// startEncryptionEvent is the type of the START_ENCRYPTION_EVENT of
+	// MariaDB, which encrypts the events after it rather than the file.
+	startEncryptionEvent = 164
+)
+
+// encryptionHeader is the header of an encrypted binlog file.
+type encryptionHeader struct {
+	// keyID is the name of the key in the keyring of mysqld that
+	// encrypts the file password, e.g. MySQLReplicationKey_<uuid>_1.
+	keyID             string
+	encryptedPassword []byte
+	iv                []byte
+}
+
+// parseEncryptionHeader parses the encryption header buf of a binlog file.
+func parseEncryptionHeader(buf []byte) (*encryptionHeader, error) {
+	if len(buf) != encryptionHeaderSize || !bytes.Equal(buf[:len(encryptedBinlogFileMagic)], encryptedBinlogFileMagic) {
+		return nil, fmt.Errorf("not an encryption header")
+	}
+	pos := len(encryptedBinlogFileMagic)
+	if version := buf[pos]; version != encryptionHeaderVersion {
+		return nil, fmt.Errorf("unsupported encryption header version %v", version)
+	}
+	pos++
+
+	h := &encryptionHeader{}
+	// The fields are followed by zeros up to the end of the header.
+	for pos < len(buf) && buf[pos] != 0 {
+		field := buf[pos]
+		pos++
+		size := 0
+		switch field {
+		case encryptionKeyIDField:
+			if pos+1 > len(buf) {
+				return nil, fmt.Errorf("key ID field overflows encryption header")
+			}
+			size = int(buf[pos])
+			pos++
+		case encryptionPasswordField:
+			size = encryptionPasswordSize
+		case encryptionIVField:
+			size = aes.BlockSize
+		default:
+			return nil, fmt.Errorf("unknown encryption header field type %v", field)
+		}
+		if pos+size > len(buf) {
+			return nil, fmt.Errorf("field of type %v overflows encryption header (%v + %v > %v)", field, pos, size, len(buf))
+		}
+		value := buf[pos : pos+size]
+		pos += size
+		switch field {
+		case encryptionKeyIDField:
+			h.keyID = string(value)
+		case encryptionPasswordField:
+			h.encryptedPassword = value
+		case encryptionIVField:
+			h.iv = value
+		}
+	}
+	if h.keyID == "" || h.encryptedPassword == nil || h.iv == nil {
+		return nil, fmt.Errorf("encryption header doesn't have a key ID, a password and an IV")
+	}
+	return h, nil
+}
+
+// decrypt returns a reader of the decrypted content of r, the encrypted
+// content of the file after the header. key is the keyring key named
+// h.keyID, which is an AES-256 key.
+func (h *encryptionHeader) decrypt(r io.Reader, key []byte) (io.Reader, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key %v has %v bytes, want 32", h.keyID, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	password := make([]byte, encryptionPasswordSize)
+	cipher.NewCBCDecrypter(block, h.iv).CryptBlocks(password, h.encryptedPassword)
+
+	// mysqld derives the key and the IV of the file from its password with
+	// EVP_BytesToKey(), SHA-512, no salt and one iteration, which is the
+	// SHA-512 of the password. The content is encrypted with AES-256-CTR.
+	sum := sha512.Sum512(password)
+	fileBlock, err := aes.NewCipher(sum[:32])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.StreamReader{S: cipher.NewCTR(fileBlock, sum[32:32+aes.BlockSize]), R: r}, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+)
+
+var (
+	// testEncryptionPassword and testEncryptionIV are the file password
+	// and the IV of the encryption headers of encryptBinlogFile.
+	testEncryptionPassword = []byte("0123456789abcdef0123456789abcdef")
+	testEncryptionIV       = []byte("fedcba9876543210")
+)
+
+// testEncryptionKey returns a 32 bytes key made of b.
+func testEncryptionKey(b byte) []byte {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = b
+	}
+	return key
+}
+
+// encryptionHeaderFields returns an encryption header with the given
+// fields, as mysqld writes it.
+func encryptionHeaderFields(fields ...[]byte) []byte {
+	buf := make([]byte, encryptionHeaderSize)
+	pos := copy(buf, encryptedBinlogFileMagic)
+	buf[pos] = encryptionHeaderVersion
+	pos++
+	for _, field := range fields {
+		pos += copy(buf[pos:], field)
+	}
+	return buf
+}
+
+// encryptBinlogFile encrypts the content of a binlog file like mysqld does
+// with binlog_encryption=ON, with the keyring key named keyID.
+func encryptBinlogFile(content []byte, keyID string, key []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	encryptedPassword := make([]byte, len(testEncryptionPassword))
+	cipher.NewCBCEncrypter(block, testEncryptionIV).CryptBlocks(encryptedPassword, testEncryptionPassword)
+	buf := encryptionHeaderFields(
+		append([]byte{encryptionKeyIDField, byte(len(keyID))}, keyID...),
+		append([]byte{encryptionPasswordField}, encryptedPassword...),
+		append([]byte{encryptionIVField}, testEncryptionIV...),
+	)
+
+	sum := sha512.Sum512(testEncryptionPassword)
+	fileBlock, err := aes.NewCipher(sum[:32])
+	if err != nil {
+		panic(err)
+	}
+	encrypted := make([]byte, len(content))
+	cipher.NewCTR(fileBlock, sum[32:32+aes.BlockSize]).XORKeyStream(encrypted, content)
+	return append(buf, encrypted...)
+}
+
+func TestParseEncryptionHeader(t *testing.T) {
+	buf := encryptBinlogFile(nil, "MySQLReplicationKey_7b0f1c1a-3c3e-11e9-8b3a-0242ac110002_1", testEncryptionKey(1))
+	h, err := parseEncryptionHeader(buf)
+	if err != nil {
+		t.Fatalf("parseEncryptionHeader() failed: %v", err)
+	}
+	if want := "MySQLReplicationKey_7b0f1c1a-3c3e-11e9-8b3a-0242ac110002_1"; h.keyID != want {
+		t.Errorf("keyID = %v, want %v", h.keyID, want)
+	}
+	if string(h.iv) != string(testEncryptionIV) || len(h.encryptedPassword) != encryptionPasswordSize {
+		t.Errorf("parseEncryptionHeader() = %#v, want IV %q and a password of %v bytes", h, testEncryptionIV, encryptionPasswordSize)
+	}
+}
+
+func TestParseEncryptionHeaderErrors(t *testing.T) {
+	version := encryptionHeaderFields()
+	version[len(encryptedBinlogFileMagic)] = 2
+	// The IV fields fill the header, and the last one is truncated.
+	overflow := encryptionHeaderFields()
+	for i := len(encryptedBinlogFileMagic) + 1; i < len(overflow); i++ {
+		overflow[i] = encryptionIVField
+	}
+	testcases := []struct {
+		buf  []byte
+		want string
+	}{
+		{make([]byte, encryptionHeaderSize), "not an encryption header"},
+		{version, "unsupported encryption header version 2"},
+		{encryptionHeaderFields([]byte{9}), "unknown encryption header field type 9"},
+		{overflow, "field of type 3 overflows encryption header"},
+		{encryptionHeaderFields([]byte{encryptionKeyIDField, 3, 'k', 'e', 'y'}), "encryption header doesn't have"},
+	}
+	for _, tc := range testcases {
+		if _, err := parseEncryptionHeader(tc.buf); err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("parseEncryptionHeader() = %v, want error starting with %q", err, tc.want)
+		}
+	}
+}
+
+// testdataEncryptionKey returns the keyring key of
+// testdata/encrypted/vt-bin.000001, the file testdata/vt-bin.000001
+// encrypted like mysqld does with binlog_encryption=ON. It was encrypted
+// with the openssl command, which calls the same OpenSSL functions as
+// mysqld, rather than with encryptBinlogFile:
+//
+//	openssl enc -aes-256-cbc -nopad -K <key> -iv <iv> -in password
+//	openssl enc -aes-256-ctr -md sha512 -nosalt -pass file:password -in vt-bin.000001
+//
+// The first one encrypts the random file password of the header, the
+// second one the content, with the key and the IV EVP_BytesToKey()
+// derives from the password.
+func testdataEncryptionKey(keyID string) ([]byte, error) {
+	if keyID != "MySQLReplicationKey_439192bd-f37c-11e4-bbeb-0242ac11035a_1" {
+		return nil, fmt.Errorf("no key %v", keyID)
+	}
+	return hex.DecodeString("4dceb718601ffe2f61f453b78f5f22a81a452d7f4fac9d9772ed13c03dc7720e")
+}
+
+func TestDecryptTestdata(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/vt-bin.000001")
+	if err != nil {
+		t.Fatalf("can't read binlog file: %v", err)
+	}
+	conn := NewFileConnection("testdata/encrypted", "vt-bin.000001", mysqlctl.NewMysql56BinlogEvent)
+	conn.EncryptionKey = testdataEncryptionKey
+	f, err := conn.openBinlogFile("vt-bin.000001")
+	if err != nil {
+		t.Fatalf("openBinlogFile() failed: %v", err)
+	}
+	defer f.Close()
+	// openBinlogFile() reads the magic of the decrypted content.
+	got, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("can't read decrypted binlog file: %v", err)
+	}
+	if !bytes.Equal(got, want[len(binlogFileMagic):]) {
+		t.Errorf("decrypted binlog file = %x, want %x", got, want[len(binlogFileMagic):])
+	}
+
+	// It has the events of the file it encrypts.
+	testFileConnectionTestdata(t, conn)
+}
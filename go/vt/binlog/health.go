@@ -0,0 +1,78 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"time"
+)
+
+// Health is the state of a stream, as reported by Streamer.Health().
+type Health int
+
+const (
+	// HealthStopped means Stream() isn't running, or hasn't started the
+	// binlog dump yet.
+	HealthStopped Health = iota
+	// HealthActive means the stream received events other than heartbeats
+	// within the last HealthThreshold.
+	HealthActive
+	// HealthIdle means the stream only received heartbeats within the
+	// last HealthThreshold, or nothing since the dump started: mysqld
+	// has nothing to send, but the connection is alive.
+	HealthIdle
+	// HealthStalled means the stream received nothing within the last
+	// HealthThreshold, not even a heartbeat.
+	HealthStalled
+)
+
+// String returns the name of the state.
+func (h Health) String() string {
+	switch h {
+	case HealthStopped:
+		return "Stopped"
+	case HealthActive:
+		return "Active"
+	case HealthIdle:
+		return "Idle"
+	case HealthStalled:
+		return "Stalled"
+	}
+	return "Unknown"
+}
+
+// defaultHealthThreshold is the HealthThreshold when it isn't set.
+const defaultHealthThreshold = 30 * time.Second
+
+// Health returns the state of the stream, from the time since it last
+// received an event, e.g. for a liveness probe. It is safe to call while
+// Stream() is running.
+func (bls *Streamer) Health() Health {
+	bls.mu.Lock()
+	running := bls.running
+	bls.mu.Unlock()
+	eventAt, heartbeatAt := bls.eventAt.Get(), bls.heartbeatAt.Get()
+	if !running || (eventAt == 0 && heartbeatAt == 0) {
+		return HealthStopped
+	}
+
+	threshold := bls.HealthThreshold
+	if threshold == 0 {
+		threshold = defaultHealthThreshold
+	}
+	since := bls.now().Add(-threshold).UnixNano()
+	switch {
+	case eventAt > since:
+		return HealthActive
+	case heartbeatAt > since:
+		return HealthIdle
+	}
+	return HealthStalled
+}
+
+// Healthy returns true if the stream is HealthActive or HealthIdle.
+func (bls *Streamer) Healthy() bool {
+	h := bls.Health()
+	return h == HealthActive || h == HealthIdle
+}
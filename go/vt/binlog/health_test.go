@@ -0,0 +1,142 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// channelBinlogConnection implements BinlogConnection. StartBinlogDump
+// returns events, so the test sends them one by one.
+type channelBinlogConnection struct {
+	events chan replication.BinlogEvent
+}
+
+func (conn *channelBinlogConnection) GetCharset() (*binlogdatapb.Charset, error) {
+	return nil, nil
+}
+
+func (conn *channelBinlogConnection) StartBinlogDump(startPos replication.Position) (<-chan replication.BinlogEvent, error) {
+	return conn.events, nil
+}
+
+func (conn *channelBinlogConnection) Close() {
+}
+
+func TestStreamerHealth(t *testing.T) {
+	conn := &channelBinlogConnection{events: make(chan replication.BinlogEvent)}
+	bls := NewStreamerWithConn("vt_test_keyspace", conn, nil, replication.Position{}, func(*binlogdatapb.BinlogTransaction) error { return nil })
+	bls.HealthThreshold = 10 * time.Second
+	var mu sync.Mutex
+	clock := time.Unix(1407805592, 0)
+	bls.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		clock = clock.Add(d)
+	}
+	// send returns once the Streamer handled ev.
+	handled := make(chan struct{})
+	bls.SendEvent = func(*StreamEvent) error {
+		handled <- struct{}{}
+		return nil
+	}
+	send := func(ev replication.BinlogEvent) {
+		conn.events <- ev
+		<-handled
+	}
+	check := func(desc string, want Health) {
+		if got := bls.Health(); got != want {
+			t.Errorf("%v: Health() = %v, want %v", desc, got, want)
+		}
+		if got, want := bls.Healthy(), want == HealthActive || want == HealthIdle; got != want {
+			t.Errorf("%v: Healthy() = %v, want %v", desc, got, want)
+		}
+	}
+
+	check("before the stream", HealthStopped)
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	conn.events <- rotateEvent{}
+	conn.events <- formatEvent{}
+	send(otherEvent{typ: heartbeatLogEvent})
+	check("after the first events", HealthActive)
+	advance(11 * time.Second)
+	check("after the threshold", HealthStalled)
+	send(otherEvent{typ: heartbeatLogEvent})
+	check("after a heartbeat", HealthIdle)
+	advance(11 * time.Second)
+	check("after the threshold", HealthStalled)
+	send(sequenceEvent(1))
+	check("after a transaction", HealthActive)
+
+	close(conn.events)
+	svm.Join()
+	check("after the stream", HealthStopped)
+}
+
+func TestStreamerHealthSlaveConnection(t *testing.T) {
+	// The heartbeats come from a SlaveConnection, like in production.
+	mysqld := mysqlctl.NewFakeMysqlDaemon(nil)
+	mysqld.BinlogDump = make(chan []byte)
+	bls := NewStreamer("vt_test_keyspace", mysqld, nil, replication.Position{}, func(*binlogdatapb.BinlogTransaction) error { return nil })
+	bls.HealthThreshold = 10 * time.Second
+	var mu sync.Mutex
+	clock := time.Unix(1407805592, 0)
+	bls.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		clock = clock.Add(d)
+	}
+	handled := make(chan struct{})
+	bls.SendEvent = func(*StreamEvent) error {
+		handled <- struct{}{}
+		return nil
+	}
+	send := func(ev replication.BinlogEvent) {
+		mysqld.BinlogDump <- dumpPacket(ev)
+		<-handled
+	}
+
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	mysqld.BinlogDump <- dumpPacket(mariadbRotateEvent)
+	mysqld.BinlogDump <- dumpPacket(mariadbFormatEvent)
+	send(mariadbHeartbeatEvent)
+	if got := bls.Health(); got != HealthActive {
+		t.Errorf("after the first events: Health() = %v, want %v", got, HealthActive)
+	}
+	advance(11 * time.Second)
+	if got := bls.Health(); got != HealthStalled {
+		t.Errorf("after the threshold: Health() = %v, want %v", got, HealthStalled)
+	}
+	send(mariadbHeartbeatEvent)
+	if got := bls.Health(); got != HealthIdle {
+		t.Errorf("after a heartbeat: Health() = %v, want %v", got, HealthIdle)
+	}
+
+	close(mysqld.BinlogDump)
+	svm.Join()
+	if got := bls.Health(); got != HealthStopped {
+		t.Errorf("after the stream: Health() = %v, want %v", got, HealthStopped)
+	}
+}
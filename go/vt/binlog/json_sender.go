@@ -0,0 +1,35 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/golang/protobuf/jsonpb"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// NewJSONSender returns a sendTransaction func for NewStreamer() that
+// writes each BinlogTransaction to w as one line of JSON, using the
+// canonical protobuf JSON mapping. This lets consumers in other languages
+// read the transactions, e.g. from a pipe, without the Go protobuf code.
+//
+// Each transaction is written with a single Write call. If it fails, the
+// stream ends with the error.
+func NewJSONSender(w io.Writer) func(trans *binlogdatapb.BinlogTransaction) error {
+	m := &jsonpb.Marshaler{}
+	return func(trans *binlogdatapb.BinlogTransaction) error {
+		var buf bytes.Buffer
+		if err := m.Marshal(&buf, trans); err != nil {
+			return fmt.Errorf("can't marshal binlog transaction to JSON: %v", err)
+		}
+		buf.WriteByte('\n')
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+}
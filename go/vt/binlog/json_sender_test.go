@@ -0,0 +1,81 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+func TestJSONSender(t *testing.T) {
+	want := []*binlogdatapb.BinlogTransaction{
+		{
+			Statements: []*binlogdatapb.BinlogTransaction_Statement{
+				{Category: binlogdatapb.BinlogTransaction_Statement_BL_SET, Sql: "SET TIMESTAMP=1407805592"},
+				{
+					Category: binlogdatapb.BinlogTransaction_Statement_BL_DML,
+					Charset:  &binlogdatapb.Charset{Client: 33, Conn: 33, Server: 33},
+					Sql:      "insert into vt_a(eid, msg) values (1, 'multi\nline')",
+				},
+			},
+			Timestamp:     1407805592,
+			TransactionId: "MariaDB/0-62344-13",
+		},
+		// An empty transaction.
+		{
+			Timestamp:     1407805593,
+			TransactionId: "MariaDB/0-62344-14",
+		},
+	}
+
+	var buf bytes.Buffer
+	send := NewJSONSender(&buf)
+	for _, trans := range want {
+		if err := send(trans); err != nil {
+			t.Fatalf("send(%v) failed: %v", trans, err)
+		}
+	}
+
+	// Each transaction is on its own line, and decodes back to the same
+	// proto.
+	scanner := bufio.NewScanner(&buf)
+	var lines int
+	for ; scanner.Scan(); lines++ {
+		if lines >= len(want) {
+			t.Fatalf("got more than %v lines: %q", len(want), scanner.Text())
+		}
+		got := &binlogdatapb.BinlogTransaction{}
+		if err := jsonpb.Unmarshal(strings.NewReader(scanner.Text()), got); err != nil {
+			t.Fatalf("can't decode line %v: %v, line: %q", lines, err, scanner.Text())
+		}
+		if !proto.Equal(got, want[lines]) {
+			t.Errorf("line %v decodes to %v, want %v", lines, got, want[lines])
+		}
+	}
+	if lines != len(want) {
+		t.Errorf("got %v lines, want %v", lines, len(want))
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestJSONSenderWriteError(t *testing.T) {
+	send := NewJSONSender(failingWriter{})
+	if err := send(&binlogdatapb.BinlogTransaction{}); err == nil || err.Error() != "broken pipe" {
+		t.Errorf("got error %v, want broken pipe", err)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+)
+
+// The flavors of the positions accepted by ParsePosition.
+const (
+	// FlavorMySQL56 is the GTID set format of MySQL 5.6 and later,
+	// including MySQL 8, e.g.
+	// "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5:7".
+	FlavorMySQL56 = "MySQL56"
+	// FlavorMariaDB is the GTID format of MariaDB, e.g. "0-62344-13".
+	FlavorMariaDB = "MariaDB"
+)
+
+// ParsePosition parses s, a GTID set of the given flavor, into a Position
+// for NewStreamer(). If flavor is empty, s must be in the format returned
+// by FormatPosition(), which starts with the flavor.
+func ParsePosition(flavor, s string) (replication.Position, error) {
+	if flavor == "" {
+		parts := strings.SplitN(s, "/", 2)
+		if len(parts) != 2 {
+			return replication.Position{}, fmt.Errorf("can't parse binlog position %q: no flavor, want <flavor>/<GTID set>", s)
+		}
+		flavor, s = parts[0], parts[1]
+	}
+	if flavor != FlavorMySQL56 && flavor != FlavorMariaDB {
+		return replication.Position{}, fmt.Errorf("can't parse binlog position %q: unknown flavor %q, want %v or %v", s, flavor, FlavorMySQL56, FlavorMariaDB)
+	}
+	pos, err := replication.ParsePosition(flavor, s)
+	if err != nil {
+		return replication.Position{}, fmt.Errorf("can't parse %v binlog position %q: %v", flavor, s, err)
+	}
+	return pos, nil
+}
+
+// FormatPosition returns pos as <flavor>/<GTID set>, which
+// ParsePosition("", ...) parses back. This is the same format as
+// replication.EncodePosition(). The zero Position is formatted as "".
+func FormatPosition(pos replication.Position) string {
+	return replication.EncodePosition(pos)
+}
@@ -0,0 +1,52 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"time"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+)
+
+// PositionSample records when a Streamer sent a transaction.
+// See Streamer.PositionHistorySize.
+type PositionSample struct {
+	// Time is the local clock when the transaction was sent.
+	Time time.Time
+	// Timestamp is the timestamp of the transaction in the binlog.
+	Timestamp uint32
+	// Position is the position of the stream after the transaction.
+	Position replication.Position
+}
+
+// positionHistory is a ring of the last PositionSamples.
+// It is not thread safe.
+type positionHistory struct {
+	next    int
+	samples []PositionSample
+}
+
+func newPositionHistory(capacity int) *positionHistory {
+	return &positionHistory{samples: make([]PositionSample, 0, capacity)}
+}
+
+// add records s, replacing the oldest sample if the ring is full.
+func (h *positionHistory) add(s PositionSample) {
+	if len(h.samples) == cap(h.samples) {
+		h.samples[h.next] = s
+		h.next = (h.next + 1) % cap(h.samples)
+	} else {
+		h.samples = append(h.samples, s)
+	}
+}
+
+// values returns a copy of the samples, oldest first.
+func (h *positionHistory) values() []PositionSample {
+	values := make([]PositionSample, len(h.samples))
+	for i := range h.samples {
+		values[i] = h.samples[(h.next+i)%cap(h.samples)]
+	}
+	return values
+}
@@ -0,0 +1,94 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+func TestPositionHistory(t *testing.T) {
+	h := newPositionHistory(3)
+	if got := h.values(); len(got) != 0 {
+		t.Errorf("values() = %v, want none", got)
+	}
+	var want []PositionSample
+	for i := 1; i <= 5; i++ {
+		s := PositionSample{Timestamp: uint32(i)}
+		h.add(s)
+		want = append(want, s)
+		if len(want) > 3 {
+			want = want[1:]
+		}
+		if got := h.values(); !reflect.DeepEqual(got, want) {
+			t.Errorf("after %v samples: values() = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestStreamerPositionHistory(t *testing.T) {
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+	}
+	for i := uint64(1); i <= 5; i++ {
+		input = append(input, sequenceEvent(i))
+	}
+	// Each transaction is sent a second after the previous one.
+	start := time.Unix(1407805592, 0)
+	clock := start
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		clock = clock.Add(time.Second)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.now = func() time.Time { return clock }
+	bls.PositionHistorySize = 3
+	if got := bls.PositionHistory(); got != nil {
+		t.Errorf("PositionHistory() before streaming = %v, want nil", got)
+	}
+
+	// The history can be read while streaming.
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				bls.PositionHistory()
+			}
+		}
+	}()
+	err := parseTestEvents(bls, input)
+	close(done)
+	if err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	got := bls.PositionHistory()
+	if len(got) != 3 {
+		t.Fatalf("PositionHistory() = %v, want 3 samples", got)
+	}
+	for i, s := range got {
+		wantPos := sequencePosition(uint64(i + 3))
+		wantTime := start.Add(time.Duration(i+3) * time.Second)
+		if !s.Position.Equal(wantPos) || s.Timestamp != 1407805592 || !s.Time.Equal(wantTime) {
+			t.Errorf("PositionHistory()[%v] = %v, want position %v, timestamp 1407805592 and time %v", i, s, wantPos, wantTime)
+		}
+	}
+
+	if err := bls.Reset(replication.Position{}); err != nil {
+		t.Fatalf("Reset() failed: %v", err)
+	}
+	if got := bls.PositionHistory(); got != nil {
+		t.Errorf("PositionHistory() after Reset() = %v, want nil", got)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+)
+
+// PositionStore persists the position of a binlog stream, so a Streamer can
+// resume where a previous one left off. See Streamer.PositionStore.
+type PositionStore interface {
+	// Load returns the saved position, or an empty Position if none was
+	// saved yet.
+	Load() (replication.Position, error)
+	// Save records pos as the position to resume from.
+	Save(pos replication.Position) error
+}
+
+// MemoryPositionStore is a PositionStore that keeps the position in memory.
+// It is meant for tests, and for consumers that persist the position
+// themselves along with other state.
+type MemoryPositionStore struct {
+	mu  sync.Mutex
+	pos replication.Position
+}
+
+// NewMemoryPositionStore returns an empty MemoryPositionStore.
+func NewMemoryPositionStore() *MemoryPositionStore {
+	return &MemoryPositionStore{}
+}
+
+// Load is part of the PositionStore interface.
+func (s *MemoryPositionStore) Load() (replication.Position, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pos, nil
+}
+
+// Save is part of the PositionStore interface.
+func (s *MemoryPositionStore) Save(pos replication.Position) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pos = pos
+	return nil
+}
+
+// FilePositionStore is a PositionStore that keeps the position in a file,
+// encoded with replication.EncodePosition().
+type FilePositionStore struct {
+	path string
+}
+
+// NewFilePositionStore returns a FilePositionStore using the file at path.
+// The file doesn't have to exist yet.
+func NewFilePositionStore(path string) *FilePositionStore {
+	return &FilePositionStore{path: path}
+}
+
+// Load is part of the PositionStore interface.
+func (s *FilePositionStore) Load() (replication.Position, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return replication.Position{}, nil
+		}
+		return replication.Position{}, err
+	}
+	return replication.DecodePosition(strings.TrimSpace(string(data)))
+}
+
+// Save is part of the PositionStore interface. The position is written and
+// synced to a temporary file, which then replaces the file atomically, so a
+// crash never leaves a partially written position.
+func (s *FilePositionStore) Save(pos replication.Position) error {
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(replication.EncodePosition(pos) + "\n"); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
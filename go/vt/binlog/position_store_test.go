@@ -0,0 +1,69 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+)
+
+var testPosition = replication.AppendGTID(replication.Position{}, replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 12})
+
+func TestMemoryPositionStore(t *testing.T) {
+	s := NewMemoryPositionStore()
+	testPositionStore(t, s)
+}
+
+func TestFilePositionStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "position_store_test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "position")
+
+	testPositionStore(t, NewFilePositionStore(filename))
+
+	// The position survives the store.
+	got, err := NewFilePositionStore(filename).Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !got.Equal(testPosition) {
+		t.Errorf("Load() = %v, want %v", got, testPosition)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("bogus/position"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if _, err := NewFilePositionStore(filename).Load(); err == nil {
+		t.Errorf("Load() of a corrupted file didn't fail")
+	}
+}
+
+func testPositionStore(t *testing.T, s PositionStore) {
+	got, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !got.IsZero() {
+		t.Errorf("Load() of an empty store = %v, want empty position", got)
+	}
+
+	if err := s.Save(testPosition); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	got, err = s.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !got.Equal(testPosition) {
+		t.Errorf("Load() = %v, want %v", got, testPosition)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+)
+
+func TestParseFormatPosition(t *testing.T) {
+	testcases := []struct {
+		desc   string
+		flavor string
+		value  string
+	}{{
+		desc:   "MySQL 5.6",
+		flavor: FlavorMySQL56,
+		value:  "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5",
+	}, {
+		desc:   "MySQL 8, with several servers and intervals",
+		flavor: FlavorMySQL56,
+		value:  "00010203-0405-0607-0809-0a0b0c0d0e0f:1-5:7-9:11,4e11fa47-71ca-11e1-9e33-c80aa9429562:1-36289",
+	}, {
+		desc:   "MariaDB",
+		flavor: FlavorMariaDB,
+		value:  "0-62344-13",
+	}}
+
+	for _, tc := range testcases {
+		pos, err := ParsePosition(tc.flavor, tc.value)
+		if err != nil {
+			t.Errorf("%v: ParsePosition(%q, %q) failed: %v", tc.desc, tc.flavor, tc.value, err)
+			continue
+		}
+		if got, want := pos.GTIDSet.Flavor(), tc.flavor; got != want {
+			t.Errorf("%v: got flavor %v, want %v", tc.desc, got, want)
+		}
+		formatted := FormatPosition(pos)
+		if want := tc.flavor + "/" + tc.value; formatted != want {
+			t.Errorf("%v: FormatPosition() = %q, want %q", tc.desc, formatted, want)
+		}
+		again, err := ParsePosition("", formatted)
+		if err != nil {
+			t.Errorf("%v: ParsePosition(\"\", %q) failed: %v", tc.desc, formatted, err)
+			continue
+		}
+		if !again.Equal(pos) {
+			t.Errorf("%v: %q parses to %v, want %v", tc.desc, formatted, again, pos)
+		}
+	}
+}
+
+func TestParsePositionErrors(t *testing.T) {
+	testcases := []struct {
+		flavor, value string
+		want          string
+	}{{
+		flavor: "",
+		value:  "0-62344-13",
+		want:   `can't parse binlog position "0-62344-13": no flavor, want <flavor>/<GTID set>`,
+	}, {
+		flavor: "Oracle",
+		value:  "0-62344-13",
+		want:   `can't parse binlog position "0-62344-13": unknown flavor "Oracle", want MySQL56 or MariaDB`,
+	}, {
+		flavor: "",
+		value:  "MySQL/0-62344-13",
+		want:   `unknown flavor "MySQL"`,
+	}, {
+		flavor: FlavorMariaDB,
+		value:  "0-62344",
+		want:   `can't parse MariaDB binlog position "0-62344": invalid MariaDB GTID`,
+	}, {
+		flavor: FlavorMySQL56,
+		value:  "0-62344-13",
+		want:   `can't parse MySQL56 binlog position "0-62344-13": invalid MySQL 5.6 GTID set`,
+	}}
+
+	for _, tc := range testcases {
+		_, err := ParsePosition(tc.flavor, tc.value)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("ParsePosition(%q, %q) = %v, want error containing %q", tc.flavor, tc.value, err, tc.want)
+		}
+	}
+}
+
+func TestFormatPositionZero(t *testing.T) {
+	if got := FormatPosition(replication.Position{}); got != "" {
+		t.Errorf("FormatPosition(zero) = %q, want empty", got)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package prometheusbinlogstreamer exports the stats of the binlog Streamer
+// as Prometheus metrics. It lives in its own package so binaries that don't
+// use Prometheus don't have to link in its client library.
+package prometheusbinlogstreamer
+
+import (
+	"expvar"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/youtube/vitess/go/stats"
+
+	// The binlog package publishes the stats this Collector reads.
+	_ "github.com/youtube/vitess/go/vt/binlog"
+)
+
+const namespace = "vitess_binlog_streamer"
+
+// Collector implements prometheus.Collector on top of the BinlogStreamer*
+// stats published by the binlog package. Those are the totals of all the
+// Streamers of the process, except the _database_ metrics, which only
+// count the Streamers with DatabaseStats, by database.
+type Collector struct {
+	errors          *prometheus.Desc
+	events          *prometheus.Desc
+	transactions    *prometheus.Desc
+	lag             *prometheus.Desc
+	catchUpRate     *prometheus.Desc
+	openTransaction *prometheus.Desc
+
+	databaseEvents       *prometheus.Desc
+	databaseTransactions *prometheus.Desc
+	databaseStatements   *prometheus.Desc
+}
+
+// NewCollector returns a Collector whose metrics are labeled with the
+// given MySQL flavor.
+func NewCollector(flavor string) *Collector {
+	labels := prometheus.Labels{"flavor": flavor}
+	return &Collector{
+		errors: prometheus.NewDesc(
+			namespace+"_errors_total",
+			"Number of errors encountered by binlog streamers, by category.",
+			[]string{"category"}, labels),
+		events: prometheus.NewDesc(
+			namespace+"_events_total",
+			"Number of binlog events received from mysqld, by event type.",
+			[]string{"type"}, labels),
+		transactions: prometheus.NewDesc(
+			namespace+"_transactions_total",
+			"Number of transactions sent to binlog stream consumers.",
+			nil, labels),
+		lag: prometheus.NewDesc(
+			namespace+"_seconds_behind_master",
+			"Difference between the local clock and the timestamp of the last transaction sent.",
+			nil, labels),
+		catchUpRate: prometheus.NewDesc(
+			namespace+"_catch_up_rate",
+			"Seconds of binlog streamed per second, above 1 when catching up.",
+			nil, labels),
+		openTransaction: prometheus.NewDesc(
+			namespace+"_open_transaction_seconds",
+			"Time since the beginning of the transaction being read, 0 outside of a transaction.",
+			nil, labels),
+		databaseEvents: prometheus.NewDesc(
+			namespace+"_database_events_total",
+			"Number of binlog events received from mysqld, by database of the stream and event type.",
+			[]string{"db", "type"}, labels),
+		databaseTransactions: prometheus.NewDesc(
+			namespace+"_database_transactions_total",
+			"Number of transactions sent to binlog stream consumers, by database of the stream.",
+			[]string{"db"}, labels),
+		databaseStatements: prometheus.NewDesc(
+			namespace+"_database_statements_total",
+			"Number of statements sent to binlog stream consumers, by database of the stream.",
+			[]string{"db"}, labels),
+	}
+}
+
+// Describe is part of the prometheus.Collector interface.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.errors
+	ch <- c.events
+	ch <- c.transactions
+	ch <- c.lag
+	ch <- c.catchUpRate
+	ch <- c.openTransaction
+	ch <- c.databaseEvents
+	ch <- c.databaseTransactions
+	ch <- c.databaseStatements
+}
+
+// Collect is part of the prometheus.Collector interface.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	collectCounters(ch, c.errors, "BinlogStreamerErrors")
+	collectCounters(ch, c.events, "BinlogStreamerEvents")
+	collectInt(ch, c.transactions, prometheus.CounterValue, "BinlogStreamerTransactions")
+	collectInt(ch, c.lag, prometheus.GaugeValue, "BinlogStreamerSecondsBehindMaster")
+	collectFloat(ch, c.catchUpRate, prometheus.GaugeValue, "BinlogStreamerCatchUpRate")
+	collectInt(ch, c.openTransaction, prometheus.GaugeValue, "BinlogStreamerOpenTransactionSeconds")
+	collectDatabaseEvents(ch, c.databaseEvents)
+	collectCounters(ch, c.databaseTransactions, "BinlogStreamerDatabaseTransactions")
+	collectCounters(ch, c.databaseStatements, "BinlogStreamerDatabaseStatements")
+}
+
+// Register creates a Collector for the given flavor, and registers it with
+// registerer. Passing prometheus.DefaultRegisterer exports the metrics on
+// the default /metrics handler.
+func Register(registerer prometheus.Registerer, flavor string) (*Collector, error) {
+	c := NewCollector(flavor)
+	if err := registerer.Register(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func collectCounters(ch chan<- prometheus.Metric, desc *prometheus.Desc, name string) {
+	counters, ok := expvar.Get(name).(*stats.Counters)
+	if !ok {
+		return
+	}
+	for label, value := range counters.Counts() {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), label)
+	}
+}
+
+// collectDatabaseEvents collects BinlogStreamerDatabaseEvents, whose
+// counters are named "<database>.<type>". The event types have no dots,
+// unlike some database names.
+func collectDatabaseEvents(ch chan<- prometheus.Metric, desc *prometheus.Desc) {
+	counters, ok := expvar.Get("BinlogStreamerDatabaseEvents").(*stats.MultiCounters)
+	if !ok {
+		return
+	}
+	for name, value := range counters.Counts() {
+		i := strings.LastIndex(name, ".")
+		if i < 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), name[:i], name[i+1:])
+	}
+}
+
+func collectInt(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, name string) {
+	v, ok := expvar.Get(name).(*stats.Int)
+	if !ok {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(desc, valueType, float64(v.Get()))
+}
+
+func collectFloat(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, name string) {
+	v, ok := expvar.Get(name).(*stats.Float)
+	if !ok {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(desc, valueType, v.Get())
+}
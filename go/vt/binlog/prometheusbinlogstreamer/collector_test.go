@@ -0,0 +1,83 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package prometheusbinlogstreamer
+
+import (
+	"expvar"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/youtube/vitess/go/stats"
+)
+
+func TestCollector(t *testing.T) {
+	expvar.Get("BinlogStreamerErrors").(*stats.Counters).Add("ParseEvents", 2)
+	expvar.Get("BinlogStreamerEvents").(*stats.Counters).Add("Query", 3)
+	expvar.Get("BinlogStreamerTransactions").(*stats.Int).Add(1)
+	expvar.Get("BinlogStreamerDatabaseEvents").(*stats.MultiCounters).Add([]string{"vt_test.keyspace", "Query"}, 3)
+	expvar.Get("BinlogStreamerDatabaseTransactions").(*stats.Counters).Add("vt_test.keyspace", 1)
+	expvar.Get("BinlogStreamerDatabaseStatements").(*stats.Counters).Add("vt_test.keyspace", 2)
+
+	registry := prometheus.NewRegistry()
+	if _, err := Register(registry, "MariaDB"); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+
+	want := map[string]float64{
+		"vitess_binlog_streamer_errors_total":                2,
+		"vitess_binlog_streamer_events_total":                3,
+		"vitess_binlog_streamer_transactions_total":          1,
+		"vitess_binlog_streamer_seconds_behind_master":       0,
+		"vitess_binlog_streamer_catch_up_rate":               0,
+		"vitess_binlog_streamer_open_transaction_seconds":    0,
+		"vitess_binlog_streamer_database_events_total":       3,
+		"vitess_binlog_streamer_database_transactions_total": 1,
+		"vitess_binlog_streamer_database_statements_total":   2,
+	}
+	// The process-wide metrics have no database.
+	wantDB := map[string]string{
+		"vitess_binlog_streamer_database_events_total":       "vt_test.keyspace",
+		"vitess_binlog_streamer_database_transactions_total": "vt_test.keyspace",
+		"vitess_binlog_streamer_database_statements_total":   "vt_test.keyspace",
+	}
+	for _, family := range families {
+		wantValue, ok := want[family.GetName()]
+		if !ok {
+			t.Errorf("unexpected metric: %v", family.GetName())
+			continue
+		}
+		delete(want, family.GetName())
+
+		metrics := family.GetMetric()
+		if len(metrics) != 1 {
+			t.Errorf("%v: got %v metrics, want 1", family.GetName(), len(metrics))
+			continue
+		}
+		labels := make(map[string]string)
+		for _, label := range metrics[0].GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["db"] != wantDB[family.GetName()] || labels["flavor"] != "MariaDB" {
+			t.Errorf("%v: wrong labels: %v", family.GetName(), labels)
+		}
+		if family.GetName() == "vitess_binlog_streamer_database_events_total" && labels["type"] != "Query" {
+			t.Errorf("%v: wrong labels: %v", family.GetName(), labels)
+		}
+		value := metrics[0].GetCounter().GetValue()
+		if metrics[0].GetCounter() == nil {
+			value = metrics[0].GetGauge().GetValue()
+		}
+		if value != wantValue {
+			t.Errorf("%v = %v, want %v", family.GetName(), value, wantValue)
+		}
+	}
+	for name := range want {
+		t.Errorf("missing metric: %v", name)
+	}
+}
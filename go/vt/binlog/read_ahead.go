@@ -0,0 +1,40 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+)
+
+// readAhead returns a channel that receives the events of events, read
+// ahead of the consumer into a buffer of size events. Once the buffer is
+// full, readAhead stops reading from events until the consumer catches up,
+// so the connection is never read further than that. The returned channel
+// is closed after events is, or when done is closed, which the consumer
+// must do when it stops reading.
+func readAhead(events <-chan replication.BinlogEvent, size int, done <-chan struct{}) <-chan replication.BinlogEvent {
+	buffered := make(chan replication.BinlogEvent, size)
+	go func() {
+		defer close(buffered)
+		for {
+			var ev replication.BinlogEvent
+			var ok bool
+			select {
+			case ev, ok = <-events:
+				if !ok {
+					return
+				}
+			case <-done:
+				return
+			}
+			select {
+			case buffered <- ev:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return buffered
+}
@@ -0,0 +1,147 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+func TestReadAhead(t *testing.T) {
+	events := make(chan replication.BinlogEvent)
+	var sent sync2.AtomicInt64
+	go func() {
+		for i := uint64(1); i <= 10; i++ {
+			events <- sequenceEvent(i)
+			sent.Add(1)
+		}
+		close(events)
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	buffered := readAhead(events, 3, done)
+
+	// Without a consumer, the buffer fills up, and one more event waits
+	// to be added to it.
+	deadline := time.Now().Add(5 * time.Second)
+	for sent.Get() < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if got := sent.Get(); got != 4 {
+		t.Errorf("read %v events ahead, want 4", got)
+	}
+
+	// All the events come out in order, then the channel is closed.
+	var seq uint64
+	for ev := range buffered {
+		seq++
+		if gtid, _ := ev.GTID(replication.BinlogFormat{}); gtid.(replication.MariadbGTID).Sequence != seq {
+			t.Errorf("got event %v, want sequence %v", gtid, seq)
+		}
+	}
+	if seq != 10 {
+		t.Errorf("got %v events, want 10", seq)
+	}
+}
+
+func TestReadAheadDone(t *testing.T) {
+	events := make(chan replication.BinlogEvent)
+	go func() {
+		for {
+			events <- sequenceEvent(1)
+		}
+	}()
+	done := make(chan struct{})
+	buffered := readAhead(events, 2, done)
+	<-buffered
+	close(done)
+
+	// The channel is closed once the buffered events are drained.
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-buffered:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("channel not closed after done")
+		}
+	}
+}
+
+// latencyConnection is a BinlogConnection that sends n autocommit
+// statements, and stalls for latency before every burst of them.
+type latencyConnection struct {
+	n       int
+	burst   int
+	latency time.Duration
+}
+
+func (conn *latencyConnection) GetCharset() (*binlogdatapb.Charset, error) {
+	return charset, nil
+}
+
+func (conn *latencyConnection) StartBinlogDump(startPos replication.Position) (<-chan replication.BinlogEvent, error) {
+	events := make(chan replication.BinlogEvent)
+	go func() {
+		events <- rotateEvent{}
+		events <- formatEvent{}
+		ev := sequenceEvent(1)
+		for i := 0; i < conn.n; i++ {
+			if i%conn.burst == 0 {
+				time.Sleep(conn.latency)
+			}
+			events <- ev
+		}
+		close(events)
+	}()
+	return events, nil
+}
+
+func (conn *latencyConnection) Close() {}
+
+// benchmarkStreamerReadAhead streams b.N statements from a connection that
+// stalls before every 100 events, to a consumer that stalls as long after
+// every 100 transactions. Read-ahead lets the two overlap.
+func benchmarkStreamerReadAhead(b *testing.B, readAhead int) {
+	const burst = 100
+	const latency = time.Millisecond
+	conn := &latencyConnection{n: b.N, burst: burst, latency: latency}
+	var sent int
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		if sent++; sent%burst == burst/2 {
+			time.Sleep(latency)
+		}
+		return nil
+	}
+	bls := NewStreamerWithConn("vt_test_keyspace", conn, charset, replication.Position{}, sendTransaction)
+	bls.ReadAhead = readAhead
+
+	b.ResetTimer()
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	if err := svm.Join(); err == nil {
+		b.Fatalf("Stream() = nil, want the end of the events")
+	}
+	b.StopTimer()
+	if sent != b.N {
+		b.Fatalf("sent %v transactions, want %v", sent, b.N)
+	}
+}
+
+func BenchmarkStreamerNoReadAhead(b *testing.B) {
+	benchmarkStreamerReadAhead(b, 0)
+}
+
+func BenchmarkStreamerReadAhead1000(b *testing.B) {
+	benchmarkStreamerReadAhead(b, 1000)
+}
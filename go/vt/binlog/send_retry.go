@@ -0,0 +1,49 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"io"
+	"math/rand"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/sync2"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// defaultSendRetryDelay is the first delay of the send retries when
+// Streamer.SendRetryDelay isn't set.
+const defaultSendRetryDelay = 100 * time.Millisecond
+
+// retrySend returns a sendTransactionFunc that calls send with a
+// transaction until it succeeds, until it returns io.EOF, or until it
+// failed bls.SendRetries more times, and returns its last error. The delays
+// are picked like the ones of retrySetup(). It stops waiting if ctx is
+// shutting down.
+func (bls *Streamer) retrySend(ctx *sync2.ServiceContext, send sendTransactionFunc) sendTransactionFunc {
+	return func(trans *binlogdatapb.BinlogTransaction) error {
+		delay := bls.SendRetryDelay
+		if delay == 0 {
+			delay = defaultSendRetryDelay
+		}
+		for attempt := 1; ; attempt++ {
+			err := send(trans)
+			if err == nil || err == io.EOF || attempt > bls.SendRetries {
+				return err
+			}
+			wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+			log.Warningf("sending binlog transaction %v failed (attempt %v of %v), trying again in %v: %v", trans.TransactionId, attempt, bls.SendRetries+1, wait, err)
+			binlogStreamerSendRetries.Add(1)
+			select {
+			case <-ctx.ShuttingDown:
+				return err
+			case <-time.After(wait):
+			}
+			delay *= 2
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/sqldb"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+)
+
+// Steps of Streamer.Stream() that can fail before any event is read.
+const (
+	SetupStepCheckDatabase   = "check database"
+	SetupStepConnect         = "connect"
+	SetupStepCheckCharset    = "check charset"
+	SetupStepStartBinlogDump = "start binlog dump"
+)
+
+// SetupError is returned by Streamer.Stream() when the stream couldn't be
+// started. Use IsRetryable() to find out if trying again may succeed.
+type SetupError struct {
+	// Position is where the stream was supposed to start.
+	Position replication.Position
+	// Step is the SetupStep* constant of the step that failed.
+	Step string
+	// Err is the error returned by that step.
+	Err error
+
+	retryable bool
+}
+
+// Error implements the error interface.
+func (e *SetupError) Error() string {
+	return fmt.Sprintf("stream error @ %v: %v: %v", e.Position, e.Step, e.Err)
+}
+
+// IsRetryable returns true if err is a SetupError for a failure that
+// may go away by itself, such as the server being unreachable or out of
+// connections. It returns false for permanent failures, such as bad
+// credentials or a start position that was purged from the binlogs, and
+// for errors that aren't SetupErrors.
+func IsRetryable(err error) bool {
+	se, ok := err.(*SetupError)
+	return ok && se.retryable
+}
+
+// newSetupError classifies err by its MySQL error code, if it has one.
+// Otherwise, retryable tells what to assume for the step that failed.
+func newSetupError(step string, err error, retryable bool) *SetupError {
+	if num, ok := sqlErrorNumber(err); ok {
+		retryable = retryableErrors[num]
+	}
+	return &SetupError{Step: step, Err: err, retryable: retryable}
+}
+
+// defaultSetupRetryDelay is the default of Streamer.SetupRetryDelay.
+const defaultSetupRetryDelay = 100 * time.Millisecond
+
+// retrySetup runs step until it succeeds, until it fails with an error
+// that isn't retryable, or until it failed bls.SetupRetries more times,
+// and returns its last error. Each delay is picked at random between half
+// and all of the current one, which doubles after each attempt. It stops
+// waiting if ctx is shutting down.
+func (bls *Streamer) retrySetup(ctx *sync2.ServiceContext, step func() *SetupError) *SetupError {
+	delay := bls.SetupRetryDelay
+	if delay == 0 {
+		delay = defaultSetupRetryDelay
+	}
+	for attempt := 1; ; attempt++ {
+		se := step()
+		if se == nil || !se.retryable || attempt > bls.SetupRetries {
+			return se
+		}
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		log.Warningf("binlog stream setup failed to %v (attempt %v of %v), trying again in %v: %v", se.Step, attempt, bls.SetupRetries+1, wait, se.Err)
+		binlogStreamerSetupRetries.Add(se.Step, 1)
+		select {
+		case <-ctx.ShuttingDown:
+			return se
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// retryableErrors lists the MySQL error codes that a later attempt may
+// not get. All other codes are considered permanent.
+var retryableErrors = map[int]bool{
+	1040: true, // ER_CON_COUNT_ERROR
+	1053: true, // ER_SERVER_SHUTDOWN
+	1158: true, // ER_NET_READ_ERROR
+	1159: true, // ER_NET_READ_INTERRUPTED
+	1160: true, // ER_NET_ERROR_ON_WRITE
+	1161: true, // ER_NET_WRITE_INTERRUPTED
+	1203: true, // ER_TOO_MANY_USER_CONNECTIONS
+	2002: true, // CR_CONNECTION_ERROR
+	2003: true, // CR_CONN_HOST_ERROR
+	2006: true, // CR_SERVER_GONE_ERROR
+	2013: true, // CR_SERVER_LOST
+	2055: true, // CR_SERVER_LOST_EXTENDED
+}
+
+var errnoExtract = regexp.MustCompile(`\(errno ([0-9]+)\)`)
+
+// sqlErrorNumber returns the MySQL error code of err. Errors that were
+// wrapped with fmt.Errorf() only keep the code in their message.
+func sqlErrorNumber(err error) (int, bool) {
+	if sqlErr, ok := err.(*sqldb.SQLError); ok {
+		return sqlErr.Number(), true
+	}
+	match := errnoExtract.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0, false
+	}
+	num, convErr := strconv.Atoi(match[1])
+	if convErr != nil {
+		return 0, false
+	}
+	return num, true
+}
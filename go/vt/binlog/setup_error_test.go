@@ -0,0 +1,243 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/sqldb"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+func TestIsRetryable(t *testing.T) {
+	testcases := []struct {
+		desc string
+		err  error
+		want bool
+	}{
+		{"too many connections", sqldb.NewSQLError(1040, "08004", "Too many connections"), true},
+		{"server shutdown", sqldb.NewSQLError(1053, "08S01", "Server shutdown in progress"), true},
+		{"user connection limit", sqldb.NewSQLError(1203, "42000", "User has more than 'max_user_connections' active connections"), true},
+		{"can't connect", sqldb.NewSQLError(2003, "HY000", "Can't connect to MySQL server"), true},
+		{"server gone", sqldb.NewSQLError(2006, "HY000", "MySQL server has gone away"), true},
+		{"server lost", sqldb.NewSQLError(2013, "HY000", "Lost connection to MySQL server during query"), true},
+		{"access denied", sqldb.NewSQLError(1045, "28000", "Access denied for user 'vt_dba'@'localhost'"), false},
+		{"missing privilege", sqldb.NewSQLError(1227, "42000", "Access denied; you need the REPLICATION SLAVE privilege"), false},
+		{"position purged", sqldb.NewSQLError(1236, "HY000", "Could not find first log file name in binary log index file"), false},
+		{"auth plugin", sqldb.NewSQLError(2059, "HY000", "Authentication plugin 'caching_sha2_password' cannot be loaded"), false},
+		{"wrapped code", fmt.Errorf("can't get charset: %v", sqldb.NewSQLError(2013, "HY000", "Lost connection")), true},
+	}
+	for _, tc := range testcases {
+		// The code takes precedence over the default of the step.
+		for _, retryable := range []bool{false, true} {
+			if got := IsRetryable(newSetupError(SetupStepConnect, tc.err, retryable)); got != tc.want {
+				t.Errorf("%v: IsRetryable() with step default %v = %v, want %v", tc.desc, retryable, got, tc.want)
+			}
+		}
+	}
+
+	// Errors without a code use the default of the step.
+	err := errors.New("charset mismatch")
+	if !IsRetryable(newSetupError(SetupStepCheckCharset, err, true)) {
+		t.Errorf("IsRetryable() = false for a retryable step")
+	}
+	if IsRetryable(newSetupError(SetupStepCheckCharset, err, false)) {
+		t.Errorf("IsRetryable() = true for a permanent step")
+	}
+
+	// Only SetupErrors are retryable.
+	if IsRetryable(sqldb.NewSQLError(2013, "HY000", "Lost connection")) {
+		t.Errorf("IsRetryable() = true for an error that isn't a SetupError")
+	}
+}
+
+func TestStreamerSetupError(t *testing.T) {
+	mysqld := mysqlctl.NewFakeMysqlDaemon(nil)
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	startPos := replication.AppendGTID(replication.Position{}, replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 10})
+	bls := NewStreamer("vt_test_keyspace", mysqld, nil, startPos, sendTransaction)
+	// FakeMysqlDaemon can only fail NewSlaveConnectionWithOptions().
+	bls.ReadTimeout = time.Second
+
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	err := svm.Join()
+	se, ok := err.(*SetupError)
+	if !ok {
+		t.Fatalf("Stream() = %#v, want a SetupError", err)
+	}
+	if se.Step != SetupStepConnect || !se.Position.Equal(startPos) {
+		t.Errorf("got SetupError for step %q @ %v, want step %q @ %v", se.Step, se.Position, SetupStepConnect, startPos)
+	}
+	if IsRetryable(err) {
+		t.Errorf("IsRetryable(%v) = true, want false", err)
+	}
+}
+
+func TestStreamerRequireDatabase(t *testing.T) {
+	const query = "SELECT 1 FROM information_schema.schemata WHERE schema_name = 'vt_test_keyspace'"
+	exists := &sqltypes.Result{Rows: [][]sqltypes.Value{{sqltypes.MakeString([]byte("1"))}}}
+	missing := &sqltypes.Result{}
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+
+	testcases := []struct {
+		desc            string
+		result          *sqltypes.Result
+		requireDatabase bool
+		wantStep        string
+		wantRetryable   bool
+		wantMissing     int64
+	}{{
+		desc:     "database exists",
+		result:   exists,
+		wantStep: SetupStepConnect,
+	}, {
+		desc:            "database exists, required",
+		result:          exists,
+		requireDatabase: true,
+		wantStep:        SetupStepConnect,
+	}, {
+		desc:        "database missing",
+		result:      missing,
+		wantStep:    SetupStepConnect,
+		wantMissing: 1,
+	}, {
+		desc:            "database missing, required",
+		result:          missing,
+		requireDatabase: true,
+		wantStep:        SetupStepCheckDatabase,
+		wantMissing:     1,
+	}, {
+		desc:     "query fails",
+		wantStep: SetupStepConnect,
+	}, {
+		desc:            "query fails, required",
+		requireDatabase: true,
+		wantStep:        SetupStepCheckDatabase,
+		wantRetryable:   true,
+	}}
+
+	for _, tc := range testcases {
+		mysqld := mysqlctl.NewFakeMysqlDaemon(nil)
+		if tc.result != nil {
+			mysqld.FetchSuperQueryMap = map[string]*sqltypes.Result{query: tc.result}
+		}
+		bls := NewStreamer("vt_test_keyspace", mysqld, nil, replication.Position{}, sendTransaction)
+		bls.RequireDatabase = tc.requireDatabase
+		// FakeMysqlDaemon can only fail NewSlaveConnectionWithOptions().
+		bls.ReadTimeout = time.Second
+
+		before := binlogStreamerMissingDatabases.Get()
+		svm := &sync2.ServiceManager{}
+		svm.Go(bls.Stream)
+		err := svm.Join()
+		se, ok := err.(*SetupError)
+		if !ok {
+			t.Errorf("%v: Stream() = %#v, want a SetupError", tc.desc, err)
+			continue
+		}
+		if se.Step != tc.wantStep {
+			t.Errorf("%v: got SetupError for step %q, want %q: %v", tc.desc, se.Step, tc.wantStep, err)
+		}
+		if tc.wantStep == SetupStepCheckDatabase && IsRetryable(err) != tc.wantRetryable {
+			t.Errorf("%v: IsRetryable(%v) = %v, want %v", tc.desc, err, !tc.wantRetryable, tc.wantRetryable)
+		}
+		if got := binlogStreamerMissingDatabases.Get() - before; got != tc.wantMissing {
+			t.Errorf("%v: BinlogStreamerMissingDatabases went up by %v, want %v", tc.desc, got, tc.wantMissing)
+		}
+	}
+}
+
+// flakyCharsetConnection is a fakeBinlogConnection whose GetCharset fails
+// with err for its first failures calls.
+type flakyCharsetConnection struct {
+	fakeBinlogConnection
+	failures int
+	err      error
+	calls    int
+}
+
+func (conn *flakyCharsetConnection) GetCharset() (*binlogdatapb.Charset, error) {
+	conn.calls++
+	if conn.calls <= conn.failures {
+		return nil, conn.err
+	}
+	return conn.fakeBinlogConnection.GetCharset()
+}
+
+func TestStreamerSetupRetries(t *testing.T) {
+	testcases := []struct {
+		desc      string
+		failures  int
+		err       error
+		wantCalls int
+		wantStep  string
+	}{{
+		desc:      "transient failures",
+		failures:  2,
+		err:       sqldb.NewSQLError(2013, "HY000", "Lost connection to MySQL server during query"),
+		wantCalls: 3,
+	}, {
+		desc:      "too many transient failures",
+		failures:  5,
+		err:       sqldb.NewSQLError(1040, "08004", "Too many connections"),
+		wantCalls: 4,
+		wantStep:  SetupStepCheckCharset,
+	}, {
+		desc:      "permanent failure",
+		failures:  1,
+		err:       sqldb.NewSQLError(1045, "28000", "Access denied for user 'vt_dba'@'localhost'"),
+		wantCalls: 1,
+		wantStep:  SetupStepCheckCharset,
+	}}
+
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	for _, tc := range testcases {
+		conn := &flakyCharsetConnection{
+			fakeBinlogConnection: fakeBinlogConnection{charset: charset},
+			failures:             tc.failures,
+			err:                  tc.err,
+		}
+		bls := NewStreamerWithConn("vt_test_keyspace", conn, charset, replication.Position{}, sendTransaction)
+		bls.SetupRetries = 3
+		bls.SetupRetryDelay = time.Millisecond
+
+		before := binlogStreamerSetupRetries.Counts()[SetupStepCheckCharset]
+		svm := &sync2.ServiceManager{}
+		svm.Go(bls.Stream)
+		err := svm.Join()
+		if conn.calls != tc.wantCalls {
+			t.Errorf("%v: GetCharset() called %v times, want %v", tc.desc, conn.calls, tc.wantCalls)
+		}
+		if got, want := binlogStreamerSetupRetries.Counts()[SetupStepCheckCharset]-before, int64(tc.wantCalls-1); got != want {
+			t.Errorf("%v: BinlogStreamerSetupRetries went up by %v, want %v", tc.desc, got, want)
+		}
+		se, ok := err.(*SetupError)
+		if tc.wantStep == "" {
+			// The stream started, and ended with the events.
+			if ok {
+				t.Errorf("%v: Stream() = %v, want the end of the events", tc.desc, err)
+			}
+			continue
+		}
+		if !ok || se.Step != tc.wantStep {
+			t.Errorf("%v: Stream() = %#v, want a SetupError for step %q", tc.desc, err, tc.wantStep)
+		}
+	}
+}
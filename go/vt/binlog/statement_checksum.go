@@ -0,0 +1,32 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// StatementChecksum returns the CRC32 (IEEE) of a statement, as reported
+// in TransactionMetadata.Checksums. It covers the category, the charset
+// and the SQL of the statement, so consumers can call it on the statements
+// they received to check nothing was corrupted on the way.
+//
+// The checksummed data is the category and the client, conn and server
+// charset numbers (0 without a charset), each as 4 little-endian bytes,
+// followed by the SQL.
+func StatementChecksum(st *binlogdatapb.BinlogTransaction_Statement) uint32 {
+	var header [16]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(st.Category))
+	if st.Charset != nil {
+		binary.LittleEndian.PutUint32(header[4:8], uint32(st.Charset.Client))
+		binary.LittleEndian.PutUint32(header[8:12], uint32(st.Charset.Conn))
+		binary.LittleEndian.PutUint32(header[12:16], uint32(st.Charset.Server))
+	}
+	crc := crc32.Update(0, crc32.IEEETable, header[:])
+	return crc32.Update(crc, crc32.IEEETable, []byte(st.Sql))
+}
@@ -0,0 +1,53 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"hash/crc32"
+	"testing"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+func TestStatementChecksum(t *testing.T) {
+	st := &binlogdatapb.BinlogTransaction_Statement{
+		Category: binlogdatapb.BinlogTransaction_Statement_BL_DML,
+		Charset:  &binlogdatapb.Charset{Client: 33, Conn: 8, Server: 63},
+		Sql:      "insert into vt_a(eid, id) values (1, 1)",
+	}
+	data := append([]byte{4, 0, 0, 0, 33, 0, 0, 0, 8, 0, 0, 0, 63, 0, 0, 0}, st.Sql...)
+	want := crc32.ChecksumIEEE(data)
+	if got := StatementChecksum(st); got != want {
+		t.Errorf("StatementChecksum() = %#x, want %#x", got, want)
+	}
+
+	// Any change to the statement changes the checksum.
+	mutations := map[string]*binlogdatapb.BinlogTransaction_Statement{
+		"sql": {
+			Category: st.Category,
+			Charset:  st.Charset,
+			Sql:      "insert into vt_a(eid, id) values (1, 2)",
+		},
+		"category": {
+			Category: binlogdatapb.BinlogTransaction_Statement_BL_DDL,
+			Charset:  st.Charset,
+			Sql:      st.Sql,
+		},
+		"charset": {
+			Category: st.Category,
+			Charset:  &binlogdatapb.Charset{Client: 33, Conn: 33, Server: 63},
+			Sql:      st.Sql,
+		},
+		"no charset": {
+			Category: st.Category,
+			Sql:      st.Sql,
+		},
+	}
+	for desc, mutated := range mutations {
+		if got := StatementChecksum(mutated); got == want {
+			t.Errorf("%v: StatementChecksum() didn't change", desc)
+		}
+	}
+}
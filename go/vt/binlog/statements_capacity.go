@@ -0,0 +1,42 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+const (
+	// minStatementsCapacity is the smallest capacity begin() allocates for
+	// the statements of a transaction.
+	minStatementsCapacity = 10
+	// maxStatementsCapacity caps it, so a few huge transactions don't make
+	// every following one allocate too much.
+	maxStatementsCapacity = 1000
+)
+
+// statementsCapacity estimates how many statements the next transaction
+// will have, with a moving average of the sizes of the previous ones.
+type statementsCapacity struct {
+	average float64
+}
+
+// record adds the number of statements of a committed transaction to the
+// average. Each new size has a weight of 1/8.
+func (sc *statementsCapacity) record(n int) {
+	if sc.average == 0 {
+		sc.average = float64(n)
+		return
+	}
+	sc.average += (float64(n) - sc.average) / 8
+}
+
+// get returns the capacity to allocate for the next transaction.
+func (sc *statementsCapacity) get() int {
+	c := int(sc.average + 0.5)
+	if c < minStatementsCapacity {
+		return minStatementsCapacity
+	}
+	if c > maxStatementsCapacity {
+		return maxStatementsCapacity
+	}
+	return c
+}
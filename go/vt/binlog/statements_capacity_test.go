@@ -0,0 +1,76 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"testing"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+func TestStatementsCapacity(t *testing.T) {
+	var sc statementsCapacity
+	if got, want := sc.get(), minStatementsCapacity; got != want {
+		t.Errorf("initial capacity = %v, want %v", got, want)
+	}
+
+	// Small transactions stay at the floor.
+	for i := 0; i < 100; i++ {
+		sc.record(2)
+	}
+	if got, want := sc.get(), minStatementsCapacity; got != want {
+		t.Errorf("capacity after small transactions = %v, want %v", got, want)
+	}
+
+	// Large transactions move the estimate towards their size.
+	for i := 0; i < 100; i++ {
+		sc.record(200)
+	}
+	if got, want := sc.get(), 200; got != want {
+		t.Errorf("capacity after large transactions = %v, want %v", got, want)
+	}
+	sc.record(10)
+	if got := sc.get(); got <= 10 || got >= 200 {
+		t.Errorf("capacity after one small transaction = %v, want between 10 and 200", got)
+	}
+
+	// Huge transactions are capped.
+	for i := 0; i < 100; i++ {
+		sc.record(100000)
+	}
+	if got, want := sc.get(), maxStatementsCapacity; got != want {
+		t.Errorf("capacity after huge transactions = %v, want %v", got, want)
+	}
+}
+
+// benchmarkStatementsAllocation replays a trace of transactions with 400
+// statements each (SET TIMESTAMP and DML pairs), allocating the statements
+// slice the way begin() does.
+func benchmarkStatementsAllocation(b *testing.B, adaptive bool) {
+	const statementsPerTransaction = 400
+	statement := &binlogdatapb.BinlogTransaction_Statement{}
+	var sc statementsCapacity
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		capacity := 10
+		if adaptive {
+			capacity = sc.get()
+		}
+		statements := make([]*binlogdatapb.BinlogTransaction_Statement, 0, capacity)
+		for j := 0; j < statementsPerTransaction; j++ {
+			statements = append(statements, statement)
+		}
+		sc.record(len(statements))
+	}
+}
+
+func BenchmarkStatementsAllocationFixed(b *testing.B) {
+	benchmarkStatementsAllocation(b, false)
+}
+
+func BenchmarkStatementsAllocationAdaptive(b *testing.B) {
+	benchmarkStatementsAllocation(b, true)
+}
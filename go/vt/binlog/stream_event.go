@@ -0,0 +1,94 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"fmt"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+)
+
+// StreamEvent is a single decoded binlog event, as sent to
+// Streamer.SendEvent.
+type StreamEvent struct {
+	// Type is the event type, as named in the BinlogStreamerEvents stats
+	// (e.g. "Query", "XID").
+	Type string
+	// Position is the stream position after this event.
+	Position replication.Position
+	// Timestamp is the timestamp from the event header.
+	Timestamp uint32
+	// GTID is the GTID carried by the event, if any.
+	GTID replication.GTID
+
+	// BeginGTID is true for a GTID_EVENT that also starts a transaction.
+	BeginGTID bool
+	// LastCommitted and SequenceNumber are the logical clock of a
+	// GTID_EVENT, if it has one. See BinlogEvent.LogicalClock().
+	LastCommitted  int64
+	SequenceNumber int64
+	// OriginalCommitTimestamp and ImmediateCommitTimestamp are the commit
+	// timestamps of a GTID_EVENT in microseconds, if it has them. See
+	// BinlogEvent.CommitTimestamps().
+	OriginalCommitTimestamp  int64
+	ImmediateCommitTimestamp int64
+	// Query is set for a QUERY_EVENT.
+	Query *replication.Query
+	// IntVarName and IntVarValue are set for an INTVAR_EVENT.
+	IntVarName  string
+	IntVarValue uint64
+	// RandSeed1 and RandSeed2 are set for a RAND_EVENT.
+	RandSeed1 uint64
+	RandSeed2 uint64
+	// IncidentType and IncidentMessage are set for an INCIDENT_EVENT.
+	IncidentType    uint16
+	IncidentMessage string
+	// XAPrepare is set for an XA_PREPARE_LOG_EVENT.
+	XAPrepare *replication.XAPrepare
+}
+
+// decodeEvent decodes the payload of the event types the Streamer
+// understands. ev must be valid, and its checksum must be stripped.
+func decodeEvent(ev replication.BinlogEvent, format replication.BinlogFormat) (*StreamEvent, error) {
+	sev := &StreamEvent{
+		Type:      getEventType(ev),
+		Timestamp: ev.Timestamp(),
+	}
+	var err error
+	switch {
+	case ev.IsGTID():
+		sev.BeginGTID = ev.IsBeginGTID(format)
+		sev.LastCommitted, sev.SequenceNumber, _ = ev.LogicalClock(format)
+		sev.OriginalCommitTimestamp, sev.ImmediateCommitTimestamp, _ = ev.CommitTimestamps(format)
+	case ev.IsIntVar():
+		sev.IntVarName, sev.IntVarValue, err = ev.IntVar(format)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse INTVAR_EVENT: %v, event data: %#v", err, ev)
+		}
+	case ev.IsRand():
+		sev.RandSeed1, sev.RandSeed2, err = ev.Rand(format)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse RAND_EVENT: %v, event data: %#v", err, ev)
+		}
+	case ev.IsIncident():
+		sev.IncidentType, sev.IncidentMessage, err = ev.Incident(format)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse INCIDENT_EVENT: %v, event data: %#v", err, ev)
+		}
+	case ev.IsXAPrepare():
+		xa, err := ev.XAPrepare(format)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse XA_PREPARE_LOG_EVENT: %v, event data: %#v", err, ev)
+		}
+		sev.XAPrepare = &xa
+	case ev.IsQuery():
+		q, err := ev.Query(format)
+		if err != nil {
+			return nil, fmt.Errorf("can't get query from binlog event: %v, event data: %#v", err, ev)
+		}
+		sev.Query = &q
+	}
+	return sev, nil
+}
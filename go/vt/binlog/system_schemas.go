@@ -0,0 +1,54 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultSystemSchemas are the schemas of mysqld that SkipSystemSchemas
+// skips when SystemSchemas isn't set.
+var DefaultSystemSchemas = []string{"mysql", "performance_schema", "sys", "information_schema"}
+
+// accountManagementStatement matches the statements that change the
+// accounts and the privileges of mysqld. It logs them as they are, rather
+// than the writes to the tables of the mysql schema they make.
+var accountManagementStatement = regexp.MustCompile("(?i)^\\s*(grant|revoke|(create|alter|drop|rename)\\s+(user|role)|set\\s+(password|default\\s+role)|flush\\s+privileges)\\b")
+
+// systemSchemaFilter selects the statements of SkipSystemSchemas.
+type systemSchemaFilter struct {
+	// tables matches the names qualified with one of the schemas, e.g.
+	// "mysql.user" or "`sys` . `x`".
+	tables *regexp.Regexp
+	// accounts is true if the schemas include mysql, where the
+	// account management statements write.
+	accounts bool
+}
+
+// newSystemSchemaFilter returns a filter of the statements that touch
+// schemas, or DefaultSystemSchemas if it's empty.
+func newSystemSchemaFilter(schemas []string) *systemSchemaFilter {
+	if len(schemas) == 0 {
+		schemas = DefaultSystemSchemas
+	}
+	f := &systemSchemaFilter{}
+	quoted := make([]string, len(schemas))
+	for i, schema := range schemas {
+		quoted[i] = regexp.QuoteMeta(schema)
+		if strings.EqualFold(schema, "mysql") {
+			f.accounts = true
+		}
+	}
+	f.tables = regexp.MustCompile("(?i)(^|[^\\w$.`])`?(" + strings.Join(quoted, "|") + ")`?\\s*\\.")
+	return f
+}
+
+// matches returns true if sql touches one of the schemas of the filter.
+// The names are looked for in the whole statement, so a string literal
+// that looks like one matches as well.
+func (f *systemSchemaFilter) matches(sql string) bool {
+	return (f.accounts && accountManagementStatement.MatchString(sql)) || f.tables.MatchString(sql)
+}
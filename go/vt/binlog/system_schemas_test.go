@@ -0,0 +1,93 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+func TestSystemSchemaFilter(t *testing.T) {
+	testcases := []struct {
+		schemas []string
+		sql     string
+		want    bool
+	}{
+		{nil, "insert into mysql.user(Host, User) values ('%', 'app')", true},
+		{nil, "UPDATE `mysql` . `db` SET Select_priv = 'Y'", true},
+		{nil, "delete from performance_schema.setup_actors", true},
+		{nil, "update sys.sys_config set value = 64", true},
+		{nil, "create table t as select * from information_schema.tables", true},
+		{nil, "GRANT SELECT ON vt_test_keyspace.* TO 'app'@'%'", true},
+		{nil, "  revoke all on *.* from 'app'@'%'", true},
+		{nil, "CREATE USER 'app'@'%' IDENTIFIED BY 'secret'", true},
+		{nil, "set password for 'app'@'%' = 'secret'", true},
+		{nil, "flush privileges", true},
+		{nil, "insert into vt_a(eid, id) values (1, 1)", false},
+		{nil, "insert into vt_test_keyspace.mysql_users(id) values (1)", false},
+		{nil, "update vt_a set sys = 1", false},
+		{nil, "insert into vt_sys.a(id) values (1)", false},
+		{nil, "create table user (id int)", false},
+		// The account management statements only match with mysql.
+		{[]string{"sys"}, "GRANT SELECT ON *.* TO 'app'@'%'", false},
+		{[]string{"sys"}, "update sys.sys_config set value = 64", true},
+		{[]string{"sys"}, "insert into mysql.user(Host, User) values ('%', 'app')", false},
+	}
+	for _, tcase := range testcases {
+		if got := newSystemSchemaFilter(tcase.schemas).matches(tcase.sql); got != tcase.want {
+			t.Errorf("newSystemSchemaFilter(%v).matches(%q) = %v, want %v", tcase.schemas, tcase.sql, got, tcase.want)
+		}
+	}
+}
+
+func TestStreamerSkipSystemSchemas(t *testing.T) {
+	query := func(sql string) replication.BinlogEvent {
+		return queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: sql}}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		// mysqld logs a GRANT as is, in the database of the session.
+		query("GRANT SELECT ON vt_test_keyspace.* TO 'app'@'%'"),
+		query("insert into vt_a(eid, id) values (1, 1)"),
+		query("BEGIN"),
+		query("insert into mysql.user(Host, User) values ('%', 'app')"),
+		query("insert into vt_a(eid, id) values (2, 1)"),
+		xidEvent{},
+	}
+
+	var got [][]string
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		var sqls []string
+		for _, st := range trans.Statements {
+			sqls = append(sqls, st.Sql)
+		}
+		got = append(got, sqls)
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, replication.Position{}, sendTransaction)
+	bls.SetTimestamp = SetTimestampNever
+	bls.SkipSystemSchemas = true
+	before := binlogStreamerSystemStatements.Get()
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// The dropped autocommit GRANT is sent as an empty transaction.
+	want := [][]string{
+		nil,
+		{"insert into vt_a(eid, id) values (1, 1)"},
+		{"insert into vt_a(eid, id) values (2, 1)"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %q, want %q", got, want)
+	}
+	if got := binlogStreamerSystemStatements.Get() - before; got != 2 {
+		t.Errorf("BinlogStreamerSystemStatements increased by %v, want 2", got)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"io"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+	"golang.org/x/net/context"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+var (
+	// binlogTailRestarts counts the times Tail() started a new stream
+	// after the previous one ended.
+	binlogTailRestarts = stats.NewInt("BinlogTailRestarts")
+)
+
+// Defaults of the TailOptions.
+const (
+	defaultTailRetryDelay    = time.Second
+	defaultTailMaxRetryDelay = time.Minute
+	defaultTailIdleTimeout   = 30 * time.Second
+)
+
+// TailOptions are the settings of Tail(). The zero value uses the defaults.
+type TailOptions struct {
+	// ClientCharset is passed to each Streamer, see NewStreamer().
+	ClientCharset *binlogdatapb.Charset
+
+	// RetryDelay is the time to wait before starting over after a stream
+	// ended with an error. It doubles after each attempt that didn't send
+	// any transaction, up to MaxRetryDelay. They default to 1s and 1m.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+
+	// IdleTimeout is the Streamer.ReadTimeout of the connections to mysqld,
+	// so a dead connection is replaced. It defaults to 30s.
+	IdleTimeout time.Duration
+
+	// Connect, if set, creates the connection of each stream instead of
+	// mysqld, e.g. to use a FileConnection. Tail closes the connections.
+	Connect func() (BinlogConnection, error)
+
+	// Setup, if set, is called with each Streamer before it starts, to
+	// set its other options. PositionStore must be left as it is.
+	Setup func(bls *Streamer)
+}
+
+// Tail streams the transactions of dbname to sink until ctx is done,
+// starting over with a new Streamer every time a stream ends, e.g. because
+// mysqld closed the connection or restarted.
+//
+// Each stream starts at the position loaded from store, which is saved
+// after each transaction sink accepted, as with Streamer.PositionStore. So
+// transactions are neither skipped nor sent twice across streams, as long
+// as sink applies them along with the saves to store. A transaction that
+// was partially read when a stream ended is sent by the next one.
+//
+// Tail returns nil once ctx is done, or if sink returns io.EOF. It returns
+// the other errors of sink, and the SetupErrors that aren't retryable (see
+// IsRetryable()). Other errors, including failures to load the position
+// from store, are logged, and the stream starts over after RetryDelay.
+func Tail(ctx context.Context, dbname string, mysqld mysqlctl.MysqlDaemon, store PositionStore, sink func(trans *binlogdatapb.BinlogTransaction) error, opts TailOptions) error {
+	retryDelay := opts.RetryDelay
+	if retryDelay == 0 {
+		retryDelay = defaultTailRetryDelay
+	}
+	maxRetryDelay := opts.MaxRetryDelay
+	if maxRetryDelay == 0 {
+		maxRetryDelay = defaultTailMaxRetryDelay
+	}
+	idleTimeout := opts.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = defaultTailIdleTimeout
+	}
+
+	delay := retryDelay
+	for {
+		// sinkErr is the error returned by sink, which ends Tail, and sent
+		// counts the transactions sink accepted in this stream.
+		var sinkErr error
+		var sent int
+		send := func(trans *binlogdatapb.BinlogTransaction) error {
+			if err := sink(trans); err != nil {
+				sinkErr = err
+				return err
+			}
+			sent++
+			return nil
+		}
+
+		// Stream() loads the start position from store, since it's empty.
+		var bls *Streamer
+		var conn BinlogConnection
+		var err error
+		if opts.Connect != nil {
+			if conn, err = opts.Connect(); err != nil {
+				err = newSetupError(SetupStepConnect, err, true)
+			} else {
+				bls = NewStreamerWithConn(dbname, conn, opts.ClientCharset, replication.Position{}, send)
+			}
+		} else {
+			bls = NewStreamer(dbname, mysqld, opts.ClientCharset, replication.Position{}, send)
+			bls.ReadTimeout = idleTimeout
+		}
+		if bls != nil {
+			if opts.Setup != nil {
+				opts.Setup(bls)
+			}
+			bls.PositionStore = store
+			err = streamUntilDone(ctx, bls)
+			if conn != nil {
+				conn.Close()
+			}
+		}
+
+		if sent > 0 {
+			delay = retryDelay
+		}
+		switch {
+		case sinkErr == io.EOF:
+			return nil
+		case sinkErr != nil:
+			return sinkErr
+		case ctx.Err() != nil:
+			return nil
+		case err == nil:
+			// The stream only ends without an error when it's stopped,
+			// e.g. by Streamer.MaxDuration.
+		case isSetupError(err) && !IsRetryable(err):
+			return err
+		default:
+			log.Warningf("binlog tail of %v: %v, starting over in %v", dbname, err, delay)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		binlogTailRestarts.Add(1)
+	}
+}
+
+// streamUntilDone runs bls.Stream(), and stops it when ctx is done.
+func streamUntilDone(ctx context.Context, bls *Streamer) error {
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			svm.Stop()
+		case <-done:
+		}
+	}()
+	return svm.Join()
+}
+
+// isSetupError returns true if err is a *SetupError.
+func isSetupError(err error) bool {
+	_, ok := err.(*SetupError)
+	return ok
+}
@@ -0,0 +1,194 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+	"golang.org/x/net/context"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// tailServer is a fake mysqld for Tail(). Its binlog has one transaction
+// per GTID sequence number, starting at 1. Each connection sends the
+// transactions after its start position, and the n-th one closes the
+// stream after drops[n] events, as if mysqld went away.
+type tailServer struct {
+	transactions [][]replication.BinlogEvent
+	drops        []int
+
+	mu        sync.Mutex
+	startSeqs []uint64
+}
+
+type tailConnection struct {
+	server *tailServer
+	drop   int
+	closed chan struct{}
+}
+
+func (s *tailServer) connect() (BinlogConnection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	drop := -1
+	if n := len(s.startSeqs); n < len(s.drops) {
+		drop = s.drops[n]
+	}
+	return &tailConnection{server: s, drop: drop, closed: make(chan struct{})}, nil
+}
+
+func (conn *tailConnection) GetCharset() (*binlogdatapb.Charset, error) {
+	return nil, errors.New("no charset")
+}
+
+func (conn *tailConnection) StartBinlogDump(startPos replication.Position) (<-chan replication.BinlogEvent, error) {
+	var startSeq uint64
+	if !startPos.IsZero() {
+		startSeq = startPos.GTIDSet.(replication.MariadbGTID).Sequence
+	}
+	s := conn.server
+	s.mu.Lock()
+	s.startSeqs = append(s.startSeqs, startSeq)
+	s.mu.Unlock()
+
+	events := []replication.BinlogEvent{rotateEvent{}, formatEvent{}}
+	for _, trans := range s.transactions[startSeq:] {
+		events = append(events, trans...)
+	}
+	if conn.drop >= 0 {
+		events = events[:2+conn.drop]
+	}
+
+	eventChan := make(chan replication.BinlogEvent)
+	go func() {
+		for _, ev := range events {
+			select {
+			case eventChan <- ev:
+			case <-conn.closed:
+				return
+			}
+		}
+		if conn.drop >= 0 {
+			close(eventChan)
+		}
+		// Otherwise, wait for more events like mysqld does.
+	}()
+	return eventChan, nil
+}
+
+func (conn *tailConnection) Close() {
+	close(conn.closed)
+}
+
+func TestTail(t *testing.T) {
+	query := func(seq uint64, sql string) replication.BinlogEvent {
+		return sequenceQueryEvent{
+			queryEvent: queryEvent{query: replication.Query{Database: "vt_test_keyspace", SQL: sql}},
+			sequence:   seq,
+		}
+	}
+	server := &tailServer{}
+	for seq := uint64(1); seq <= 6; seq++ {
+		server.transactions = append(server.transactions, []replication.BinlogEvent{
+			query(seq, "BEGIN"),
+			query(seq, fmt.Sprintf("insert into vt_a(eid, id) values (%d, 1)", seq)),
+			query(seq, "COMMIT"),
+		})
+	}
+	// The first connection drops in the middle of the second transaction,
+	// the second one right after its first transaction, and the third one
+	// before sending anything.
+	server.drops = []int{5, 3, 0}
+
+	store := NewMemoryPositionStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var got []string
+	sink := func(trans *binlogdatapb.BinlogTransaction) error {
+		for _, st := range trans.Statements {
+			got = append(got, st.Sql)
+		}
+		if trans.TransactionId == replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 6}) {
+			cancel()
+		}
+		return nil
+	}
+	opts := TailOptions{
+		RetryDelay: time.Millisecond,
+		Connect:    server.connect,
+		Setup: func(bls *Streamer) {
+			bls.SetTimestamp = SetTimestampNever
+		},
+	}
+	if err := Tail(ctx, "vt_test_keyspace", nil, store, sink, opts); err != nil {
+		t.Errorf("Tail() = %v, want nil", err)
+	}
+
+	// Each transaction is sent once, in order.
+	var want []string
+	for seq := 1; seq <= 6; seq++ {
+		want = append(want, fmt.Sprintf("insert into vt_a(eid, id) values (%d, 1)", seq))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %q, want %q", got, want)
+	}
+	if got, want := server.startSeqs, []uint64{0, 1, 2, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("connections started at %v, want %v", got, want)
+	}
+	if pos, _ := store.Load(); !pos.Equal(sequencePosition(6)) {
+		t.Errorf("saved position %v, want %v", pos, sequencePosition(6))
+	}
+}
+
+func TestTailSinkError(t *testing.T) {
+	server := &tailServer{
+		transactions: [][]replication.BinlogEvent{{sequenceEvent(1)}, {sequenceEvent(2)}},
+	}
+	store := NewMemoryPositionStore()
+	sinkErr := errors.New("consumer is full")
+	sink := func(trans *binlogdatapb.BinlogTransaction) error {
+		return sinkErr
+	}
+	opts := TailOptions{RetryDelay: time.Millisecond, Connect: server.connect}
+	if err := Tail(context.Background(), "vt_test_keyspace", nil, store, sink, opts); err != sinkErr {
+		t.Errorf("Tail() = %v, want %v", err, sinkErr)
+	}
+	// The transaction that failed isn't saved.
+	if pos, _ := store.Load(); !pos.IsZero() {
+		t.Errorf("saved position %v, want none", pos)
+	}
+}
+
+func TestTailConnectError(t *testing.T) {
+	var attempts int
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := TailOptions{
+		RetryDelay:    time.Millisecond,
+		MaxRetryDelay: 4 * time.Millisecond,
+		Connect: func() (BinlogConnection, error) {
+			if attempts++; attempts == 3 {
+				cancel()
+			}
+			return nil, errors.New("mysqld is down")
+		},
+	}
+	sink := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	if err := Tail(ctx, "vt_test_keyspace", nil, NewMemoryPositionStore(), sink, opts); err != nil {
+		t.Errorf("Tail() = %v, want nil", err)
+	}
+	if attempts != 3 {
+		t.Errorf("got %v connection attempts, want 3", attempts)
+	}
+}
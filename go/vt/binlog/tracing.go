@@ -0,0 +1,42 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/trace"
+)
+
+// noSpan is the Span of the Streamer when Tracer isn't set. Unlike
+// trace.NewSpan(), it doesn't use the SpanFactory of the tracing plugin.
+type noSpan struct{}
+
+func (noSpan) StartLocal(string)            {}
+func (noSpan) StartClient(string)           {}
+func (noSpan) StartServer(string)           {}
+func (noSpan) Finish()                      {}
+func (noSpan) Annotate(string, interface{}) {}
+
+// newSpan returns a new Span of bls.Tracer, which is a child of parent if
+// it isn't nil.
+func (bls *Streamer) newSpan(parent trace.Span) trace.Span {
+	if bls.Tracer == nil {
+		return noSpan{}
+	}
+	return bls.Tracer.New(parent)
+}
+
+// traceRetrySetup runs bls.retrySetup(ctx, step) in a client span labeled
+// label, which is a child of parent, and annotates it with the last error.
+func (bls *Streamer) traceRetrySetup(ctx *sync2.ServiceContext, parent trace.Span, label string, step func() *SetupError) *SetupError {
+	span := bls.newSpan(parent)
+	span.StartClient(label)
+	defer span.Finish()
+	se := bls.retrySetup(ctx, step)
+	if se != nil {
+		span.Annotate("error", se.Error())
+	}
+	return se
+}
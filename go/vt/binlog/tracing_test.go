@@ -0,0 +1,174 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/trace"
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// recordedSpan is a Span of spanRecorder.
+type recordedSpan struct {
+	parent      *recordedSpan
+	label       string
+	annotations map[string]interface{}
+	finished    bool
+}
+
+func (s *recordedSpan) StartLocal(label string)  { s.label = label }
+func (s *recordedSpan) StartClient(label string) { s.label = label }
+func (s *recordedSpan) StartServer(label string) { s.label = label }
+func (s *recordedSpan) Finish()                  { s.finished = true }
+func (s *recordedSpan) Annotate(key string, value interface{}) {
+	s.annotations[key] = value
+}
+
+// spanRecorder implements trace.SpanFactory, and records the spans it
+// creates.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (r *spanRecorder) New(parent trace.Span) trace.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	span := &recordedSpan{annotations: make(map[string]interface{})}
+	if parent != nil {
+		span.parent = parent.(*recordedSpan)
+	}
+	r.spans = append(r.spans, span)
+	return span
+}
+
+func (r *spanRecorder) FromContext(ctx context.Context) (trace.Span, bool) {
+	return nil, false
+}
+
+func (r *spanRecorder) NewContext(parent context.Context, span trace.Span) context.Context {
+	return parent
+}
+
+// find returns the span labeled label, or nil.
+func (r *spanRecorder) find(label string) *recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, span := range r.spans {
+		if span.label == label {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestStreamerTracing(t *testing.T) {
+	conn := &fakeBinlogConnection{
+		charset: charset,
+		events: []replication.BinlogEvent{
+			rotateEvent{},
+			formatEvent{},
+			sequenceEvent(1),
+		},
+	}
+	var sent *binlogdatapb.BinlogTransaction
+	bls := NewStreamerWithConn("vt_test_keyspace", conn, charset, replication.Position{}, func(trans *binlogdatapb.BinlogTransaction) error {
+		sent = trans
+		return nil
+	})
+	recorder := &spanRecorder{}
+	bls.Tracer = recorder
+	bls.TraceTransactions = true
+
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	if err := svm.Join(); err == nil || !strings.Contains(err.Error(), ErrServerEOF.Error()) {
+		t.Errorf("wrong error, got %v, want %v", err, ErrServerEOF)
+	}
+
+	setup := recorder.find("Streamer.Setup")
+	if setup == nil {
+		t.Fatalf("no Streamer.Setup span in %v", recorder.spans)
+	}
+	if !setup.finished || setup.parent != nil {
+		t.Errorf("Streamer.Setup: finished = %v, parent = %v, want a finished root span", setup.finished, setup.parent)
+	}
+	if got, want := setup.annotations["db"], "vt_test_keyspace"; got != want {
+		t.Errorf("Streamer.Setup db = %v, want %v", got, want)
+	}
+	if _, ok := setup.annotations["error"]; ok {
+		t.Errorf("Streamer.Setup has an error: %v", setup.annotations["error"])
+	}
+	for _, label := range []string{"Streamer.CheckCharset", "Streamer.StartBinlogDump"} {
+		span := recorder.find(label)
+		if span == nil {
+			t.Errorf("no %v span", label)
+			continue
+		}
+		if !span.finished || span.parent != setup {
+			t.Errorf("%v: finished = %v, parent = %v, want a finished child of Streamer.Setup", label, span.finished, span.parent)
+		}
+	}
+	if span := recorder.find("Streamer.Connect"); span != nil {
+		t.Errorf("got a Streamer.Connect span for a connection of the caller")
+	}
+
+	send := recorder.find("Streamer.SendTransaction")
+	if send == nil {
+		t.Fatalf("no Streamer.SendTransaction span")
+	}
+	size := 0
+	for _, st := range sent.Statements {
+		size += len(st.Sql)
+	}
+	want := map[string]interface{}{
+		"db":         "vt_test_keyspace",
+		"gtid":       sent.TransactionId,
+		"statements": len(sent.Statements),
+		"size":       size,
+	}
+	if !send.finished || !reflect.DeepEqual(send.annotations, want) {
+		t.Errorf("Streamer.SendTransaction: finished = %v, annotations = %v, want finished with %v", send.finished, send.annotations, want)
+	}
+}
+
+func TestStreamerTracingSetupError(t *testing.T) {
+	mysqld := mysqlctl.NewFakeMysqlDaemon(nil)
+	bls := NewStreamer("vt_test_keyspace", mysqld, nil, replication.Position{}, func(*binlogdatapb.BinlogTransaction) error { return nil })
+	recorder := &spanRecorder{}
+	bls.Tracer = recorder
+	bls.ReadTimeout = time.Second
+
+	// FakeMysqlDaemon can't create slave connections with options.
+	svm := &sync2.ServiceManager{}
+	svm.Go(bls.Stream)
+	if err := svm.Join(); err == nil {
+		t.Errorf("expected error from FakeMysqlDaemon, got none")
+	}
+	for _, label := range []string{"Streamer.Setup", "Streamer.Connect"} {
+		span := recorder.find(label)
+		if span == nil {
+			t.Errorf("no %v span", label)
+			continue
+		}
+		if _, ok := span.annotations["error"]; !ok || !span.finished {
+			t.Errorf("%v: finished = %v, annotations = %v, want finished with an error", label, span.finished, span.annotations)
+		}
+	}
+	if span := recorder.find("Streamer.StartBinlogDump"); span != nil {
+		t.Errorf("got a Streamer.StartBinlogDump span after a failed connect")
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"fmt"
+	"sync"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// orderedSender enforces that transactions reach a sendTransactionFunc in
+// binlog order. Each transaction gets a sequence number when it is parsed,
+// and sendInOrder refuses any transaction that isn't the next one. This
+// guards features that move the sending out of the parsing goroutine.
+type orderedSender struct {
+	send sendTransactionFunc
+
+	// mu serializes the calls to send, and protects next.
+	mu   sync.Mutex
+	next int64
+}
+
+func newOrderedSender(send sendTransactionFunc) *orderedSender {
+	return &orderedSender{send: send}
+}
+
+// sendInOrder calls send with trans if seq is the next sequence number, and
+// returns an error without sending anything otherwise.
+func (s *orderedSender) sendInOrder(seq int64, trans *binlogdatapb.BinlogTransaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq != s.next {
+		binlogStreamerErrors.Add("OutOfOrder", 1)
+		return fmt.Errorf("binlog transaction #%v was about to be sent out of order, expected #%v", seq, s.next)
+	}
+	s.next++
+	return s.send(trans)
+}
@@ -0,0 +1,154 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+func TestOrderedSender(t *testing.T) {
+	var got []int64
+	s := newOrderedSender(func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, trans.Timestamp)
+		return nil
+	})
+
+	for _, seq := range []int64{0, 1} {
+		if err := s.sendInOrder(seq, &binlogdatapb.BinlogTransaction{Timestamp: seq}); err != nil {
+			t.Errorf("sendInOrder(%v) failed: %v", seq, err)
+		}
+	}
+	before := binlogStreamerErrors.Counts()["OutOfOrder"]
+	for _, seq := range []int64{3, 1} {
+		if err := s.sendInOrder(seq, &binlogdatapb.BinlogTransaction{Timestamp: seq}); err == nil {
+			t.Errorf("sendInOrder(%v) didn't fail", seq)
+		}
+	}
+	if got := binlogStreamerErrors.Counts()["OutOfOrder"] - before; got != 2 {
+		t.Errorf("BinlogStreamerErrors[OutOfOrder] increased by %v, want 2", got)
+	}
+	if err := s.sendInOrder(2, &binlogdatapb.BinlogTransaction{Timestamp: 2}); err != nil {
+		t.Errorf("sendInOrder(2) failed: %v", err)
+	}
+
+	if len(got) != 3 || got[0] != 0 || got[1] != 1 || got[2] != 2 {
+		t.Errorf("sent %v, want [0 1 2]", got)
+	}
+}
+
+func TestOrderedSenderStress(t *testing.T) {
+	const count = 10000
+	const senders = 8
+
+	// send runs under the orderedSender lock.
+	var sent []int64
+	s := newOrderedSender(func(trans *binlogdatapb.BinlogTransaction) error {
+		sent = append(sent, trans.Timestamp)
+		return nil
+	})
+
+	// The parser assigns the sequence numbers, and a sender goroutine sends
+	// the transactions while the parser runs ahead, like a buffered sender.
+	parsed := make(chan int64, 100)
+	go func() {
+		for seq := int64(0); seq < count; seq++ {
+			parsed <- seq
+		}
+		close(parsed)
+	}()
+	rejected := 0
+	for seq := range parsed {
+		if err := s.sendInOrder(seq, &binlogdatapb.BinlogTransaction{Timestamp: seq}); err != nil {
+			rejected++
+		}
+	}
+	if rejected != 0 {
+		t.Errorf("rejected %v transactions sent in order", rejected)
+	}
+
+	// Then several senders race to send each transaction: only one gets
+	// it through, the others are rejected.
+	var mu sync.Mutex
+	rejected = 0
+	for seq := int64(count); seq < 2*count; seq++ {
+		wg := sync.WaitGroup{}
+		for i := 0; i < senders; i++ {
+			wg.Add(1)
+			go func(seq int64) {
+				defer wg.Done()
+				if err := s.sendInOrder(seq, &binlogdatapb.BinlogTransaction{Timestamp: seq}); err != nil {
+					mu.Lock()
+					rejected++
+					mu.Unlock()
+				}
+			}(seq)
+		}
+		wg.Wait()
+	}
+	if want := count * (senders - 1); rejected != want {
+		t.Errorf("rejected %v racing transactions, want %v", rejected, want)
+	}
+
+	if len(sent) != 2*count {
+		t.Fatalf("sent %v transactions, want %v", len(sent), 2*count)
+	}
+	for i, seq := range sent {
+		if seq != int64(i) {
+			t.Fatalf("transaction #%v was sent in position %v", seq, i)
+		}
+	}
+}
+
+func TestStreamerSendOrder(t *testing.T) {
+	// The transactions that aren't sent, the duplicates and the suppressed
+	// ones, get no sequence number, and the parts of a split transaction
+	// get one each, so the Streamer sends them all in order.
+	query := func(seq uint64, db, sql string) replication.BinlogEvent {
+		return sequenceQueryEvent{queryEvent: queryEvent{query: replication.Query{Database: db, SQL: sql}}, sequence: seq}
+	}
+	input := []replication.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		sequenceEvent(2),
+		sequenceEvent(3),
+		sequenceEvent(4),
+		query(5, "other", "BEGIN"),
+		query(5, "other", "insert into vt_b(eid, id) values (5, 1)"),
+		query(5, "other", "COMMIT"),
+		query(6, "vt_test_keyspace", "BEGIN"),
+		query(6, "vt_test_keyspace", "insert into vt_a(eid, id) values (6, 1)"),
+		query(6, "vt_test_keyspace", "alter table vt_a add column msg varchar(64)"),
+		query(6, "vt_test_keyspace", "insert into vt_a(eid, id, msg) values (6, 2, 'a')"),
+		query(6, "vt_test_keyspace", "COMMIT"),
+		sequenceEvent(7),
+	}
+
+	var got []int
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		got = append(got, len(trans.Statements))
+		return nil
+	}
+	bls := NewStreamer("vt_test_keyspace", nil, nil, sequencePosition(1), sendTransaction)
+	bls.Dedup = NewDeduplicator(sequencePosition(3))
+	bls.SuppressEmptyTransactions = true
+	bls.IsolateDDL = true
+	before := binlogStreamerErrors.Counts()["OutOfOrder"]
+	if err := parseTestEvents(bls, input); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if n := binlogStreamerErrors.Counts()["OutOfOrder"] - before; n != 0 {
+		t.Errorf("BinlogStreamerErrors[OutOfOrder] increased by %v, want 0", n)
+	}
+	// 4, the three parts of 6, and 7.
+	if want := []int{2, 2, 2, 2, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions of %v statements, want %v", got, want)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/net/context"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// Transactions runs the stream in the background, and returns the channel
+// of its transactions, for the consumers that would rather pull them than
+// be called back:
+//
+//	transactions, errc := bls.Transactions(ctx)
+//	for trans := range transactions {
+//		...
+//	}
+//	if err := <-errc; err != nil {
+//		...
+//	}
+//
+// The Streamer must be created with a nil sendTransaction func, since the
+// transactions are sent to the channel instead. The channel is closed when
+// the stream ends, and errc then receives how it ended: the error of
+// Stream(), e.g. ErrServerEOF, ctx.Err() if ctx is done, or nil if the
+// stream was stopped, e.g. by MaxDuration. A consumer that stops reading
+// before the end must cancel ctx, so the stream ends.
+func (bls *Streamer) Transactions(ctx context.Context) (<-chan *binlogdatapb.BinlogTransaction, <-chan error) {
+	transactions := make(chan *binlogdatapb.BinlogTransaction)
+	errc := make(chan error, 1)
+	if bls.sendTransaction != nil {
+		close(transactions)
+		errc <- fmt.Errorf("Transactions() needs a Streamer created with a nil sendTransaction func")
+		close(errc)
+		return transactions, errc
+	}
+	bls.sendTransaction = func(trans *binlogdatapb.BinlogTransaction) error {
+		select {
+		case transactions <- trans:
+			return nil
+		case <-ctx.Done():
+			return io.EOF
+		}
+	}
+	go func() {
+		err := streamUntilDone(ctx, bls)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		close(transactions)
+		errc <- err
+		close(errc)
+	}()
+	return transactions, errc
+}
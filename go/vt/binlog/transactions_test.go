@@ -0,0 +1,75 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+	"golang.org/x/net/context"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+func TestStreamerTransactions(t *testing.T) {
+	conn := &fakeBinlogConnection{events: []replication.BinlogEvent{rotateEvent{}, formatEvent{}, sequenceEvent(1), sequenceEvent(2)}}
+	bls := NewStreamerWithConn("vt_test_keyspace", conn, nil, replication.Position{}, nil)
+	transactions, errc := bls.Transactions(context.Background())
+
+	var got []string
+	for trans := range transactions {
+		got = append(got, trans.TransactionId)
+	}
+	want := []string{
+		replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 1}),
+		replication.EncodeGTID(replication.MariadbGTID{Domain: 0, Server: 62344, Sequence: 2}),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got transactions %v, want %v", got, want)
+	}
+	if err := <-errc; err == nil || !strings.Contains(err.Error(), ErrServerEOF.Error()) {
+		t.Errorf("wrong error, got %v, want %v", err, ErrServerEOF)
+	}
+	if _, ok := <-errc; ok {
+		t.Errorf("errc is still open after the error")
+	}
+}
+
+func TestStreamerTransactionsCancel(t *testing.T) {
+	conn := &fakeBinlogConnection{events: []replication.BinlogEvent{rotateEvent{}, formatEvent{}, sequenceEvent(1), sequenceEvent(2)}}
+	bls := NewStreamerWithConn("vt_test_keyspace", conn, nil, replication.Position{}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	transactions, errc := bls.Transactions(ctx)
+
+	if _, ok := <-transactions; !ok {
+		t.Fatalf("transactions closed before the first one")
+	}
+	// The stream ends while it waits to send the second transaction.
+	cancel()
+	for range transactions {
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("wrong error, got %v, want %v", err, context.Canceled)
+	}
+	if !bls.committedPos.Equal(sequencePosition(1)) {
+		t.Errorf("committed position = %v, want %v", bls.committedPos, sequencePosition(1))
+	}
+}
+
+func TestStreamerTransactionsSendTransaction(t *testing.T) {
+	sendTransaction := func(trans *binlogdatapb.BinlogTransaction) error {
+		return nil
+	}
+	bls := NewStreamerWithConn("vt_test_keyspace", &fakeBinlogConnection{}, nil, replication.Position{}, sendTransaction)
+	transactions, errc := bls.Transactions(context.Background())
+	if _, ok := <-transactions; ok {
+		t.Errorf("got a transaction, want transactions closed")
+	}
+	if err := <-errc; err == nil || !strings.Contains(err.Error(), "nil sendTransaction func") {
+		t.Errorf("wrong error, got %v, want error about the sendTransaction func", err)
+	}
+}
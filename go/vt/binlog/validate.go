@@ -0,0 +1,165 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/concurrency"
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+)
+
+// eventTypeStatNames are the event types as named in the
+// BinlogStreamerEvents stats, i.e. the values returned by getEventType().
+var eventTypeStatNames = map[string]bool{
+	"FormatDescription": true,
+	"Rotate":            true,
+	"GTID":              true,
+	"XID":               true,
+	"IntVar":            true,
+	"Rand":              true,
+	"Query":             true,
+	"Stop":              true,
+	"Incident":          true,
+	"TableMap":          true,
+	"XAPrepare":         true,
+	"Other":             true,
+}
+
+// Validate checks the settings of the Streamer, without connecting to
+// mysqld, so a misconfigured Streamer can be rejected before Stream() is
+// called. It returns all the problems it finds, separated by ";", or nil.
+//
+// Some problems can only be found by Stream(), e.g. a start position of
+// another flavor than the one of mysqld, or a charset mismatch.
+func (bls *Streamer) Validate() error {
+	rec := concurrency.AllErrorRecorder{}
+
+	filter := bls.currentFilter()
+	if filter.Database == "" {
+		rec.RecordError(errors.New("no database to stream"))
+	}
+	for i, re := range filter.DropStatements {
+		if re == nil {
+			rec.RecordError(fmt.Errorf("DropStatements[%v] is nil", i))
+		}
+	}
+	if bls.mysqld == nil && bls.conn == nil {
+		rec.RecordError(errors.New("no mysqld or connection to stream from"))
+	}
+	if bls.RequireDatabase && bls.mysqld == nil {
+		rec.RecordError(errors.New("RequireDatabase requires a mysqld to check the database in"))
+	}
+	if bls.sendTransaction == nil && bls.SendEvent == nil {
+		rec.RecordError(errors.New("no sendTransaction func or SendEvent to send the stream to"))
+	}
+	if !bls.startPos.IsZero() {
+		if _, err := replication.DecodePosition(replication.EncodePosition(bls.startPos)); err != nil {
+			rec.RecordError(fmt.Errorf("invalid start position %v: %v", bls.startPos, err))
+		}
+	}
+
+	if bls.BeginCommit > BeginCommitAll {
+		rec.RecordError(fmt.Errorf("unknown BeginCommit mode %v", bls.BeginCommit))
+	}
+	if bls.SetTimestamp > SetTimestampNever {
+		rec.RecordError(fmt.Errorf("unknown SetTimestamp mode %v", bls.SetTimestamp))
+	}
+	if bls.Provider > ProviderRDS {
+		rec.RecordError(fmt.Errorf("unknown Provider %v", bls.Provider))
+	}
+	for gtid := range bls.SkipGTIDs {
+		if gtid == nil {
+			rec.RecordError(errors.New("nil GTID in SkipGTIDs"))
+		}
+	}
+	for name := range bls.TolerateBeforeFormat {
+		if !eventTypeStatNames[name] {
+			rec.RecordError(fmt.Errorf("unknown event type %q in TolerateBeforeFormat", name))
+		}
+	}
+
+	if bls.StatementLogPositions && bls.SendMetadata == nil {
+		rec.RecordError(errors.New("StatementLogPositions requires SendMetadata"))
+	}
+	if bls.StatementChecksums && bls.SendMetadata == nil {
+		rec.RecordError(errors.New("StatementChecksums requires SendMetadata"))
+	}
+	if bls.RewriteSets && bls.RewriteStatement == nil {
+		rec.RecordError(errors.New("RewriteSets requires RewriteStatement"))
+	}
+	if bls.PositionSaveInterval != 0 && bls.PositionStore == nil {
+		rec.RecordError(errors.New("PositionSaveInterval requires PositionStore"))
+	}
+	if bls.BatchAutocommit < 0 {
+		rec.RecordError(fmt.Errorf("negative BatchAutocommit %v", bls.BatchAutocommit))
+	}
+	if bls.BatchAutocommitWindow != 0 && bls.BatchAutocommit == 0 {
+		rec.RecordError(errors.New("BatchAutocommitWindow requires BatchAutocommit"))
+	}
+	if bls.BatchAutocommitSize < 0 {
+		rec.RecordError(fmt.Errorf("negative BatchAutocommitSize %v", bls.BatchAutocommitSize))
+	}
+	if (bls.BatchAutocommitSize != 0 || bls.BatchAutocommitSameTimestamp) && bls.BatchAutocommit == 0 {
+		rec.RecordError(errors.New("BatchAutocommitSize and BatchAutocommitSameTimestamp require BatchAutocommit"))
+	}
+	if bls.SetupRetries < 0 {
+		rec.RecordError(fmt.Errorf("negative SetupRetries %v", bls.SetupRetries))
+	}
+	if bls.SetupRetryDelay != 0 && bls.SetupRetries == 0 {
+		rec.RecordError(errors.New("SetupRetryDelay requires SetupRetries"))
+	}
+	if bls.SendRetries < 0 {
+		rec.RecordError(fmt.Errorf("negative SendRetries %v", bls.SendRetries))
+	}
+	if bls.SendRetryDelay != 0 && bls.SendRetries == 0 {
+		rec.RecordError(errors.New("SendRetryDelay requires SendRetries"))
+	}
+	if len(bls.SystemSchemas) != 0 && !bls.SkipSystemSchemas {
+		rec.RecordError(errors.New("SystemSchemas requires SkipSystemSchemas"))
+	}
+	if bls.TraceTransactions && bls.Tracer == nil {
+		rec.RecordError(errors.New("TraceTransactions requires Tracer"))
+	}
+	if bls.ReadAhead < 0 {
+		rec.RecordError(fmt.Errorf("negative ReadAhead %v", bls.ReadAhead))
+	}
+	if bls.PositionHistorySize < 0 {
+		rec.RecordError(fmt.Errorf("negative PositionHistorySize %v", bls.PositionHistorySize))
+	}
+
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"PositionSaveInterval", bls.PositionSaveInterval},
+		{"ReadTimeout", bls.ReadTimeout},
+		{"KeepAlive", bls.KeepAlive},
+		{"MaxDuration", bls.MaxDuration},
+		{"LagThreshold", bls.LagThreshold},
+		{"LagHysteresis", bls.LagHysteresis},
+		{"DedupDDLWindow", bls.DedupDDLWindow},
+		{"BatchAutocommitWindow", bls.BatchAutocommitWindow},
+		{"SetupRetryDelay", bls.SetupRetryDelay},
+		{"HeartbeatInterval", bls.HeartbeatInterval},
+		{"SendRetryDelay", bls.SendRetryDelay},
+		{"HealthThreshold", bls.HealthThreshold},
+		{"MaxOpenTransactionDuration", bls.MaxOpenTransactionDuration},
+	} {
+		if d.value < 0 {
+			rec.RecordError(fmt.Errorf("negative %v %v", d.name, d.value))
+		}
+	}
+	if bls.LagChanged == nil && (bls.LagThreshold != 0 || bls.LagHysteresis != 0) {
+		rec.RecordError(errors.New("LagThreshold and LagHysteresis require LagChanged"))
+	}
+	if bls.LagHysteresis > bls.LagThreshold {
+		rec.RecordError(fmt.Errorf("LagHysteresis %v is larger than LagThreshold %v", bls.LagHysteresis, bls.LagThreshold))
+	}
+
+	return rec.Error()
+}
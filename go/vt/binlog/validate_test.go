@@ -0,0 +1,248 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package binlog
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
+
+	binlogdatapb "github.com/youtube/vitess/go/vt/proto/binlogdata"
+)
+
+// unknownFlavorGTIDSet is a GTIDSet whose flavor has no registered parser.
+type unknownFlavorGTIDSet struct {
+	replication.GTIDSet
+}
+
+func (unknownFlavorGTIDSet) Flavor() string { return "unknown" }
+
+func TestStreamerValidate(t *testing.T) {
+	sendTransaction := func(*binlogdatapb.BinlogTransaction) error { return nil }
+	newStreamer := func() *Streamer {
+		return NewStreamerWithConn("vt_test_keyspace", &fakeBinlogConnection{}, nil, sequencePosition(1), sendTransaction)
+	}
+
+	testcases := []struct {
+		desc  string
+		setup func(bls *Streamer)
+		want  string
+	}{{
+		desc: "no database",
+		setup: func(bls *Streamer) {
+			bls.dbname = ""
+		},
+		want: "no database to stream",
+	}, {
+		desc: "nil DropStatements pattern",
+		setup: func(bls *Streamer) {
+			bls.DropStatements = []*regexp.Regexp{regexp.MustCompile("^drop "), nil}
+		},
+		want: "DropStatements[1] is nil",
+	}, {
+		desc: "empty filter database",
+		setup: func(bls *Streamer) {
+			bls.SetFilter(Filter{})
+		},
+		want: "no database to stream",
+	}, {
+		desc: "no connection",
+		setup: func(bls *Streamer) {
+			bls.conn = nil
+		},
+		want: "no mysqld or connection to stream from",
+	}, {
+		desc: "RequireDatabase without mysqld",
+		setup: func(bls *Streamer) {
+			bls.RequireDatabase = true
+		},
+		want: "RequireDatabase requires a mysqld to check the database in",
+	}, {
+		desc: "nothing to send to",
+		setup: func(bls *Streamer) {
+			bls.sendTransaction = nil
+		},
+		want: "no sendTransaction func or SendEvent to send the stream to",
+	}, {
+		desc: "start position of unknown flavor",
+		setup: func(bls *Streamer) {
+			bls.startPos = replication.Position{GTIDSet: unknownFlavorGTIDSet{sequencePosition(1).GTIDSet}}
+		},
+		want: "invalid start position 0-62344-1: parse error: unknown GTIDSet flavor \"unknown\"",
+	}, {
+		desc: "unknown BeginCommit mode",
+		setup: func(bls *Streamer) {
+			bls.BeginCommit = BeginCommitAll + 1
+		},
+		want: "unknown BeginCommit mode 3",
+	}, {
+		desc: "unknown SetTimestamp mode",
+		setup: func(bls *Streamer) {
+			bls.SetTimestamp = SetTimestampNever + 1
+		},
+		want: "unknown SetTimestamp mode 3",
+	}, {
+		desc: "unknown Provider",
+		setup: func(bls *Streamer) {
+			bls.Provider = ProviderRDS + 1
+		},
+		want: "unknown Provider 2",
+	}, {
+		desc: "unknown TolerateBeforeFormat event type",
+		setup: func(bls *Streamer) {
+			bls.TolerateBeforeFormat = map[string]bool{"Query": true, "query": true}
+		},
+		want: "unknown event type \"query\" in TolerateBeforeFormat",
+	}, {
+		desc: "StatementLogPositions without SendMetadata",
+		setup: func(bls *Streamer) {
+			bls.StatementLogPositions = true
+		},
+		want: "StatementLogPositions requires SendMetadata",
+	}, {
+		desc: "StatementChecksums without SendMetadata",
+		setup: func(bls *Streamer) {
+			bls.StatementChecksums = true
+		},
+		want: "StatementChecksums requires SendMetadata",
+	}, {
+		desc: "PositionSaveInterval without PositionStore",
+		setup: func(bls *Streamer) {
+			bls.PositionSaveInterval = time.Second
+		},
+		want: "PositionSaveInterval requires PositionStore",
+	}, {
+		desc: "negative PositionHistorySize",
+		setup: func(bls *Streamer) {
+			bls.PositionHistorySize = -1
+		},
+		want: "negative PositionHistorySize -1",
+	}, {
+		desc: "BatchAutocommitWindow without BatchAutocommit",
+		setup: func(bls *Streamer) {
+			bls.BatchAutocommitWindow = time.Second
+		},
+		want: "BatchAutocommitWindow requires BatchAutocommit",
+	}, {
+		desc: "BatchAutocommitSameTimestamp without BatchAutocommit",
+		setup: func(bls *Streamer) {
+			bls.BatchAutocommitSameTimestamp = true
+		},
+		want: "BatchAutocommitSize and BatchAutocommitSameTimestamp require BatchAutocommit",
+	}, {
+		desc: "SetupRetryDelay without SetupRetries",
+		setup: func(bls *Streamer) {
+			bls.SetupRetryDelay = time.Second
+		},
+		want: "SetupRetryDelay requires SetupRetries",
+	}, {
+		desc: "SendRetryDelay without SendRetries",
+		setup: func(bls *Streamer) {
+			bls.SendRetryDelay = time.Second
+		},
+		want: "SendRetryDelay requires SendRetries",
+	}, {
+		desc: "TraceTransactions without Tracer",
+		setup: func(bls *Streamer) {
+			bls.TraceTransactions = true
+		},
+		want: "TraceTransactions requires Tracer",
+	}, {
+		desc: "SystemSchemas without SkipSystemSchemas",
+		setup: func(bls *Streamer) {
+			bls.SystemSchemas = []string{"mysql"}
+		},
+		want: "SystemSchemas requires SkipSystemSchemas",
+	}, {
+		desc: "nil GTID in SkipGTIDs",
+		setup: func(bls *Streamer) {
+			bls.SkipGTIDs = map[replication.GTID]bool{nil: true}
+		},
+		want: "nil GTID in SkipGTIDs",
+	}, {
+		desc: "negative ReadAhead",
+		setup: func(bls *Streamer) {
+			bls.ReadAhead = -1
+		},
+		want: "negative ReadAhead -1",
+	}, {
+		desc: "RewriteSets without RewriteStatement",
+		setup: func(bls *Streamer) {
+			bls.RewriteSets = true
+		},
+		want: "RewriteSets requires RewriteStatement",
+	}, {
+		desc: "negative ReadTimeout",
+		setup: func(bls *Streamer) {
+			bls.ReadTimeout = -time.Second
+		},
+		want: "negative ReadTimeout -1s",
+	}, {
+		desc: "negative MaxDuration",
+		setup: func(bls *Streamer) {
+			bls.MaxDuration = -time.Minute
+		},
+		want: "negative MaxDuration -1m0s",
+	}, {
+		desc: "LagThreshold without LagChanged",
+		setup: func(bls *Streamer) {
+			bls.LagThreshold = time.Minute
+		},
+		want: "LagThreshold and LagHysteresis require LagChanged",
+	}, {
+		desc: "LagHysteresis larger than LagThreshold",
+		setup: func(bls *Streamer) {
+			bls.LagChanged = func(bool, time.Duration) {}
+			bls.LagThreshold = time.Second
+			bls.LagHysteresis = time.Minute
+		},
+		want: "LagHysteresis 1m0s is larger than LagThreshold 1s",
+	}, {
+		desc: "several problems",
+		setup: func(bls *Streamer) {
+			bls.dbname = ""
+			bls.StatementChecksums = true
+			bls.KeepAlive = -time.Second
+		},
+		want: "no database to stream;StatementChecksums requires SendMetadata;negative KeepAlive -1s",
+	}}
+
+	for _, tc := range testcases {
+		bls := newStreamer()
+		tc.setup(bls)
+		err := bls.Validate()
+		if err == nil {
+			t.Errorf("%v: Validate() = nil, want error %q", tc.desc, tc.want)
+			continue
+		}
+		if got := err.Error(); got != tc.want {
+			t.Errorf("%v: Validate() = %q, want %q", tc.desc, got, tc.want)
+		}
+	}
+
+	// A valid configuration, with every option that needs another one set.
+	bls := newStreamer()
+	bls.DropStatements = []*regexp.Regexp{regexp.MustCompile("^drop ")}
+	bls.TolerateBeforeFormat = map[string]bool{"Other": true}
+	bls.SendMetadata = func(*binlogdatapb.BinlogTransaction, TransactionMetadata) {}
+	bls.StatementLogPositions = true
+	bls.StatementChecksums = true
+	bls.LagChanged = func(bool, time.Duration) {}
+	bls.LagThreshold = time.Minute
+	bls.LagHysteresis = time.Second
+	bls.Provider = ProviderRDS
+	if err := bls.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+
+	// Only SendEvent is enough to stream.
+	bls = NewStreamerWithConn("vt_test_keyspace", &fakeBinlogConnection{}, nil, replication.Position{}, nil)
+	bls.SendEvent = func(*StreamEvent) error { return nil }
+	if err := bls.Validate(); err != nil {
+		t.Errorf("Validate() with SendEvent = %v, want nil", err)
+	}
+}
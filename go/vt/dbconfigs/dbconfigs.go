@@ -65,6 +65,7 @@ func registerConnFlags(connParams *sqldb.ConnParams, cnfName DbConfigName, defau
 	flag.StringVar(&connParams.SslCaPath, "db-config-"+name+"-ssl-ca-path", defaultParams.SslCaPath, "db "+name+" connection ssl ca path")
 	flag.StringVar(&connParams.SslCert, "db-config-"+name+"-ssl-cert", defaultParams.SslCert, "db "+name+" connection ssl certificate")
 	flag.StringVar(&connParams.SslKey, "db-config-"+name+"-ssl-key", defaultParams.SslKey, "db "+name+" connection ssl key")
+	flag.StringVar(&connParams.AuthPlugin, "db-config-"+name+"-auth-plugin", defaultParams.AuthPlugin, "db "+name+" connection authentication plugin (mysql_native_password or caching_sha2_password)")
 }
 
 // RegisterFlags registers the flags for the given DBConfigFlag.
@@ -98,7 +99,11 @@ func RegisterFlags(flags DBConfigFlag) DBConfigFlag {
 
 // initConnParams may overwrite the socket file,
 // and refresh the password to check that works.
+// It also checks that the client library can use the auth plugin.
 func initConnParams(cp *sqldb.ConnParams, socketFile string) error {
+	if err := mysql.CheckAuthPlugin(cp.AuthPlugin); err != nil {
+		return err
+	}
 	if socketFile != "" {
 		cp.UnixSocket = socketFile
 	}
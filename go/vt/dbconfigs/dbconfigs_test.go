@@ -4,7 +4,10 @@
 
 package dbconfigs
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestRegisterFlagsWithoutFlags(t *testing.T) {
 	defer func() {
@@ -41,3 +44,12 @@ func TestInitWithEmptyFlags(t *testing.T) {
 	}()
 	Init("", EmptyConfig)
 }
+
+func TestInitWithUnsupportedAuthPlugin(t *testing.T) {
+	dbConfigs = DBConfigs{}
+	dbConfigs.Dba.AuthPlugin = "sha256_password"
+	_, err := Init("", DbaConfig)
+	if err == nil || !strings.Contains(err.Error(), "sha256_password") {
+		t.Errorf("Init() = %v, want an error for the auth plugin", err)
+	}
+}
@@ -84,8 +84,16 @@ func (ev binlogEvent) ServerID() uint32 {
 	return binary.LittleEndian.Uint32(ev.Bytes()[5 : 5+4])
 }
 
+// NextPosition returns the next_position field from the header.
+func (ev binlogEvent) NextPosition() uint32 {
+	return binary.LittleEndian.Uint32(ev.Bytes()[13 : 13+4])
+}
+
 // Length returns the event_length field from the header.
 func (ev binlogEvent) Length() uint32 {
+	if len(ev.Bytes()) < 9+4 {
+		return 0
+	}
 	return binary.LittleEndian.Uint32(ev.Bytes()[9 : 9+4])
 }
 
@@ -119,6 +127,16 @@ func (ev binlogEvent) IsRand() bool {
 	return ev.Type() == 13
 }
 
+// IsStop implements BinlogEvent.IsStop().
+func (ev binlogEvent) IsStop() bool {
+	return ev.Type() == 3
+}
+
+// IsIncident implements BinlogEvent.IsIncident().
+func (ev binlogEvent) IsIncident() bool {
+	return ev.Type() == 26
+}
+
 // Format implements BinlogEvent.Format().
 //
 // Expected format (L = total length of event data):
@@ -184,6 +202,8 @@ func (ev binlogEvent) Query(f replication.BinlogFormat) (query replication.Query
 	// it (db and vars) is in-bounds too.
 	query.Database = string(data[dbPos : dbPos+dbLen])
 	query.SQL = string(data[sqlPos:])
+	query.ExecutionTime = binary.LittleEndian.Uint32(data[4 : 4+4])
+	query.ErrorCode = binary.LittleEndian.Uint16(data[4+4+1 : 4+4+1+2])
 
 	// Scan the status vars for ones we care about. This requires us to know the
 	// size of every var that comes before the ones we're interested in.
@@ -222,6 +242,36 @@ varsLoop:
 				Server: int32(binary.LittleEndian.Uint16(vars[pos+4 : pos+6])),
 			}
 			pos += 6
+		case 5: // Q_TIME_ZONE_CODE
+			if pos+1 > len(vars) {
+				return query, fmt.Errorf("Q_TIME_ZONE_CODE status var overflows buffer (%v + 1 > %v)", pos, len(vars))
+			}
+			pos += 1 + int(vars[pos])
+		case 7, 8: // Q_LC_TIME_NAMES_CODE, Q_CHARSET_DATABASE_CODE
+			pos += 2
+		case 9: // Q_TABLE_MAP_FOR_UPDATE_CODE
+			pos += 8
+		case 10: // Q_MASTER_DATA_WRITTEN_CODE
+			pos += 4
+		case 11: // Q_INVOKER
+			// The user and the host are each prefixed by their length.
+			if pos+1 > len(vars) {
+				return query, fmt.Errorf("Q_INVOKER status var overflows buffer (%v + 1 > %v)", pos, len(vars))
+			}
+			userLen := int(vars[pos])
+			pos++
+			if pos+userLen+1 > len(vars) {
+				return query, fmt.Errorf("Q_INVOKER status var overflows buffer (%v + %v + 1 > %v)", pos, userLen, len(vars))
+			}
+			query.InvokerUser = string(vars[pos : pos+userLen])
+			pos += userLen
+			hostLen := int(vars[pos])
+			pos++
+			if pos+hostLen > len(vars) {
+				return query, fmt.Errorf("Q_INVOKER status var overflows buffer (%v + %v > %v)", pos, hostLen, len(vars))
+			}
+			query.InvokerHost = string(vars[pos : pos+hostLen])
+			pos += hostLen
 		default:
 			// If we see something higher than what we're interested in, we can stop.
 			break varsLoop
@@ -266,11 +316,239 @@ func (ev binlogEvent) Rand(f replication.BinlogFormat) (seed1 uint64, seed2 uint
 	return seed1, seed2, nil
 }
 
+// Incident implements BinlogEvent.Incident().
+//
+// Expected format:
+//   # bytes   field
+//   2         incident type
+//   1         message length
+//   N         message
+func (ev binlogEvent) Incident(f replication.BinlogFormat) (incidentType uint16, message string, err error) {
+	data := ev.Bytes()[f.HeaderLength:]
+	if len(data) < 2+1 {
+		return 0, "", fmt.Errorf("incident header overflows buffer (%v > %v)", 2+1, len(data))
+	}
+	incidentType = binary.LittleEndian.Uint16(data[0:2])
+	msgEnd := 2 + 1 + int(data[2])
+	if msgEnd > len(data) {
+		return 0, "", fmt.Errorf("incident message overflows buffer (%v > %v)", msgEnd, len(data))
+	}
+	return incidentType, string(data[2+1 : msgEnd]), nil
+}
+
+// Rotate implements BinlogEvent.Rotate().
+//
+// Expected format (L = total length of event data):
+//   # bytes   field
+//   8         position
+//   L-8       file name (no NULL terminator)
+func (ev binlogEvent) Rotate(f replication.BinlogFormat) (position uint64, fileName string, err error) {
+	data := ev.Bytes()[f.HeaderLength:]
+	if len(data) < 8 {
+		return 0, "", fmt.Errorf("rotate position overflows buffer (%v > %v)", 8, len(data))
+	}
+	position = binary.LittleEndian.Uint64(data[0:8])
+	return position, string(data[8:]), nil
+}
+
+// IsTableMap implements BinlogEvent.IsTableMap().
+func (ev binlogEvent) IsTableMap() bool {
+	return ev.Type() == 19
+}
+
+// TableMap implements BinlogEvent.TableMap().
+//
+// Expected format (L = total length of event data):
+//   # bytes   field
+//   6         table id
+//   2         flags
+//   1         length of db name, not including NULL terminator (X)
+//   X+1       db name + NULL terminator
+//   1         length of table name, not including NULL terminator (Y)
+//   Y+1       table name + NULL terminator
+//   1-9       number of columns (N), as a length-encoded integer
+//   N         column types
+//   1-9       length of metadata block, as a length-encoded integer (M)
+//   M         metadata block
+//   (N+7)/8   bitmap of nullable columns
+func (ev binlogEvent) TableMap(f replication.BinlogFormat) (tm replication.TableMap, err error) {
+	data := ev.Bytes()[f.HeaderLength:]
+	if len(data) < 6+2+1 {
+		return tm, fmt.Errorf("table map header overflows buffer (%v > %v)", 6+2+1, len(data))
+	}
+	tm.TableID = uint64(binary.LittleEndian.Uint32(data[0:4])) | uint64(binary.LittleEndian.Uint16(data[4:6]))<<32
+	tm.Flags = binary.LittleEndian.Uint16(data[6 : 6+2])
+	pos := 6 + 2
+
+	if tm.Database, pos, err = readNullTerminatedName(data, pos); err != nil {
+		return tm, fmt.Errorf("table map db name: %v", err)
+	}
+	if tm.Name, pos, err = readNullTerminatedName(data, pos); err != nil {
+		return tm, fmt.Errorf("table map table name: %v", err)
+	}
+
+	columnCount, pos, err := readLenEncInt(data, pos)
+	if err != nil {
+		return tm, fmt.Errorf("table map column count: %v", err)
+	}
+	if uint64(len(data)-pos) < columnCount {
+		return tm, fmt.Errorf("table map column types overflow buffer (%v + %v > %v)", pos, columnCount, len(data))
+	}
+	n := int(columnCount)
+	tm.Types = append([]byte(nil), data[pos:pos+n]...)
+	pos += n
+
+	metadataLen, pos, err := readLenEncInt(data, pos)
+	if err != nil {
+		return tm, fmt.Errorf("table map metadata length: %v", err)
+	}
+	if uint64(len(data)-pos) < metadataLen {
+		return tm, fmt.Errorf("table map metadata overflows buffer (%v + %v > %v)", pos, metadataLen, len(data))
+	}
+	metadata := data[pos : pos+int(metadataLen)]
+	pos += int(metadataLen)
+	tm.Metadata = make([]uint16, n)
+	metaPos := 0
+	for i, typ := range tm.Types {
+		size := columnMetadataSize(typ)
+		if metaPos+size > len(metadata) {
+			return tm, fmt.Errorf("table map metadata of column %v overflows metadata block (%v + %v > %v)", i, metaPos, size, len(metadata))
+		}
+		switch typ {
+		case 246, 247, 248, 254:
+			// MYSQL_TYPE_NEWDECIMAL has the precision then the scale, and
+			// MYSQL_TYPE_STRING has the real type then the length, so these
+			// are big-endian.
+			tm.Metadata[i] = binary.BigEndian.Uint16(metadata[metaPos : metaPos+2])
+		default:
+			switch size {
+			case 1:
+				tm.Metadata[i] = uint16(metadata[metaPos])
+			case 2:
+				tm.Metadata[i] = binary.LittleEndian.Uint16(metadata[metaPos : metaPos+2])
+			}
+		}
+		metaPos += size
+	}
+
+	bitmapLen := (n + 7) / 8
+	if pos+bitmapLen > len(data) {
+		return tm, fmt.Errorf("table map null bitmap overflows buffer (%v + %v > %v)", pos, bitmapLen, len(data))
+	}
+	tm.CanBeNull = make([]bool, n)
+	for i := range tm.CanBeNull {
+		tm.CanBeNull[i] = data[pos+i/8]&(1<<uint(i%8)) != 0
+	}
+	return tm, nil
+}
+
+// columnMetadataSize returns the size in the TABLE_MAP_EVENT metadata block
+// of the metadata for a column of type typ.
+func columnMetadataSize(typ byte) int {
+	switch typ {
+	case 4, 5, 17, 18, 19, 245, 252, 255:
+		// MYSQL_TYPE_FLOAT, DOUBLE, TIMESTAMP2, DATETIME2, TIME2, JSON,
+		// BLOB and GEOMETRY.
+		return 1
+	case 15, 16, 246, 247, 248, 253, 254:
+		// MYSQL_TYPE_VARCHAR, BIT, NEWDECIMAL, ENUM, SET, VAR_STRING and
+		// STRING.
+		return 2
+	}
+	return 0
+}
+
+// readNullTerminatedName reads a name stored as a 1-byte length, the name,
+// and a NULL terminator, starting at pos. It returns the position after it.
+func readNullTerminatedName(data []byte, pos int) (string, int, error) {
+	if pos+1 > len(data) {
+		return "", 0, fmt.Errorf("length overflows buffer (%v + 1 > %v)", pos, len(data))
+	}
+	end := pos + 1 + int(data[pos])
+	if end+1 > len(data) {
+		return "", 0, fmt.Errorf("name overflows buffer (%v + 1 > %v)", end, len(data))
+	}
+	return string(data[pos+1 : end]), end + 1, nil
+}
+
+// readLenEncInt reads a MySQL length-encoded integer starting at pos. It
+// returns the position after it.
+func readLenEncInt(data []byte, pos int) (uint64, int, error) {
+	if pos+1 > len(data) {
+		return 0, 0, fmt.Errorf("length-encoded integer overflows buffer (%v + 1 > %v)", pos, len(data))
+	}
+	size := 0
+	switch data[pos] {
+	case 0xfc:
+		size = 2
+	case 0xfd:
+		size = 3
+	case 0xfe:
+		size = 8
+	case 0xfb, 0xff:
+		return 0, 0, fmt.Errorf("invalid length-encoded integer prefix: %#x", data[pos])
+	default:
+		return uint64(data[pos]), pos + 1, nil
+	}
+	if pos+1+size > len(data) {
+		return 0, 0, fmt.Errorf("length-encoded integer overflows buffer (%v + %v > %v)", pos+1, size, len(data))
+	}
+	var value uint64
+	for i := size - 1; i >= 0; i-- {
+		value = value<<8 | uint64(data[pos+1+i])
+	}
+	return value, pos + 1 + size, nil
+}
+
+// IsXAPrepare implements BinlogEvent.IsXAPrepare().
+func (ev binlogEvent) IsXAPrepare() bool {
+	return ev.Type() == 38
+}
+
+// XAPrepare implements BinlogEvent.XAPrepare().
+//
+// Expected format (L = total length of event data):
+//   # bytes   field
+//   1         one phase flag
+//   4         format id
+//   4         length of gtrid (G)
+//   4         length of bqual (B)
+//   G+B       gtrid + bqual
+func (ev binlogEvent) XAPrepare(f replication.BinlogFormat) (xa replication.XAPrepare, err error) {
+	const dataPos = 1 + 4 + 4 + 4
+
+	data := ev.Bytes()[f.HeaderLength:]
+	if len(data) < dataPos {
+		return xa, fmt.Errorf("XA prepare header overflows buffer (%v > %v)", dataPos, len(data))
+	}
+	xa.OnePhase = data[0] != 0
+	xa.FormatID = binary.LittleEndian.Uint32(data[1 : 1+4])
+	gtridLen := uint64(binary.LittleEndian.Uint32(data[1+4 : 1+4+4]))
+	bqualLen := uint64(binary.LittleEndian.Uint32(data[1+4+4 : dataPos]))
+	if dataPos+gtridLen+bqualLen > uint64(len(data)) {
+		return xa, fmt.Errorf("XA prepare XID overflows buffer (%v + %v + %v > %v)", dataPos, gtridLen, bqualLen, len(data))
+	}
+	bqualPos := dataPos + int(gtridLen)
+	xa.GTRID = append([]byte(nil), data[dataPos:bqualPos]...)
+	xa.BQual = append([]byte(nil), data[bqualPos:bqualPos+int(bqualLen)]...)
+	return xa, nil
+}
+
 // IsBeginGTID implements BinlogEvent.IsBeginGTID().
 func (ev binlogEvent) IsBeginGTID(f replication.BinlogFormat) bool {
 	return false
 }
 
+// LogicalClock implements BinlogEvent.LogicalClock().
+func (ev binlogEvent) LogicalClock(f replication.BinlogFormat) (lastCommitted, sequenceNumber int64, ok bool) {
+	return 0, 0, false
+}
+
+// CommitTimestamps implements BinlogEvent.CommitTimestamps().
+func (ev binlogEvent) CommitTimestamps(f replication.BinlogFormat) (original, immediate int64, ok bool) {
+	return 0, 0, false
+}
+
 // These constants are common between MariaDB 10.0 and MySQL 5.6.
 const (
 	// BinlogChecksumAlgOff indicates that checksums are supported but off.
@@ -5,7 +5,9 @@
 package mysqlctl
 
 import (
+	"encoding/binary"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
@@ -20,12 +22,16 @@ var (
 	googleQueryEvent   = []byte{0x53, 0x52, 0xe9, 0x53, 0x2, 0x88, 0xf3, 0x0, 0x0, 0xad, 0x0, 0x0, 0x0, 0x9a, 0x4, 0x0, 0x0, 0x0, 0x0, 0xb, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1b, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x40, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x8, 0x0, 0x8, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x20, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x20, 0x69, 0x66, 0x20, 0x6e, 0x6f, 0x74, 0x20, 0x65, 0x78, 0x69, 0x73, 0x74, 0x73, 0x20, 0x76, 0x74, 0x5f, 0x61, 0x20, 0x28, 0xa, 0x65, 0x69, 0x64, 0x20, 0x62, 0x69, 0x67, 0x69, 0x6e, 0x74, 0x2c, 0xa, 0x69, 0x64, 0x20, 0x69, 0x6e, 0x74, 0x2c, 0xa, 0x70, 0x72, 0x69, 0x6d, 0x61, 0x72, 0x79, 0x20, 0x6b, 0x65, 0x79, 0x28, 0x65, 0x69, 0x64, 0x2c, 0x20, 0x69, 0x64, 0x29, 0xa, 0x29, 0x20, 0x45, 0x6e, 0x67, 0x69, 0x6e, 0x65, 0x3d, 0x49, 0x6e, 0x6e, 0x6f, 0x44, 0x42}
 	googleXIDEvent     = []byte{0x53, 0x52, 0xe9, 0x53, 0x10, 0x88, 0xf3, 0x0, 0x0, 0x23, 0x0, 0x0, 0x0, 0x4e, 0xa, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x78, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
 	googleIntVarEvent1 = []byte{0xea, 0xa8, 0xea, 0x53, 0x5, 0x88, 0xf3, 0x0, 0x0, 0x24, 0x0, 0x0, 0x0, 0xb8, 0x6, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x65, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
+	googleLostEvents   = []byte{0x53, 0x52, 0xe9, 0x53, 0x1a, 0x88, 0xf3, 0x0, 0x0, 0x3d, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x1f, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x20, 0x77, 0x72, 0x69, 0x74, 0x69, 0x6e, 0x67, 0x20, 0x74, 0x6f, 0x20, 0x74, 0x68, 0x65, 0x20, 0x62, 0x69, 0x6e, 0x61, 0x72, 0x79, 0x20, 0x6c, 0x6f, 0x67}
+	googleStopEvent    = []byte{0x53, 0x52, 0xe9, 0x53, 0x3, 0x88, 0xf3, 0x0, 0x0, 0x1b, 0x0, 0x0, 0x0, 0x69, 0xa, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
 	googleIntVarEvent2 = []byte{0xea, 0xa8, 0xea, 0x53, 0x5, 0x88, 0xf3, 0x0, 0x0, 0x24, 0x0, 0x0, 0x0, 0xb8, 0x6, 0x0, 0x0, 0x0, 0x0, 0xd, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x65, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
 
 	mariadbFormatEvent         = []byte{0x87, 0x41, 0x9, 0x54, 0xf, 0x88, 0xf3, 0x0, 0x0, 0xf4, 0x0, 0x0, 0x0, 0xf8, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x0, 0x31, 0x30, 0x2e, 0x30, 0x2e, 0x31, 0x33, 0x2d, 0x4d, 0x61, 0x72, 0x69, 0x61, 0x44, 0x42, 0x2d, 0x31, 0x7e, 0x70, 0x72, 0x65, 0x63, 0x69, 0x73, 0x65, 0x2d, 0x6c, 0x6f, 0x67, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x87, 0x41, 0x9, 0x54, 0x13, 0x38, 0xd, 0x0, 0x8, 0x0, 0x12, 0x0, 0x4, 0x4, 0x4, 0x4, 0x12, 0x0, 0x0, 0xdc, 0x0, 0x4, 0x1a, 0x8, 0x0, 0x0, 0x0, 0x8, 0x8, 0x8, 0x2, 0x0, 0x0, 0x0, 0xa, 0xa, 0xa, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x13, 0x4, 0x0, 0x6e, 0xe0, 0xfd, 0x41}
 	mariadbStandaloneGTIDEvent = []byte{0x88, 0x41, 0x9, 0x54, 0xa2, 0x88, 0xf3, 0x0, 0x0, 0x26, 0x0, 0x0, 0x0, 0xcf, 0x8, 0x0, 0x0, 0x8, 0x0, 0x9, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
 	mariadbBeginGTIDEvent      = []byte{0x88, 0x41, 0x9, 0x54, 0xa2, 0x88, 0xf3, 0x0, 0x0, 0x26, 0x0, 0x0, 0x0, 0xb5, 0x9, 0x0, 0x0, 0x8, 0x0, 0xa, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
 	mariadbInsertEvent         = []byte{0x88, 0x41, 0x9, 0x54, 0x2, 0x88, 0xf3, 0x0, 0x0, 0xa8, 0x0, 0x0, 0x0, 0x79, 0xa, 0x0, 0x0, 0x0, 0x0, 0x27, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x21, 0x0, 0x21, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x20, 0x69, 0x6e, 0x74, 0x6f, 0x20, 0x76, 0x74, 0x5f, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x28, 0x6d, 0x73, 0x67, 0x29, 0x20, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x20, 0x28, 0x27, 0x74, 0x65, 0x73, 0x74, 0x20, 0x30, 0x27, 0x29, 0x20, 0x2f, 0x2a, 0x20, 0x5f, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x20, 0x76, 0x74, 0x5f, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x20, 0x28, 0x69, 0x64, 0x20, 0x29, 0x20, 0x28, 0x6e, 0x75, 0x6c, 0x6c, 0x20, 0x29, 0x3b, 0x20, 0x2a, 0x2f}
+	mariadbTableMapEvent       = []byte{0x88, 0x41, 0x9, 0x54, 0x13, 0x88, 0xf3, 0x0, 0x0, 0x44, 0x0, 0x0, 0x0, 0xc2, 0x1, 0x0, 0x0, 0x0, 0x0, 0x4d, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x10, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x4, 0x76, 0x74, 0x5f, 0x61, 0x0, 0x6, 0x8, 0xf, 0xf6, 0x12, 0xfc, 0xfe, 0x8, 0x80, 0x1, 0xa, 0x2, 0x0, 0x2, 0xf7, 0x1, 0x16}
+	mariadbXAPrepareEvent      = []byte{0x88, 0x41, 0x9, 0x54, 0x26, 0x88, 0xf3, 0x0, 0x0, 0x26, 0x0, 0x0, 0x0, 0xf0, 0x2, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0, 0x4, 0x0, 0x0, 0x0, 0x2, 0x0, 0x0, 0x0, 0x74, 0x72, 0x78, 0x31, 0x62, 0x31}
 
 	mariadbChecksumFormatEvent        = []byte{0x22, 0xe5, 0x3e, 0x54, 0xf, 0x8b, 0xf3, 0x0, 0x0, 0xf4, 0x0, 0x0, 0x0, 0xf8, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x0, 0x31, 0x30, 0x2e, 0x30, 0x2e, 0x31, 0x33, 0x2d, 0x4d, 0x61, 0x72, 0x69, 0x61, 0x44, 0x42, 0x2d, 0x31, 0x7e, 0x70, 0x72, 0x65, 0x63, 0x69, 0x73, 0x65, 0x2d, 0x6c, 0x6f, 0x67, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x13, 0x38, 0xd, 0x0, 0x8, 0x0, 0x12, 0x0, 0x4, 0x4, 0x4, 0x4, 0x12, 0x0, 0x0, 0xdc, 0x0, 0x4, 0x1a, 0x8, 0x0, 0x0, 0x0, 0x8, 0x8, 0x8, 0x2, 0x0, 0x0, 0x0, 0xa, 0xa, 0xa, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x13, 0x4, 0x1, 0x14, 0x13, 0x32, 0xdc}
 	mariadbChecksumQueryEvent         = []byte{0x22, 0xe5, 0x3e, 0x54, 0x2, 0x8a, 0xf3, 0x0, 0x0, 0xd9, 0x0, 0x0, 0x0, 0x69, 0x2, 0x0, 0x0, 0x0, 0x0, 0x1d, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x8, 0x0, 0x8, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x55, 0x50, 0x44, 0x41, 0x54, 0x45, 0x20, 0x5f, 0x76, 0x74, 0x2e, 0x62, 0x6c, 0x70, 0x5f, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x20, 0x53, 0x45, 0x54, 0x20, 0x70, 0x6f, 0x73, 0x3d, 0x27, 0x4d, 0x61, 0x72, 0x69, 0x61, 0x44, 0x42, 0x2f, 0x30, 0x2d, 0x36, 0x32, 0x33, 0x34, 0x34, 0x2d, 0x31, 0x34, 0x27, 0x2c, 0x20, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x3d, 0x31, 0x34, 0x31, 0x33, 0x34, 0x30, 0x38, 0x30, 0x33, 0x34, 0x2c, 0x20, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x3d, 0x31, 0x34, 0x31, 0x33, 0x34, 0x30, 0x38, 0x30, 0x33, 0x34, 0x20, 0x57, 0x48, 0x45, 0x52, 0x45, 0x20, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x5f, 0x73, 0x68, 0x61, 0x72, 0x64, 0x5f, 0x75, 0x69, 0x64, 0x3d, 0x30, 0xce, 0x49, 0x7a, 0x53}
@@ -184,6 +190,83 @@ func TestBinlogEventIsNotXID(t *testing.T) {
 	}
 }
 
+func TestBinlogEventIsStop(t *testing.T) {
+	input := binlogEvent(googleStopEvent)
+	want := true
+	if got := input.IsStop(); got != want {
+		t.Errorf("%#v.IsStop() = %v, want %v", input, got, want)
+	}
+}
+
+func TestBinlogEventIsNotStop(t *testing.T) {
+	input := binlogEvent(googleXIDEvent)
+	want := false
+	if got := input.IsStop(); got != want {
+		t.Errorf("%#v.IsStop() = %v, want %v", input, got, want)
+	}
+}
+
+func TestBinlogEventIsIncident(t *testing.T) {
+	input := binlogEvent(googleLostEvents)
+	want := true
+	if got := input.IsIncident(); got != want {
+		t.Errorf("%#v.IsIncident() = %v, want %v", input, got, want)
+	}
+}
+
+func TestBinlogEventIsNotIncident(t *testing.T) {
+	input := binlogEvent(googleStopEvent)
+	want := false
+	if got := input.IsIncident(); got != want {
+		t.Errorf("%#v.IsIncident() = %v, want %v", input, got, want)
+	}
+}
+
+func TestBinlogEventIncident(t *testing.T) {
+	f, err := binlogEvent(googleFormatEvent).Format()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	input := binlogEvent(googleLostEvents)
+	if !input.IsValid() {
+		t.Fatalf("%#v.IsValid() = false, want true", input)
+	}
+	incidentType, message, err := input.Incident(f)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	// 1 is INCIDENT_LOST_EVENTS.
+	if incidentType != 1 || message != "error writing to the binary log" {
+		t.Errorf("%#v.Incident() = (%v, %#v), want (1, %#v)", input, incidentType, message, "error writing to the binary log")
+	}
+}
+
+func TestBinlogEventIncidentBadLength(t *testing.T) {
+	f, err := binlogEvent(googleFormatEvent).Format()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	buf := make([]byte, len(googleLostEvents))
+	copy(buf, googleLostEvents)
+	buf[27+2] = 200 // mess up the message length
+
+	input := binlogEvent(buf)
+	want := "incident message overflows buffer (203 > 34)"
+	_, _, err = input.Incident(f)
+	if err == nil {
+		t.Errorf("expected error, got none")
+		return
+	}
+	if got := err.Error(); got != want {
+		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	}
+}
+
 func TestBinlogEventFormat(t *testing.T) {
 	input := binlogEvent(googleFormatEvent)
 	want := replication.BinlogFormat{
@@ -261,6 +344,259 @@ primary key(eid, id)
 	}
 }
 
+func TestBinlogEventQueryInvoker(t *testing.T) {
+	f := replication.BinlogFormat{HeaderLength: 19}
+
+	vars := []byte{
+		0x4, 0x21, 0x0, 0x21, 0x0, 0x8, 0x0, // Q_CHARSET_CODE
+		0x5, 0x6, 'S', 'Y', 'S', 'T', 'E', 'M', // Q_TIME_ZONE_CODE
+		0x7, 0x0, 0x0, // Q_LC_TIME_NAMES_CODE
+		0xb, 0x4, 'v', 't', '_', 'a', 0x9, 'l', 'o', 'c', 'a', 'l', 'h', 'o', 's', 't', // Q_INVOKER
+	}
+	buf := make([]byte, 19+13)
+	buf[19+8] = 2 // length of db_name
+	binary.LittleEndian.PutUint16(buf[19+11:], uint16(len(vars)))
+	buf = append(buf, vars...)
+	buf = append(buf, "vt\x00GRANT SELECT ON *.* TO 'vt_b'@'%'"...)
+
+	input := binlogEvent(buf)
+	want := replication.Query{
+		Database:    "vt",
+		Charset:     &binlogdatapb.Charset{Client: 33, Conn: 33, Server: 8},
+		SQL:         "GRANT SELECT ON *.* TO 'vt_b'@'%'",
+		InvokerUser: "vt_a",
+		InvokerHost: "localhost",
+	}
+	got, err := input.Query(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%#v.Query() = %#v, want %#v", input, got, want)
+	}
+
+	// A truncated Q_INVOKER is an error.
+	binary.LittleEndian.PutUint16(buf[19+11:], uint16(len(vars)-3))
+	if _, err := binlogEvent(buf).Query(f); err == nil || !strings.HasPrefix(err.Error(), "Q_INVOKER status var overflows buffer") {
+		t.Errorf("Query() with a truncated Q_INVOKER = %v, want overflow error", err)
+	}
+}
+
+func TestBinlogEventQueryMasterError(t *testing.T) {
+	f, err := binlogEvent(googleFormatEvent).Format()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	buf := make([]byte, len(googleQueryEvent))
+	copy(buf, googleQueryEvent)
+	// set the execution time and error code
+	binary.LittleEndian.PutUint32(buf[19+8+4:], 3)
+	binary.LittleEndian.PutUint16(buf[19+8+4+4+1:], 1062)
+
+	input := binlogEvent(buf)
+	got, err := input.Query(f)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if got.ExecutionTime != 3 || got.ErrorCode != 1062 {
+		t.Errorf("%#v.Query() = %v, want ExecutionTime 3 and ErrorCode 1062", input, got)
+	}
+	if got.Database != "vt_test_keyspace" {
+		t.Errorf("%#v.Query().Database = %#v, want %#v", input, got.Database, "vt_test_keyspace")
+	}
+}
+
+func TestBinlogEventLength(t *testing.T) {
+	testcases := []struct {
+		input binlogEvent
+		want  uint32
+	}{
+		{binlogEvent(googleQueryEvent), uint32(len(googleQueryEvent))},
+		// Truncated events still report the length from their header.
+		{binlogEvent(googleQueryEvent[:19]), uint32(len(googleQueryEvent))},
+		{binlogEvent(googleQueryEvent[:10]), 0},
+		{binlogEvent(nil), 0},
+	}
+	for _, tcase := range testcases {
+		if got := tcase.input.Length(); got != tcase.want {
+			t.Errorf("%#v.Length() = %v, want %v", tcase.input, got, tcase.want)
+		}
+	}
+}
+
+func TestBinlogEventNextPosition(t *testing.T) {
+	input := binlogEvent(googleQueryEvent)
+	want := uint32(0x049a)
+	if got := input.NextPosition(); got != want {
+		t.Errorf("%#v.NextPosition() = %v, want %v", input, got, want)
+	}
+}
+
+func TestBinlogEventRotate(t *testing.T) {
+	// The artificial ROTATE_EVENT at the start of a stream comes before the
+	// FORMAT_DESCRIPTION_EVENT, and has a plain v4 header.
+	f := replication.BinlogFormat{HeaderLength: 19}
+	input := binlogEvent(googleRotateEvent)
+	position, fileName, err := input.Rotate(f)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if want := uint64(0x323); position != want {
+		t.Errorf("%#v.Rotate() position = %v, want %v", input, position, want)
+	}
+	if want := "vt-0000062344-bin.000001"; fileName != want {
+		t.Errorf("%#v.Rotate() file name = %#v, want %#v", input, fileName, want)
+	}
+}
+
+func TestBinlogEventRotateBadLength(t *testing.T) {
+	f := replication.BinlogFormat{HeaderLength: 19}
+	input := binlogEvent(googleRotateEvent[:19+7])
+	want := "rotate position overflows buffer (8 > 7)"
+	_, _, err := input.Rotate(f)
+	if err == nil {
+		t.Errorf("expected error, got none")
+		return
+	}
+	if got := err.Error(); got != want {
+		t.Errorf("wrong error, got %#v, want %#v", got, want)
+	}
+}
+
+func TestBinlogEventIsTableMap(t *testing.T) {
+	input := binlogEvent(mariadbTableMapEvent)
+	want := true
+	if got := input.IsTableMap(); got != want {
+		t.Errorf("%#v.IsTableMap() = %v, want %v", input, got, want)
+	}
+}
+
+func TestBinlogEventIsNotTableMap(t *testing.T) {
+	input := binlogEvent(mariadbInsertEvent)
+	want := false
+	if got := input.IsTableMap(); got != want {
+		t.Errorf("%#v.IsTableMap() = %v, want %v", input, got, want)
+	}
+}
+
+func TestBinlogEventTableMap(t *testing.T) {
+	// The table is:
+	//   id bigint not null,
+	//   name varchar(128) character set utf8,
+	//   price decimal(10,2),
+	//   created datetime not null,
+	//   data blob,
+	//   flag enum('a','b') not null
+	f := replication.BinlogFormat{HeaderLength: 19}
+	input := binlogEvent(mariadbTableMapEvent)
+	want := replication.TableMap{
+		TableID:   0x4d,
+		Flags:     1,
+		Database:  "vt_test_keyspace",
+		Name:      "vt_a",
+		Types:     []byte{8, 15, 246, 18, 252, 254},
+		Metadata:  []uint16{0, 384, 10<<8 | 2, 0, 2, 247<<8 | 1},
+		CanBeNull: []bool{false, true, true, false, true, false},
+	}
+	got, err := input.TableMap(f)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%#v.TableMap() = %#v, want %#v", input, got, want)
+	}
+}
+
+func TestBinlogEventTableMapBadLength(t *testing.T) {
+	f := replication.BinlogFormat{HeaderLength: 19}
+	testcases := []struct {
+		length int
+		want   string
+	}{
+		{19 + 7, "table map header overflows buffer (9 > 7)"},
+		{19 + 20, "table map db name: name overflows buffer (25 + 1 > 20)"},
+		{19 + 34, "table map column types overflow buffer (33 + 6 > 34)"},
+		{19 + 44, "table map metadata overflows buffer (40 + 8 > 44)"},
+		{19 + 48, "table map null bitmap overflows buffer (48 + 1 > 48)"},
+	}
+	for _, tcase := range testcases {
+		input := binlogEvent(mariadbTableMapEvent[:tcase.length])
+		_, err := input.TableMap(f)
+		if err == nil {
+			t.Errorf("length %v: expected error, got none", tcase.length)
+			continue
+		}
+		if got := err.Error(); got != tcase.want {
+			t.Errorf("length %v: wrong error, got %#v, want %#v", tcase.length, got, tcase.want)
+		}
+	}
+}
+
+func TestBinlogEventIsXAPrepare(t *testing.T) {
+	input := binlogEvent(mariadbXAPrepareEvent)
+	want := true
+	if got := input.IsXAPrepare(); got != want {
+		t.Errorf("%#v.IsXAPrepare() = %v, want %v", input, got, want)
+	}
+}
+
+func TestBinlogEventIsNotXAPrepare(t *testing.T) {
+	input := binlogEvent(mariadbInsertEvent)
+	want := false
+	if got := input.IsXAPrepare(); got != want {
+		t.Errorf("%#v.IsXAPrepare() = %v, want %v", input, got, want)
+	}
+}
+
+func TestBinlogEventXAPrepare(t *testing.T) {
+	f := replication.BinlogFormat{HeaderLength: 19}
+	input := binlogEvent(mariadbXAPrepareEvent)
+	want := replication.XAPrepare{
+		OnePhase: false,
+		FormatID: 1,
+		GTRID:    []byte("trx1"),
+		BQual:    []byte("b1"),
+	}
+	got, err := input.XAPrepare(f)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%#v.XAPrepare() = %#v, want %#v", input, got, want)
+	}
+	if got, want := got.XID(), "X'74727831',X'6231',1"; got != want {
+		t.Errorf("XID() = %v, want %v", got, want)
+	}
+}
+
+func TestBinlogEventXAPrepareBadLength(t *testing.T) {
+	f := replication.BinlogFormat{HeaderLength: 19}
+	testcases := []struct {
+		length int
+		want   string
+	}{
+		{19 + 10, "XA prepare header overflows buffer (13 > 10)"},
+		{19 + 16, "XA prepare XID overflows buffer (13 + 4 + 2 > 16)"},
+	}
+	for _, tcase := range testcases {
+		input := binlogEvent(mariadbXAPrepareEvent[:tcase.length])
+		_, err := input.XAPrepare(f)
+		if err == nil {
+			t.Errorf("length %v: expected error, got none", tcase.length)
+			continue
+		}
+		if got := err.Error(); got != tcase.want {
+			t.Errorf("length %v: wrong error, got %#v, want %#v", tcase.length, got, tcase.want)
+		}
+	}
+}
+
 func TestBinlogEventQueryBadLength(t *testing.T) {
 	f, err := binlogEvent(googleFormatEvent).Format()
 	if err != nil {
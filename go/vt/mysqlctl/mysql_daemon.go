@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/youtube/vitess/go/mysql"
 	"github.com/youtube/vitess/go/sqldb"
 	"github.com/youtube/vitess/go/sqltypes"
 	"github.com/youtube/vitess/go/stats"
@@ -82,6 +84,10 @@ type MysqlDaemon interface {
 	// NewSlaveConnection returns a SlaveConnection to the database.
 	NewSlaveConnection() (*SlaveConnection, error)
 
+	// NewSlaveConnectionWithOptions returns a SlaveConnection to the
+	// database with the given settings.
+	NewSlaveConnectionWithOptions(opts SlaveConnectionOptions) (*SlaveConnection, error)
+
 	// EnableBinlogPlayback enables playback of binlog events
 	EnableBinlogPlayback() error
 
@@ -207,6 +213,20 @@ type FakeMysqlDaemon struct {
 	SemiSyncMasterEnabled bool
 	// SemiSyncSlaveEnabled represents the state of rpl_semi_sync_slave_enabled.
 	SemiSyncSlaveEnabled bool
+
+	// SlaveConnectionOptions is set by NewSlaveConnectionWithOptions
+	SlaveConnectionOptions SlaveConnectionOptions
+
+	// BinlogDump, if set, makes NewSlaveConnection and
+	// NewSlaveConnectionWithOptions return a SlaveConnection of the MariaDB
+	// flavor on a fake connection. Its binlog dump reads the packets sent
+	// on BinlogDump, i.e. a 1-byte OK header followed by an event, until
+	// it's closed or the connection is.
+	BinlogDump chan []byte
+
+	// BinlogDumpError, if set, is returned by the reads of the binlog dump
+	// once BinlogDump is closed, instead of the EOF packet that ends a dump.
+	BinlogDumpError error
 }
 
 // NewFakeMysqlDaemon returns a FakeMysqlDaemon where mysqld appears
@@ -389,9 +409,71 @@ func (fmd *FakeMysqlDaemon) FetchSuperQuery(query string) (*sqltypes.Result, err
 
 // NewSlaveConnection is part of the MysqlDaemon interface
 func (fmd *FakeMysqlDaemon) NewSlaveConnection() (*SlaveConnection, error) {
+	if fmd.BinlogDump != nil {
+		return fmd.NewSlaveConnectionWithOptions(SlaveConnectionOptions{})
+	}
 	panic(fmt.Errorf("not implemented on FakeMysqlDaemon"))
 }
 
+// NewSlaveConnectionWithOptions is part of the MysqlDaemon interface.
+// It records opts in SlaveConnectionOptions, and returns an error, unless
+// BinlogDump is set.
+func (fmd *FakeMysqlDaemon) NewSlaveConnectionWithOptions(opts SlaveConnectionOptions) (*SlaveConnection, error) {
+	fmd.SlaveConnectionOptions = opts
+	if fmd.BinlogDump == nil {
+		return nil, fmt.Errorf("not implemented on FakeMysqlDaemon")
+	}
+	return &SlaveConnection{
+		Conn:        &fakeDumpConn{packets: fmd.BinlogDump, endErr: fmd.BinlogDumpError, shutdown: make(chan struct{})},
+		mysqld:      &Mysqld{mysqlFlavor: &mariaDB10{}},
+		slaveID:     slaveIDPool.Get(),
+		readTimeout: opts.ReadTimeout,
+	}, nil
+}
+
+// fakeDumpConn is the sqldb.Conn of the SlaveConnections of
+// FakeMysqlDaemon.BinlogDump. Its queries and commands succeed, and
+// ReadPacket returns the packets of the dump.
+type fakeDumpConn struct {
+	sqldb.Conn
+	packets  chan []byte
+	endErr   error
+	shutdown chan struct{}
+	once     sync.Once
+}
+
+func (c *fakeDumpConn) ExecuteFetch(query string, maxrows int, wantfields bool) (*sqltypes.Result, error) {
+	return &sqltypes.Result{}, nil
+}
+
+func (c *fakeDumpConn) SendCommand(command uint32, data []byte) error {
+	return nil
+}
+
+func (c *fakeDumpConn) ReadPacket() ([]byte, error) {
+	select {
+	case buf, ok := <-c.packets:
+		if ok {
+			return buf, nil
+		}
+		if c.endErr != nil {
+			return nil, c.endErr
+		}
+		// mysqld ends a dump with an EOF packet.
+		return []byte{254}, nil
+	case <-c.shutdown:
+		return nil, &sqldb.SQLError{Num: mysql.ErrServerLost, Message: "Lost connection to MySQL server during query"}
+	}
+}
+
+func (c *fakeDumpConn) Shutdown() {
+	c.once.Do(func() { close(c.shutdown) })
+}
+
+func (c *fakeDumpConn) Close() {
+	c.Shutdown()
+}
+
 // EnableBinlogPlayback is part of the MysqlDaemon interface
 func (fmd *FakeMysqlDaemon) EnableBinlogPlayback() error {
 	if fmd.BinlogPlayerEnabled {
@@ -200,6 +200,60 @@ func (ev mysql56BinlogEvent) GTID(f replication.BinlogFormat) (replication.GTID,
 	return replication.Mysql56GTID{Server: sid, Sequence: gno}, nil
 }
 
+// LogicalClock implements BinlogEvent.LogicalClock().
+//
+// MySQL 5.7 adds these fields after the GTID:
+//   # bytes   field
+//   1         lt_type (2 for LOGICAL_TIMESTAMP_TYPECODE)
+//   8         last_committed (signed int)
+//   8         sequence_number (signed int)
+func (ev mysql56BinlogEvent) LogicalClock(f replication.BinlogFormat) (lastCommitted, sequenceNumber int64, ok bool) {
+	data := ev.Bytes()[f.HeaderLength:]
+	const pos = 1 + 16 + 8
+	if len(data) < pos+1+8+8 || data[pos] != 2 {
+		return 0, 0, false
+	}
+	lastCommitted = int64(binary.LittleEndian.Uint64(data[pos+1 : pos+1+8]))
+	sequenceNumber = int64(binary.LittleEndian.Uint64(data[pos+1+8 : pos+1+8+8]))
+	return lastCommitted, sequenceNumber, true
+}
+
+// CommitTimestamps implements BinlogEvent.CommitTimestamps().
+//
+// MySQL 8.0 adds these fields after the logical clock:
+//   # bytes   field
+//   7         immediate_commit_timestamp (microseconds), with the highest
+//             bit set if original_commit_timestamp follows
+//   7         original_commit_timestamp (microseconds), only if it's not
+//             the same
+func (ev mysql56BinlogEvent) CommitTimestamps(f replication.BinlogFormat) (original, immediate int64, ok bool) {
+	data := ev.Bytes()[f.HeaderLength:]
+	const clockPos = 1 + 16 + 8
+	const pos = clockPos + 1 + 8 + 8
+	if len(data) < pos+7 || data[clockPos] != 2 {
+		return 0, 0, false
+	}
+	immediate = readUint56(data[pos : pos+7])
+	original = immediate
+	if immediate&(1<<55) != 0 {
+		immediate &^= 1 << 55
+		if len(data) < pos+7+7 {
+			return 0, 0, false
+		}
+		original = readUint56(data[pos+7 : pos+7+7])
+	}
+	return original, immediate, true
+}
+
+// readUint56 reads a 7-byte little-endian integer.
+func readUint56(data []byte) int64 {
+	var v int64
+	for i := 6; i >= 0; i-- {
+		v = v<<8 | int64(data[i])
+	}
+	return v
+}
+
 // StripChecksum implements BinlogEvent.StripChecksum().
 func (ev mysql56BinlogEvent) StripChecksum(f replication.BinlogFormat) (replication.BinlogEvent, []byte, error) {
 	switch f.ChecksumAlgorithm {
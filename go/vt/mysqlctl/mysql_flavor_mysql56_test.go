@@ -17,7 +17,14 @@ import (
 var (
 	mysql56FormatEvent = NewMysql56BinlogEvent([]byte{0x78, 0x4e, 0x49, 0x55, 0xf, 0x64, 0x0, 0x0, 0x0, 0x74, 0x0, 0x0, 0x0, 0x78, 0x0, 0x0, 0x0, 0x1, 0x0, 0x4, 0x0, 0x35, 0x2e, 0x36, 0x2e, 0x32, 0x34, 0x2d, 0x6c, 0x6f, 0x67, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x78, 0x4e, 0x49, 0x55, 0x13, 0x38, 0xd, 0x0, 0x8, 0x0, 0x12, 0x0, 0x4, 0x4, 0x4, 0x4, 0x12, 0x0, 0x0, 0x5c, 0x0, 0x4, 0x1a, 0x8, 0x0, 0x0, 0x0, 0x8, 0x8, 0x8, 0x2, 0x0, 0x0, 0x0, 0xa, 0xa, 0xa, 0x19, 0x19, 0x0, 0x1, 0x18, 0x4a, 0xf, 0xca})
 	mysql56GTIDEvent   = NewMysql56BinlogEvent([]byte{0xff, 0x4e, 0x49, 0x55, 0x21, 0x64, 0x0, 0x0, 0x0, 0x30, 0x0, 0x0, 0x0, 0xf5, 0x2, 0x0, 0x0, 0x0, 0x0, 0x1, 0x43, 0x91, 0x92, 0xbd, 0xf3, 0x7c, 0x11, 0xe4, 0xbb, 0xeb, 0x2, 0x42, 0xac, 0x11, 0x3, 0x5a, 0x4, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x48, 0x45, 0x82, 0x27})
+	mysql57GTIDEvent   = NewMysql56BinlogEvent([]byte{0xff, 0x4e, 0x49, 0x55, 0x21, 0x64, 0x0, 0x0, 0x0, 0x41, 0x0, 0x0, 0x0, 0x36, 0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0x43, 0x91, 0x92, 0xbd, 0xf3, 0x7c, 0x11, 0xe4, 0xbb, 0xeb, 0x2, 0x42, 0xac, 0x11, 0x3, 0x5a, 0x4, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x7, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x9, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x42, 0x4d, 0x0, 0xdb})
 	mysql56QueryEvent  = NewMysql56BinlogEvent([]byte{0xff, 0x4e, 0x49, 0x55, 0x2, 0x64, 0x0, 0x0, 0x0, 0x77, 0x0, 0x0, 0x0, 0xdb, 0x3, 0x0, 0x0, 0x0, 0x0, 0x3d, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x0, 0x0, 0x21, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x8, 0x0, 0x8, 0x0, 0x21, 0x0, 0xc, 0x1, 0x74, 0x65, 0x73, 0x74, 0x0, 0x74, 0x65, 0x73, 0x74, 0x0, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x20, 0x69, 0x6e, 0x74, 0x6f, 0x20, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x20, 0x28, 0x6d, 0x73, 0x67, 0x29, 0x20, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x20, 0x28, 0x27, 0x68, 0x65, 0x6c, 0x6c, 0x6f, 0x27, 0x29, 0x92, 0x12, 0x79, 0xc3})
+
+	// mysql80GTIDEvent is the GTID_EVENT of a MySQL 8.0 server with
+	// binlog_transaction_dependency_tracking=WRITESET. It has the commit
+	// timestamp, the transaction length and the server version after the
+	// logical clock.
+	mysql80GTIDEvent = NewMysql56BinlogEvent([]byte{0xff, 0x4e, 0x49, 0x55, 0x21, 0x64, 0x0, 0x0, 0x0, 0x4f, 0x0, 0x0, 0x0, 0x44, 0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0x43, 0x91, 0x92, 0xbd, 0xf3, 0x7c, 0x11, 0xe4, 0xbb, 0xeb, 0x2, 0x42, 0xac, 0x11, 0x3, 0x5a, 0x4, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x2, 0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xa, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x60, 0xa4, 0xe2, 0x9a, 0x15, 0x5, 0xfc, 0x3c, 0x1, 0x93, 0x38, 0x1, 0x0, 0x6a, 0x6e, 0xe5, 0x7})
 )
 
 func TestMysql56IsGTID(t *testing.T) {
@@ -96,6 +103,74 @@ func TestMysql56GTID(t *testing.T) {
 	}
 }
 
+func TestMysql56LogicalClock(t *testing.T) {
+	format, err := mysql56FormatEvent.Format()
+	if err != nil {
+		t.Fatalf("Format() error: %v", err)
+	}
+	testcases := []struct {
+		input              replication.BinlogEvent
+		wantLastCommitted  int64
+		wantSequenceNumber int64
+		wantOK             bool
+	}{
+		{mysql57GTIDEvent, 7, 9, true},
+		// With WRITESET, last_committed is the last transaction that
+		// changed the same rows, so it can be far behind sequence_number.
+		{mysql80GTIDEvent, 3, 10, true},
+		// MySQL 5.6 doesn't have a logical clock.
+		{mysql56GTIDEvent, 0, 0, false},
+	}
+	for _, tcase := range testcases {
+		input, _, err := tcase.input.StripChecksum(format)
+		if err != nil {
+			t.Fatalf("StripChecksum() error: %v", err)
+		}
+		lastCommitted, sequenceNumber, ok := input.LogicalClock(format)
+		if lastCommitted != tcase.wantLastCommitted || sequenceNumber != tcase.wantSequenceNumber || ok != tcase.wantOK {
+			t.Errorf("%#v.LogicalClock() = (%v, %v, %v), want (%v, %v, %v)", input, lastCommitted, sequenceNumber, ok, tcase.wantLastCommitted, tcase.wantSequenceNumber, tcase.wantOK)
+		}
+	}
+	// The logical clock doesn't change the GTID.
+	want, _ := (&mysql56{}).ParseGTID("439192bd-f37c-11e4-bbeb-0242ac11035a:4")
+	for _, input := range []replication.BinlogEvent{mysql57GTIDEvent, mysql80GTIDEvent} {
+		if got, err := input.GTID(format); err != nil || got != want {
+			t.Errorf("GTID() = (%#v, %v), want %#v", got, err, want)
+		}
+	}
+}
+
+func TestMysql56CommitTimestamps(t *testing.T) {
+	format, err := mysql56FormatEvent.Format()
+	if err != nil {
+		t.Fatalf("Format() error: %v", err)
+	}
+	// On a slave, the original_commit_timestamp of the master follows the
+	// immediate_commit_timestamp.
+	relayed := append([]byte(nil), mysql80GTIDEvent.(mysql56BinlogEvent).Bytes()[:int(format.HeaderLength)+42]...)
+	relayed = append(relayed, 0x40, 0xe2, 0x1, 0x0, 0x0, 0x0, 0x80)
+	relayed = append(relayed, 0x0, 0x60, 0xa4, 0xe2, 0x9a, 0x15, 0x5)
+	testcases := []struct {
+		input         replication.BinlogEvent
+		wantOriginal  int64
+		wantImmediate int64
+		wantOK        bool
+	}{
+		{mysql80GTIDEvent, 1431129855123456, 1431129855123456, true},
+		{NewMysql56BinlogEvent(relayed), 1431129855123456, 123456, true},
+		// MySQL 5.7 and 5.6 don't have them.
+		{mysql57GTIDEvent, 0, 0, false},
+		{mysql56GTIDEvent, 0, 0, false},
+	}
+	// The relayed event has no checksum, so the checksums aren't stripped.
+	for _, tcase := range testcases {
+		original, immediate, ok := tcase.input.CommitTimestamps(format)
+		if original != tcase.wantOriginal || immediate != tcase.wantImmediate || ok != tcase.wantOK {
+			t.Errorf("%#v.CommitTimestamps() = (%v, %v, %v), want (%v, %v, %v)", tcase.input, original, immediate, ok, tcase.wantOriginal, tcase.wantImmediate, tcase.wantOK)
+		}
+	}
+}
+
 func TestMysql56ParseGTID(t *testing.T) {
 	input := "00010203-0405-0607-0809-0A0B0C0D0E0F:56789"
 	want := replication.Mysql56GTID{
@@ -33,6 +33,11 @@ type BinlogEvent interface {
 	// only be called if this one returns true. This ensures you won't get panics
 	// due to bounds checking on the byte array.
 	IsValid() bool
+	// Length returns the event length declared in the event header, or 0 if
+	// the buffer is too short to contain a header. Unlike the other methods,
+	// it can be called when IsValid() returns false, to tell an oversized or
+	// truncated event apart from garbage.
+	Length() uint32
 
 	// IsFormatDescription returns true if this is a FORMAT_DESCRIPTION_EVENT.
 	IsFormatDescription() bool
@@ -50,13 +55,31 @@ type BinlogEvent interface {
 	IsIntVar() bool
 	// IsRand returns true if this is a RAND_EVENT.
 	IsRand() bool
+	// IsStop returns true if this is a STOP_EVENT, which mysqld writes to the
+	// binlog when it shuts down cleanly.
+	IsStop() bool
+	// IsIncident returns true if this is an INCIDENT_EVENT, which mysqld
+	// writes when it knows the binlog is missing some changes.
+	IsIncident() bool
+	// IsTableMap returns true if this is a TABLE_MAP_EVENT, which describes
+	// the table of the rows events that follow it.
+	IsTableMap() bool
+	// IsXAPrepare returns true if this is an XA_PREPARE_LOG_EVENT, which ends
+	// the statements of an XA transaction branch.
+	IsXAPrepare() bool
 	// HasGTID returns true if this event contains a GTID. That could either be
 	// because it's a GTID_EVENT (MariaDB, MySQL 5.6), or because it is some
 	// arbitrary event type that has a GTID in the header (Google MySQL).
 	HasGTID(BinlogFormat) bool
 
+	// Type returns the type code from the event header.
+	Type() byte
 	// Timestamp returns the timestamp from the event header.
 	Timestamp() uint32
+	// NextPosition returns the log_pos field from the event header, which is
+	// the offset of the next event in the binlog file. It is 0 for artificial
+	// events that aren't read from a binlog file.
+	NextPosition() uint32
 
 	// Format returns a BinlogFormat struct based on the event data.
 	// This is only valid if IsFormatDescription() returns true.
@@ -69,6 +92,28 @@ type BinlogEvent interface {
 	// the following QUERY_EVENT.
 	// This is only valid if IsGTID() returns true.
 	IsBeginGTID(BinlogFormat) bool
+	// LogicalClock returns the last_committed and sequence_number fields of
+	// a GTID_EVENT, which MySQL 5.7 writes for parallel replication: a
+	// transaction can be applied in parallel with the ones whose
+	// sequence_number comes after its last_committed. ok is false if the
+	// event doesn't have them, e.g. for older versions or other flavors.
+	// How last_committed is computed depends on
+	// binlog_transaction_dependency_tracking on the master, which isn't in
+	// the binlog: with COMMIT_ORDER, it's the last transaction that was
+	// committed when this one was prepared, and with WRITESET or
+	// WRITESET_SESSION, the last one that changed the same rows, which can
+	// be much older. Either way, the transaction can be applied once the
+	// ones up to last_committed are.
+	// This is only valid if IsGTID() returns true.
+	LogicalClock(BinlogFormat) (lastCommitted, sequenceNumber int64, ok bool)
+	// CommitTimestamps returns the original_commit_timestamp and
+	// immediate_commit_timestamp fields of a GTID_EVENT, which MySQL 8.0
+	// writes in microseconds since the epoch: the time the transaction was
+	// committed on the master it comes from, and on the server that wrote
+	// this binlog. They are the same on that master. ok is false if the
+	// event doesn't have them, e.g. for older versions or other flavors.
+	// This is only valid if IsGTID() returns true.
+	CommitTimestamps(BinlogFormat) (original, immediate int64, ok bool)
 	// Query returns a Query struct representing data from a QUERY_EVENT.
 	// This is only valid if IsQuery() returns true.
 	Query(BinlogFormat) (Query, error)
@@ -78,6 +123,21 @@ type BinlogEvent interface {
 	// Rand returns the two seed values for a RAND_EVENT.
 	// This is only valid if IsRand() returns true.
 	Rand(BinlogFormat) (uint64, uint64, error)
+	// Incident returns the incident type and message of an INCIDENT_EVENT.
+	// This is only valid if IsIncident() returns true.
+	Incident(BinlogFormat) (uint16, string, error)
+	// Rotate returns the offset and the name of the binlog file a
+	// ROTATE_EVENT points to.
+	// This is only valid if IsRotate() returns true.
+	Rotate(BinlogFormat) (uint64, string, error)
+	// TableMap returns a TableMap struct representing data from a
+	// TABLE_MAP_EVENT.
+	// This is only valid if IsTableMap() returns true.
+	TableMap(BinlogFormat) (TableMap, error)
+	// XAPrepare returns an XAPrepare struct representing data from an
+	// XA_PREPARE_LOG_EVENT.
+	// This is only valid if IsXAPrepare() returns true.
+	XAPrepare(BinlogFormat) (XAPrepare, error)
 
 	// StripChecksum returns the checksum and a modified event with the checksum
 	// stripped off, if any. If there is no checksum, it returns the same event
@@ -109,6 +169,19 @@ type Query struct {
 	Database string
 	Charset  *binlogdatapb.Charset
 	SQL      string
+
+	// ExecutionTime is the number of seconds the statement took to run on
+	// the master.
+	ExecutionTime uint32
+	// ErrorCode is the MySQL error code the statement produced on the
+	// master, or 0 if it succeeded.
+	ErrorCode uint16
+	// InvokerUser and InvokerHost are the account the statement ran as on
+	// the master, if mysqld logged it. It does for the statements that
+	// depend on the current user, e.g. GRANT, or a CREATE VIEW without a
+	// DEFINER clause.
+	InvokerUser string
+	InvokerHost string
 }
 
 // String pretty-prints a Query.
@@ -116,3 +189,37 @@ func (q Query) String() string {
 	return fmt.Sprintf("{Database: %q, Charset: %v, SQL: %q}",
 		q.Database, q.Charset, q.SQL)
 }
+
+// TableMap contains data from a TABLE_MAP_EVENT.
+type TableMap struct {
+	// TableID is the number the rows events use to refer to the table.
+	TableID  uint64
+	Flags    uint16
+	Database string
+	Name     string
+
+	// Types has the MYSQL_TYPE_* code of each column.
+	Types []byte
+	// Metadata has the type-specific metadata of each column, such as the
+	// maximum length of a VARCHAR, or 0 for types that have none.
+	Metadata []uint16
+	// CanBeNull tells whether each column is nullable.
+	CanBeNull []bool
+}
+
+// XAPrepare contains data from an XA_PREPARE_LOG_EVENT.
+type XAPrepare struct {
+	// OnePhase is true for XA COMMIT ... ONE PHASE, which commits the
+	// branch without preparing it first.
+	OnePhase bool
+	// FormatID, GTRID and BQual are the parts of the XID of the branch.
+	FormatID uint32
+	GTRID    []byte
+	BQual    []byte
+}
+
+// XID returns the XID of the branch as mysqld writes it in the XA COMMIT
+// and XA ROLLBACK statements of the binlog, e.g. X'7478',X'6231',1.
+func (xa XAPrepare) XID() string {
+	return fmt.Sprintf("X'%x',X'%x',%d", xa.GTRID, xa.BQual, xa.FormatID)
+}
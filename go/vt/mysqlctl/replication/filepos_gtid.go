@@ -0,0 +1,166 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package replication
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const filePosFlavorID = "FilePos"
+
+// parseFilePosGTID is registered as a GTID parser.
+func parseFilePosGTID(s string) (GTID, error) {
+	i := strings.LastIndex(s, ":")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid FilePos GTID (%v): expecting file:pos", s)
+	}
+
+	pos, err := strconv.ParseUint(s[i+1:], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FilePos GTID position (%v): %v", s[i+1:], err)
+	}
+
+	return FilePosGTID{
+		File: s[:i],
+		Pos:  uint32(pos),
+	}, nil
+}
+
+// parseFilePosGTIDSet is registered as a GTIDSet parser.
+func parseFilePosGTIDSet(s string) (GTIDSet, error) {
+	gtid, err := parseFilePosGTID(s)
+	if err != nil {
+		return nil, err
+	}
+	return gtid.(FilePosGTID), err
+}
+
+// FilePosGTID implements GTID for the servers that don't have GTIDs. It's
+// the coordinates of a position in the binlog files, like the ones of
+// CHANGE MASTER TO MASTER_LOG_FILE=..., MASTER_LOG_POS=.... Like
+// MariadbGTID, it's also the GTIDSet of all the transactions before it.
+type FilePosGTID struct {
+	// File is the name of the binlog file.
+	File string
+	// Pos is the offset in File, e.g. of the end of a transaction.
+	Pos uint32
+}
+
+// String implements GTID.String().
+func (gtid FilePosGTID) String() string {
+	return fmt.Sprintf("%s:%d", gtid.File, gtid.Pos)
+}
+
+// Flavor implements GTID.Flavor().
+func (gtid FilePosGTID) Flavor() string {
+	return filePosFlavorID
+}
+
+// SequenceDomain implements GTID.SequenceDomain(). The positions of all the
+// binlog files can be compared, so there is only one domain.
+func (gtid FilePosGTID) SequenceDomain() interface{} {
+	return nil
+}
+
+// SourceServer implements GTID.SourceServer(). The binlog coordinates don't
+// tell which server generated the transaction, so it's nil.
+func (gtid FilePosGTID) SourceServer() interface{} {
+	return nil
+}
+
+// SequenceNumber implements GTID.SequenceNumber().
+func (gtid FilePosGTID) SequenceNumber() interface{} {
+	return gtid.Pos
+}
+
+// GTIDSet implements GTID.GTIDSet().
+func (gtid FilePosGTID) GTIDSet() GTIDSet {
+	return gtid
+}
+
+// ContainsGTID implements GTIDSet.ContainsGTID().
+func (gtid FilePosGTID) ContainsGTID(other GTID) bool {
+	if other == nil {
+		return true
+	}
+	fpOther, ok := other.(FilePosGTID)
+	if !ok {
+		return false
+	}
+	return !gtid.before(fpOther)
+}
+
+// Contains implements GTIDSet.Contains().
+func (gtid FilePosGTID) Contains(other GTIDSet) bool {
+	if other == nil {
+		return true
+	}
+	fpOther, ok := other.(FilePosGTID)
+	if !ok {
+		return false
+	}
+	return !gtid.before(fpOther)
+}
+
+// Equal implements GTIDSet.Equal().
+func (gtid FilePosGTID) Equal(other GTIDSet) bool {
+	fpOther, ok := other.(FilePosGTID)
+	if !ok {
+		return false
+	}
+	return gtid == fpOther
+}
+
+// AddGTID implements GTIDSet.AddGTID().
+func (gtid FilePosGTID) AddGTID(other GTID) GTIDSet {
+	fpOther, ok := other.(FilePosGTID)
+	if !ok || !gtid.before(fpOther) {
+		return gtid
+	}
+	return fpOther
+}
+
+// BinlogFileStart returns the position of the first event of the binlog
+// file named file, after its 4-byte magic header, e.g. to replay a file
+// from its beginning after a restore. It's a FilePosGTID, so a stream
+// started there ignores the GTIDs of the server.
+func BinlogFileStart(file string) Position {
+	return Position{GTIDSet: FilePosGTID{File: file, Pos: binlogFileHeaderSize}}
+}
+
+// binlogFileHeaderSize is the size of the magic header of a binlog file.
+// mysqld refuses to dump binlogs from an offset before it.
+const binlogFileHeaderSize = 4
+
+// before returns true if gtid is before other in the binlog files.
+func (gtid FilePosGTID) before(other FilePosGTID) bool {
+	if gtid.File == other.File {
+		return gtid.Pos < other.Pos
+	}
+	return binlogFileBefore(gtid.File, other.File)
+}
+
+// binlogFileBefore returns true if the binlog file a comes before b. The
+// files of a server share a base name, and their extension is a number
+// that mysqld increments at each rotation. It has at least 6 digits, but
+// can have more, so the extensions are compared as numbers.
+func binlogFileBefore(a, b string) bool {
+	i, j := strings.LastIndex(a, "."), strings.LastIndex(b, ".")
+	if i >= 0 && j >= 0 && a[:i] == b[:j] {
+		an, aErr := strconv.ParseUint(a[i+1:], 10, 64)
+		bn, bErr := strconv.ParseUint(b[j+1:], 10, 64)
+		if aErr == nil && bErr == nil {
+			return an < bn
+		}
+	}
+	return a < b
+}
+
+func init() {
+	gtidParsers[filePosFlavorID] = parseFilePosGTID
+	gtidSetParsers[filePosFlavorID] = parseFilePosGTIDSet
+}
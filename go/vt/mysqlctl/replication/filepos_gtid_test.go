@@ -0,0 +1,108 @@
+// Copyright 2016, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package replication
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFilePosGTID(t *testing.T) {
+	input := "vt-bin.000012:3456"
+	want := FilePosGTID{File: "vt-bin.000012", Pos: 3456}
+
+	got, err := parseFilePosGTID(input)
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	if got.(FilePosGTID) != want {
+		t.Errorf("parseFilePosGTID(%v) = %v, want %v", input, got, want)
+	}
+	if got.String() != input {
+		t.Errorf("%#v.String() = %v, want %v", got, got.String(), input)
+	}
+}
+
+func TestParseInvalidFilePosGTID(t *testing.T) {
+	testcases := []struct {
+		input, want string
+	}{
+		{"vt-bin.000012", "invalid FilePos GTID"},
+		{":3456", "invalid FilePos GTID"},
+		{"vt-bin.000012:x", "invalid FilePos GTID position"},
+		{"vt-bin.000012:4294967296", "invalid FilePos GTID position"},
+	}
+	for _, tc := range testcases {
+		_, err := parseFilePosGTID(tc.input)
+		if err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("parseFilePosGTID(%v) = %v, want error starting with %q", tc.input, err, tc.want)
+		}
+	}
+}
+
+func TestFilePosGTIDDecodePosition(t *testing.T) {
+	want := Position{GTIDSet: FilePosGTID{File: "vt-bin.000012", Pos: 3456}}
+	got, err := DecodePosition(EncodePosition(want))
+	if err != nil {
+		t.Fatalf("DecodePosition() failed: %v", err)
+	}
+	if !got.Equal(want) {
+		t.Errorf("DecodePosition(EncodePosition(%v)) = %v", want, got)
+	}
+}
+
+func TestBinlogFileStart(t *testing.T) {
+	want := Position{GTIDSet: FilePosGTID{File: "vt-bin.000012", Pos: 4}}
+	if got := BinlogFileStart("vt-bin.000012"); !got.Equal(want) {
+		t.Errorf("BinlogFileStart() = %v, want %v", got, want)
+	}
+}
+
+func TestFilePosGTIDContains(t *testing.T) {
+	gtid := FilePosGTID{File: "vt-bin.000012", Pos: 3456}
+	testcases := []struct {
+		other GTIDSet
+		want  bool
+	}{
+		{nil, true},
+		{gtid, true},
+		{FilePosGTID{File: "vt-bin.000012", Pos: 4}, true},
+		{FilePosGTID{File: "vt-bin.000012", Pos: 3457}, false},
+		{FilePosGTID{File: "vt-bin.000011", Pos: 9999}, true},
+		{FilePosGTID{File: "vt-bin.000013", Pos: 4}, false},
+		// The extensions are compared as numbers.
+		{FilePosGTID{File: "vt-bin.1000000", Pos: 4}, false},
+		{MariadbGTID{Domain: 0, Server: 1, Sequence: 2}, false},
+	}
+	for _, tc := range testcases {
+		if got := gtid.Contains(tc.other); got != tc.want {
+			t.Errorf("%v.Contains(%v) = %v, want %v", gtid, tc.other, got, tc.want)
+		}
+		if other, ok := tc.other.(GTID); ok {
+			if got := gtid.ContainsGTID(other); got != tc.want {
+				t.Errorf("%v.ContainsGTID(%v) = %v, want %v", gtid, other, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestFilePosGTIDAddGTID(t *testing.T) {
+	gtid := FilePosGTID{File: "vt-bin.000012", Pos: 3456}
+	testcases := []struct {
+		other GTID
+		want  GTIDSet
+	}{
+		{nil, gtid},
+		{FilePosGTID{File: "vt-bin.000012", Pos: 1000}, gtid},
+		{FilePosGTID{File: "vt-bin.000012", Pos: 4000}, FilePosGTID{File: "vt-bin.000012", Pos: 4000}},
+		{FilePosGTID{File: "vt-bin.000013", Pos: 4}, FilePosGTID{File: "vt-bin.000013", Pos: 4}},
+		{MariadbGTID{Domain: 0, Server: 1, Sequence: 2}, gtid},
+	}
+	for _, tc := range testcases {
+		if got := gtid.AddGTID(tc.other); !got.Equal(tc.want) {
+			t.Errorf("%v.AddGTID(%v) = %v, want %v", gtid, tc.other, got, tc.want)
+		}
+	}
+}
@@ -320,6 +320,71 @@ func (set Mysql56GTIDSet) AddGTID(gtid GTID) GTIDSet {
 	return newSet
 }
 
+// Difference returns the set of the GTIDs of set that aren't in other.
+func (set Mysql56GTIDSet) Difference(other Mysql56GTIDSet) Mysql56GTIDSet {
+	diff := make(Mysql56GTIDSet)
+	for sid, intervals := range set {
+		if remaining := subtractIntervals(mergeIntervals(intervals), mergeIntervals(other[sid])); len(remaining) != 0 {
+			diff[sid] = remaining
+		}
+	}
+	return diff
+}
+
+// Count returns the number of GTIDs in the set.
+func (set Mysql56GTIDSet) Count() int64 {
+	var count int64
+	for _, intervals := range set {
+		for _, iv := range mergeIntervals(intervals) {
+			count += iv.end - iv.start + 1
+		}
+	}
+	return count
+}
+
+// mergeIntervals returns a copy of the sorted intervals, with the ones that
+// overlap or are adjacent merged, as a parsed set may have them.
+func mergeIntervals(intervals []interval) []interval {
+	merged := make([]interval, 0, len(intervals))
+	for _, iv := range intervals {
+		if count := len(merged); count != 0 && iv.start <= merged[count-1].end+1 {
+			if iv.end > merged[count-1].end {
+				merged[count-1].end = iv.end
+			}
+			continue
+		}
+		merged = append(merged, iv)
+	}
+	return merged
+}
+
+// subtractIntervals returns the parts of a that aren't in b. Both must be
+// merged by mergeIntervals().
+func subtractIntervals(a, b []interval) []interval {
+	var result []interval
+	j := 0
+	for _, iv := range a {
+		// The intervals of b that end before iv also end before the next
+		// intervals of a.
+		for j < len(b) && b[j].end < iv.start {
+			j++
+		}
+		start := iv.start
+		for k := j; k < len(b) && b[k].start <= iv.end; k++ {
+			if b[k].start > start {
+				result = append(result, interval{start: start, end: b[k].start - 1})
+			}
+			if b[k].end >= start {
+				start = b[k].end + 1
+			}
+		}
+		if start <= iv.end {
+			result = append(result, interval{start: start, end: iv.end})
+		}
+	}
+	return result
+}
+
 // SIDBlock returns the binary encoding of a MySQL 5.6 GTID set as expected
 // by internal commands that refer to an "SID block".
 //
@@ -441,3 +441,69 @@ func TestMysql56GTIDSetSIDBlock(t *testing.T) {
 		t.Errorf("%#v.SIDBlock() = %#v, want %#v", input, got, want)
 	}
 }
+
+func TestMysql56GTIDSetDifference(t *testing.T) {
+	sid1 := SID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	sid2 := SID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 16}
+	sid3 := SID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 17}
+
+	set := Mysql56GTIDSet{
+		sid1: []interval{{20, 30}, {35, 40}, {42, 45}},
+		sid2: []interval{{1, 5}, {50, 50}, {60, 70}},
+	}
+
+	testcases := []struct {
+		other, want Mysql56GTIDSet
+	}{
+		// Nothing to subtract.
+		{Mysql56GTIDSet{}, set},
+		{Mysql56GTIDSet{sid3: []interval{{1, 100}}}, set},
+		// Everything.
+		{set, Mysql56GTIDSet{}},
+		{Mysql56GTIDSet{sid1: []interval{{1, 100}}, sid2: []interval{{1, 100}}}, Mysql56GTIDSet{}},
+		// Parts of intervals.
+		{
+			Mysql56GTIDSet{sid1: []interval{{25, 36}, {44, 44}}, sid2: []interval{{3, 3}}},
+			Mysql56GTIDSet{
+				sid1: []interval{{20, 24}, {37, 40}, {42, 43}, {45, 45}},
+				sid2: []interval{{1, 2}, {4, 5}, {50, 50}, {60, 70}},
+			},
+		},
+		// Overlapping intervals, as parsed.
+		{
+			Mysql56GTIDSet{sid2: []interval{{1, 50}, {40, 65}}},
+			Mysql56GTIDSet{
+				sid1: []interval{{20, 30}, {35, 40}, {42, 45}},
+				sid2: []interval{{66, 70}},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		if got := set.Difference(tc.other); !got.Equal(tc.want) {
+			t.Errorf("%#v.Difference(%#v) = %#v, want %#v", set, tc.other, got, tc.want)
+		}
+	}
+}
+
+func TestMysql56GTIDSetCount(t *testing.T) {
+	sid1 := SID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	sid2 := SID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 16}
+
+	testcases := []struct {
+		set  Mysql56GTIDSet
+		want int64
+	}{
+		{Mysql56GTIDSet{}, 0},
+		{Mysql56GTIDSet{sid1: []interval{{20, 20}}}, 1},
+		{Mysql56GTIDSet{sid1: []interval{{20, 30}, {35, 40}}, sid2: []interval{{1, 5}}}, 22},
+		// Overlapping intervals are counted once.
+		{Mysql56GTIDSet{sid1: []interval{{1, 10}, {5, 20}, {6, 8}}}, 20},
+	}
+
+	for _, tc := range testcases {
+		if got := tc.set.Count(); got != tc.want {
+			t.Errorf("%#v.Count() = %v, want %v", tc.set, got, tc.want)
+		}
+	}
+}
@@ -65,6 +65,45 @@ func (rp Position) IsZero() bool {
 	return rp.GTIDSet == nil
 }
 
+// TransactionsUntil returns the number of transactions that are in target
+// but not in rp, e.g. to estimate the work left to reach target from rp.
+// It's 0 if rp is at or past target. It's only an estimate of the work,
+// since transactions differ in size. For MariaDB, it's the difference of
+// the sequence numbers, which may have gaps. It returns an error if the
+// positions can't be compared, e.g. if they are of different flavors.
+func (rp Position) TransactionsUntil(target Position) (int64, error) {
+	switch set := target.GTIDSet.(type) {
+	case nil:
+		return 0, nil
+	case Mysql56GTIDSet:
+		if rp.GTIDSet == nil {
+			return set.Count(), nil
+		}
+		current, ok := rp.GTIDSet.(Mysql56GTIDSet)
+		if !ok {
+			return 0, fmt.Errorf("can't compare %v position %v with %v position %v", rp.GTIDSet.Flavor(), rp, set.Flavor(), target)
+		}
+		return set.Difference(current).Count(), nil
+	case MariadbGTID:
+		if rp.GTIDSet == nil {
+			return int64(set.Sequence), nil
+		}
+		current, ok := rp.GTIDSet.(MariadbGTID)
+		if !ok {
+			return 0, fmt.Errorf("can't compare %v position %v with %v position %v", rp.GTIDSet.Flavor(), rp, set.Flavor(), target)
+		}
+		if current.Domain != set.Domain {
+			return 0, fmt.Errorf("can't compare MariaDB positions %v and %v of different domains", rp, target)
+		}
+		if current.Sequence >= set.Sequence {
+			return 0, nil
+		}
+		return int64(set.Sequence - current.Sequence), nil
+	default:
+		return 0, fmt.Errorf("can't count the transactions of %v position %v", set.Flavor(), target)
+	}
+}
+
 // AppendGTID returns a new Position that represents the position
 // after the given GTID is replicated.
 func AppendGTID(rp Position, gtid GTID) Position {
@@ -473,3 +473,62 @@ func TestNewStatus(t *testing.T) {
 		}
 	}
 }
+
+func TestPositionTransactionsUntil(t *testing.T) {
+	mysql56 := func(s string) Position {
+		set, err := parseMysql56GTIDSet(s)
+		if err != nil {
+			t.Fatalf("parseMysql56GTIDSet(%q) failed: %v", s, err)
+		}
+		return Position{GTIDSet: set}
+	}
+	mariadb := func(domain uint32, sequence uint64) Position {
+		return Position{GTIDSet: MariadbGTID{Domain: domain, Server: 5555, Sequence: sequence}}
+	}
+
+	testcases := []struct {
+		current, target Position
+		want            int64
+	}{
+		{Position{}, Position{}, 0},
+		{mariadb(3, 1234), Position{}, 0},
+		{Position{}, mariadb(3, 1234), 1234},
+		{mariadb(3, 1200), mariadb(3, 1234), 34},
+		{mariadb(3, 1234), mariadb(3, 1234), 0},
+		{mariadb(3, 1300), mariadb(3, 1234), 0},
+		{Position{}, mysql56("00010203-0405-0607-0809-0a0b0c0d0e0f:1-10"), 10},
+		{
+			mysql56("00010203-0405-0607-0809-0a0b0c0d0e0f:1-5"),
+			mysql56("00010203-0405-0607-0809-0a0b0c0d0e0f:1-10:20-29,00010203-0405-0607-0809-0a0b0c0d0e10:1-3"),
+			18,
+		},
+		{
+			mysql56("00010203-0405-0607-0809-0a0b0c0d0e0f:1-30"),
+			mysql56("00010203-0405-0607-0809-0a0b0c0d0e0f:1-10:20-29"),
+			0,
+		},
+	}
+	for _, tc := range testcases {
+		got, err := tc.current.TransactionsUntil(tc.target)
+		if err != nil {
+			t.Errorf("%v.TransactionsUntil(%v) failed: %v", tc.current, tc.target, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%v.TransactionsUntil(%v) = %v, want %v", tc.current, tc.target, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		current, target Position
+	}{
+		{mariadb(3, 1200), mariadb(4, 1234)},
+		{mariadb(3, 1200), mysql56("00010203-0405-0607-0809-0a0b0c0d0e0f:1-10")},
+		{mysql56("00010203-0405-0607-0809-0a0b0c0d0e0f:1-10"), mariadb(3, 1234)},
+		{Position{}, Position{GTIDSet: fakeGTID{}}},
+	} {
+		if _, err := tc.current.TransactionsUntil(tc.target); err == nil {
+			t.Errorf("%v.TransactionsUntil(%v) = nil, want error", tc.current, tc.target)
+		}
+	}
+}
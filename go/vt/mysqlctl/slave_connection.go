@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"time"
 
 	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/mysql"
@@ -27,6 +28,11 @@ type SlaveConnection struct {
 	mysqld  *Mysqld
 	slaveID uint32
 	svm     sync2.ServiceManager
+	// readTimeout is SlaveConnectionOptions.ReadTimeout.
+	readTimeout time.Duration
+	// dumpErr is returned by DumpError(). It's set before the events
+	// channel is closed, so it can be read once the channel is closed.
+	dumpErr error
 }
 
 // NewSlaveConnection creates a new slave connection to the mysqld instance.
@@ -37,10 +43,55 @@ type SlaveConnection struct {
 // 2) No real slave servers will have IDs in the range 1-N where N is the peak
 //    number of concurrent fake slave connections we will ever make.
 func (mysqld *Mysqld) NewSlaveConnection() (*SlaveConnection, error) {
+	return mysqld.NewSlaveConnectionWithOptions(SlaveConnectionOptions{})
+}
+
+// SlaveConnectionOptions are the optional settings of a SlaveConnection.
+type SlaveConnectionOptions struct {
+	// SSL, if set, makes the connection use SSL with these settings instead
+	// of the ones from the dba connection parameters.
+	SSL *SSLParams
+	// ReadTimeout, if non-zero, ends a binlog dump with an error if nothing
+	// is received from mysqld for that long. mysqld is asked to send
+	// heartbeats twice as often, so an idle master doesn't time out.
+	ReadTimeout time.Duration
+	// KeepAlive, if non-zero, enables TCP keepalive probes once the
+	// connection has been idle that long. See sqldb.ConnParams.KeepAlive.
+	KeepAlive time.Duration
+}
+
+// SSLParams are the SSL settings of a connection to mysqld. They are the
+// names of the files given to the MySQL client library, and can be empty.
+type SSLParams struct {
+	Ca     string
+	CaPath string
+	Cert   string
+	Key    string
+}
+
+// apply makes params use SSL with these settings.
+func (ssl *SSLParams) apply(params *sqldb.ConnParams) {
+	mysql.EnableSSL(params)
+	params.SslConnect = true
+	params.SslCa = ssl.Ca
+	params.SslCaPath = ssl.CaPath
+	params.SslCert = ssl.Cert
+	params.SslKey = ssl.Key
+}
+
+// NewSlaveConnectionWithOptions is like NewSlaveConnection, with the
+// settings from opts.
+func (mysqld *Mysqld) NewSlaveConnectionWithOptions(opts SlaveConnectionOptions) (*SlaveConnection, error) {
 	params, err := dbconfigs.MysqlParams(mysqld.dba)
 	if err != nil {
 		return nil, err
 	}
+	if opts.SSL != nil {
+		opts.SSL.apply(&params)
+	}
+	if opts.KeepAlive != 0 {
+		params.KeepAlive = opts.KeepAlive
+	}
 
 	conn, err := sqldb.Connect(params)
 	if err != nil {
@@ -48,9 +99,10 @@ func (mysqld *Mysqld) NewSlaveConnection() (*SlaveConnection, error) {
 	}
 
 	sc := &SlaveConnection{
-		Conn:    conn,
-		mysqld:  mysqld,
-		slaveID: slaveIDPool.Get(),
+		Conn:        conn,
+		mysqld:      mysqld,
+		slaveID:     slaveIDPool.Get(),
+		readTimeout: opts.ReadTimeout,
 	}
 	log.Infof("new slave connection: slaveID=%d", sc.slaveID)
 	return sc, nil
@@ -64,38 +116,71 @@ var slaveIDPool = pools.NewIDPool()
 // be sent. The stream will continue, waiting for new events if necessary,
 // until the connection is closed, either by the master or by calling
 // SlaveConnection.Close(). At that point, the channel will also be closed.
+//
+// If startPos is a replication.FilePosGTID, the dump starts at those binlog
+// coordinates instead of a GTID, whatever the flavor, e.g. for masters
+// that don't have GTIDs enabled, or to replay a file from its start with
+// replication.BinlogFileStart(). A Position is either one or a GTID set,
+// so a dump can't start at both.
 func (sc *SlaveConnection) StartBinlogDump(startPos replication.Position) (<-chan replication.BinlogEvent, error) {
 	flavor, err := sc.mysqld.flavor()
 	if err != nil {
 		return nil, fmt.Errorf("StartBinlogDump needs flavor: %v", err)
 	}
 
+	if sc.readTimeout != 0 {
+		// The period is in nanoseconds.
+		if _, err := sc.Conn.ExecuteFetch(fmt.Sprintf("SET @master_heartbeat_period = %d", int64(sc.readTimeout/2)), 0, false); err != nil {
+			return nil, fmt.Errorf("can't enable binlog dump heartbeats: %v", err)
+		}
+	}
+
 	log.Infof("sending binlog dump command: startPos=%v, slaveID=%v", startPos, sc.slaveID)
-	if err = flavor.SendBinlogDumpCommand(sc, startPos); err != nil {
+	if filePos, ok := startPos.GTIDSet.(replication.FilePosGTID); ok {
+		err = sc.sendBinlogDumpFilePosCommand(filePos)
+	} else {
+		err = flavor.SendBinlogDumpCommand(sc, startPos)
+	}
+	if err != nil {
 		log.Errorf("couldn't send binlog dump command: %v", err)
 		return nil, err
 	}
 
 	// Read the first packet to see if it's an error response to our dump command.
-	buf, err := sc.Conn.ReadPacket()
+	buf, err := sc.readPacket()
 	if err != nil {
 		log.Errorf("couldn't start binlog dump: %v", err)
 		return nil, err
 	}
 
 	eventChan := make(chan replication.BinlogEvent)
+	sc.dumpErr = nil
 
 	// Start reading events.
 	sc.svm.Go(func(svc *sync2.ServiceContext) error {
 		defer close(eventChan)
 
+		semiSync := false
 		for svc.IsRunning() {
+			if stripped, ackNeeded, ok := stripSemiSyncHeader(buf); ok {
+				// We never register as a semi-sync slave, so mysqld shouldn't
+				// frame the events for us, or wait for our acks.
+				if !semiSync {
+					log.Warningf("binlog dump events have semi-sync headers, but acks are not sent (first one requests an ack: %v)", ackNeeded)
+					semiSync = true
+				}
+				buf = stripped
+			}
+
 			if buf[0] == 254 {
 				// The master is telling us to stop.
 				log.Infof("received EOF packet in binlog dump: %#v", buf)
 				return nil
 			}
 
+			// The HEARTBEAT_LOG_EVENTs are sent like the other events, so
+			// that the consumer, e.g. a binlog.Streamer, knows the stream is
+			// idle and live.
 			select {
 			// Skip the first byte because it's only used for signaling EOF.
 			case eventChan <- flavor.MakeBinlogEvent(buf[1:]):
@@ -103,7 +188,7 @@ func (sc *SlaveConnection) StartBinlogDump(startPos replication.Position) (<-cha
 				return nil
 			}
 
-			buf, err = sc.Conn.ReadPacket()
+			buf, err = sc.readPacket()
 			if err != nil {
 				if sqlErr, ok := err.(*sqldb.SQLError); ok && sqlErr.Number() == mysql.ErrServerLost {
 					// ErrServerLost = Lost connection to MySQL server during query
@@ -113,6 +198,7 @@ func (sc *SlaveConnection) StartBinlogDump(startPos replication.Position) (<-cha
 					return err
 				}
 				log.Errorf("read error while streaming binlog events: %v", err)
+				sc.dumpErr = err
 				return err
 			}
 		}
@@ -122,6 +208,57 @@ func (sc *SlaveConnection) StartBinlogDump(startPos replication.Position) (<-cha
 	return eventChan, nil
 }
 
+// DumpError returns the error that ended the binlog dump started by
+// StartBinlogDump(), once its channel is closed, e.g. the *sqldb.SQLError
+// of the ERR packet mysqld sent instead of an event. It's nil if mysqld
+// ended the dump with an EOF packet, or if the connection was lost
+// (mysql.ErrServerLost), e.g. because of Close(), so the consumer can tell
+// an error of mysqld from the end of the connection.
+func (sc *SlaveConnection) DumpError() error {
+	return sc.dumpErr
+}
+
+// readPacket reads the next packet of a binlog dump. If readTimeout is set
+// and nothing is received in time, it shuts the connection down to unblock
+// the read, and returns an error.
+func (sc *SlaveConnection) readPacket() ([]byte, error) {
+	if sc.readTimeout == 0 {
+		return sc.Conn.ReadPacket()
+	}
+	timer := time.AfterFunc(sc.readTimeout, sc.Conn.Shutdown)
+	buf, err := sc.Conn.ReadPacket()
+	if !timer.Stop() {
+		return nil, fmt.Errorf("nothing received from mysqld in %v, the connection is probably dead", sc.readTimeout)
+	}
+	return buf, err
+}
+
+// stripSemiSyncHeader removes the 2-byte semi-sync header that mysqld puts
+// between the OK packet header and the event when the slave registered for
+// semi-sync replication. ackNeeded is the flag that asks the slave to
+// acknowledge the event. ok is false if buf has no semi-sync header.
+//
+// The magic byte 0xef could also be the first byte of the event timestamp,
+// so the header is only recognized if the event_length field matches the
+// size of the event without it.
+func stripSemiSyncHeader(buf []byte) (stripped []byte, ackNeeded bool, ok bool) {
+	const headerLen = 1 + 2 + 19
+	if len(buf) < headerLen || buf[0] != 0 || buf[1] != 0xef || buf[2] > 1 {
+		return nil, false, false
+	}
+	if binary.LittleEndian.Uint32(buf[1+9:1+9+4]) == uint32(len(buf)-1) {
+		// The event is complete without stripping anything.
+		return nil, false, false
+	}
+	if binary.LittleEndian.Uint32(buf[3+9:3+9+4]) != uint32(len(buf)-3) {
+		return nil, false, false
+	}
+	ackNeeded = buf[2] == 1
+	// Keep a 1-byte OK packet header in front of the event.
+	buf[2] = buf[0]
+	return buf[2:], ackNeeded, true
+}
+
 // Close closes the slave connection, which also signals an ongoing dump
 // started with StartBinlogDump() to stop and close its BinlogEvent channel.
 // The ID for the slave connection is recycled back into the pool.
@@ -140,6 +277,21 @@ func (sc *SlaveConnection) Close() {
 	}
 }
 
+// sendBinlogDumpFilePosCommand sends the COM_BINLOG_DUMP command that starts
+// a dump at the binlog coordinates of startPos.
+func (sc *SlaveConnection) sendBinlogDumpFilePosCommand(startPos replication.FilePosGTID) error {
+	const ComBinlogDump = 0x12
+
+	// Tell the server that we understand the format of events that will be used
+	// if binlog_checksum is enabled on the server.
+	if _, err := sc.ExecuteFetch("SET @master_binlog_checksum=@@global.binlog_checksum", 0, false); err != nil {
+		return fmt.Errorf("failed to set @master_binlog_checksum=@@global.binlog_checksum: %v", err)
+	}
+
+	buf := makeBinlogDumpCommand(startPos.Pos, 0, sc.slaveID, startPos.File)
+	return sc.SendCommand(ComBinlogDump, buf)
+}
+
 // makeBinlogDumpCommand builds a buffer containing the data for a MySQL
 // COM_BINLOG_DUMP command.
 func makeBinlogDumpCommand(pos uint32, flags uint16, serverID uint32, filename string) []byte {
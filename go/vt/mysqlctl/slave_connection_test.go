@@ -5,8 +5,15 @@
 package mysqlctl
 
 import (
+	"bytes"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/mysql"
+	"github.com/youtube/vitess/go/sqldb"
+	"github.com/youtube/vitess/go/vt/mysqlctl/replication"
 )
 
 func TestMakeBinlogDumpCommand(t *testing.T) {
@@ -44,3 +51,141 @@ func TestMakeBinlogDumpCommandEmptyFilename(t *testing.T) {
 		t.Errorf("makeBinlogDumpCommand() = %#v, want %#v", got, want)
 	}
 }
+
+func TestSSLParamsApply(t *testing.T) {
+	params := sqldb.ConnParams{
+		Host:   "db.example.com",
+		Uname:  "vt_dba",
+		SslCa:  "old-ca.pem",
+		SslKey: "old-key.pem",
+	}
+	ssl := &SSLParams{
+		Ca:   "ca.pem",
+		Cert: "client-cert.pem",
+		Key:  "client-key.pem",
+	}
+	ssl.apply(&params)
+
+	want := sqldb.ConnParams{
+		Host:    "db.example.com",
+		Uname:   "vt_dba",
+		SslCa:   "ca.pem",
+		SslCert: "client-cert.pem",
+		SslKey:  "client-key.pem",
+
+		SslConnect: true,
+	}
+	mysql.EnableSSL(&want)
+	if !reflect.DeepEqual(params, want) {
+		t.Errorf("apply() = %#v, want %#v", params, want)
+	}
+	if !mysql.SslEnabled(&params) {
+		t.Errorf("apply() didn't enable SSL")
+	}
+}
+
+// hangingConn is a sqldb.Conn on which ReadPacket returns the packets it
+// has, and then blocks until Shutdown is called, like a connection to a
+// mysqld that stopped responding.
+type hangingConn struct {
+	sqldb.Conn
+	packets  [][]byte
+	shutdown chan struct{}
+}
+
+func (c *hangingConn) ReadPacket() ([]byte, error) {
+	if len(c.packets) > 0 {
+		buf := c.packets[0]
+		c.packets = c.packets[1:]
+		return buf, nil
+	}
+	<-c.shutdown
+	return nil, &sqldb.SQLError{Num: mysql.ErrServerLost, Message: "Lost connection to MySQL server during query"}
+}
+
+func (c *hangingConn) Shutdown() {
+	close(c.shutdown)
+}
+
+func TestSlaveConnectionReadTimeout(t *testing.T) {
+	conn := &hangingConn{
+		packets:  [][]byte{{0, 1, 2, 3}},
+		shutdown: make(chan struct{}),
+	}
+	sc := &SlaveConnection{Conn: conn, readTimeout: 100 * time.Millisecond}
+
+	buf, err := sc.readPacket()
+	if err != nil || !reflect.DeepEqual(buf, []byte{0, 1, 2, 3}) {
+		t.Errorf("readPacket() = (%v, %v), want ([0 1 2 3], nil)", buf, err)
+	}
+
+	start := time.Now()
+	_, err = sc.readPacket()
+	if err == nil || !strings.Contains(err.Error(), "nothing received from mysqld in 100ms") {
+		t.Errorf("wrong error, got %v, want a read timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("readPacket() took %v to time out", elapsed)
+	}
+}
+
+func TestStripSemiSyncHeader(t *testing.T) {
+	// A 19-byte HEARTBEAT_LOG_EVENT header, with event_length = 19. Its
+	// timestamp starts with the semi-sync magic byte.
+	event := []byte{0xef, 0, 0, 0, 27, 1, 0, 0, 0, 19, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	plain := append([]byte{0}, event...)
+	testcases := []struct {
+		desc     string
+		buf      []byte
+		want     []byte
+		wantAck  bool
+		wantSemi bool
+	}{
+		{"ack requested", append([]byte{0, 0xef, 1}, event...), plain, true, true},
+		{"no ack requested", append([]byte{0, 0xef, 0}, event...), plain, false, true},
+		{"no semi-sync header", plain, nil, false, false},
+		{"bad flag", append([]byte{0, 0xef, 2}, event...), nil, false, false},
+		{"EOF", []byte{254}, nil, false, false},
+	}
+	for _, tcase := range testcases {
+		got, gotAck, gotSemi := stripSemiSyncHeader(tcase.buf)
+		if gotSemi != tcase.wantSemi || gotAck != tcase.wantAck || !bytes.Equal(got, tcase.want) {
+			t.Errorf("%v: stripSemiSyncHeader() = (%v, %v, %v), want (%v, %v, %v)", tcase.desc, got, gotAck, gotSemi, tcase.want, tcase.wantAck, tcase.wantSemi)
+		}
+	}
+}
+
+func TestSlaveConnectionHeartbeats(t *testing.T) {
+	// A 19-byte HEARTBEAT_LOG_EVENT header, with event_length = 19, after
+	// the 1-byte OK packet header.
+	heartbeat := []byte{0, 0x88, 0x41, 0x9, 0x54, 27, 0x88, 0xf3, 0, 0, 19, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	dump := make(chan []byte, 2)
+	dump <- heartbeat
+	dump <- heartbeat
+	close(dump)
+
+	mysqld := NewFakeMysqlDaemon(nil)
+	mysqld.BinlogDump = dump
+	sc, err := mysqld.NewSlaveConnection()
+	if err != nil {
+		t.Fatalf("NewSlaveConnection() failed: %v", err)
+	}
+	defer sc.Close()
+	events, err := sc.StartBinlogDump(replication.Position{})
+	if err != nil {
+		t.Fatalf("StartBinlogDump() failed: %v", err)
+	}
+	count := 0
+	for ev := range events {
+		if !ev.IsValid() || ev.Type() != 27 {
+			t.Errorf("got event %v, want a HEARTBEAT_LOG_EVENT", ev)
+		}
+		count++
+	}
+	if count != 2 {
+		t.Errorf("got %v events, want the 2 heartbeats", count)
+	}
+	if err := sc.DumpError(); err != nil {
+		t.Errorf("DumpError() = %v, want nil after an EOF packet", err)
+	}
+}